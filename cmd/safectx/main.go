@@ -1,14 +1,260 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"safectx/internal/approval"
+	"safectx/internal/cache"
+	"safectx/internal/capture"
+	"safectx/internal/config"
+	"safectx/internal/contextfilter"
+	"safectx/internal/dedup"
+	"safectx/internal/enforcement"
+	"safectx/internal/handshake"
+	"safectx/internal/metrics"
 	"safectx/internal/middleware"
+	"safectx/internal/openai"
+	"safectx/internal/pipeline"
+	"safectx/internal/policy"
 	"safectx/internal/rpc"
+	"safectx/internal/stdio"
+	"safectx/internal/toolschema"
+	"safectx/internal/ws"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
+	cfg := config.DefaultGatewayConfig()
+
+	configPath := flag.String("config", "", "path to a YAML configuration file")
+	flag.StringVar(&cfg.Mode, "mode", cfg.Mode, "upstream mode: http, stdio-http or stdio")
+	flag.StringVar(&cfg.ListenAddr, "listen", cfg.ListenAddr, "address to listen on")
+	flag.StringVar(&cfg.Upstream.URL, "upstream", "http://localhost:9090/mcp", "upstream MCP server URL")
+	flag.DurationVar(&cfg.Upstream.Timeout, "upstream-timeout", cfg.Upstream.Timeout, "upstream request timeout")
+	flag.StringVar(&cfg.Admin.ListenAddr, "admin-listen", cfg.Admin.ListenAddr, "address of the admin server, empty to disable")
+	replayPath := flag.String("replay", "", "replay a capture file through the configuration and report changed decisions, then exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [-- command args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// Flags given on the command line override the configuration file
+	if *configPath != "" {
+		loaded, err := config.LoadGatewayConfig(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "mode":
+				loaded.Mode = cfg.Mode
+			case "listen":
+				loaded.ListenAddr = cfg.ListenAddr
+			case "upstream":
+				loaded.Upstream.URL = cfg.Upstream.URL
+			case "upstream-timeout":
+				loaded.Upstream.Timeout = cfg.Upstream.Timeout
+			case "admin-listen":
+				loaded.Admin.ListenAddr = cfg.Admin.ListenAddr
+			}
+		})
+		cfg = loaded
+	}
+
+	// In the stdio modes the remaining arguments are the MCP server command
+	if flag.NArg() > 0 {
+		cfg.Stdio.Command = flag.Arg(0)
+		cfg.Stdio.Args = flag.Args()[1:]
+	}
+
+	if err := config.ValidateGatewayConfig(cfg); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	engine := policy.NewEngineFromConfig(&cfg.Policy)
+	responses, err := contextfilter.NewResponseFilterFromConfig(&cfg.Response)
+	if err != nil {
+		log.Fatalf("Invalid response rules: %v", err)
+	}
+	registry := pipeline.NewDefaultRegistry(engine)
+	register := func(stage pipeline.Stage) {
+		if err := registry.Register(stage); err != nil {
+			log.Fatalf("Invalid pipeline: %v", err)
+		}
+	}
+	if slices.Contains(cfg.Pipeline.StageNames(), pipeline.StageReplay) {
+		register(pipeline.NewReplayStage(newReplayStore(&cfg.ReplayProtection), cfg.ReplayProtection.Window))
+	}
+	var guard *handshake.Guard
+	if slices.Contains(cfg.Pipeline.StageNames(), pipeline.StageHandshake) {
+		guard = handshake.NewGuard(&cfg.Handshake)
+		register(pipeline.NewHandshakeStage(guard))
+	}
+	var tools *toolschema.Registry
+	if slices.Contains(cfg.Pipeline.StageNames(), pipeline.StageArguments) {
+		if tools, err = toolschema.NewRegistry(&cfg.Arguments); err != nil {
+			log.Fatalf("Invalid tool schemas: %v", err)
+		}
+		register(pipeline.NewArgumentsStage(tools))
+	}
+	if slices.Contains(cfg.Pipeline.StageNames(), pipeline.StageServerRequests) {
+		stage, err := pipeline.NewServerRequestsStage(&cfg.ServerRequests)
+		if err != nil {
+			log.Fatalf("Invalid server request settings: %v", err)
+		}
+		register(stage)
+	}
+	requests, err := registry.Build(&cfg.Pipeline)
+	if err != nil {
+		log.Fatalf("Invalid pipeline: %v", err)
+	}
+	var responseCache *rpc.ResponseCache
+	if len(cfg.Cache.Methods) > 0 {
+		responseCache = rpc.NewResponseCache(&cfg.Cache, newCacheStore(&cfg.Cache))
+	}
+	var approvals *approval.Queue
+	if cfg.Policy.RequiresApproval() {
+		approvals = approval.NewQueue(&cfg.Approvals, newApprovalStore(&cfg.Approvals))
+	}
+	modes := enforcement.NewModes(&cfg.Enforcement)
+	inspector := rpc.NewInspector(engine).WithPipeline(requests).WithResponseFilter(responses).WithEnforcement(modes).
+		WithDenialDetail(cfg.Denials.Detail).WithHandshake(guard).WithCache(responseCache).WithApprovals(approvals).
+		WithToolSchemas(tools)
+
+	if *replayPath != "" {
+		os.Exit(runReplay(*replayPath, inspector))
+	}
+
+	if *configPath != "" {
+		go reloadEnforcement(ctx, *configPath, modes)
+	}
+
+	if cfg.Mode == "stdio" {
+		runStdio(ctx, cfg, inspector)
+		return
+	}
+	runHTTP(ctx, cfg, &components{inspector: inspector, cache: responseCache, approvals: approvals})
+}
+
+// components are the parts of the gateway assembled from the configuration
+// that the HTTP handlers share
+type components struct {
+	// inspector screens the messages of every handler
+	inspector *rpc.Inspector
+	// cache answers idempotent requests, if any methods are cached
+	cache *rpc.ResponseCache
+	// approvals holds the requests awaiting approval, if any rule
+	// requires it
+	approvals *approval.Queue
+}
+
+// newReplayStore creates the store remembering the request IDs seen by the
+// replay stage
+func newReplayStore(cfg *config.ReplayProtectionConfig) dedup.Store {
+	if cfg.Store != "redis" {
+		return dedup.NewMemoryStore(cfg.Window)
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	return dedup.NewRedisStore(client, cfg.KeyPrefix)
+}
+
+// newCacheStore creates the store keeping the results of cached methods
+func newCacheStore(cfg *config.CacheConfig) cache.Store {
+	if cfg.Store != "redis" {
+		return cache.NewMemoryStore(cfg.MaxEntries)
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	return cache.NewRedisStore(client, cfg.KeyPrefix)
+}
+
+// newApprovalStore creates the store keeping the approvals of held
+// requests
+func newApprovalStore(cfg *config.ApprovalConfig) approval.Store {
+	if cfg.Store != "redis" {
+		return approval.NewMemoryStore()
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	return approval.NewRedisStore(client, cfg.KeyPrefix)
+}
+
+// runReplay replays a capture file through inspector and prints every
+// decision that changed. It returns the exit status: 1 if any decision
+// changed.
+func runReplay(path string, inspector *rpc.Inspector) int {
+	records, err := capture.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read capture: %v", err)
+	}
+	report := capture.Replay(records, inspector)
+	for _, change := range report.Changes {
+		fmt.Println(change)
+	}
+	fmt.Printf("Replayed %d requests, skipped %d, %d decisions changed\n", report.Replayed, report.Skipped, len(report.Changes))
+	if len(report.Changes) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// reloadEnforcement applies the enforcement modes of the configuration file
+// whenever the process receives SIGHUP, so stages can be switched between
+// monitor and enforce without a restart
+func reloadEnforcement(ctx context.Context, path string, modes *enforcement.Modes) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+		case <-ctx.Done():
+			return
+		}
+
+		loaded, err := config.LoadGatewayConfig(path)
+		if err == nil {
+			err = config.ValidateEnforcementConfig(&loaded.Enforcement, loaded.Pipeline.StageNames())
+		}
+		if err != nil {
+			log.Printf("Keeping enforcement modes, reload failed: %v", err)
+			continue
+		}
+		modes.Update(&loaded.Enforcement)
+		log.Printf("Reloaded enforcement modes from %s", path)
+	}
+}
+
+// runHTTP serves the gateway over HTTP, forwarding to an HTTP upstream or
+// to a supervised stdio MCP server. Every handler screens messages with the
+// inspector of parts.
+func runHTTP(ctx context.Context, cfg *config.GatewayConfig, parts *components) {
 	// Create OIDC authenticator
 	oidcAuth, err := middleware.NewOIDCAuthenticator(
 		"https://your-oidc-provider",
@@ -26,12 +272,158 @@ func main() {
 		middleware.RateLimitMiddleware(middleware.NewRateLimiter(10.0, 10.0, time.Second)),
 		middleware.AuthMiddleware(oidcAuth),
 	)
+
+	// Create the upstream forwarder
+	var upstream rpc.Forwarder
+	var pools []*rpc.Pool
+	target := cfg.Upstream.URL
+	if cfg.Mode == "stdio-http" {
+		process := stdio.NewProcess(&cfg.Stdio)
+		if err := process.Start(); err != nil {
+			log.Fatalf("Failed to start MCP server: %v", err)
+		}
+		defer process.Close()
+		upstream = process
+		target = cfg.Stdio.Command
+	} else if len(cfg.Upstreams) > 0 {
+		router := rpc.NewRouter(&cfg.Routing)
+		names := make([]string, 0, len(cfg.Upstreams))
+		for i := range cfg.Upstreams {
+			pool, err := rpc.NewPool(cfg.Upstreams[i].Name, &cfg.Upstreams[i].UpstreamConfig)
+			if err != nil {
+				log.Fatalf("Failed to create upstream pool %s: %v", cfg.Upstreams[i].Name, err)
+			}
+			router.WithUpstream(cfg.Upstreams[i].Name, pool)
+			pools = append(pools, pool)
+			names = append(names, cfg.Upstreams[i].Name)
+		}
+		upstream = router
+		target = strings.Join(names, ", ")
+	} else {
+		pool, err := rpc.NewPool(rpc.DefaultUpstreamName, &cfg.Upstream)
+		if err != nil {
+			log.Fatalf("Failed to create upstream pool: %v", err)
+		}
+		pools = append(pools, pool)
+		upstream = pool
+		if target == "" {
+			target = strings.Join(cfg.Upstream.Endpoints, ", ")
+		}
+	}
+	for _, pool := range pools {
+		pool.Start()
+		defer pool.Close()
+	}
+
 	// Create the gateway handler
-	handler := rpc.NewGatewayHandler()
+	handler := rpc.NewGatewayHandler(upstream).
+		WithInspector(parts.inspector).
+		WithDeadlines(&cfg.Deadlines).
+		WithMaxBatchSize(cfg.MaxBatchSize)
+	if cfg.Capture.Path != "" {
+		recorder, err := capture.NewWriter(cfg.Capture.Path)
+		if err != nil {
+			log.Fatalf("Failed to open capture file: %v", err)
+		}
+		defer recorder.Close()
+		handler.WithCapture(recorder)
+		log.Printf("Capturing traffic to %s", cfg.Capture.Path)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", chain(handler))
+	if cfg.WebSocket.Path != "" {
+		// WebSocket connections authenticate on upgrade and are rate limited
+		// per connection, so they bypass the HTTP auth and rate limits
+		wsHandler := ws.NewHandler(&cfg.WebSocket, parts.inspector, upstream).
+			WithAuthenticators(oidcAuth).
+			WithAllowedHeaders(cfg.Upstream.AllowedHeaders).
+			WithDeadlines(&cfg.Deadlines).
+			WithCache(parts.cache)
+		mux.Handle(cfg.WebSocket.Path, middleware.LoggingMiddleware()(wsHandler))
+	}
+	if cfg.ChatCompletions.Path != "" {
+		mux.Handle(cfg.ChatCompletions.Path, chain(openai.NewHandler(&cfg.ChatCompletions, parts.inspector).WithUpstream(upstream)))
+		log.Printf("Serving chat completions on %s, forwarding to %s", cfg.ChatCompletions.Path, cfg.ChatCompletions.Upstream)
+	}
+
+	server := &http.Server{
+		Addr:    cfg.ListenAddr,
+		Handler: mux,
+	}
 
 	// Start the HTTP server
-	log.Println("Starting SafeCtx server on :8080")
-	if err := http.ListenAndServe(":8080", handler); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	go func() {
+		log.Printf("Starting SafeCtx server on %s, forwarding to %s", cfg.ListenAddr, target)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// The admin server is only reachable on its own address
+	if cfg.Admin.ListenAddr != "" {
+		admin := http.NewServeMux()
+		admin.Handle("/health", rpc.HealthHandler(pools...))
+		admin.Handle("/metrics", metrics.Default.Handler())
+		// Traces reveal the policy and detection rules, so only admins
+		// may request them
+		admin.Handle("/explain", middleware.Chain(
+			middleware.AuthMiddleware(oidcAuth),
+			middleware.RoleMiddleware(cfg.Admin.ExplainRoles...),
+		)(handler.ExplainHandler()))
+		if parts.approvals != nil {
+			// Approvers authenticate like clients, so decisions are audited
+			// with their identity. Held calls carry their params, so only
+			// approvers may read them.
+			approvalHandler := middleware.Chain(
+				middleware.AuthMiddleware(oidcAuth),
+				middleware.RoleMiddleware(cfg.Approvals.ApproverRoles...),
+			)(parts.approvals.Handler())
+			admin.Handle("/approvals", approvalHandler)
+			admin.Handle("/approvals/", approvalHandler)
+		}
+		adminServer := &http.Server{Addr: cfg.Admin.ListenAddr, Handler: admin}
+		go func() {
+			log.Printf("Starting SafeCtx admin server on %s", cfg.Admin.ListenAddr)
+			if err := adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Failed to start admin server: %v", err)
+			}
+		}()
+		defer adminServer.Close()
+	}
+
+	<-ctx.Done()
+	log.Println("Shutting down SafeCtx server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		// Open SSE streams do not end on their own
+		server.Close()
+	}
+}
+
+// runStdio relays between our own stdin/stdout and a supervised stdio MCP
+// server. Logs go to stderr so stdout only carries JSON-RPC messages.
+func runStdio(ctx context.Context, cfg *config.GatewayConfig, inspector *rpc.Inspector) {
+	process := stdio.NewProcess(&cfg.Stdio)
+	relay := stdio.NewRelay(process, inspector, os.Stdout)
+	if err := process.Start(); err != nil {
+		log.Fatalf("Failed to start MCP server: %v", err)
+	}
+	defer process.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- relay.Run(os.Stdin)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Printf("Error reading from client: %v", err)
+		}
+	case <-ctx.Done():
 	}
+	log.Println("Shutting down SafeCtx stdio bridge")
 }
@@ -6,8 +6,10 @@ require (
 	github.com/coreos/go-oidc/v3 v3.9.0
 	github.com/crewjam/saml v0.4.14
 	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/gorilla/websocket v1.5.3
 	github.com/redis/go-redis/v9 v9.5.1
 	golang.org/x/oauth2 v0.17.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,122 @@
+package approval
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"safectx/internal/middleware"
+	"safectx/internal/policy"
+)
+
+// decisionRequest is the optional body of an approve or deny request
+type decisionRequest struct {
+	Comment string `json:"comment"`
+}
+
+// Handler serves the approval queue on the admin server:
+//
+//	GET  /approvals               lists the pending approvals, or those
+//	                              with the status given by ?status=, or
+//	                              every approval with ?status=all
+//	GET  /approvals/{id}          returns an approval
+//	POST /approvals/{id}/approve  approves it
+//	POST /approvals/{id}/deny     denies it
+//
+// A decision may carry a JSON body with a comment. The approver is the
+// authenticated caller, so the handler must run behind the auth
+// middleware.
+func (q *Queue) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /approvals", q.serveList)
+	mux.HandleFunc("GET /approvals/{id}", q.serveGet)
+	mux.HandleFunc("POST /approvals/{id}/approve", func(w http.ResponseWriter, r *http.Request) {
+		q.serveDecision(w, r, true)
+	})
+	mux.HandleFunc("POST /approvals/{id}/deny", func(w http.ResponseWriter, r *http.Request) {
+		q.serveDecision(w, r, false)
+	})
+	return mux
+}
+
+// serveList lists approvals by status
+func (q *Queue) serveList(w http.ResponseWriter, r *http.Request) {
+	status := Status(r.URL.Query().Get("status"))
+	switch status {
+	case "":
+		status = Pending
+	case "all":
+		status = ""
+	case Pending, Approved, Denied, Expired:
+	default:
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	approvals, err := q.List(r.Context(), status)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"approvals": approvals})
+}
+
+// serveGet returns a single approval
+func (q *Queue) serveGet(w http.ResponseWriter, r *http.Request) {
+	a, err := q.Check(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// serveDecision approves or denies an approval on behalf of the
+// authenticated caller
+func (q *Queue) serveDecision(w http.ResponseWriter, r *http.Request, approve bool) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body decisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	approver := &policy.Subject{ID: user.ID, Roles: user.Roles, Claims: user.Claims}
+	a, err := q.Decide(r.Context(), r.PathValue("id"), approve, approver, body.Comment)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// writeError writes the HTTP error for a failed queue operation
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrNotApprover), errors.Is(err, ErrSelfApproval):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrDecided), errors.Is(err, ErrExpired):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Error accessing approval store: %v", err)
+		http.Error(w, "Approval store unavailable", http.StatusServiceUnavailable)
+	}
+}
+
+// writeJSON writes v as a JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
@@ -0,0 +1,349 @@
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/internal/metrics"
+	"safectx/internal/policy"
+)
+
+// Status is the state of an approval
+type Status string
+
+// Approval states
+const (
+	Pending  Status = "pending"
+	Approved Status = "approved"
+	Denied   Status = "denied"
+	Expired  Status = "expired"
+)
+
+// pollInterval is how often a held request checks the store for a
+// decision made on another gateway instance
+const pollInterval = time.Second
+
+var (
+	ErrDecided      = errors.New("approval already decided")
+	ErrExpired      = errors.New("approval expired")
+	ErrNotApproved  = errors.New("approval not granted or already used")
+	ErrNotApprover  = errors.New("caller may not decide approvals")
+	ErrSelfApproval = errors.New("requester cannot decide their own approval")
+)
+
+// Approval is a request held until a human decides it
+type Approval struct {
+	ID     string `json:"id"`
+	Status Status `json:"status"`
+	// Method and Params are the held request, after redaction
+	Method string                 `json:"method"`
+	Tool   string                 `json:"tool,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+	// RuleID is the policy rule that requires the approval
+	RuleID string `json:"rule"`
+	Reason string `json:"reason,omitempty"`
+	// Requester is the ID of the caller, empty if anonymous
+	Requester string `json:"requester,omitempty"`
+	Session   string `json:"session,omitempty"`
+	// RequestID correlates the approval with the gateway logs
+	RequestID string `json:"requestId,omitempty"`
+	// Fingerprint identifies identical requests of the same caller, so a
+	// retry finds the approval of the first attempt
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// ExpiresAt is when a pending approval times out
+	ExpiresAt time.Time  `json:"expiresAt"`
+	DecidedBy string     `json:"decidedBy,omitempty"`
+	DecidedAt *time.Time `json:"decidedAt,omitempty"`
+	Comment   string     `json:"comment,omitempty"`
+	// Forwarded is set once the approved request was forwarded. An
+	// approval lets a single request through.
+	Forwarded bool `json:"forwarded,omitempty"`
+}
+
+// Queue holds the approvals of requests that a policy rule requires a
+// human to approve. Decisions are audited in the log with the identity of
+// the approver.
+type Queue struct {
+	store         Store
+	timeout       time.Duration
+	retention     time.Duration
+	approverRoles []string
+
+	mu sync.Mutex
+	// changed is closed and replaced whenever an approval is decided on
+	// this instance, to wake the held requests
+	changed chan struct{}
+}
+
+// NewQueue creates a queue keeping its approvals in store
+func NewQueue(cfg *config.ApprovalConfig, store Store) *Queue {
+	return &Queue{
+		store:         store,
+		timeout:       cfg.Timeout,
+		retention:     cfg.Retention,
+		approverRoles: cfg.ApproverRoles,
+		changed:       make(chan struct{}),
+	}
+}
+
+// Request returns the approval for a held request described by a: the
+// approval an identical earlier request of the same caller is still
+// waiting for or was granted and has not used, or else a new pending
+// approval. Requests of anonymous callers outside a session always get a
+// new approval, since their retries cannot be told apart.
+func (q *Queue) Request(ctx context.Context, a *Approval) (*Approval, error) {
+	a.Fingerprint = fingerprint(a)
+	if a.Fingerprint != "" {
+		existing, err := q.store.Find(ctx, a.Fingerprint)
+		if err == nil && q.reusable(existing) {
+			return existing, nil
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	a.ID = newID()
+	a.Status = Pending
+	a.CreatedAt = now
+	a.ExpiresAt = now.Add(q.timeout)
+	if err := q.store.Create(ctx, a, q.retention); err != nil {
+		return nil, err
+	}
+	log.Printf("Audit: approval %s requested for %s by %s under rule %s (request %s)", a.ID, describe(a), requester(a), a.RuleID, a.RequestID)
+	return a, nil
+}
+
+// Wait blocks until the approval with the given ID is decided or times
+// out, or ctx is done. It returns the approval in its final state.
+func (q *Queue) Wait(ctx context.Context, id string) (*Approval, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		changed := q.changes()
+		a, err := q.store.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if a.Status != Pending {
+			return a, nil
+		}
+		remaining := time.Until(a.ExpiresAt)
+		if remaining <= 0 {
+			return q.expire(ctx, id)
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-changed:
+		case <-ticker.C:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// Check returns the approval with the given ID without waiting for it. A
+// pending approval that has timed out is expired.
+func (q *Queue) Check(ctx context.Context, id string) (*Approval, error) {
+	a, err := q.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if a.Status == Pending && !time.Now().Before(a.ExpiresAt) {
+		return q.expire(ctx, id)
+	}
+	return a, nil
+}
+
+// Consume marks an approved approval as used by the request it lets
+// through. It returns ErrNotApproved if the approval was not granted or
+// another request already used it.
+func (q *Queue) Consume(ctx context.Context, id string) (*Approval, error) {
+	return q.store.Update(ctx, id, func(a *Approval) error {
+		if a.Status != Approved || a.Forwarded {
+			return ErrNotApproved
+		}
+		a.Forwarded = true
+		return nil
+	})
+}
+
+// Decide approves or denies a pending approval on behalf of approver, who
+// must have one of the approver roles if any are configured and must not
+// be the requester
+func (q *Queue) Decide(ctx context.Context, id string, approve bool, approver *policy.Subject, comment string) (*Approval, error) {
+	if approver == nil || approver.ID == "" {
+		return nil, ErrNotApprover
+	}
+	if len(q.approverRoles) > 0 && !slices.ContainsFunc(q.approverRoles, approver.HasRole) {
+		return nil, ErrNotApprover
+	}
+
+	status := Denied
+	if approve {
+		status = Approved
+	}
+	a, err := q.store.Update(ctx, id, func(a *Approval) error {
+		switch {
+		case a.Requester != "" && a.Requester == approver.ID:
+			return ErrSelfApproval
+		case a.Status != Pending:
+			return ErrDecided
+		case !time.Now().Before(a.ExpiresAt):
+			return ErrExpired
+		}
+		now := time.Now()
+		a.Status = status
+		a.DecidedBy = approver.ID
+		a.DecidedAt = &now
+		a.Comment = comment
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.ApprovalDecisions.Inc(a.RuleID, string(a.Status))
+	log.Printf("Audit: approval %s of %s by %s %s by %s: %q", a.ID, describe(a), requester(a), a.Status, a.DecidedBy, a.Comment)
+	q.notify()
+	return a, nil
+}
+
+// List returns the approvals with the given status, or every approval if
+// status is empty, oldest first. Pending approvals that have timed out are
+// listed as expired.
+func (q *Queue) List(ctx context.Context, status Status) ([]*Approval, error) {
+	all, err := q.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	approvals := make([]*Approval, 0, len(all))
+	for _, a := range all {
+		if a.Status == Pending && !now.Before(a.ExpiresAt) {
+			a.Status = Expired
+		}
+		if status == "" || a.Status == status {
+			approvals = append(approvals, a)
+		}
+	}
+	sort.Slice(approvals, func(i, j int) bool { return approvals[i].CreatedAt.Before(approvals[j].CreatedAt) })
+	return approvals, nil
+}
+
+// expire marks a pending approval that has timed out as expired. An
+// approval decided in the meantime is returned as decided.
+func (q *Queue) expire(ctx context.Context, id string) (*Approval, error) {
+	expired := false
+	a, err := q.store.Update(ctx, id, func(a *Approval) error {
+		if a.Status == Pending {
+			a.Status = Expired
+			expired = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		metrics.ApprovalDecisions.Inc(a.RuleID, string(Expired))
+		log.Printf("Audit: approval %s of %s by %s expired without a decision", a.ID, describe(a), requester(a))
+	}
+	return a, nil
+}
+
+// reusable reports whether a retried request may wait for, or be let
+// through by, an existing approval
+func (q *Queue) reusable(a *Approval) bool {
+	switch a.Status {
+	case Pending:
+		return time.Now().Before(a.ExpiresAt)
+	case Approved:
+		return !a.Forwarded && a.DecidedAt != nil && time.Since(*a.DecidedAt) < q.timeout
+	default:
+		return false
+	}
+}
+
+// changes returns the channel closed on the next decision
+func (q *Queue) changes() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.changed
+}
+
+// notify wakes the requests waiting for a decision
+func (q *Queue) notify() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// fingerprint identifies the request of an approval by caller, method and
+// params. Metadata such as progress tokens is ignored. It is empty for
+// anonymous callers outside a session.
+func fingerprint(a *Approval) string {
+	caller := "user:" + a.Requester
+	switch {
+	case a.Requester != "":
+	case a.Session != "":
+		caller = "session:" + a.Session
+	default:
+		return ""
+	}
+
+	params := make(map[string]interface{}, len(a.Params))
+	for name, value := range a.Params {
+		if name != "_meta" {
+			params[name] = value
+		}
+	}
+	data, err := json.Marshal([]interface{}{caller, a.Method, params})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// newID creates a random approval ID
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// describe names the held request of an approval in the audit log
+func describe(a *Approval) string {
+	if a.Tool != "" {
+		return a.Method + " " + a.Tool
+	}
+	return a.Method
+}
+
+// requester names the caller of an approval in the audit log
+func requester(a *Approval) string {
+	if a.Requester == "" {
+		return "anonymous caller"
+	}
+	return "user " + a.Requester
+}
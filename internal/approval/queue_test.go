@@ -0,0 +1,257 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/internal/middleware"
+	"safectx/internal/policy"
+)
+
+func newTestQueue(timeout time.Duration) *Queue {
+	cfg := config.DefaultApprovalConfig()
+	cfg.Timeout = timeout
+	cfg.ApproverRoles = []string{"approver"}
+	return NewQueue(&cfg, NewMemoryStore())
+}
+
+func deploy(requester string) *Approval {
+	return &Approval{
+		Method:    "tools/call",
+		Tool:      "deploy",
+		Params:    map[string]interface{}{"name": "deploy", "arguments": map[string]interface{}{"env": "prod"}},
+		RuleID:    "approve-deploy",
+		Requester: requester,
+	}
+}
+
+func TestQueueRequest(t *testing.T) {
+	ctx := context.Background()
+	q := newTestQueue(time.Minute)
+
+	first, err := q.Request(ctx, deploy("alice"))
+	if err != nil || first.Status != Pending {
+		t.Fatalf("Request() = %v, %v, want a pending approval", first, err)
+	}
+
+	// A retry waits for the same approval, other callers get their own
+	retry := deploy("alice")
+	retry.Params["_meta"] = map[string]interface{}{"progressToken": 1}
+	if a, _ := q.Request(ctx, retry); a.ID != first.ID {
+		t.Errorf("Retry got approval %s, want %s", a.ID, first.ID)
+	}
+	if a, _ := q.Request(ctx, deploy("bob")); a.ID == first.ID {
+		t.Error("Another caller got the same approval")
+	}
+	a, _ := q.Request(ctx, deploy(""))
+	if b, _ := q.Request(ctx, deploy("")); a.ID == b.ID {
+		t.Error("Anonymous callers outside a session share an approval")
+	}
+
+	// A granted approval lets a single retry through
+	approver := &policy.Subject{ID: "carol", Roles: []string{"approver"}}
+	if _, err := q.Decide(ctx, first.ID, true, approver, ""); err != nil {
+		t.Fatalf("Decide() error = %v", err)
+	}
+	if a, _ := q.Request(ctx, deploy("alice")); a.ID != first.ID || a.Status != Approved {
+		t.Errorf("Retry after approval got %s %s, want %s approved", a.ID, a.Status, first.ID)
+	}
+	if _, err := q.Consume(ctx, first.ID); err != nil {
+		t.Errorf("Consume() error = %v", err)
+	}
+	if _, err := q.Consume(ctx, first.ID); !errors.Is(err, ErrNotApproved) {
+		t.Errorf("Second Consume() error = %v, want %v", err, ErrNotApproved)
+	}
+	if a, _ := q.Request(ctx, deploy("alice")); a.ID == first.ID || a.Status != Pending {
+		t.Errorf("Request after the approval was used got %s %s, want a new pending approval", a.ID, a.Status)
+	}
+}
+
+func TestQueueDecide(t *testing.T) {
+	ctx := context.Background()
+	q := newTestQueue(time.Minute)
+	a, _ := q.Request(ctx, deploy("alice"))
+
+	tests := []struct {
+		name     string
+		approver *policy.Subject
+		wantErr  error
+	}{
+		{"anonymous", nil, ErrNotApprover},
+		{"missing role", &policy.Subject{ID: "bob"}, ErrNotApprover},
+		{"self approval", &policy.Subject{ID: "alice", Roles: []string{"approver"}}, ErrSelfApproval},
+		{"approver", &policy.Subject{ID: "carol", Roles: []string{"approver"}}, nil},
+		{"already decided", &policy.Subject{ID: "dave", Roles: []string{"approver"}}, ErrDecided},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := q.Decide(ctx, a.ID, false, tt.approver, "too risky")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decide() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (got.Status != Denied || got.DecidedBy != "carol" || got.Comment != "too risky") {
+				t.Errorf("Decide() = %+v, want denied by carol with the comment", got)
+			}
+		})
+	}
+
+	if _, err := q.Decide(ctx, "unknown", true, tests[3].approver, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Decide() error = %v for an unknown approval, want %v", err, ErrNotFound)
+	}
+}
+
+func TestQueueWait(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("decided", func(t *testing.T) {
+		q := newTestQueue(time.Minute)
+		a, _ := q.Request(ctx, deploy("alice"))
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			q.Decide(ctx, a.ID, true, &policy.Subject{ID: "carol", Roles: []string{"approver"}}, "")
+		}()
+
+		start := time.Now()
+		got, err := q.Wait(ctx, a.ID)
+		if err != nil || got.Status != Approved {
+			t.Fatalf("Wait() = %v, %v, want the approval granted", got, err)
+		}
+		if elapsed := time.Since(start); elapsed > pollInterval/2 {
+			t.Errorf("Wait() took %s, want it woken by the decision", elapsed)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		q := newTestQueue(30 * time.Millisecond)
+		a, _ := q.Request(ctx, deploy("alice"))
+		got, err := q.Wait(ctx, a.ID)
+		if err != nil || got.Status != Expired {
+			t.Fatalf("Wait() = %v, %v, want the approval expired", got, err)
+		}
+		if _, err := q.Decide(ctx, a.ID, true, &policy.Subject{ID: "carol", Roles: []string{"approver"}}, ""); !errors.Is(err, ErrDecided) {
+			t.Errorf("Decide() error = %v after expiry, want %v", err, ErrDecided)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		q := newTestQueue(time.Minute)
+		a, _ := q.Request(ctx, deploy("alice"))
+		cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		if _, err := q.Wait(cctx, a.ID); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	q := newTestQueue(time.Minute)
+	handler := q.Handler()
+	a, _ := q.Request(ctx, deploy("alice"))
+
+	carol := &middleware.User{ID: "carol", Roles: []string{"approver"}}
+	send := func(method, target, body string, user *middleware.User) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if user != nil {
+			req = req.WithContext(middleware.WithUser(req.Context(), user))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		user       *middleware.User
+		wantStatus int
+	}{
+		{"get", "GET", "/approvals/" + a.ID, "", nil, http.StatusOK},
+		{"get unknown", "GET", "/approvals/unknown", "", nil, http.StatusNotFound},
+		{"invalid status", "GET", "/approvals?status=maybe", "", nil, http.StatusBadRequest},
+		{"unauthenticated", "POST", "/approvals/" + a.ID + "/approve", "", nil, http.StatusUnauthorized},
+		{"not an approver", "POST", "/approvals/" + a.ID + "/approve", "", &middleware.User{ID: "bob"}, http.StatusForbidden},
+		{"self approval", "POST", "/approvals/" + a.ID + "/approve", "", &middleware.User{ID: "alice", Roles: []string{"approver"}}, http.StatusForbidden},
+		{"invalid body", "POST", "/approvals/" + a.ID + "/approve", "{", carol, http.StatusBadRequest},
+		{"approve", "POST", "/approvals/" + a.ID + "/approve", `{"comment":"ship it"}`, carol, http.StatusOK},
+		{"already decided", "POST", "/approvals/" + a.ID + "/deny", "", carol, http.StatusConflict},
+		{"wrong method", "GET", "/approvals/" + a.ID + "/approve", "", carol, http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := send(tt.method, tt.target, tt.body, tt.user); rr.Code != tt.wantStatus {
+				t.Errorf("Got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("list", func(t *testing.T) {
+		for status, want := range map[string]int{"": 0, "approved": 1, "all": 1} {
+			rr := send("GET", "/approvals?status="+status, "", nil)
+			var resp struct {
+				Approvals []*Approval `json:"approvals"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to unmarshal response %q: %v", rr.Body.String(), err)
+			}
+			if len(resp.Approvals) != want {
+				t.Errorf("Listed %d approvals for status %q, want %d", len(resp.Approvals), status, want)
+			}
+		}
+	})
+}
+
+func TestHandlerApproverRoles(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DefaultApprovalConfig()
+	q := NewQueue(&cfg, NewMemoryStore())
+	a, _ := q.Request(ctx, deploy("alice"))
+
+	// The admin server puts the handler behind the approver roles
+	handler := middleware.RoleMiddleware(cfg.ApproverRoles...)(q.Handler())
+	bob := &middleware.User{ID: "bob", Roles: []string{"dev"}}
+	admin := &middleware.User{ID: "carol", Roles: []string{"admin"}}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		user       *middleware.User
+		wantStatus int
+	}{
+		{"list", "GET", "/approvals", bob, http.StatusForbidden},
+		{"get", "GET", "/approvals/" + a.ID, bob, http.StatusForbidden},
+		{"decide", "POST", "/approvals/" + a.ID + "/approve", bob, http.StatusForbidden},
+		{"list as admin", "GET", "/approvals", admin, http.StatusOK},
+		{"get as admin", "GET", "/approvals/" + a.ID, admin, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req = req.WithContext(middleware.WithUser(req.Context(), tt.user))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+
+	if got, _ := q.Check(ctx, a.ID); got.Status != Pending {
+		t.Errorf("Approval is %s, want it still pending", got.Status)
+	}
+}
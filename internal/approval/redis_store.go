@@ -0,0 +1,149 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxUpdateAttempts bounds the retries of an update that raced with
+// another one
+const maxUpdateAttempts = 5
+
+// RedisStore implements Store using Redis, so approvals survive gateway
+// restarts and are shared by every instance of a cluster
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore creates a new Redis-based store
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Create implements the Store interface
+func (s *RedisStore) Create(ctx context.Context, a *Approval, ttl time.Duration) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, s.getKey(a.ID), data, ttl)
+	pipe.Set(ctx, s.getFingerprintKey(a.Fingerprint), a.ID, ttl)
+	pipe.SAdd(ctx, s.getIndexKey(), a.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Get implements the Store interface
+func (s *RedisStore) Get(ctx context.Context, id string) (*Approval, error) {
+	return s.get(ctx, s.client, id)
+}
+
+// Find implements the Store interface
+func (s *RedisStore) Find(ctx context.Context, fingerprint string) (*Approval, error) {
+	id, err := s.client.Get(ctx, s.getFingerprintKey(fingerprint)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id)
+}
+
+// Update implements the Store interface. The approval is watched, so an
+// update racing with another one is retried on the new state.
+func (s *RedisStore) Update(ctx context.Context, id string, fn func(a *Approval) error) (*Approval, error) {
+	key := s.getKey(id)
+	var updated *Approval
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			a, err := s.get(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if err := fn(a); err != nil {
+				return err
+			}
+			data, err := json.Marshal(a)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, data, redis.KeepTTL)
+				return nil
+			})
+			updated = a
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return updated, err
+		}
+	}
+	return nil, redis.TxFailedErr
+}
+
+// List implements the Store interface
+func (s *RedisStore) List(ctx context.Context) ([]*Approval, error) {
+	ids, err := s.client.SMembers(ctx, s.getIndexKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var approvals []*Approval
+	for _, id := range ids {
+		a, err := s.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			// Clean up the ID of an approval past its retention
+			_ = s.client.SRem(ctx, s.getIndexKey(), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, a)
+	}
+	return approvals, nil
+}
+
+// get reads an approval with the given client or transaction
+func (s *RedisStore) get(ctx context.Context, c redis.Cmdable, id string) (*Approval, error) {
+	data, err := c.Get(ctx, s.getKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var a Approval
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// getKey returns the Redis key for an approval
+func (s *RedisStore) getKey(id string) string {
+	return s.prefix + ":approval:" + id
+}
+
+// getFingerprintKey returns the Redis key holding the ID of the most
+// recent approval with a fingerprint
+func (s *RedisStore) getFingerprintKey(fingerprint string) string {
+	return s.prefix + ":fingerprint:" + fingerprint
+}
+
+// getIndexKey returns the Redis key of the set of approval IDs
+func (s *RedisStore) getIndexKey() string {
+	return s.prefix + ":approvals"
+}
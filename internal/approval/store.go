@@ -0,0 +1,133 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned for an approval that does not exist or has
+// passed its retention
+var ErrNotFound = errors.New("approval not found")
+
+// Store keeps approvals for a limited time
+type Store interface {
+	// Create stores a new approval for ttl
+	Create(ctx context.Context, a *Approval, ttl time.Duration) error
+
+	// Get returns the approval with the given ID
+	Get(ctx context.Context, id string) (*Approval, error)
+
+	// Find returns the most recent approval with the given fingerprint
+	Find(ctx context.Context, fingerprint string) (*Approval, error)
+
+	// Update applies fn to the approval with the given ID and stores the
+	// result, atomically with respect to other updates. An error returned
+	// by fn is returned as is and nothing is stored.
+	Update(ctx context.Context, id string, fn func(a *Approval) error) (*Approval, error)
+
+	// List returns every approval that has not passed its retention
+	List(ctx context.Context) ([]*Approval, error)
+}
+
+// MemoryStore implements Store in memory, for a single gateway instance.
+// Approvals are lost when the gateway restarts.
+type MemoryStore struct {
+	mu           sync.Mutex
+	approvals    map[string]*memoryEntry
+	fingerprints map[string]string
+}
+
+// memoryEntry is a stored approval and the time it expires
+type memoryEntry struct {
+	approval Approval
+	expiry   time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		approvals:    make(map[string]*memoryEntry),
+		fingerprints: make(map[string]string),
+	}
+}
+
+// Create implements the Store interface
+func (s *MemoryStore) Create(ctx context.Context, a *Approval, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+	s.approvals[a.ID] = &memoryEntry{approval: *a, expiry: now.Add(ttl)}
+	s.fingerprints[a.Fingerprint] = a.ID
+	return nil
+}
+
+// Get implements the Store interface
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.approvals[id]
+	if !ok || !time.Now().Before(e.expiry) {
+		return nil, ErrNotFound
+	}
+	a := e.approval
+	return &a, nil
+}
+
+// Find implements the Store interface
+func (s *MemoryStore) Find(ctx context.Context, fingerprint string) (*Approval, error) {
+	s.mu.Lock()
+	id, ok := s.fingerprints[fingerprint]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.Get(ctx, id)
+}
+
+// Update implements the Store interface
+func (s *MemoryStore) Update(ctx context.Context, id string, fn func(a *Approval) error) (*Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.approvals[id]
+	if !ok || !time.Now().Before(e.expiry) {
+		return nil, ErrNotFound
+	}
+	a := e.approval
+	if err := fn(&a); err != nil {
+		return nil, err
+	}
+	e.approval = a
+	return &a, nil
+}
+
+// List implements the Store interface
+func (s *MemoryStore) List(ctx context.Context) ([]*Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(time.Now())
+	approvals := make([]*Approval, 0, len(s.approvals))
+	for _, e := range s.approvals {
+		a := e.approval
+		approvals = append(approvals, &a)
+	}
+	return approvals, nil
+}
+
+// sweep removes the approvals past their retention
+func (s *MemoryStore) sweep(now time.Time) {
+	for id, e := range s.approvals {
+		if !now.Before(e.expiry) {
+			delete(s.approvals, id)
+			if s.fingerprints[e.approval.Fingerprint] == id {
+				delete(s.fingerprints, e.approval.Fingerprint)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore implements Store using Redis, so cached values and their
+// invalidation are shared by every gateway instance of a cluster
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore creates a new Redis-based store
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Get implements the Store interface
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.client.Get(ctx, s.getKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Set implements the Store interface
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.getKey(key), value, ttl).Err()
+}
+
+// Generation implements the Store interface
+func (s *RedisStore) Generation(ctx context.Context, topic string) (int64, error) {
+	gen, err := s.client.Get(ctx, s.getGenerationKey(topic)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return gen, err
+}
+
+// Invalidate implements the Store interface
+func (s *RedisStore) Invalidate(ctx context.Context, topic string) error {
+	return s.client.Incr(ctx, s.getGenerationKey(topic)).Err()
+}
+
+// getKey returns the Redis key for a cached value
+func (s *RedisStore) getKey(key string) string {
+	return s.prefix + ":entry:" + key
+}
+
+// getGenerationKey returns the Redis key holding the generation of a
+// topic
+func (s *RedisStore) getGenerationKey(topic string) string {
+	return s.prefix + ":generation:" + topic
+}
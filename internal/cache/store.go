@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps cached values for a limited time. Values are grouped in
+// topics, e.g. the tools of the upstream, and invalidating a topic starts
+// a new generation of it; callers include the generation in their keys,
+// so values stored under an older generation are no longer found.
+type Store interface {
+	// Get returns the value stored under key, or false if there is none
+	// or it has expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// Set stores value under key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Generation returns the current generation of topic
+	Generation(ctx context.Context, topic string) (int64, error)
+
+	// Invalidate starts a new generation of topic
+	Invalidate(ctx context.Context, topic string) error
+}
+
+// MemoryStore implements Store in memory, for a single gateway instance
+type MemoryStore struct {
+	mu          sync.Mutex
+	entries     map[string]memoryEntry
+	generations map[string]int64
+	maxEntries  int
+}
+
+// memoryEntry is a cached value and the time it expires
+type memoryEntry struct {
+	value  []byte
+	expiry time.Time
+}
+
+// NewMemoryStore creates an in-memory store holding at most maxEntries
+// values. When it is full, expired values are removed, and new values are
+// not stored until there is room.
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		entries:     make(map[string]memoryEntry),
+		generations: make(map[string]int64),
+		maxEntries:  maxEntries,
+	}
+}
+
+// Get implements the Store interface
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !time.Now().Before(e.expiry) {
+		delete(s.entries, key)
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+// Set implements the Store interface
+func (s *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if _, exists := s.entries[key]; !exists && len(s.entries) >= s.maxEntries {
+		s.sweep(now)
+		if len(s.entries) >= s.maxEntries {
+			return nil
+		}
+	}
+	s.entries[key] = memoryEntry{value: value, expiry: now.Add(ttl)}
+	return nil
+}
+
+// Generation implements the Store interface
+func (s *MemoryStore) Generation(ctx context.Context, topic string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generations[topic], nil
+}
+
+// Invalidate implements the Store interface
+func (s *MemoryStore) Invalidate(ctx context.Context, topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generations[topic]++
+	return nil
+}
+
+// sweep removes the expired values
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, e := range s.entries {
+		if !now.Before(e.expiry) {
+			delete(s.entries, key)
+		}
+	}
+}
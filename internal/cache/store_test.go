@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(2)
+
+	store.Set(ctx, "a", []byte("1"), 50*time.Millisecond)
+	if value, ok, _ := store.Get(ctx, "a"); !ok || string(value) != "1" {
+		t.Fatalf("Get() = %q, %v, want the stored value", value, ok)
+	}
+	if _, ok, _ := store.Get(ctx, "b"); ok {
+		t.Error("Get() found an unknown key")
+	}
+
+	// A full store keeps its values until they expire
+	store.Set(ctx, "b", []byte("2"), time.Minute)
+	store.Set(ctx, "c", []byte("3"), time.Minute)
+	if _, ok, _ := store.Get(ctx, "c"); ok {
+		t.Error("Get() found a value stored in a full store")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, ok, _ := store.Get(ctx, "a"); ok {
+		t.Error("Get() found an expired value")
+	}
+	store.Set(ctx, "c", []byte("3"), time.Minute)
+	if _, ok, _ := store.Get(ctx, "c"); !ok {
+		t.Error("Get() did not find a value stored after an expiry")
+	}
+
+	if gen, _ := store.Generation(ctx, "tools"); gen != 0 {
+		t.Errorf("Generation() = %d for a new topic, want 0", gen)
+	}
+	store.Invalidate(ctx, "tools")
+	if gen, _ := store.Generation(ctx, "tools"); gen != 1 {
+		t.Errorf("Generation() = %d after Invalidate(), want 1", gen)
+	}
+	if gen, _ := store.Generation(ctx, "prompts"); gen != 0 {
+		t.Errorf("Generation() = %d for another topic, want 0", gen)
+	}
+}
@@ -0,0 +1,230 @@
+package capture
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"safectx/internal/contextfilter"
+	"safectx/internal/pipeline"
+	"safectx/internal/policy"
+	"safectx/pkg/schema"
+)
+
+// Request decisions
+const (
+	DecisionForward = "forward"
+	DecisionDeny    = "deny"
+)
+
+// Response decisions
+const (
+	ResponsePass   = "pass"
+	ResponseFilter = "filter"
+	ResponseBlock  = "block"
+)
+
+// secretRuleID identifies the redaction applied to captured messages
+const secretRuleID = "capture-secrets"
+
+// secrets redacts credentials from captured messages before they are
+// written, whatever the response rules of the gateway are
+var secrets = contextfilter.NewResponseFilter(contextfilter.ResponseRule{
+	ID:       secretRuleID,
+	Action:   contextfilter.ActionRedact,
+	Patterns: contextfilter.SecretPatterns,
+})
+
+// Record is a single request captured from gateway traffic, one line of a
+// capture file
+type Record struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	Path      string    `json:"path,omitempty"`
+	// Session is a hash of the MCP session ID, so replays keep requests
+	// of a session together without storing the ID
+	Session string   `json:"session,omitempty"`
+	Subject *Subject `json:"subject,omitempty"`
+	// Request is the request as the client sent it, before the pipeline
+	// changed it, with sensitive keys and secrets redacted
+	Request json.RawMessage `json:"request"`
+	// Decision is DecisionForward or DecisionDeny
+	Decision string    `json:"decision"`
+	Verdicts []Verdict `json:"verdicts,omitempty"`
+	// Error is the error the gateway returned instead of a response, e.g.
+	// for a denial or an unreachable upstream
+	Error *schema.Error `json:"error,omitempty"`
+	// Status is the HTTP status of the upstream response
+	Status int `json:"status,omitempty"`
+	// Response is the upstream response before the response rules ran,
+	// with secrets redacted
+	Response json.RawMessage `json:"response,omitempty"`
+	// Redacted lists the JSON pointers of the secrets redacted from
+	// Response
+	Redacted        []string         `json:"redacted,omitempty"`
+	ResponseVerdict *ResponseVerdict `json:"responseVerdict,omitempty"`
+	// DurationMS is the time from receiving the request to relaying the
+	// response, in milliseconds
+	DurationMS float64 `json:"durationMs"`
+}
+
+// Subject is the captured caller of a request
+type Subject struct {
+	ID     string                 `json:"id,omitempty"`
+	Roles  []string               `json:"roles,omitempty"`
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// Verdict is a captured pipeline verdict other than allow
+type Verdict struct {
+	Stage     string  `json:"stage"`
+	Outcome   string  `json:"outcome"`
+	Rule      string  `json:"rule"`
+	Severity  string  `json:"severity,omitempty"`
+	Path      string  `json:"path,omitempty"`
+	Reason    string  `json:"reason,omitempty"`
+	Score     float64 `json:"score,omitempty"`
+	Monitored bool    `json:"monitored,omitempty"`
+}
+
+// ResponseVerdict is the captured outcome of the response rules
+type ResponseVerdict struct {
+	// Decision is ResponsePass, ResponseFilter or ResponseBlock
+	Decision  string                  `json:"decision"`
+	BlockedBy string                  `json:"blockedBy,omitempty"`
+	Findings  []contextfilter.Finding `json:"findings,omitempty"`
+}
+
+// Start begins the record of a call. It must be called before the call
+// runs through the pipeline, so the request is captured as it was sent.
+func Start(call *pipeline.Call) *Record {
+	rec := &Record{
+		Time:      time.Now().UTC(),
+		RequestID: call.CorrelationID,
+		Path:      call.Path,
+		Request:   redactRequest(call.Request),
+	}
+	if call.Session != "" {
+		sum := sha256.Sum256([]byte(call.Session))
+		rec.Session = hex.EncodeToString(sum[:16])
+	}
+	if s := call.Subject; s != nil {
+		rec.Subject = &Subject{ID: s.ID, Roles: s.Roles, Claims: s.Claims}
+	}
+	return rec
+}
+
+// Screened records the pipeline result of the call
+func (r *Record) Screened(result *pipeline.Result) {
+	if r == nil {
+		return
+	}
+	r.Decision, r.Verdicts = requestDecision(result)
+}
+
+// Forwarded records the HTTP status of the upstream response
+func (r *Record) Forwarded(status int) {
+	if r == nil {
+		return
+	}
+	r.Status = status
+}
+
+// Respond records the upstream response and the verdict of the response
+// rules on it
+func (r *Record) Respond(data []byte, verdict *contextfilter.ResponseVerdict) {
+	if r == nil {
+		return
+	}
+	r.Response, r.Redacted = redactSecrets(data)
+	r.ResponseVerdict = responseDecision(verdict)
+}
+
+// Finish records the error returned to the client, if any, and the time
+// spent on the request
+func (r *Record) Finish(rpcErr *schema.Error) {
+	if r == nil {
+		return
+	}
+	r.Error = rpcErr
+	r.DurationMS = float64(time.Since(r.Time).Microseconds()) / 1000
+}
+
+// subject returns the policy subject of the record, or nil if the request
+// was anonymous
+func (r *Record) subject() *policy.Subject {
+	if r.Subject == nil {
+		return nil
+	}
+	return &policy.Subject{ID: r.Subject.ID, Roles: r.Subject.Roles, Claims: r.Subject.Claims}
+}
+
+// requestDecision summarizes a pipeline result
+func requestDecision(result *pipeline.Result) (string, []Verdict) {
+	decision := DecisionForward
+	if result.Denial != nil {
+		decision = DecisionDeny
+	}
+	var verdicts []Verdict
+	for _, v := range result.Verdicts {
+		verdicts = append(verdicts, Verdict{
+			Stage:     v.Stage,
+			Outcome:   string(v.Outcome),
+			Rule:      v.RuleID,
+			Severity:  v.Severity,
+			Path:      v.Path,
+			Reason:    v.Reason,
+			Score:     v.Score,
+			Monitored: v.Monitored,
+		})
+	}
+	return decision, verdicts
+}
+
+// responseDecision summarizes the verdict of the response rules
+func responseDecision(verdict *contextfilter.ResponseVerdict) *ResponseVerdict {
+	if verdict == nil {
+		return &ResponseVerdict{Decision: ResponsePass}
+	}
+	decision := ResponsePass
+	switch {
+	case verdict.Blocked():
+		decision = ResponseBlock
+	case verdict.Changed():
+		decision = ResponseFilter
+	}
+	return &ResponseVerdict{Decision: decision, BlockedBy: verdict.BlockedBy, Findings: verdict.Findings}
+}
+
+// redactRequest returns a copy of req with sensitive keys and secrets
+// redacted
+func redactRequest(req *schema.MCPRequest) json.RawMessage {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil
+	}
+	if clone, err := schema.ParseRequest(data); err == nil {
+		contextfilter.Redact(clone)
+		if data, err = json.Marshal(clone); err != nil {
+			return nil
+		}
+	}
+	out, _ := redactSecrets(data)
+	return out
+}
+
+// redactSecrets replaces the secrets in every string of a JSON message and
+// returns the JSON pointers of the replaced strings. Messages that are not
+// valid JSON are not captured.
+func redactSecrets(data []byte) (json.RawMessage, []string) {
+	out, verdict, err := secrets.FilterResult(data)
+	if err != nil {
+		return nil, nil
+	}
+	var paths []string
+	for _, f := range verdict.Findings {
+		paths = append(paths, f.Path)
+	}
+	return append(json.RawMessage(nil), out...), paths
+}
@@ -0,0 +1,163 @@
+package capture
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"safectx/internal/contextfilter"
+	"safectx/internal/pipeline"
+	"safectx/pkg/schema"
+)
+
+// Kinds of changed decisions
+const (
+	// ChangeRequest is a request that is forwarded in one run and denied
+	// in the other, or denied by a different rule
+	ChangeRequest = "request"
+	// ChangeVerdicts is a request with the same decision but different
+	// flags, mutations or monitored verdicts
+	ChangeVerdicts = "verdicts"
+	// ChangeResponse is a response the response rules treat differently
+	ChangeResponse = "response"
+)
+
+// Inspector evaluates requests and responses without forwarding, logging
+// or counting them. rpc.Inspector implements it.
+type Inspector interface {
+	// Explain runs a request through the pipeline
+	Explain(call *pipeline.Call) (*pipeline.Result, *schema.Error)
+	// ExplainResponse runs a response through the response rules
+	ExplainResponse(data []byte) *contextfilter.ResponseVerdict
+}
+
+// Change is a decision that differs between the captured run and the
+// replay
+type Change struct {
+	RequestID string `json:"requestId"`
+	Method    string `json:"method"`
+	Kind      string `json:"kind"`
+	Before    string `json:"before"`
+	After     string `json:"after"`
+}
+
+// String describes the change on a single line
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s: %s %s -> %s", c.RequestID, c.Method, c.Kind, c.Before, c.After)
+}
+
+// Report lists the decisions that changed in a replay
+type Report struct {
+	// Replayed is the number of records that were replayed
+	Replayed int `json:"replayed"`
+	// Skipped is the number of records whose request could not be parsed
+	Skipped int      `json:"skipped"`
+	Changes []Change `json:"changes"`
+}
+
+// Replay runs captured requests, and the responses to the requests that
+// are still forwarded, through inspector and reports every decision that
+// differs from the capture. Nothing is sent upstream.
+func Replay(records []Record, inspector Inspector) *Report {
+	report := &Report{}
+	for i := range records {
+		rec := &records[i]
+		req, err := schema.ParseRequest(rec.Request)
+		if err != nil {
+			report.Skipped++
+			continue
+		}
+		report.Replayed++
+
+		change := func(kind, before, after string) {
+			report.Changes = append(report.Changes, Change{
+				RequestID: rec.RequestID,
+				Method:    req.Method,
+				Kind:      kind,
+				Before:    before,
+				After:     after,
+			})
+		}
+
+		call := &pipeline.Call{Request: req, Subject: rec.subject(), Path: rec.Path, CorrelationID: rec.RequestID, Session: rec.Session}
+		result, _ := inspector.Explain(call)
+		decision, verdicts := requestDecision(result)
+
+		before, after := describeRequest(rec.Decision, rec.Verdicts), describeRequest(decision, verdicts)
+		if before != after {
+			change(ChangeRequest, before, after)
+			continue
+		}
+		if before, after := describeVerdicts(rec.Verdicts), describeVerdicts(verdicts); before != after {
+			change(ChangeVerdicts, before, after)
+		}
+
+		if decision != DecisionForward || len(rec.Response) == 0 || rec.ResponseVerdict == nil {
+			continue
+		}
+		replayed := inspector.ExplainResponse(rec.Response)
+		before, after = describeResponse(rec.ResponseVerdict.Findings, rec.Redacted), describeResponse(replayed.Findings, rec.Redacted)
+		if before != after {
+			change(ChangeResponse, before, after)
+		}
+	}
+	return report
+}
+
+// describeRequest summarizes a request decision and the rule that denied
+// it, if any
+func describeRequest(decision string, verdicts []Verdict) string {
+	if decision != DecisionDeny {
+		return decision
+	}
+	for i := len(verdicts) - 1; i >= 0; i-- {
+		if v := verdicts[i]; v.Outcome == string(pipeline.Deny) && !v.Monitored {
+			return fmt.Sprintf("%s by %s/%s", decision, v.Stage, v.Rule)
+		}
+	}
+	return decision
+}
+
+// describeVerdicts lists the verdicts of a request
+func describeVerdicts(verdicts []Verdict) string {
+	if len(verdicts) == 0 {
+		return "none"
+	}
+	parts := make([]string, len(verdicts))
+	for i, v := range verdicts {
+		parts[i] = fmt.Sprintf("%s %s/%s", v.Outcome, v.Stage, v.Rule)
+		if v.Monitored {
+			parts[i] += " (monitored)"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// describeResponse summarizes the decision of the response rules and the
+// rules behind it. Findings in the secrets redacted on capture are left
+// out, since the replay cannot see what was there.
+func describeResponse(findings []contextfilter.Finding, redacted []string) string {
+	decision := ResponsePass
+	var rules []string
+	for _, f := range findings {
+		if slices.Contains(redacted, "/result"+f.Path) {
+			continue
+		}
+		rule := f.Action + " " + f.RuleID
+		switch {
+		case f.Monitored:
+			rule += " (monitored)"
+		case f.Action == contextfilter.ActionBlock:
+			decision = ResponseBlock
+		case decision == ResponsePass:
+			decision = ResponseFilter
+		}
+		if !slices.Contains(rules, rule) {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return decision
+	}
+	return decision + " by " + strings.Join(rules, ", ")
+}
@@ -0,0 +1,88 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// maxLineSize bounds a single record when a capture file is read
+const maxLineSize = 64 << 20
+
+// Writer appends records to a capture file, one JSON object per line
+type Writer struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewWriter opens the capture file at path for appending, creating it if
+// it does not exist. The file is only readable by its owner.
+func NewWriter(path string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open capture file: %w", err)
+	}
+	return &Writer{file: file}, nil
+}
+
+// Write appends a record. Errors are logged, so capture never fails a
+// request. A nil writer or record is ignored.
+func (w *Writer) Write(rec *Record) {
+	if w == nil || rec == nil {
+		return
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("Error encoding capture record %s: %v", rec.RequestID, err)
+		return
+	}
+	data = append(data, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.file.Write(data); err != nil {
+		log.Printf("Error writing capture record %s: %v", rec.RequestID, err)
+	}
+}
+
+// Close closes the capture file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// Read decodes the records of a capture file
+func Read(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("capture line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ReadFile decodes the records of the capture file at path
+func ReadFile(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open capture file: %w", err)
+	}
+	defer file.Close()
+	return Read(file)
+}
@@ -0,0 +1,58 @@
+package config
+
+import "time"
+
+// EffectApprove is the policy effect holding a request until a human
+// approves it
+const EffectApprove = "approve"
+
+// ApprovalConfig holds the settings for the approval queue, which holds
+// requests matching a policy rule with the approve effect until a human
+// decides them
+type ApprovalConfig struct {
+	// Timeout is how long a held request waits for a decision before it is
+	// rejected. An approved request must also be retried within Timeout.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// ApproverRoles limits listing, reading and deciding approvals to
+	// callers with any of these roles. Empty allows any authenticated
+	// caller; requesters can never decide their own approvals.
+	ApproverRoles []string `yaml:"approverRoles"`
+
+	// Retention is how long approvals are kept after they were created,
+	// for retries and audit
+	Retention time.Duration `yaml:"retention"`
+
+	// Store keeps the approvals: "memory" (default) for a single instance,
+	// or "redis" so they survive restarts and are shared across a cluster
+	Store string `yaml:"store"`
+
+	// Redis holds the connection settings when Store is "redis"
+	Redis RedisConfig `yaml:"redis"`
+
+	// KeyPrefix namespaces the keys in Redis
+	KeyPrefix string `yaml:"keyPrefix"`
+}
+
+// DefaultApprovalConfig returns a configuration holding requests for five
+// minutes and keeping approvals in memory for a day
+func DefaultApprovalConfig() ApprovalConfig {
+	return ApprovalConfig{
+		Timeout:       5 * time.Minute,
+		ApproverRoles: []string{"admin"},
+		Retention:     24 * time.Hour,
+		Store:         "memory",
+		KeyPrefix:     "safectx:approval",
+	}
+}
+
+// RequiresApproval reports whether any rule of the policy holds requests
+// for approval
+func (c *PolicyConfig) RequiresApproval() bool {
+	for _, rule := range c.Rules {
+		if rule.Effect == EffectApprove {
+			return true
+		}
+	}
+	return false
+}
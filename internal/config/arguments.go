@@ -0,0 +1,36 @@
+package config
+
+// ArgumentsStage is the name of the pipeline stage validating tools/call
+// arguments against the input schema of the tool
+const ArgumentsStage = "arguments"
+
+// Effects of the arguments stage on calls of tools without a known schema
+const (
+	UnknownToolsAllow = "allow"
+	UnknownToolsDeny  = "deny"
+)
+
+// ArgumentsConfig holds the settings for the arguments stage
+type ArgumentsConfig struct {
+	// Schemas pins the input schema of tools: it maps tool names to JSON
+	// Schema files. A pinned schema takes precedence over the one the
+	// upstream publishes.
+	Schemas map[string]string `yaml:"schemas"`
+
+	// Learn takes the input schemas of the other tools from the tools/list
+	// results of the upstream
+	Learn bool `yaml:"learn"`
+
+	// UnknownTools decides the calls of tools without a known schema:
+	// "allow" (default) or "deny"
+	UnknownTools string `yaml:"unknownTools"`
+}
+
+// DefaultArgumentsConfig returns a configuration learning the schemas from
+// the upstream and allowing calls of tools it has not listed yet
+func DefaultArgumentsConfig() ArgumentsConfig {
+	return ArgumentsConfig{
+		Learn:        true,
+		UnknownTools: UnknownToolsAllow,
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import "time"
+
+// CacheableMethods are the idempotent MCP methods whose results may be
+// cached
+var CacheableMethods = []string{
+	"tools/list",
+	"prompts/list",
+	"resources/list",
+	"resources/templates/list",
+	"resources/read",
+}
+
+// CacheConfig holds the settings for caching the results of idempotent
+// MCP methods in the gateway
+type CacheConfig struct {
+	// Methods maps the cached methods to how long their results are kept.
+	// Methods that are not listed are not cached.
+	Methods map[string]time.Duration `yaml:"methods"`
+
+	// PerUser lists the methods whose results are cached for each user
+	// separately, because the upstream may answer them differently
+	// depending on the caller's credentials. Other results are shared and
+	// only reduced to the caller's view when they are served.
+	PerUser []string `yaml:"perUser"`
+
+	// Store keeps the cached results: "memory" (default) for a single
+	// instance, or "redis" to share them across a cluster
+	Store string `yaml:"store"`
+
+	// MaxEntries bounds the results kept by the memory store
+	MaxEntries int `yaml:"maxEntries"`
+
+	// Redis holds the connection settings when Store is "redis"
+	Redis RedisConfig `yaml:"redis"`
+
+	// KeyPrefix namespaces the keys in Redis
+	KeyPrefix string `yaml:"keyPrefix"`
+}
+
+// DefaultCacheConfig returns a configuration caching nothing until methods
+// are listed, with resources read per user
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		PerUser:    []string{"resources/read"},
+		Store:      "memory",
+		MaxEntries: 10000,
+		KeyPrefix:  "safectx:cache",
+	}
+}
+
+// TTL returns how long the results of method are cached, or false if they
+// are not
+func (c *CacheConfig) TTL(method string) (time.Duration, bool) {
+	ttl, ok := c.Methods[method]
+	return ttl, ok && ttl > 0
+}
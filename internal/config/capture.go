@@ -0,0 +1,8 @@
+package config
+
+// CaptureConfig holds the settings for recording gateway traffic
+type CaptureConfig struct {
+	// Path is the JSONL file every request is appended to, with its
+	// verdicts, upstream response and timing. Empty disables capture.
+	Path string `yaml:"path"`
+}
@@ -0,0 +1,38 @@
+package config
+
+import "time"
+
+// ChatCompletionsConfig holds the settings for fronting a model server
+// that speaks the OpenAI-compatible chat completions API
+type ChatCompletionsConfig struct {
+	// Path is the URL path chat completion requests are accepted on, e.g.
+	// "/v1/chat/completions". Empty disables the endpoint.
+	Path string `yaml:"path"`
+
+	// Upstream is the URL of the chat completions endpoint of the model
+	// server, e.g. "http://localhost:8000/v1/chat/completions"
+	Upstream string `yaml:"upstream"`
+
+	// APIKeyEnv names the environment variable holding the API key sent
+	// to the upstream as a bearer token. The Authorization header of the
+	// client authenticates it to the gateway and is not passed on.
+	APIKeyEnv string `yaml:"apiKeyEnv"`
+
+	// Timeout bounds a completion, including every chunk of a streamed
+	// one
+	Timeout time.Duration `yaml:"timeout"`
+
+	// StreamHoldback is the number of characters of streamed content held
+	// back per choice until the next delta arrives, so a pattern split
+	// across deltas is found if it is no longer than this
+	StreamHoldback int `yaml:"streamHoldback"`
+}
+
+// DefaultChatCompletionsConfig returns a disabled endpoint with a timeout
+// long enough for slow completions
+func DefaultChatCompletionsConfig() ChatCompletionsConfig {
+	return ChatCompletionsConfig{
+		Timeout:        5 * time.Minute,
+		StreamHoldback: 256,
+	}
+}
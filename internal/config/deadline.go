@@ -0,0 +1,23 @@
+package config
+
+import "time"
+
+// DeadlineConfig bounds how long a forwarded request may take, from
+// sending it upstream to the end of its response
+type DeadlineConfig struct {
+	// Methods maps JSON-RPC method names to their deadline
+	Methods map[string]time.Duration `yaml:"methods"`
+
+	// Tools maps tool names to the deadline of their tools/call requests.
+	// It takes precedence over the deadline of tools/call.
+	Tools map[string]time.Duration `yaml:"tools"`
+}
+
+// Deadline returns the deadline of a request for method, calling tool if
+// it is a tools/call request, or 0 if none is configured
+func (c *DeadlineConfig) Deadline(method, tool string) time.Duration {
+	if d, ok := c.Tools[tool]; ok && tool != "" {
+		return d
+	}
+	return c.Methods[method]
+}
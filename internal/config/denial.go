@@ -0,0 +1,28 @@
+package config
+
+// Denial detail levels
+const (
+	// DetailMinimal only tells the client the request ID to quote when
+	// asking an operator about a denial
+	DetailMinimal = "minimal"
+	// DetailStandard adds the stage, rule and severity of the denial
+	DetailStandard = "standard"
+	// DetailFull adds the matched field path and the reason, which can
+	// reveal how rules are written
+	DetailFull = "full"
+)
+
+// DenialConfig decides how much of a denial is exposed to clients in the
+// JSON-RPC error data. The gateway log always has every detail.
+type DenialConfig struct {
+	// Detail is "minimal", "standard" (default) or "full"
+	Detail string `yaml:"detail"`
+}
+
+// DefaultDenialConfig returns a configuration exposing the stage, rule
+// and severity of denials
+func DefaultDenialConfig() DenialConfig {
+	return DenialConfig{
+		Detail: DetailStandard,
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+// Enforcement modes
+const (
+	// ModeEnforce applies verdicts: requests are blocked and content is
+	// redacted
+	ModeEnforce = "enforce"
+	// ModeMonitor only records what would have been blocked or redacted in
+	// logs and metrics, and lets messages through unchanged
+	ModeMonitor = "monitor"
+)
+
+// ResponseStage is the stage name of the response rules, which run
+// outside the request pipeline
+const ResponseStage = "response"
+
+// EnforcementConfig decides whether the inspection stages enforce their
+// verdicts or only record them. The most specific setting wins: a rule
+// mode over a stage mode over the global mode.
+type EnforcementConfig struct {
+	// Mode applies to every stage and rule without its own mode
+	Mode string `yaml:"mode"`
+
+	// Stages set the mode of a stage by name: a pipeline stage, e.g.
+	// "detection", "policy" or "redaction", or "response" for the
+	// response rules
+	Stages map[string]string `yaml:"stages"`
+
+	// Rules set the mode of single rules by ID. Policy and response rules
+	// use their configured IDs, "default" is the policy default effect,
+	// "prompt-injection" the request injection detector and
+	// "sensitive-keys" the request redaction.
+	Rules map[string]string `yaml:"rules"`
+}
+
+// DefaultEnforcementConfig returns a configuration enforcing every stage
+func DefaultEnforcementConfig() EnforcementConfig {
+	return EnforcementConfig{
+		Mode: ModeEnforce,
+	}
+}
@@ -0,0 +1,265 @@
+package config
+
+import "time"
+
+// GatewayConfig holds the request-forwarding configuration of the gateway
+type GatewayConfig struct {
+	// Mode selects how the upstream MCP server is reached:
+	//   "http"       - forward over HTTP to Upstream (default)
+	//   "stdio-http" - launch Stdio.Command and expose it over HTTP
+	//   "stdio"      - launch Stdio.Command and relay between our own
+	//                  stdin/stdout and the child process
+	Mode string `yaml:"mode"`
+
+	// ListenAddr is the address the gateway HTTP server listens on
+	ListenAddr string `yaml:"listenAddr"`
+
+	// Upstream is the MCP server that validated requests are forwarded to
+	// when no Upstreams are configured
+	Upstream UpstreamConfig `yaml:"upstream"`
+
+	// Upstreams are named MCP servers that requests are routed to with
+	// Routing. Setting them puts a single merged MCP server in front of
+	// all of them.
+	Upstreams []NamedUpstreamConfig `yaml:"upstreams"`
+
+	// Routing picks the upstream for each request when Upstreams are set
+	Routing RoutingConfig `yaml:"routing"`
+
+	// Stdio holds the child process settings for the stdio modes
+	Stdio StdioConfig `yaml:"stdio"`
+
+	// WebSocket holds the settings for the WebSocket transport
+	WebSocket WebSocketConfig `yaml:"websocket"`
+
+	// ChatCompletions holds the settings for the OpenAI-compatible chat
+	// completions endpoint
+	ChatCompletions ChatCompletionsConfig `yaml:"chatCompletions"`
+
+	// MaxBatchSize is the maximum number of messages accepted in a single
+	// JSON-RPC batch
+	MaxBatchSize int `yaml:"maxBatchSize"`
+
+	// Policy holds the rules used to authorize requests
+	Policy PolicyConfig `yaml:"policy"`
+
+	// Response holds the rules applied to upstream responses
+	Response ResponseConfig `yaml:"response"`
+
+	// Pipeline orders the stages requests run through
+	Pipeline PipelineConfig `yaml:"pipeline"`
+
+	// Enforcement decides which stages and rules block and redact, and
+	// which only record their verdicts
+	Enforcement EnforcementConfig `yaml:"enforcement"`
+
+	// Denials decides how much detail denial errors expose to clients
+	Denials DenialConfig `yaml:"denials"`
+
+	// ReplayProtection configures the replay stage
+	ReplayProtection ReplayProtectionConfig `yaml:"replayProtection"`
+
+	// Deadlines bound forwarded requests by method and tool
+	Deadlines DeadlineConfig `yaml:"deadlines"`
+
+	// Handshake configures the handshake stage
+	Handshake HandshakeConfig `yaml:"handshake"`
+
+	// Arguments configures the arguments stage
+	Arguments ArgumentsConfig `yaml:"arguments"`
+
+	// ServerRequests configures the server-requests stage
+	ServerRequests ServerRequestsConfig `yaml:"serverRequests"`
+
+	// Cache configures caching the results of idempotent methods
+	Cache CacheConfig `yaml:"cache"`
+
+	// Approvals configures the queue of requests held for approval
+	Approvals ApprovalConfig `yaml:"approvals"`
+
+	// Capture records gateway traffic for offline replay
+	Capture CaptureConfig `yaml:"capture"`
+
+	// Admin holds the settings for the admin endpoints, e.g. upstream
+	// health
+	Admin AdminConfig `yaml:"admin"`
+}
+
+// UpstreamConfig holds the settings for a single upstream MCP server
+type UpstreamConfig struct {
+	// URL is the JSON-RPC endpoint of the upstream server
+	URL string `yaml:"url"`
+
+	// Timeout bounds the time spent waiting for the upstream's complete
+	// response. For streamed (SSE) responses it only bounds the wait for
+	// the stream to start.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// AllowedHeaders lists the client request headers that are passed
+	// through to the upstream. All other client headers are dropped.
+	AllowedHeaders []string `yaml:"allowedHeaders"`
+
+	// Endpoints are further URLs serving the same MCP server. Requests are
+	// balanced across URL and Endpoints; URL may be left empty when
+	// Endpoints are set.
+	Endpoints []string `yaml:"endpoints"`
+
+	// Balancing picks the endpoint for each request:
+	//   "round-robin"     - cycle through the endpoints (default)
+	//   "least-in-flight" - the endpoint with the fewest open requests
+	Balancing string `yaml:"balancing"`
+
+	// HealthCheck configures the active health checks of the endpoints
+	HealthCheck HealthCheckConfig `yaml:"healthCheck"`
+
+	// CircuitBreaker configures the per-endpoint circuit breakers
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuitBreaker"`
+
+	// Retry configures retries of idempotent requests on other endpoints
+	Retry RetryConfig `yaml:"retry"`
+}
+
+// HealthCheckConfig holds the settings for active endpoint health checks.
+// An endpoint is healthy while it answers the check method with a non-5xx
+// status, even if it rejects the request itself.
+type HealthCheckConfig struct {
+	// Interval is the time between checks. 0 disables health checks.
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout bounds a single check
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Method is the JSON-RPC method sent as the check
+	Method string `yaml:"method"`
+
+	// UnhealthyThreshold is the number of consecutive failed checks after
+	// which an endpoint stops receiving requests
+	UnhealthyThreshold int `yaml:"unhealthyThreshold"`
+
+	// HealthyThreshold is the number of consecutive passed checks after
+	// which an unhealthy endpoint receives requests again
+	HealthyThreshold int `yaml:"healthyThreshold"`
+}
+
+// CircuitBreakerConfig holds the settings for per-endpoint circuit breakers
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed requests that
+	// opens the circuit. 0 disables the breaker.
+	FailureThreshold int `yaml:"failureThreshold"`
+
+	// OpenDuration is how long an open circuit rejects requests before a
+	// probe request is let through
+	OpenDuration time.Duration `yaml:"openDuration"`
+}
+
+// RetryConfig holds the settings for retrying failed requests
+type RetryConfig struct {
+	// MaxAttempts bounds the attempts per request, including the first.
+	// Each attempt goes to a different endpoint.
+	MaxAttempts int `yaml:"maxAttempts"`
+
+	// Methods are the idempotent JSON-RPC methods that may be retried
+	Methods []string `yaml:"methods"`
+}
+
+// AdminConfig holds the settings for the admin HTTP server
+type AdminConfig struct {
+	// ListenAddr is the address the admin server listens on. Empty
+	// disables the admin server.
+	ListenAddr string `yaml:"listenAddr"`
+
+	// ExplainRoles limits /explain to authenticated callers with any of
+	// these roles. Empty allows any authenticated caller.
+	ExplainRoles []string `yaml:"explainRoles"`
+}
+
+// StdioConfig holds the settings for an MCP server that speaks JSON-RPC
+// over stdin/stdout
+type StdioConfig struct {
+	// Command is the executable to launch
+	Command string `yaml:"command"`
+
+	// Args are the command line arguments passed to Command
+	Args []string `yaml:"args"`
+
+	// Env holds extra KEY=value environment variables for the child process
+	Env []string `yaml:"env"`
+
+	// Dir is the working directory of the child process
+	Dir string `yaml:"dir"`
+
+	// RequestTimeout bounds the time spent waiting for a response
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+
+	// RestartDelay is the initial delay before restarting a crashed process.
+	// It doubles after every crash up to MaxRestartDelay.
+	RestartDelay time.Duration `yaml:"restartDelay"`
+
+	// MaxRestartDelay caps the restart backoff
+	MaxRestartDelay time.Duration `yaml:"maxRestartDelay"`
+
+	// ShutdownTimeout is how long the process gets to exit after its stdin
+	// is closed before it is killed
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// DefaultGatewayConfig returns a default gateway configuration
+func DefaultGatewayConfig() *GatewayConfig {
+	return &GatewayConfig{
+		Mode:         "http",
+		ListenAddr:   ":8080",
+		MaxBatchSize: 50,
+		Upstream: UpstreamConfig{
+			Timeout:        30 * time.Second,
+			AllowedHeaders: []string{"User-Agent", "X-Request-ID"},
+			Balancing:      "round-robin",
+			HealthCheck: HealthCheckConfig{
+				Interval:           10 * time.Second,
+				Timeout:            2 * time.Second,
+				Method:             "ping",
+				UnhealthyThreshold: 3,
+				HealthyThreshold:   2,
+			},
+			CircuitBreaker: CircuitBreakerConfig{
+				FailureThreshold: 5,
+				OpenDuration:     30 * time.Second,
+			},
+			Retry: RetryConfig{
+				MaxAttempts: 2,
+				Methods: []string{
+					"ping",
+					"tools/list",
+					"prompts/list",
+					"prompts/get",
+					"resources/list",
+					"resources/templates/list",
+					"resources/read",
+				},
+			},
+		},
+		Stdio: StdioConfig{
+			RequestTimeout:  30 * time.Second,
+			RestartDelay:    time.Second,
+			MaxRestartDelay: 30 * time.Second,
+			ShutdownTimeout: 5 * time.Second,
+		},
+		Routing:          DefaultRoutingConfig(),
+		WebSocket:        DefaultWebSocketConfig(),
+		ChatCompletions:  DefaultChatCompletionsConfig(),
+		Policy:           DefaultPolicyConfig(),
+		Response:         DefaultResponseConfig(),
+		Pipeline:         DefaultPipelineConfig(),
+		Enforcement:      DefaultEnforcementConfig(),
+		Denials:          DefaultDenialConfig(),
+		ReplayProtection: DefaultReplayProtectionConfig(),
+		Handshake:        DefaultHandshakeConfig(),
+		Arguments:        DefaultArgumentsConfig(),
+		ServerRequests:   DefaultServerRequestsConfig(),
+		Cache:            DefaultCacheConfig(),
+		Approvals:        DefaultApprovalConfig(),
+		Admin: AdminConfig{
+			ListenAddr:   "127.0.0.1:8081",
+			ExplainRoles: []string{"admin"},
+		},
+	}
+}
@@ -0,0 +1,31 @@
+package config
+
+import "time"
+
+// HandshakeStage is the name of the pipeline stage governing the MCP
+// initialize handshake
+const HandshakeStage = "handshake"
+
+// HandshakeConfig holds the settings for the handshake stage, which pins
+// the protocol versions and strips capabilities during initialize
+type HandshakeConfig struct {
+	// ProtocolVersions are the MCP protocol versions clients and servers
+	// may agree on. An empty list allows any version.
+	ProtocolVersions []string `yaml:"protocolVersions"`
+
+	// DenyCapabilities are removed from the capabilities of both the
+	// client and the server, e.g. "sampling" or "tools.listChanged"
+	DenyCapabilities []string `yaml:"denyCapabilities"`
+
+	// SessionTTL is how long the negotiated state of an idle session is
+	// kept
+	SessionTTL time.Duration `yaml:"sessionTTL"`
+}
+
+// DefaultHandshakeConfig returns a configuration allowing every version
+// and capability
+func DefaultHandshakeConfig() HandshakeConfig {
+	return HandshakeConfig{
+		SessionTTL: 24 * time.Hour,
+	}
+}
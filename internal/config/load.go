@@ -0,0 +1,54 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadGatewayConfig reads a YAML gateway configuration file. Settings that
+// are not in the file keep their default values.
+func LoadGatewayConfig(path string) (*GatewayConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	cfg := DefaultGatewayConfig()
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	// Named upstreams inherit the settings they leave unset from upstream
+	for i := range cfg.Upstreams {
+		named := &cfg.Upstreams[i]
+		if named.Timeout == 0 {
+			named.Timeout = cfg.Upstream.Timeout
+		}
+		if named.AllowedHeaders == nil {
+			named.AllowedHeaders = cfg.Upstream.AllowedHeaders
+		}
+		if named.Balancing == "" {
+			named.Balancing = cfg.Upstream.Balancing
+		}
+		if named.HealthCheck == (HealthCheckConfig{}) {
+			named.HealthCheck = cfg.Upstream.HealthCheck
+		}
+		if named.CircuitBreaker == (CircuitBreakerConfig{}) {
+			named.CircuitBreaker = cfg.Upstream.CircuitBreaker
+		}
+		if named.Retry.MaxAttempts == 0 {
+			named.Retry.MaxAttempts = cfg.Upstream.Retry.MaxAttempts
+		}
+		if named.Retry.Methods == nil {
+			named.Retry.Methods = cfg.Upstream.Retry.Methods
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadGatewayConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "safectx.yaml")
+	data := `
+listenAddr: ":9000"
+upstreams:
+  - name: github
+    url: http://github-mcp:8080/mcp
+    timeout: 10s
+  - name: db
+    endpoints:
+      - http://db-mcp-1:8080/mcp
+      - http://db-mcp-2:8080/mcp
+    balancing: least-in-flight
+    retry:
+      maxAttempts: 3
+routing:
+  onCollision: first
+  routes:
+    - upstream: db
+      uriSchemes: [postgres]
+    - upstream: github
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadGatewayConfig(path)
+	if err != nil {
+		t.Fatalf("LoadGatewayConfig() error = %v", err)
+	}
+	if cfg.ListenAddr != ":9000" || cfg.MaxBatchSize != 50 {
+		t.Errorf("Got listen address %q and batch size %d, want file value and default", cfg.ListenAddr, cfg.MaxBatchSize)
+	}
+	if len(cfg.Upstreams) != 2 || cfg.Upstreams[0].Timeout != 10*time.Second || cfg.Upstreams[1].Timeout != 30*time.Second {
+		t.Errorf("Got upstreams %+v", cfg.Upstreams)
+	}
+	if db := cfg.Upstreams[1]; db.Balancing != "least-in-flight" || db.Retry.MaxAttempts != 3 || len(db.Retry.Methods) == 0 ||
+		db.HealthCheck != cfg.Upstream.HealthCheck {
+		t.Errorf("Got pool settings %+v, want file values and inherited health checks", db)
+	}
+	if err := ValidateGatewayConfig(cfg); err != nil {
+		t.Errorf("Loaded config is invalid: %v", err)
+	}
+	if cfg.Routing.OnCollision != "first" || len(cfg.Routing.Routes) != 2 {
+		t.Errorf("Got routing %+v", cfg.Routing)
+	}
+
+	if err := os.WriteFile(path, []byte("listenAdr: \":9000\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadGatewayConfig(path); err == nil {
+		t.Error("LoadGatewayConfig() should reject unknown fields")
+	}
+}
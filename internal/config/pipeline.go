@@ -0,0 +1,62 @@
+package config
+
+// PipelineConfig orders the stages every request runs through before it is
+// forwarded
+type PipelineConfig struct {
+	// Stages are the names of the stages run for requests that match no
+	// route, in order. The built-in stages are "detection", "policy" and
+	// "redaction"; other stages are registered in code.
+	Stages []string `yaml:"stages"`
+
+	// DenyScore denies requests whose verdicts add up to at least this
+	// score. 0 disables the threshold.
+	DenyScore float64 `yaml:"denyScore"`
+
+	// Routes run their own stages for matching requests. The first
+	// matching route wins.
+	Routes []PipelineRoute `yaml:"routes"`
+}
+
+// PipelineRoute runs its own stages for matching requests. Every criterion
+// that is set must match.
+type PipelineRoute struct {
+	// PathPrefix matches the URL path of the client request
+	PathPrefix string `yaml:"pathPrefix"`
+
+	// Methods are JSON-RPC method names or path.Match patterns
+	Methods []string `yaml:"methods"`
+
+	// Stages replace the default stages for matching requests
+	Stages []string `yaml:"stages"`
+}
+
+// StageNames returns the names of every stage used by the pipeline
+func (c *PipelineConfig) StageNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, list := range append([][]string{c.Stages}, routeStages(c.Routes)...) {
+		for _, name := range list {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// routeStages returns the stage lists of the routes
+func routeStages(routes []PipelineRoute) [][]string {
+	lists := make([][]string, len(routes))
+	for i, route := range routes {
+		lists[i] = route.Stages
+	}
+	return lists
+}
+
+// DefaultPipelineConfig returns the built-in stage order
+func DefaultPipelineConfig() PipelineConfig {
+	return PipelineConfig{
+		Stages: []string{"detection", "policy", "redaction"},
+	}
+}
@@ -0,0 +1,52 @@
+package config
+
+// PolicyConfig holds the rules evaluated by the default policy engine
+type PolicyConfig struct {
+	// DefaultEffect applies when no rule matches ("allow" or "deny").
+	// An empty value allows the request.
+	DefaultEffect string `yaml:"defaultEffect"`
+
+	// Rules are evaluated in order and the first matching rule wins
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule allows or denies messages by JSON-RPC method, tool, prompt or
+// resource, optionally only for some callers. Every criterion that is set
+// must match.
+type PolicyRule struct {
+	// ID identifies the rule in logs and denial responses
+	ID string `yaml:"id"`
+
+	// Methods are method names or path.Match patterns, e.g. "notifications/*"
+	Methods []string `yaml:"methods"`
+
+	// Tools are tool names or path.Match patterns. A rule with tools only
+	// matches tools/call requests for those tools.
+	Tools []string `yaml:"tools"`
+
+	// Prompts are prompt names or path.Match patterns. A rule with prompts
+	// only matches prompts/get requests for those prompts.
+	Prompts []string `yaml:"prompts"`
+
+	// Resources are URI prefixes, e.g. "file:///etc/". A rule with resources
+	// only matches resources/read requests for those URIs.
+	Resources []string `yaml:"resources"`
+
+	// Roles limit the rule to callers with any of these roles
+	Roles []string `yaml:"roles"`
+
+	// Claims limit the rule to callers whose claims have these values. A
+	// list-valued claim matches if it contains the value.
+	Claims map[string]string `yaml:"claims"`
+
+	// Effect is the outcome when the rule matches ("allow", "deny", or
+	// "approve" to hold the request until a human approves it)
+	Effect string `yaml:"effect"`
+}
+
+// DefaultPolicyConfig returns a policy that allows every request
+func DefaultPolicyConfig() PolicyConfig {
+	return PolicyConfig{
+		DefaultEffect: "allow",
+	}
+}
@@ -0,0 +1,35 @@
+package config
+
+import "time"
+
+// ReplayStage is the name of the pipeline stage rejecting replayed requests
+const ReplayStage = "replay"
+
+// ReplayProtectionConfig holds the settings for the replay stage, which
+// rejects requests whose ID, or client nonce, was already seen
+type ReplayProtectionConfig struct {
+	// Window is how long request IDs and nonces are remembered. Requests
+	// carrying a timestamp further than Window from the gateway clock are
+	// rejected too.
+	Window time.Duration `yaml:"window"`
+
+	// Store keeps the seen IDs: "memory" (default) for a single instance,
+	// or "redis" to share them across a cluster
+	Store string `yaml:"store"`
+
+	// Redis holds the connection settings when Store is "redis"
+	Redis RedisConfig `yaml:"redis"`
+
+	// KeyPrefix namespaces the keys in Redis
+	KeyPrefix string `yaml:"keyPrefix"`
+}
+
+// DefaultReplayProtectionConfig returns a configuration remembering
+// request IDs in memory for five minutes
+func DefaultReplayProtectionConfig() ReplayProtectionConfig {
+	return ReplayProtectionConfig{
+		Window:    5 * time.Minute,
+		Store:     "memory",
+		KeyPrefix: "safectx:replay",
+	}
+}
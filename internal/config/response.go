@@ -0,0 +1,45 @@
+package config
+
+// ResponseConfig holds the rules applied to the results returned by the
+// upstream before they reach the client
+type ResponseConfig struct {
+	// Rules are applied in order to every string in a response result.
+	// A response is blocked as soon as a blocking rule matches.
+	Rules []ResponseRule `yaml:"rules"`
+}
+
+// ResponseRule scans response content with a detector and decides what to
+// do with a match
+type ResponseRule struct {
+	// ID identifies the rule in logs and annotations
+	ID string `yaml:"id"`
+
+	// Detector selects what the rule looks for:
+	//   "injection" - the prompt injection patterns used for requests
+	//   "secret"    - API keys, tokens and private keys
+	//   "pii"       - email addresses, card and social security numbers
+	//   "pattern"   - the regular expression in Pattern
+	Detector string `yaml:"detector"`
+
+	// Pattern is the regular expression used by the "pattern" detector
+	Pattern string `yaml:"pattern"`
+
+	// Action is applied when the detector matches:
+	//   "block"    - replace the response with an error
+	//   "redact"   - replace the matched text with [REDACTED]
+	//   "annotate" - pass the content through and list the match in the
+	//                result's _meta
+	Action string `yaml:"action"`
+}
+
+// DefaultResponseConfig returns rules that block injected instructions and
+// redact secrets and personal data in upstream results
+func DefaultResponseConfig() ResponseConfig {
+	return ResponseConfig{
+		Rules: []ResponseRule{
+			{ID: "response-injection", Detector: "injection", Action: "block"},
+			{ID: "response-secrets", Detector: "secret", Action: "redact"},
+			{ID: "response-pii", Detector: "pii", Action: "redact"},
+		},
+	}
+}
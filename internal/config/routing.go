@@ -0,0 +1,59 @@
+package config
+
+import "time"
+
+// NamedUpstreamConfig is an upstream MCP server that routes refer to by name
+type NamedUpstreamConfig struct {
+	// Name identifies the upstream in routes, logs and prefixed tool names
+	Name string `yaml:"name"`
+
+	UpstreamConfig `yaml:",inline"`
+}
+
+// RoutingConfig holds the routing table used when several upstreams are
+// configured
+type RoutingConfig struct {
+	// Routes are evaluated in order and the first matching route picks the
+	// upstream. A route without criteria matches every request.
+	Routes []RouteConfig `yaml:"routes"`
+
+	// OnCollision decides what happens when aggregated tools/list results
+	// contain the same tool name from several upstreams:
+	//   "prefix" - expose every copy as "<upstream>__<name>" (default)
+	//   "first"  - keep the tool of the first upstream in Upstreams order
+	OnCollision string `yaml:"onCollision"`
+
+	// SessionTTL is how long the upstream sessions of an idle gateway
+	// session are kept
+	SessionTTL time.Duration `yaml:"sessionTTL"`
+}
+
+// DefaultRoutingConfig returns a routing configuration prefixing colliding
+// tool names
+func DefaultRoutingConfig() RoutingConfig {
+	return RoutingConfig{
+		OnCollision: "prefix",
+		SessionTTL:  24 * time.Hour,
+	}
+}
+
+// RouteConfig sends matching requests to an upstream. Every criterion that
+// is set must match.
+type RouteConfig struct {
+	// Upstream is the name of the upstream that serves matching requests
+	Upstream string `yaml:"upstream"`
+
+	// PathPrefix matches the URL path of the client request
+	PathPrefix string `yaml:"pathPrefix"`
+
+	// Methods are JSON-RPC method names or path.Match patterns
+	Methods []string `yaml:"methods"`
+
+	// Tools are tool names or path.Match patterns matched against
+	// params.name of tools/call requests
+	Tools []string `yaml:"tools"`
+
+	// URISchemes match the scheme of params.uri of resources/* requests,
+	// e.g. "file" or "postgres"
+	URISchemes []string `yaml:"uriSchemes"`
+}
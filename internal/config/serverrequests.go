@@ -0,0 +1,84 @@
+package config
+
+// ServerRequestsStage is the name of the pipeline stage governing the
+// sampling and elicitation requests servers send to the client
+const ServerRequestsStage = "server-requests"
+
+// ServerRequestsConfig holds the settings for the server-requests stage
+type ServerRequestsConfig struct {
+	// Sampling governs sampling/createMessage, which has the client's LLM
+	// generate a message for the server
+	Sampling SamplingConfig `yaml:"sampling"`
+
+	// Elicitation governs elicitation/create, which has the client ask the
+	// user for data on behalf of the server
+	Elicitation ElicitationConfig `yaml:"elicitation"`
+}
+
+// UpstreamFilter allows or denies a server request by the name of the
+// upstream that sent it. The single upstream of a gateway without named
+// upstreams is named "upstream".
+type UpstreamFilter struct {
+	// Allow lists the upstreams that may send the request, as path.Match
+	// patterns. An empty list allows every upstream.
+	Allow []string `yaml:"allow"`
+
+	// Deny lists the upstreams that may not send the request, as
+	// path.Match patterns. It takes precedence over Allow; ["*"] denies
+	// the request altogether.
+	Deny []string `yaml:"deny"`
+}
+
+// SamplingConfig holds the limits on sampling requests
+type SamplingConfig struct {
+	UpstreamFilter `yaml:",inline"`
+
+	// MaxTokens caps the maxTokens of a request; larger values are
+	// lowered to it. 0 leaves them as requested.
+	MaxTokens int `yaml:"maxTokens"`
+
+	// Models lists the model hints a server may give, as path.Match
+	// patterns. Other hints are removed. An empty list keeps every hint.
+	Models []string `yaml:"models"`
+
+	// IncludeContext lists the includeContext values a server may ask
+	// for. "allServers" would share the context of every other server
+	// with the requesting one. An empty list allows every value.
+	IncludeContext []string `yaml:"includeContext"`
+}
+
+// ElicitationConfig holds the limits on elicitation requests
+type ElicitationConfig struct {
+	UpstreamFilter `yaml:",inline"`
+
+	// DenyFields are regular expressions matched against the names,
+	// titles and descriptions of the fields a server asks the user for.
+	// Requests asking for a matching field are denied.
+	DenyFields []string `yaml:"denyFields"`
+}
+
+// DefaultCredentialFields match the elicitation fields asking for
+// credentials or other secrets
+var DefaultCredentialFields = []string{
+	`(?i)passw(or)?d|passphrase|passcode`,
+	`(?i)secret`,
+	`(?i)token`,
+	`(?i)api[\s_-]?key|access[\s_-]?key|private[\s_-]?key`,
+	`(?i)credential`,
+	`(?i)\b(otp|mfa|2fa|totp|pin)\b|one[\s_-]?time`,
+	`(?i)\b(ssn|cvv|cvc)\b|social[\s_-]?security|card[\s_-]?number`,
+}
+
+// DefaultServerRequestsConfig returns a configuration allowing sampling
+// and elicitation from every upstream, with sampling kept to the context
+// of the requesting server and elicitation of credentials denied
+func DefaultServerRequestsConfig() ServerRequestsConfig {
+	return ServerRequestsConfig{
+		Sampling: SamplingConfig{
+			IncludeContext: []string{"none", "thisServer"},
+		},
+		Elicitation: ElicitationConfig{
+			DenyFields: DefaultCredentialFields,
+		},
+	}
+}
@@ -1,8 +1,15 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 )
 
 // ValidationError represents a configuration validation error
@@ -109,7 +116,7 @@ func validateSessionConfig(cfg *SessionConfig) error {
 	}
 
 	if cfg.SessionStoreType == "redis" {
-		if err := validateRedisConfig(&cfg.Redis); err != nil {
+		if err := validateRedisConfig("session.redis", &cfg.Redis); err != nil {
 			return err
 		}
 	}
@@ -117,18 +124,18 @@ func validateSessionConfig(cfg *SessionConfig) error {
 	return nil
 }
 
-// validateRedisConfig validates Redis configuration
-func validateRedisConfig(cfg *RedisConfig) error {
+// validateRedisConfig validates Redis configuration found at field
+func validateRedisConfig(field string, cfg *RedisConfig) error {
 	if cfg.Addr == "" {
 		return &ValidationError{
-			Field:   "session.redis.addr",
+			Field:   field + ".addr",
 			Message: "Redis address must be specified",
 		}
 	}
 
 	if cfg.DB < 0 {
 		return &ValidationError{
-			Field:   "session.redis.db",
+			Field:   field + ".db",
 			Message: "Redis database number must be non-negative",
 		}
 	}
@@ -201,3 +208,921 @@ func validateSAMLConfig(cfg *SAMLConfig) error {
 
 	return nil
 }
+
+// ValidateGatewayConfig validates the gateway configuration
+func ValidateGatewayConfig(cfg *GatewayConfig) error {
+	switch cfg.Mode {
+	case "", "http", "stdio-http", "stdio":
+		// Valid modes
+	default:
+		return &ValidationError{
+			Field:   "mode",
+			Message: "invalid mode, must be 'http', 'stdio-http' or 'stdio'",
+		}
+	}
+
+	if cfg.ListenAddr == "" && cfg.Mode != "stdio" {
+		return &ValidationError{
+			Field:   "listenAddr",
+			Message: "listen address must be specified",
+		}
+	}
+
+	if cfg.MaxBatchSize <= 0 {
+		return &ValidationError{
+			Field:   "maxBatchSize",
+			Message: "max batch size must be greater than 0",
+		}
+	}
+
+	switch {
+	case cfg.Mode == "stdio" || cfg.Mode == "stdio-http":
+		if err := validateStdioConfig(&cfg.Stdio); err != nil {
+			return err
+		}
+	case len(cfg.Upstreams) > 0:
+		if err := validateRoutingConfig(cfg.Upstreams, &cfg.Routing); err != nil {
+			return err
+		}
+	default:
+		if err := validateUpstreamConfig(&cfg.Upstream); err != nil {
+			return err
+		}
+	}
+
+	if cfg.WebSocket.Path != "" {
+		if cfg.Mode == "stdio" {
+			return &ValidationError{
+				Field:   "websocket.path",
+				Message: "the WebSocket transport is not available in stdio mode",
+			}
+		}
+		if err := validateWebSocketConfig(&cfg.WebSocket); err != nil {
+			return err
+		}
+	}
+
+	if cfg.ChatCompletions.Path != "" {
+		if cfg.Mode == "stdio" {
+			return &ValidationError{
+				Field:   "chatCompletions.path",
+				Message: "the chat completions endpoint is not available in stdio mode",
+			}
+		}
+		if cfg.ChatCompletions.Path == cfg.WebSocket.Path {
+			return &ValidationError{
+				Field:   "chatCompletions.path",
+				Message: "path is already used by the WebSocket transport",
+			}
+		}
+		if err := validateChatCompletionsConfig(&cfg.ChatCompletions); err != nil {
+			return err
+		}
+	}
+
+	if err := validatePolicyConfig(&cfg.Policy); err != nil {
+		return err
+	}
+
+	if err := validateResponseConfig(&cfg.Response); err != nil {
+		return err
+	}
+
+	if err := validatePipelineConfig(&cfg.Pipeline); err != nil {
+		return err
+	}
+
+	switch cfg.Denials.Detail {
+	case "", DetailMinimal, DetailStandard, DetailFull:
+	default:
+		return &ValidationError{
+			Field:   "denials.detail",
+			Message: "invalid detail, must be 'minimal', 'standard' or 'full'",
+		}
+	}
+
+	if slices.Contains(cfg.Pipeline.StageNames(), ReplayStage) {
+		if err := validateReplayProtectionConfig(&cfg.ReplayProtection); err != nil {
+			return err
+		}
+	}
+
+	if err := validateDeadlineConfig(&cfg.Deadlines); err != nil {
+		return err
+	}
+
+	if slices.Contains(cfg.Pipeline.StageNames(), HandshakeStage) {
+		if err := validateHandshakeConfig(&cfg.Handshake); err != nil {
+			return err
+		}
+	}
+
+	if slices.Contains(cfg.Pipeline.StageNames(), ArgumentsStage) {
+		if err := validateArgumentsConfig(&cfg.Arguments); err != nil {
+			return err
+		}
+	}
+
+	if slices.Contains(cfg.Pipeline.StageNames(), ServerRequestsStage) {
+		if err := validateServerRequestsConfig(&cfg.ServerRequests); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Policy.RequiresApproval() {
+		if err := validateApprovalConfig(&cfg.Approvals); err != nil {
+			return err
+		}
+	}
+
+	if len(cfg.Cache.Methods) > 0 {
+		if err := validateCacheConfig(&cfg.Cache); err != nil {
+			return err
+		}
+	}
+
+	for _, role := range cfg.Admin.ExplainRoles {
+		if role == "" {
+			return &ValidationError{
+				Field:   "admin.explainRoles",
+				Message: "role must not be empty",
+			}
+		}
+	}
+
+	return ValidateEnforcementConfig(&cfg.Enforcement, cfg.Pipeline.StageNames())
+}
+
+// validateApprovalConfig validates the settings of the approval queue
+func validateApprovalConfig(cfg *ApprovalConfig) error {
+	if cfg.Timeout <= 0 {
+		return &ValidationError{
+			Field:   "approvals.timeout",
+			Message: "timeout must be greater than 0",
+		}
+	}
+
+	if cfg.Retention < cfg.Timeout {
+		return &ValidationError{
+			Field:   "approvals.retention",
+			Message: "retention must not be shorter than the timeout",
+		}
+	}
+
+	for _, role := range cfg.ApproverRoles {
+		if role == "" {
+			return &ValidationError{
+				Field:   "approvals.approverRoles",
+				Message: "role must not be empty",
+			}
+		}
+	}
+
+	switch cfg.Store {
+	case "memory":
+	case "redis":
+		return validateRedisConfig("approvals.redis", &cfg.Redis)
+	default:
+		return &ValidationError{
+			Field:   "approvals.store",
+			Message: "invalid store, must be 'memory' or 'redis'",
+		}
+	}
+	return nil
+}
+
+// validateCacheConfig validates the cached methods and the cache store
+func validateCacheConfig(cfg *CacheConfig) error {
+	for method, ttl := range cfg.Methods {
+		if !slices.Contains(CacheableMethods, method) {
+			return &ValidationError{
+				Field:   "cache.methods",
+				Message: fmt.Sprintf("method %q cannot be cached, must be one of %s", method, strings.Join(CacheableMethods, ", ")),
+			}
+		}
+		if ttl <= 0 {
+			return &ValidationError{
+				Field:   "cache.methods." + method,
+				Message: "TTL must be greater than 0",
+			}
+		}
+	}
+
+	for _, method := range cfg.PerUser {
+		if !slices.Contains(CacheableMethods, method) {
+			return &ValidationError{
+				Field:   "cache.perUser",
+				Message: fmt.Sprintf("method %q cannot be cached", method),
+			}
+		}
+	}
+
+	switch cfg.Store {
+	case "memory":
+		if cfg.MaxEntries <= 0 {
+			return &ValidationError{
+				Field:   "cache.maxEntries",
+				Message: "max entries must be greater than 0",
+			}
+		}
+	case "redis":
+		return validateRedisConfig("cache.redis", &cfg.Redis)
+	default:
+		return &ValidationError{
+			Field:   "cache.store",
+			Message: "invalid store, must be 'memory' or 'redis'",
+		}
+	}
+	return nil
+}
+
+// validateDeadlineConfig validates the per-method and per-tool deadlines
+func validateDeadlineConfig(cfg *DeadlineConfig) error {
+	lists := []struct {
+		field     string
+		deadlines map[string]time.Duration
+	}{
+		{"deadlines.methods", cfg.Methods},
+		{"deadlines.tools", cfg.Tools},
+	}
+	for _, list := range lists {
+		field := list.field
+		for name, d := range list.deadlines {
+			if name == "" {
+				return &ValidationError{
+					Field:   field,
+					Message: "name must not be empty",
+				}
+			}
+			if d <= 0 {
+				return &ValidationError{
+					Field:   field + "." + name,
+					Message: "deadline must be greater than 0",
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateHandshakeConfig validates the settings of the handshake stage
+func validateHandshakeConfig(cfg *HandshakeConfig) error {
+	for _, version := range cfg.ProtocolVersions {
+		if version == "" {
+			return &ValidationError{
+				Field:   "handshake.protocolVersions",
+				Message: "protocol version must not be empty",
+			}
+		}
+	}
+
+	for _, capability := range cfg.DenyCapabilities {
+		if slices.Contains(strings.Split(capability, "."), "") {
+			return &ValidationError{
+				Field:   "handshake.denyCapabilities",
+				Message: fmt.Sprintf("invalid capability: %q", capability),
+			}
+		}
+	}
+
+	if cfg.SessionTTL <= 0 {
+		return &ValidationError{
+			Field:   "handshake.sessionTTL",
+			Message: "session TTL must be greater than 0",
+		}
+	}
+	return nil
+}
+
+// validateArgumentsConfig validates the settings of the arguments stage.
+// The schema files are compiled when the stage is created.
+func validateServerRequestsConfig(cfg *ServerRequestsConfig) error {
+	if err := validateUpstreamFilter("serverRequests.sampling", &cfg.Sampling.UpstreamFilter); err != nil {
+		return err
+	}
+	if cfg.Sampling.MaxTokens < 0 {
+		return &ValidationError{
+			Field:   "serverRequests.sampling.maxTokens",
+			Message: "max tokens cannot be negative",
+		}
+	}
+	for _, pattern := range cfg.Sampling.Models {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return &ValidationError{
+				Field:   "serverRequests.sampling.models",
+				Message: fmt.Sprintf("invalid model pattern: %s", pattern),
+			}
+		}
+	}
+	for _, value := range cfg.Sampling.IncludeContext {
+		switch value {
+		case "none", "thisServer", "allServers":
+		default:
+			return &ValidationError{
+				Field:   "serverRequests.sampling.includeContext",
+				Message: fmt.Sprintf("invalid value %q, must be 'none', 'thisServer' or 'allServers'", value),
+			}
+		}
+	}
+
+	if err := validateUpstreamFilter("serverRequests.elicitation", &cfg.Elicitation.UpstreamFilter); err != nil {
+		return err
+	}
+	for _, pattern := range cfg.Elicitation.DenyFields {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return &ValidationError{
+				Field:   "serverRequests.elicitation.denyFields",
+				Message: fmt.Sprintf("invalid pattern: %v", err),
+			}
+		}
+	}
+	return nil
+}
+
+func validateUpstreamFilter(field string, filter *UpstreamFilter) error {
+	for _, pattern := range slices.Concat(filter.Allow, filter.Deny) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return &ValidationError{
+				Field:   field,
+				Message: fmt.Sprintf("invalid upstream pattern: %s", pattern),
+			}
+		}
+	}
+	return nil
+}
+
+func validateArgumentsConfig(cfg *ArgumentsConfig) error {
+	for tool, file := range cfg.Schemas {
+		if tool == "" || file == "" {
+			return &ValidationError{
+				Field:   "arguments.schemas",
+				Message: fmt.Sprintf("tool %q must name a schema file", tool),
+			}
+		}
+	}
+
+	if !cfg.Learn && len(cfg.Schemas) == 0 {
+		return &ValidationError{
+			Field:   "arguments.schemas",
+			Message: "schemas must be pinned when learning them is disabled",
+		}
+	}
+
+	switch cfg.UnknownTools {
+	case UnknownToolsAllow, UnknownToolsDeny:
+	default:
+		return &ValidationError{
+			Field:   "arguments.unknownTools",
+			Message: "invalid effect, must be 'allow' or 'deny'",
+		}
+	}
+	return nil
+}
+
+// validateReplayProtectionConfig validates the settings of the replay
+// stage
+func validateReplayProtectionConfig(cfg *ReplayProtectionConfig) error {
+	if cfg.Window <= 0 {
+		return &ValidationError{
+			Field:   "replayProtection.window",
+			Message: "window must be greater than 0",
+		}
+	}
+
+	switch cfg.Store {
+	case "memory":
+	case "redis":
+		return validateRedisConfig("replayProtection.redis", &cfg.Redis)
+	default:
+		return &ValidationError{
+			Field:   "replayProtection.store",
+			Message: "invalid store, must be 'memory' or 'redis'",
+		}
+	}
+	return nil
+}
+
+// validatePipelineConfig validates the stage order and pipeline routes.
+// Stage names are resolved when the pipeline is built, since stages can be
+// registered in code.
+func validatePipelineConfig(cfg *PipelineConfig) error {
+	if err := validateStageList("pipeline.stages", cfg.Stages); err != nil {
+		return err
+	}
+
+	if cfg.DenyScore < 0 {
+		return &ValidationError{
+			Field:   "pipeline.denyScore",
+			Message: "deny score must not be negative",
+		}
+	}
+
+	for i, route := range cfg.Routes {
+		field := fmt.Sprintf("pipeline.routes[%d]", i)
+
+		if route.PathPrefix != "" && !strings.HasPrefix(route.PathPrefix, "/") {
+			return &ValidationError{
+				Field:   field + ".pathPrefix",
+				Message: "path prefix must start with /",
+			}
+		}
+		for _, pattern := range route.Methods {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return &ValidationError{
+					Field:   field + ".methods",
+					Message: fmt.Sprintf("invalid method pattern: %s", pattern),
+				}
+			}
+		}
+		if route.Stages == nil {
+			return &ValidationError{
+				Field:   field + ".stages",
+				Message: "stages must be specified, use [] to run no stages",
+			}
+		}
+		if err := validateStageList(field+".stages", route.Stages); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateStageList checks that a stage list names every stage once
+func validateStageList(field string, stages []string) error {
+	seen := make(map[string]bool)
+	for _, name := range stages {
+		if name == "" {
+			return &ValidationError{
+				Field:   field,
+				Message: "stage name must not be empty",
+			}
+		}
+		if seen[name] {
+			return &ValidationError{
+				Field:   field,
+				Message: fmt.Sprintf("duplicate stage: %s", name),
+			}
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// ValidateEnforcementConfig validates enforcement modes against the names
+// of the pipeline stages. It is exported so modes can be checked on their
+// own when they are reloaded.
+func ValidateEnforcementConfig(cfg *EnforcementConfig, stages []string) error {
+	if cfg.Mode != "" && !validMode(cfg.Mode) {
+		return &ValidationError{
+			Field:   "enforcement.mode",
+			Message: "invalid mode, must be 'enforce' or 'monitor'",
+		}
+	}
+
+	known := append([]string{ResponseStage}, stages...)
+	for stage, mode := range cfg.Stages {
+		if !slices.Contains(known, stage) {
+			return &ValidationError{
+				Field:   "enforcement.stages",
+				Message: fmt.Sprintf("unknown stage %s, must be one of %s", stage, strings.Join(known, ", ")),
+			}
+		}
+		if !validMode(mode) {
+			return &ValidationError{
+				Field:   "enforcement.stages." + stage,
+				Message: "invalid mode, must be 'enforce' or 'monitor'",
+			}
+		}
+	}
+
+	for rule, mode := range cfg.Rules {
+		if !validMode(mode) {
+			return &ValidationError{
+				Field:   "enforcement.rules." + rule,
+				Message: "invalid mode, must be 'enforce' or 'monitor'",
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateUpstreamConfig validates upstream configuration
+func validateUpstreamConfig(cfg *UpstreamConfig) error {
+	if cfg.URL == "" && len(cfg.Endpoints) == 0 {
+		return &ValidationError{
+			Field:   "upstream.url",
+			Message: "upstream URL must be specified",
+		}
+	}
+
+	if cfg.URL != "" {
+		if err := validateEndpointURL("upstream.url", cfg.URL); err != nil {
+			return err
+		}
+	}
+	for i, endpoint := range cfg.Endpoints {
+		if err := validateEndpointURL(fmt.Sprintf("upstream.endpoints[%d]", i), endpoint); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Timeout <= 0 {
+		return &ValidationError{
+			Field:   "upstream.timeout",
+			Message: "upstream timeout must be greater than 0",
+		}
+	}
+
+	switch cfg.Balancing {
+	case "", "round-robin", "least-in-flight":
+	default:
+		return &ValidationError{
+			Field:   "upstream.balancing",
+			Message: "invalid balancing, must be 'round-robin' or 'least-in-flight'",
+		}
+	}
+
+	if check := cfg.HealthCheck; check.Interval > 0 {
+		if check.Timeout <= 0 {
+			return &ValidationError{
+				Field:   "upstream.healthCheck.timeout",
+				Message: "health check timeout must be greater than 0",
+			}
+		}
+		if check.Method == "" {
+			return &ValidationError{
+				Field:   "upstream.healthCheck.method",
+				Message: "health check method must be specified",
+			}
+		}
+	}
+	if cfg.HealthCheck.Interval < 0 || cfg.HealthCheck.UnhealthyThreshold < 0 || cfg.HealthCheck.HealthyThreshold < 0 {
+		return &ValidationError{
+			Field:   "upstream.healthCheck",
+			Message: "health check interval and thresholds must not be negative",
+		}
+	}
+
+	if cfg.CircuitBreaker.FailureThreshold < 0 {
+		return &ValidationError{
+			Field:   "upstream.circuitBreaker.failureThreshold",
+			Message: "failure threshold must not be negative",
+		}
+	}
+	if cfg.CircuitBreaker.FailureThreshold > 0 && cfg.CircuitBreaker.OpenDuration <= 0 {
+		return &ValidationError{
+			Field:   "upstream.circuitBreaker.openDuration",
+			Message: "open duration must be greater than 0",
+		}
+	}
+
+	if cfg.Retry.MaxAttempts < 0 {
+		return &ValidationError{
+			Field:   "upstream.retry.maxAttempts",
+			Message: "max attempts must not be negative",
+		}
+	}
+
+	return nil
+}
+
+// validateEndpointURL checks that an upstream URL is an absolute http(s) URL
+func validateEndpointURL(field, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &ValidationError{
+			Field:   field,
+			Message: fmt.Sprintf("invalid upstream URL, must be an absolute http(s) URL: %s", rawURL),
+		}
+	}
+	return nil
+}
+
+// validateChatCompletionsConfig validates the chat completions endpoint
+// settings
+func validateChatCompletionsConfig(cfg *ChatCompletionsConfig) error {
+	if !strings.HasPrefix(cfg.Path, "/") {
+		return &ValidationError{
+			Field:   "chatCompletions.path",
+			Message: "path must start with /",
+		}
+	}
+
+	u, err := url.Parse(cfg.Upstream)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &ValidationError{
+			Field:   "chatCompletions.upstream",
+			Message: "upstream must be an absolute http:// or https:// URL",
+		}
+	}
+
+	if cfg.Timeout <= 0 {
+		return &ValidationError{
+			Field:   "chatCompletions.timeout",
+			Message: "timeout must be greater than 0",
+		}
+	}
+
+	if cfg.StreamHoldback < 0 {
+		return &ValidationError{
+			Field:   "chatCompletions.streamHoldback",
+			Message: "stream holdback must not be negative",
+		}
+	}
+
+	return nil
+}
+
+// validateWebSocketConfig validates the WebSocket transport settings
+func validateWebSocketConfig(cfg *WebSocketConfig) error {
+	if !strings.HasPrefix(cfg.Path, "/") {
+		return &ValidationError{
+			Field:   "websocket.path",
+			Message: "path must start with /",
+		}
+	}
+
+	if cfg.Upstream != "" {
+		u, err := url.Parse(cfg.Upstream)
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			return &ValidationError{
+				Field:   "websocket.upstream",
+				Message: "upstream must be an absolute ws:// or wss:// URL",
+			}
+		}
+	}
+
+	if cfg.RateLimit < 0 {
+		return &ValidationError{
+			Field:   "websocket.rateLimit",
+			Message: "rate limit must not be negative",
+		}
+	}
+
+	if cfg.RateLimit > 0 && cfg.Burst <= 0 {
+		return &ValidationError{
+			Field:   "websocket.burst",
+			Message: "burst must be greater than 0 when a rate limit is set",
+		}
+	}
+
+	if cfg.MaxMessageSize <= 0 {
+		return &ValidationError{
+			Field:   "websocket.maxMessageSize",
+			Message: "max message size must be greater than 0",
+		}
+	}
+
+	return nil
+}
+
+// validateStdioConfig validates stdio child process configuration
+func validateStdioConfig(cfg *StdioConfig) error {
+	if cfg.Command == "" {
+		return &ValidationError{
+			Field:   "stdio.command",
+			Message: "command must be specified",
+		}
+	}
+
+	if cfg.RequestTimeout <= 0 {
+		return &ValidationError{
+			Field:   "stdio.requestTimeout",
+			Message: "request timeout must be greater than 0",
+		}
+	}
+
+	if cfg.RestartDelay <= 0 || cfg.MaxRestartDelay < cfg.RestartDelay {
+		return &ValidationError{
+			Field:   "stdio.restartDelay",
+			Message: "restart delay must be greater than 0 and not exceed maxRestartDelay",
+		}
+	}
+
+	if cfg.ShutdownTimeout <= 0 {
+		return &ValidationError{
+			Field:   "stdio.shutdownTimeout",
+			Message: "shutdown timeout must be greater than 0",
+		}
+	}
+
+	return nil
+}
+
+// validateRoutingConfig validates named upstreams and the routes between them
+func validateRoutingConfig(upstreams []NamedUpstreamConfig, cfg *RoutingConfig) error {
+	names := make(map[string]bool)
+	for i, upstream := range upstreams {
+		field := fmt.Sprintf("upstreams[%d]", i)
+
+		if upstream.Name == "" {
+			return &ValidationError{
+				Field:   field + ".name",
+				Message: "upstream name must be specified",
+			}
+		}
+		if names[upstream.Name] {
+			return &ValidationError{
+				Field:   field + ".name",
+				Message: fmt.Sprintf("duplicate upstream name: %s", upstream.Name),
+			}
+		}
+		names[upstream.Name] = true
+
+		if err := validateUpstreamConfig(&upstream.UpstreamConfig); err != nil {
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				verr.Field = strings.Replace(verr.Field, "upstream", field, 1)
+			}
+			return err
+		}
+	}
+
+	switch cfg.OnCollision {
+	case "", "prefix", "first":
+	default:
+		return &ValidationError{
+			Field:   "routing.onCollision",
+			Message: "invalid collision handling, must be 'prefix' or 'first'",
+		}
+	}
+
+	if cfg.SessionTTL < 0 {
+		return &ValidationError{
+			Field:   "routing.sessionTTL",
+			Message: "session TTL must not be negative",
+		}
+	}
+
+	if len(cfg.Routes) == 0 {
+		return &ValidationError{
+			Field:   "routing.routes",
+			Message: "at least one route must be specified",
+		}
+	}
+
+	for i, route := range cfg.Routes {
+		field := fmt.Sprintf("routing.routes[%d]", i)
+
+		if !names[route.Upstream] {
+			return &ValidationError{
+				Field:   field + ".upstream",
+				Message: fmt.Sprintf("unknown upstream: %s", route.Upstream),
+			}
+		}
+
+		if route.PathPrefix != "" && !strings.HasPrefix(route.PathPrefix, "/") {
+			return &ValidationError{
+				Field:   field + ".pathPrefix",
+				Message: "path prefix must start with /",
+			}
+		}
+
+		for _, patterns := range [][]string{route.Methods, route.Tools} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return &ValidationError{
+						Field:   field,
+						Message: fmt.Sprintf("invalid pattern: %s", pattern),
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// validatePolicyConfig validates policy configuration
+func validatePolicyConfig(cfg *PolicyConfig) error {
+	if cfg.DefaultEffect != "" && !validEffect(cfg.DefaultEffect) {
+		return &ValidationError{
+			Field:   "policy.defaultEffect",
+			Message: "invalid effect, must be 'allow' or 'deny'",
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, rule := range cfg.Rules {
+		field := fmt.Sprintf("policy.rules[%d]", i)
+
+		if rule.ID == "" {
+			return &ValidationError{
+				Field:   field + ".id",
+				Message: "rule ID must be specified",
+			}
+		}
+		if seen[rule.ID] {
+			return &ValidationError{
+				Field:   field + ".id",
+				Message: fmt.Sprintf("duplicate rule ID: %s", rule.ID),
+			}
+		}
+		seen[rule.ID] = true
+
+		if len(rule.Methods) == 0 && len(rule.Tools) == 0 && len(rule.Prompts) == 0 && len(rule.Resources) == 0 &&
+			len(rule.Roles) == 0 && len(rule.Claims) == 0 {
+			return &ValidationError{
+				Field:   field + ".methods",
+				Message: "at least one method, tool, prompt, resource, role or claim must be specified",
+			}
+		}
+		for _, pattern := range rule.Methods {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return &ValidationError{
+					Field:   field + ".methods",
+					Message: fmt.Sprintf("invalid method pattern: %s", pattern),
+				}
+			}
+		}
+		for _, pattern := range append(append([]string(nil), rule.Tools...), rule.Prompts...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return &ValidationError{
+					Field:   field,
+					Message: fmt.Sprintf("invalid name pattern: %s", pattern),
+				}
+			}
+		}
+
+		if !validEffect(rule.Effect) && rule.Effect != EffectApprove {
+			return &ValidationError{
+				Field:   field + ".effect",
+				Message: "invalid effect, must be 'allow', 'deny' or 'approve'",
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateResponseConfig validates response inspection rules
+func validateResponseConfig(cfg *ResponseConfig) error {
+	seen := make(map[string]bool)
+	for i, rule := range cfg.Rules {
+		field := fmt.Sprintf("response.rules[%d]", i)
+
+		if rule.ID == "" {
+			return &ValidationError{
+				Field:   field + ".id",
+				Message: "rule ID must be specified",
+			}
+		}
+		if seen[rule.ID] {
+			return &ValidationError{
+				Field:   field + ".id",
+				Message: fmt.Sprintf("duplicate rule ID: %s", rule.ID),
+			}
+		}
+		seen[rule.ID] = true
+
+		switch rule.Detector {
+		case "injection", "secret", "pii":
+		case "pattern":
+			if rule.Pattern == "" {
+				return &ValidationError{
+					Field:   field + ".pattern",
+					Message: "pattern must be specified for the pattern detector",
+				}
+			}
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				return &ValidationError{
+					Field:   field + ".pattern",
+					Message: fmt.Sprintf("invalid pattern: %v", err),
+				}
+			}
+		default:
+			return &ValidationError{
+				Field:   field + ".detector",
+				Message: "invalid detector, must be 'injection', 'secret', 'pii' or 'pattern'",
+			}
+		}
+
+		switch rule.Action {
+		case "block", "redact", "annotate":
+		default:
+			return &ValidationError{
+				Field:   field + ".action",
+				Message: "invalid action, must be 'block', 'redact' or 'annotate'",
+			}
+		}
+	}
+
+	return nil
+}
+
+// validMode reports whether mode is a known enforcement mode
+func validMode(mode string) bool {
+	return mode == ModeEnforce || mode == ModeMonitor
+}
+
+// validEffect reports whether effect is a known policy effect
+func validEffect(effect string) bool {
+	switch effect {
+	case "allow", "deny":
+		return true
+	default:
+		return false
+	}
+}
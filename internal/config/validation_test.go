@@ -172,10 +172,651 @@ func TestValidateRedisConfig(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateRedisConfig(tt.config)
+			err := validateRedisConfig("session.redis", tt.config)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("validateRedisConfig() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestValidateGatewayConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *GatewayConfig
+		wantErr bool
+	}{
+		{
+			name: "valid configuration",
+			config: &GatewayConfig{
+				ListenAddr:   ":8080",
+				MaxBatchSize: 50,
+				Upstream: UpstreamConfig{
+					URL:     "http://localhost:9090/mcp",
+					Timeout: 30 * time.Second,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "policy rule matching roles only",
+			config: &GatewayConfig{
+				ListenAddr:   ":8080",
+				MaxBatchSize: 50,
+				Upstream: UpstreamConfig{
+					URL:     "http://localhost:9090/mcp",
+					Timeout: 30 * time.Second,
+				},
+				Policy: PolicyConfig{
+					Rules: []PolicyRule{{ID: "admins", Roles: []string{"admin"}, Effect: "allow"}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "missing listen address",
+			config: &GatewayConfig{
+				MaxBatchSize: 50,
+				Upstream: UpstreamConfig{
+					URL:     "http://localhost:9090/mcp",
+					Timeout: 30 * time.Second,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid max batch size",
+			config: &GatewayConfig{
+				ListenAddr: ":8080",
+				Upstream: UpstreamConfig{
+					URL:     "http://localhost:9090/mcp",
+					Timeout: 30 * time.Second,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing upstream URL",
+			config: &GatewayConfig{
+				ListenAddr:   ":8080",
+				MaxBatchSize: 50,
+				Upstream: UpstreamConfig{
+					Timeout: 30 * time.Second,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relative upstream URL",
+			config: &GatewayConfig{
+				ListenAddr:   ":8080",
+				MaxBatchSize: 50,
+				Upstream: UpstreamConfig{
+					URL:     "/mcp",
+					Timeout: 30 * time.Second,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid stdio configuration",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Mode = "stdio"
+				cfg.Stdio.Command = "mcp-server-filesystem"
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "stdio mode without command",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Mode = "stdio-http"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid mode",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Mode = "grpc"
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid policy effect",
+			config: &GatewayConfig{
+				ListenAddr:   ":8080",
+				MaxBatchSize: 50,
+				Upstream: UpstreamConfig{
+					URL:     "http://localhost:9090/mcp",
+					Timeout: 30 * time.Second,
+				},
+				Policy: PolicyConfig{
+					Rules: []PolicyRule{
+						{ID: "block-progress", Methods: []string{"notifications/progress"}, Effect: "block"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate policy rule ID",
+			config: &GatewayConfig{
+				ListenAddr:   ":8080",
+				MaxBatchSize: 50,
+				Upstream: UpstreamConfig{
+					URL:     "http://localhost:9090/mcp",
+					Timeout: 30 * time.Second,
+				},
+				Policy: PolicyConfig{
+					Rules: []PolicyRule{
+						{ID: "notifications", Methods: []string{"notifications/initialized"}, Effect: "allow"},
+						{ID: "notifications", Methods: []string{"notifications/*"}, Effect: "deny"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "upstream pool without URL",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.Endpoints = []string{"http://mcp-1:9090/mcp", "http://mcp-2:9090/mcp"}
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "invalid upstream endpoint",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.Endpoints = []string{"http://mcp-1:9090/mcp", "mcp-2:9090"}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid upstream balancing",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Upstream.Balancing = "random"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "circuit breaker without open duration",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Upstream.CircuitBreaker.OpenDuration = 0
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "monitored stage",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Enforcement.Stages = map[string]string{"detection": "monitor"}
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "unknown enforcement stage",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Enforcement.Stages = map[string]string{"auth": "monitor"}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid enforcement rule mode",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Enforcement.Rules = map[string]string{"deny-shell": "shadow"}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "enforcement mode for a routed stage",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Routes = []PipelineRoute{{Methods: []string{"tools/*"}, Stages: []string{"policy", "audit"}}}
+				cfg.Enforcement.Stages = map[string]string{"audit": "monitor"}
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "duplicate pipeline stage",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Stages = []string{"detection", "policy", "detection"}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "negative deny score",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.DenyScore = -1
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "pipeline route without stages",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Routes = []PipelineRoute{{PathPrefix: "/admin"}}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "pipeline route path without slash",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Routes = []PipelineRoute{{PathPrefix: "admin", Stages: []string{}}}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid response rule pattern",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Response.Rules = append(cfg.Response.Rules, ResponseRule{
+					ID: "internal-hosts", Detector: "pattern", Pattern: "([a-z", Action: "redact",
+				})
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid response rule action",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Response.Rules = []ResponseRule{{ID: "secrets", Detector: "secret", Action: "drop"}}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid denial detail",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Denials.Detail = "verbose"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "websocket with upstream",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.WebSocket.Path = "/ws"
+				cfg.WebSocket.Upstream = "wss://mcp.internal/ws"
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "websocket path without slash",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.WebSocket.Path = "ws"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "websocket upstream with http scheme",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.WebSocket.Path = "/ws"
+				cfg.WebSocket.Upstream = "http://mcp.internal/ws"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "chat completions endpoint",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.ChatCompletions.Path = "/v1/chat/completions"
+				cfg.ChatCompletions.Upstream = "http://localhost:8000/v1/chat/completions"
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "chat completions without upstream",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.ChatCompletions.Path = "/v1/chat/completions"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "chat completions on websocket path",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.WebSocket.Path = "/v1"
+				cfg.ChatCompletions.Path = "/v1"
+				cfg.ChatCompletions.Upstream = "http://localhost:8000/v1/chat/completions"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "replay stage with redis store",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Stages = []string{ReplayStage, "detection", "policy", "redaction"}
+				cfg.ReplayProtection.Store = "redis"
+				cfg.ReplayProtection.Redis.Addr = "localhost:6379"
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "replay stage without window",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Stages = []string{ReplayStage}
+				cfg.ReplayProtection.Window = 0
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "replay stage with redis store without address",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Stages = []string{ReplayStage}
+				cfg.ReplayProtection.Store = "redis"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "handshake stage",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Stages = []string{HandshakeStage, "detection", "policy", "redaction"}
+				cfg.Handshake.ProtocolVersions = []string{"2025-06-18"}
+				cfg.Handshake.DenyCapabilities = []string{"sampling", "tools.listChanged"}
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "handshake stage with invalid capability",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Stages = []string{HandshakeStage}
+				cfg.Handshake.DenyCapabilities = []string{"tools."}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "deadlines",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Deadlines.Methods = map[string]time.Duration{"tools/list": 5 * time.Second}
+				cfg.Deadlines.Tools = map[string]time.Duration{"build": 2 * time.Minute}
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "zero tool deadline",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Deadlines.Tools = map[string]time.Duration{"build": 0}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "cache",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Cache.Methods = map[string]time.Duration{"tools/list": 5 * time.Minute, "resources/read": 30 * time.Second}
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "cache of a method with side effects",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Cache.Methods = map[string]time.Duration{"tools/call": time.Minute}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "cache with redis store without address",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Cache.Methods = map[string]time.Duration{"tools/list": time.Minute}
+				cfg.Cache.Store = "redis"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "arguments stage",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Stages = []string{ArgumentsStage, "policy"}
+				cfg.Arguments.Schemas = map[string]string{"deploy": "schemas/deploy.json"}
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "arguments stage without schemas",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Stages = []string{ArgumentsStage}
+				cfg.Arguments.Learn = false
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid unknown tools effect",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Stages = []string{ArgumentsStage}
+				cfg.Arguments.UnknownTools = "flag"
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "server requests stage",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Stages = []string{ServerRequestsStage, "policy"}
+				cfg.ServerRequests.Sampling.Deny = []string{"untrusted-*"}
+				cfg.ServerRequests.Sampling.MaxTokens = 1024
+				cfg.ServerRequests.Sampling.Models = []string{"claude-*"}
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "invalid include context",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Stages = []string{ServerRequestsStage}
+				cfg.ServerRequests.Sampling.IncludeContext = []string{"everything"}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid elicitation field pattern",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Pipeline.Stages = []string{ServerRequestsStage}
+				cfg.ServerRequests.Elicitation.DenyFields = []string{"pass("}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "approval rule",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Policy.Rules = []PolicyRule{{ID: "approve-deploy", Tools: []string{"deploy"}, Effect: EffectApprove}}
+				cfg.Approvals.ApproverRoles = []string{"sre"}
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "empty explain role",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Admin.ExplainRoles = []string{"admin", ""}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "approval without timeout",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Policy.Rules = []PolicyRule{{ID: "approve-deploy", Tools: []string{"deploy"}, Effect: EffectApprove}}
+				cfg.Approvals.Timeout = 0
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "approval retention shorter than timeout",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstream.URL = "http://localhost:9090/mcp"
+				cfg.Policy.Rules = []PolicyRule{{ID: "approve-deploy", Tools: []string{"deploy"}, Effect: EffectApprove}}
+				cfg.Approvals.Retention = time.Minute
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "valid routing configuration",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstreams = []NamedUpstreamConfig{
+					{Name: "github", UpstreamConfig: UpstreamConfig{URL: "http://github-mcp:8080/mcp", Timeout: time.Second}},
+					{Name: "db", UpstreamConfig: UpstreamConfig{URL: "http://db-mcp:8080/mcp", Timeout: time.Second}},
+				}
+				cfg.Routing.Routes = []RouteConfig{
+					{Upstream: "db", URISchemes: []string{"postgres"}},
+					{Upstream: "github"},
+				}
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "route to unknown upstream",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstreams = []NamedUpstreamConfig{
+					{Name: "github", UpstreamConfig: UpstreamConfig{URL: "http://github-mcp:8080/mcp", Timeout: time.Second}},
+				}
+				cfg.Routing.Routes = []RouteConfig{{Upstream: "search"}}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid named upstream URL",
+			config: func() *GatewayConfig {
+				cfg := DefaultGatewayConfig()
+				cfg.Upstreams = []NamedUpstreamConfig{
+					{Name: "github", UpstreamConfig: UpstreamConfig{URL: "github-mcp", Timeout: time.Second}},
+				}
+				cfg.Routing.Routes = []RouteConfig{{Upstream: "github"}}
+				return cfg
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid upstream timeout",
+			config: &GatewayConfig{
+				ListenAddr:   ":8080",
+				MaxBatchSize: 50,
+				Upstream: UpstreamConfig{
+					URL: "http://localhost:9090/mcp",
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateGatewayConfig(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateGatewayConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+// WebSocketConfig holds the settings for clients that keep a long-lived
+// WebSocket connection and send JSON-RPC messages as text frames
+type WebSocketConfig struct {
+	// Path is the URL path WebSocket connections are accepted on, e.g.
+	// "/ws". Empty disables the WebSocket transport.
+	Path string `yaml:"path"`
+
+	// Upstream is the ws:// or wss:// URL of an upstream MCP server that
+	// speaks WebSocket. Each client connection gets its own upstream
+	// connection. When empty, messages are forwarded to the HTTP upstream
+	// one request at a time.
+	Upstream string `yaml:"upstream"`
+
+	// RateLimit is the number of messages per second a single connection
+	// may send. 0 disables the limit.
+	RateLimit float64 `yaml:"rateLimit"`
+
+	// Burst is the number of messages a connection may send at once
+	Burst int `yaml:"burst"`
+
+	// MaxMessageSize bounds the size of a single frame in bytes
+	MaxMessageSize int64 `yaml:"maxMessageSize"`
+
+	// CloseOnBlock closes the connection with code 1008 (policy
+	// violation) after a blocked request has been answered with its
+	// error. When false the connection stays open.
+	CloseOnBlock bool `yaml:"closeOnBlock"`
+}
+
+// DefaultWebSocketConfig returns a disabled WebSocket transport with
+// per-connection limits
+func DefaultWebSocketConfig() WebSocketConfig {
+	return WebSocketConfig{
+		RateLimit:      20,
+		Burst:          40,
+		MaxMessageSize: 10 << 20,
+		CloseOnBlock:   true,
+	}
+}
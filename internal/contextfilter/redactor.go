@@ -1,28 +1,143 @@
 package contextfilter
 
 import (
+	"fmt"
+	"sort"
+
 	"safectx/pkg/schema"
 )
 
-// Redact removes sensitive information from the request
-func Redact(req *schema.MCPRequest) {
+// SensitiveKeysRuleID identifies request redaction in logs, metrics and
+// enforcement modes
+const SensitiveKeysRuleID = "sensitive-keys"
+
+// sensitiveKeys are the param and argument names whose values are redacted
+var sensitiveKeys = []string{
+	"password",
+	"api_key",
+	"secret",
+	"token",
+	"credentials",
+}
+
+// Redact removes sensitive information from the request. Top-level params
+// and the arguments of tools/call and prompts/get, at any depth, are
+// redacted by key. Chat completion requests are redacted by role: secrets
+// in the text of system, developer and user messages, and sensitive keys
+// in the arguments of assistant tool calls. It returns the JSON pointers
+// of the redacted values within params.
+func Redact(req *schema.MCPRequest) []string {
+	return redact(req, true)
+}
+
+// SensitivePaths returns the JSON pointers of the values within params that
+// Redact would replace, without changing the request
+func SensitivePaths(req *schema.MCPRequest) []string {
+	return redact(req, false)
+}
+
+// RedactValue removes sensitive keys at any depth of a decoded JSON value,
+// e.g. the result of a client response to a server request. It returns the
+// JSON pointers of the redacted values.
+func RedactValue(v interface{}) []string {
+	var paths []string
+	redactTree(v, "", true, &paths)
+	sort.Strings(paths)
+	return paths
+}
+
+// SensitiveValuePaths returns the JSON pointers of the values within v that
+// RedactValue would replace, without changing v
+func SensitiveValuePaths(v interface{}) []string {
+	var paths []string
+	redactTree(v, "", false, &paths)
+	sort.Strings(paths)
+	return paths
+}
+
+// redact collects, and if apply is set replaces, the sensitive values of a
+// request
+func redact(req *schema.MCPRequest, apply bool) []string {
 	if req.Params == nil {
-		return
+		return nil
 	}
 
-	// List of sensitive keys to redact
-	sensitiveKeys := []string{
-		"password",
-		"api_key",
-		"secret",
-		"token",
-		"credentials",
+	// Redact sensitive values
+	var paths []string
+	redactKeys(req.Params, "", apply, &paths)
+
+	if call, ok := req.ToolCall(); ok {
+		redactTree(call.Arguments, "/arguments", apply, &paths)
+	}
+	if prompt, ok := req.PromptGet(); ok {
+		redactTree(prompt.Arguments, "/arguments", apply, &paths)
+	}
+	if messages, ok := req.ChatMessages(); ok {
+		redactChat(messages, apply, &paths)
 	}
 
-	// Redact sensitive values
+	sort.Strings(paths)
+	return paths
+}
+
+// redactKeys redacts the sensitive keys of a single object
+func redactKeys(obj map[string]interface{}, path string, apply bool, paths *[]string) {
 	for _, key := range sensitiveKeys {
-		if _, exists := req.Params[key]; exists {
-			req.Params[key] = "[REDACTED]"
+		if _, exists := obj[key]; exists {
+			*paths = append(*paths, path+"/"+schema.EscapePointer(key))
+			if apply {
+				obj[key] = redacted
+			}
+		}
+	}
+}
+
+// redactTree redacts the sensitive keys of every object in a decoded JSON
+// value
+func redactTree(v interface{}, path string, apply bool, paths *[]string) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		redactKeys(val, path, apply, paths)
+		for key, child := range val {
+			redactTree(child, path+"/"+schema.EscapePointer(key), apply, paths)
+		}
+	case []interface{}:
+		for i, child := range val {
+			redactTree(child, fmt.Sprintf("%s/%d", path, i), apply, paths)
+		}
+	}
+}
+
+// redactChat redacts the secrets written into the instructions of a chat
+// completion request and the sensitive arguments of its tool calls. Tool
+// results are left to the response rules.
+func redactChat(messages []schema.ChatMessage, apply bool, paths *[]string) {
+	for _, msg := range messages {
+		switch msg.Role {
+		case schema.RoleSystem, schema.RoleDeveloper, schema.RoleUser:
+			for i := range msg.Texts {
+				text := &msg.Texts[i]
+				replaced := text.Text
+				for _, pattern := range SecretPatterns {
+					replaced = pattern.ReplaceAllString(replaced, redacted)
+				}
+				if replaced == text.Text {
+					continue
+				}
+				*paths = append(*paths, text.Path)
+				if apply {
+					text.Set(replaced)
+				}
+			}
+		case schema.RoleAssistant:
+			for i := range msg.ToolCalls {
+				call := &msg.ToolCalls[i]
+				found := len(*paths)
+				redactTree(call.Arguments, call.Path, apply, paths)
+				if apply && len(*paths) > found {
+					call.Update()
+				}
+			}
 		}
 	}
 }
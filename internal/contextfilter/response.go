@@ -0,0 +1,269 @@
+package contextfilter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"safectx/internal/config"
+	"safectx/internal/detection"
+	"safectx/pkg/schema"
+)
+
+// Actions a response rule can take on a match
+const (
+	ActionBlock    = "block"
+	ActionRedact   = "redact"
+	ActionAnnotate = "annotate"
+)
+
+// redacted replaces sensitive content
+const redacted = "[REDACTED]"
+
+// FindingsMetaKey is the key under the result's _meta where annotations
+// are listed
+const FindingsMetaKey = "safectx/findings"
+
+// ResponseRule applies an action to response content matching any of its
+// patterns
+type ResponseRule struct {
+	ID       string
+	Action   string
+	Patterns []*regexp.Regexp
+}
+
+// Finding records a rule that matched a string in a response result
+type Finding struct {
+	RuleID string `json:"rule"`
+	Action string `json:"action"`
+	// Path is the JSON pointer of the matching string within the result
+	Path string `json:"path"`
+	// Monitored is set when the rule is in monitor mode, so the action
+	// was recorded but not applied
+	Monitored bool `json:"monitored,omitempty"`
+}
+
+// ResponseVerdict is the outcome of filtering a response result
+type ResponseVerdict struct {
+	// BlockedBy is the ID of the rule that blocked the response
+	BlockedBy string
+	// Findings lists every rule that matched, in document order
+	Findings []Finding
+}
+
+// Changed reports whether any finding was applied to the result
+func (v *ResponseVerdict) Changed() bool {
+	if v == nil {
+		return false
+	}
+	for _, f := range v.Findings {
+		if !f.Monitored {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocked reports whether the response must not reach the client
+func (v *ResponseVerdict) Blocked() bool {
+	return v != nil && v.BlockedBy != ""
+}
+
+// String summarizes the verdict for logs
+func (v *ResponseVerdict) String() string {
+	if v == nil || len(v.Findings) == 0 {
+		return "passed"
+	}
+	parts := make([]string, len(v.Findings))
+	for i, f := range v.Findings {
+		action := f.Action
+		if f.Monitored {
+			action = "would " + action
+		}
+		parts[i] = fmt.Sprintf("%s by %s at %s", action, f.RuleID, f.Path)
+	}
+	switch {
+	case v.Blocked():
+		return "blocked: " + strings.Join(parts, ", ")
+	case v.Changed():
+		return "filtered: " + strings.Join(parts, ", ")
+	default:
+		return "monitored: " + strings.Join(parts, ", ")
+	}
+}
+
+// ResponseFilter scans the result of upstream responses for injected
+// instructions, secrets and personal data
+type ResponseFilter struct {
+	rules []ResponseRule
+}
+
+// NewResponseFilter creates a filter applying the given rules in order
+func NewResponseFilter(rules ...ResponseRule) *ResponseFilter {
+	return &ResponseFilter{rules: rules}
+}
+
+// NewDefaultResponseFilter creates a filter with the default response rules
+func NewDefaultResponseFilter() *ResponseFilter {
+	cfg := config.DefaultResponseConfig()
+	filter, _ := NewResponseFilterFromConfig(&cfg)
+	return filter
+}
+
+// NewResponseFilterFromConfig creates a filter from the response
+// configuration
+func NewResponseFilterFromConfig(cfg *config.ResponseConfig) (*ResponseFilter, error) {
+	rules := make([]ResponseRule, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		var patterns []*regexp.Regexp
+		switch rule.Detector {
+		case "injection":
+			patterns = detection.BlockedPatterns
+		case "secret":
+			patterns = SecretPatterns
+		case "pii":
+			patterns = PIIPatterns
+		case "pattern":
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("response rule %s: %w", rule.ID, err)
+			}
+			patterns = []*regexp.Regexp{pattern}
+		default:
+			return nil, fmt.Errorf("response rule %s: unknown detector %q", rule.ID, rule.Detector)
+		}
+		rules = append(rules, ResponseRule{ID: rule.ID, Action: rule.Action, Patterns: patterns})
+	}
+	return NewResponseFilter(rules...), nil
+}
+
+// FilterResult applies the rules to every string in a JSON-RPC result,
+// which covers the text blocks of MCP content arrays and the text of
+// embedded resources. Base64 payloads are skipped. It returns the result
+// to relay, which is unchanged if nothing was redacted or annotated, and
+// nil if the response is blocked.
+func (f *ResponseFilter) FilterResult(result json.RawMessage) (json.RawMessage, *ResponseVerdict, error) {
+	return f.FilterResultMonitored(result, nil)
+}
+
+// FilterResultMonitored is like FilterResult, except that the rules for
+// which monitored returns true only record their findings and leave the
+// result as is
+func (f *ResponseFilter) FilterResultMonitored(result json.RawMessage, monitored func(ruleID string) bool) (json.RawMessage, *ResponseVerdict, error) {
+	verdict := &ResponseVerdict{}
+	if len(f.rules) == 0 || len(result) == 0 {
+		return result, verdict, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(result))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, verdict, err
+	}
+
+	doc = f.walk(doc, "", monitored, verdict)
+	if verdict.Blocked() {
+		return nil, verdict, nil
+	}
+	if !verdict.Changed() {
+		return result, verdict, nil
+	}
+
+	annotate(doc, verdict)
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, verdict, err
+	}
+	return out, verdict, nil
+}
+
+// walk filters a decoded JSON value, returning the value to keep in its
+// place. It stops as soon as the response is blocked.
+func (f *ResponseFilter) walk(v interface{}, path string, monitored func(string) bool, verdict *ResponseVerdict) interface{} {
+	switch val := v.(type) {
+	case string:
+		return f.scan(val, path, monitored, verdict)
+	case []interface{}:
+		for i, child := range val {
+			val[i] = f.walk(child, fmt.Sprintf("%s/%d", path, i), monitored, verdict)
+			if verdict.Blocked() {
+				break
+			}
+		}
+	case map[string]interface{}:
+		// Image and audio blocks carry base64 data, resources carry blobs
+		binary := val["type"] == "image" || val["type"] == "audio"
+
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if key == "blob" || (binary && key == "data") {
+				continue
+			}
+			val[key] = f.walk(val[key], path+"/"+schema.EscapePointer(key), monitored, verdict)
+			if verdict.Blocked() {
+				break
+			}
+		}
+	}
+	return v
+}
+
+// scan applies every rule to a single string. Monitored rules only record
+// their findings.
+func (f *ResponseFilter) scan(text, path string, monitored func(string) bool, verdict *ResponseVerdict) string {
+	for _, rule := range f.rules {
+		monitor := monitored != nil && monitored(rule.ID)
+		matched := false
+		for _, pattern := range rule.Patterns {
+			if !pattern.MatchString(text) {
+				continue
+			}
+			matched = true
+			if rule.Action != ActionRedact || monitor {
+				break
+			}
+			text = pattern.ReplaceAllString(text, redacted)
+		}
+		if !matched {
+			continue
+		}
+
+		verdict.Findings = append(verdict.Findings, Finding{RuleID: rule.ID, Action: rule.Action, Path: path, Monitored: monitor})
+		if rule.Action == ActionBlock && !monitor {
+			verdict.BlockedBy = rule.ID
+			return text
+		}
+	}
+	return text
+}
+
+// annotate lists the annotate findings in the result's _meta so the client
+// can see what the gateway flagged
+func annotate(doc interface{}, verdict *ResponseVerdict) {
+	var findings []Finding
+	for _, finding := range verdict.Findings {
+		if finding.Action == ActionAnnotate && !finding.Monitored {
+			findings = append(findings, finding)
+		}
+	}
+	result, ok := doc.(map[string]interface{})
+	if !ok || len(findings) == 0 {
+		return
+	}
+
+	meta, ok := result["_meta"].(map[string]interface{})
+	if !ok {
+		meta = make(map[string]interface{})
+		result["_meta"] = meta
+	}
+	meta[FindingsMetaKey] = findings
+}
@@ -0,0 +1,39 @@
+package dedup
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore implements Store using Redis, so keys are shared by every
+// gateway instance of a cluster
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore creates a new Redis-based store
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Add implements the Store interface
+func (s *RedisStore) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.getKey(key), 1, ttl).Result()
+}
+
+// Contains implements the Store interface
+func (s *RedisStore) Contains(ctx context.Context, key string) (bool, error) {
+	n, err := s.client.Exists(ctx, s.getKey(key)).Result()
+	return n > 0, err
+}
+
+// getKey returns the Redis key for a key
+func (s *RedisStore) getKey(key string) string {
+	return s.prefix + ":" + key
+}
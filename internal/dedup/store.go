@@ -0,0 +1,72 @@
+package dedup
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store remembers keys for a limited time, e.g. the request IDs a client
+// already used
+type Store interface {
+	// Add records key for ttl. It returns false if the key is already
+	// recorded and has not expired.
+	Add(ctx context.Context, key string, ttl time.Duration) (bool, error)
+
+	// Contains reports whether key is recorded and has not expired
+	Contains(ctx context.Context, key string) (bool, error)
+}
+
+// MemoryStore implements Store in memory, for a single gateway instance
+type MemoryStore struct {
+	mu        sync.Mutex
+	expiry    map[string]time.Time
+	lastSweep time.Time
+	// sweepEvery is the time between removals of expired keys
+	sweepEvery time.Duration
+}
+
+// NewMemoryStore creates an in-memory store. Expired keys are removed at
+// most every sweepEvery, which is usually the longest ttl used.
+func NewMemoryStore(sweepEvery time.Duration) *MemoryStore {
+	return &MemoryStore{
+		expiry:     make(map[string]time.Time),
+		lastSweep:  time.Now(),
+		sweepEvery: sweepEvery,
+	}
+}
+
+// Add implements the Store interface
+func (s *MemoryStore) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= s.sweepEvery {
+		s.sweep(now)
+	}
+	if expiry, ok := s.expiry[key]; ok && now.Before(expiry) {
+		return false, nil
+	}
+	s.expiry[key] = now.Add(ttl)
+	return true, nil
+}
+
+// Contains implements the Store interface
+func (s *MemoryStore) Contains(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiry, ok := s.expiry[key]
+	return ok && time.Now().Before(expiry), nil
+}
+
+// sweep removes the expired keys
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, expiry := range s.expiry {
+		if !now.Before(expiry) {
+			delete(s.expiry, key)
+		}
+	}
+	s.lastSweep = now
+}
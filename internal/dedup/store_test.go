@@ -0,0 +1,30 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(time.Millisecond)
+
+	if fresh, _ := store.Add(ctx, "a", 50*time.Millisecond); !fresh {
+		t.Fatal("Add() reported a new key as seen")
+	}
+	if fresh, _ := store.Add(ctx, "a", 50*time.Millisecond); fresh {
+		t.Error("Add() reported a seen key as new")
+	}
+	if seen, _ := store.Contains(ctx, "b"); seen {
+		t.Error("Contains() reported an unknown key as seen")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if seen, _ := store.Contains(ctx, "a"); seen {
+		t.Error("Contains() reported an expired key as seen")
+	}
+	if fresh, _ := store.Add(ctx, "a", time.Minute); !fresh {
+		t.Error("Add() reported an expired key as seen")
+	}
+}
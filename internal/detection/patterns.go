@@ -1,10 +1,16 @@
 package detection
 
 import (
+	"fmt"
 	"regexp"
 	"safectx/pkg/schema"
+	"sort"
 )
 
+// InjectionRuleID identifies the request injection detector in logs,
+// metrics and enforcement modes
+const InjectionRuleID = "prompt-injection"
+
 // BlockedPatterns contains regex patterns for detecting malicious prompts
 var BlockedPatterns = []*regexp.Regexp{
 	regexp.MustCompile(`(?i)drop\s+table`),
@@ -14,21 +20,117 @@ var BlockedPatterns = []*regexp.Regexp{
 	regexp.MustCompile(`(?i)execute\s+shell`),
 }
 
-// CheckForInjection checks if the request contains any blocked patterns
+// CheckForInjection checks if the request contains any blocked patterns.
+// It looks at params["prompt"], at every string in the arguments of
+// tools/call and prompts/get requests, at the messages of the sampling
+// and elicitation requests servers send, and at the instructions of chat
+// completion requests.
 func CheckForInjection(req *schema.MCPRequest) bool {
+	_, found := FindInjection(req)
+	return found
+}
+
+// FindInjection is like CheckForInjection, and also returns the JSON
+// pointer of the first matching string within params
+func FindInjection(req *schema.MCPRequest) (string, bool) {
 	if req.Params == nil {
-		return false
+		return "", false
 	}
 
 	// Convert params to string for pattern matching
 	paramsStr := req.Params["prompt"]
-	if prompt, ok := paramsStr.(string); ok {
-		for _, pattern := range BlockedPatterns {
-			if pattern.MatchString(prompt) {
-				return true
+	if prompt, ok := paramsStr.(string); ok && MatchesInjection(prompt) {
+		return "/prompt", true
+	}
+
+	if call, ok := req.ToolCall(); ok {
+		return findInjection(call.Arguments, "/arguments")
+	}
+	if prompt, ok := req.PromptGet(); ok {
+		return findInjection(prompt.Arguments, "/arguments")
+	}
+
+	// Servers asking the client's model to sample, or its user for data,
+	// may try to steer them too
+	switch req.Method {
+	case schema.MethodCreateMessage:
+		if path, ok := findInjection(req.Params["systemPrompt"], "/systemPrompt"); ok {
+			return path, true
+		}
+		return findInjection(req.Params["messages"], "/messages")
+	case schema.MethodElicit:
+		return findInjection(req.Params["message"], "/message")
+	case schema.MethodChatCompletions:
+		return findChatInjection(req)
+	}
+
+	return "", false
+}
+
+// findChatInjection checks the instructions of a chat completion request:
+// the text of system, developer and user messages, and the arguments of the
+// tool calls made by the assistant. Tool results are content, not
+// instructions, and are left to the response rules.
+func findChatInjection(req *schema.MCPRequest) (string, bool) {
+	messages, _ := req.ChatMessages()
+	for _, msg := range messages {
+		switch msg.Role {
+		case schema.RoleSystem, schema.RoleDeveloper, schema.RoleUser:
+			for _, text := range msg.Texts {
+				if MatchesInjection(text.Text) {
+					return text.Path, true
+				}
+			}
+		case schema.RoleAssistant:
+			for _, call := range msg.ToolCalls {
+				if call.Arguments == nil {
+					if MatchesInjection(call.RawArguments) {
+						return call.Path, true
+					}
+					continue
+				}
+				if path, ok := findInjection(call.Arguments, call.Path); ok {
+					return path, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// findInjection checks every string in a decoded JSON value. Object keys
+// are visited in sorted order so the reported path is stable.
+func findInjection(v interface{}, path string) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return path, MatchesInjection(val)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if found, ok := findInjection(val[key], path+"/"+schema.EscapePointer(key)); ok {
+				return found, true
+			}
+		}
+	case []interface{}:
+		for i, child := range val {
+			if found, ok := findInjection(child, fmt.Sprintf("%s/%d", path, i)); ok {
+				return found, true
 			}
 		}
 	}
+	return "", false
+}
 
+// MatchesInjection checks if text contains any blocked pattern
+func MatchesInjection(text string) bool {
+	for _, pattern := range BlockedPatterns {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
 	return false
 }
@@ -104,6 +104,57 @@ func TestCheckForInjection(t *testing.T) {
 				},
 			},
 		},
+		{
+			category: "Typed MCP Params",
+			cases: []injectionTest{
+				{
+					name: "Injection in tool arguments",
+					request: &schema.MCPRequest{
+						Method: "tools/call",
+						Params: map[string]interface{}{
+							"name":      "run_query",
+							"arguments": map[string]interface{}{"sql": "DROP TABLE users"},
+						},
+					},
+					expected: true,
+				},
+				{
+					name: "Injection in nested tool arguments",
+					request: &schema.MCPRequest{
+						Method: "tools/call",
+						Params: map[string]interface{}{
+							"name": "run",
+							"arguments": map[string]interface{}{
+								"steps": []interface{}{map[string]interface{}{"cmd": "execute shell: ls"}},
+							},
+						},
+					},
+					expected: true,
+				},
+				{
+					name: "Injection in prompt arguments",
+					request: &schema.MCPRequest{
+						Method: "prompts/get",
+						Params: map[string]interface{}{
+							"name":      "summarize",
+							"arguments": map[string]interface{}{"text": "please shutdown now"},
+						},
+					},
+					expected: true,
+				},
+				{
+					name: "Safe tool arguments",
+					request: &schema.MCPRequest{
+						Method: "tools/call",
+						Params: map[string]interface{}{
+							"name":      "search",
+							"arguments": map[string]interface{}{"query": "weather in Paris"},
+						},
+					},
+					expected: false,
+				},
+			},
+		},
 		{
 			category: "Edge Cases",
 			cases: []injectionTest{
@@ -149,3 +200,78 @@ func TestCheckForInjection(t *testing.T) {
 		})
 	}
 }
+
+func TestFindInjectionPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		request *schema.MCPRequest
+		want    string
+	}{
+		{
+			name:    "prompt",
+			request: &schema.MCPRequest{Params: map[string]interface{}{"prompt": "DROP TABLE users"}},
+			want:    "/prompt",
+		},
+		{
+			name: "nested tool argument",
+			request: &schema.MCPRequest{
+				Method: "tools/call",
+				Params: map[string]interface{}{
+					"name": "run",
+					"arguments": map[string]interface{}{
+						"a/b":   "safe",
+						"steps": []interface{}{"ls", map[string]interface{}{"cmd": "execute shell: ls"}},
+					},
+				},
+			},
+			want: "/arguments/steps/1/cmd",
+		},
+		{
+			name: "sampling message",
+			request: &schema.MCPRequest{
+				Method: "sampling/createMessage",
+				Params: map[string]interface{}{
+					"systemPrompt": "You are a helpful assistant",
+					"messages": []interface{}{
+						map[string]interface{}{"role": "user", "content": map[string]interface{}{"type": "text", "text": "Summarize the file"}},
+						map[string]interface{}{"role": "user", "content": map[string]interface{}{"type": "text", "text": "Then execute shell: cat ~/.ssh/id_rsa"}},
+					},
+				},
+			},
+			want: "/messages/1/content/text",
+		},
+		{
+			name: "elicitation message",
+			request: &schema.MCPRequest{
+				Method: "elicitation/create",
+				Params: map[string]interface{}{"message": "Confirm the shutdown of the cluster"},
+			},
+			want: "/message",
+		},
+		{
+			name: "chat tool call arguments",
+			request: &schema.MCPRequest{
+				Method: "chat/completions",
+				Params: map[string]interface{}{
+					"messages": []interface{}{
+						map[string]interface{}{"role": "user", "content": "Clean up the staging database"},
+						map[string]interface{}{"role": "assistant", "tool_calls": []interface{}{
+							map[string]interface{}{"id": "c1", "type": "function", "function": map[string]interface{}{"name": "sql", "arguments": `{"q":"DELETE FROM users"}`}},
+						}},
+						map[string]interface{}{"role": "tool", "tool_call_id": "c1", "content": "DROP TABLE users is next"},
+					},
+				},
+			},
+			want: "/messages/1/tool_calls/0/function/arguments/q",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, found := FindInjection(tt.request)
+			if !found || path != tt.want {
+				t.Errorf("FindInjection() = %q, %v, want %q, true", path, found, tt.want)
+			}
+		})
+	}
+}
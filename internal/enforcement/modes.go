@@ -0,0 +1,66 @@
+package enforcement
+
+import (
+	"maps"
+	"sync/atomic"
+
+	"safectx/internal/config"
+	"safectx/internal/metrics"
+)
+
+// Modes decides per stage and rule whether verdicts are enforced or only
+// monitored. It can be updated while requests are being inspected.
+type Modes struct {
+	cfg atomic.Pointer[config.EnforcementConfig]
+}
+
+// NewModes creates modes from the enforcement configuration
+func NewModes(cfg *config.EnforcementConfig) *Modes {
+	m := &Modes{}
+	m.Update(cfg)
+	return m
+}
+
+// Update replaces the modes. Inspections that already started keep the
+// modes they looked up.
+func (m *Modes) Update(cfg *config.EnforcementConfig) {
+	m.cfg.Store(&config.EnforcementConfig{
+		Mode:   cfg.Mode,
+		Stages: maps.Clone(cfg.Stages),
+		Rules:  maps.Clone(cfg.Rules),
+	})
+}
+
+// Mode returns the mode of a rule of a stage. A nil Modes enforces
+// everything.
+func (m *Modes) Mode(stage, rule string) string {
+	if m == nil {
+		return config.ModeEnforce
+	}
+
+	cfg := m.cfg.Load()
+	if mode, ok := cfg.Rules[rule]; ok {
+		return mode
+	}
+	if mode, ok := cfg.Stages[stage]; ok {
+		return mode
+	}
+	if cfg.Mode != "" {
+		return cfg.Mode
+	}
+	return config.ModeEnforce
+}
+
+// Monitor reports whether verdicts of the rule are only monitored
+func (m *Modes) Monitor(stage, rule string) bool {
+	return m.Mode(stage, rule) == config.ModeMonitor
+}
+
+// Record counts a verdict in the metrics and reports whether it is only
+// monitored, in which case the caller must let the message through
+// unchanged
+func (m *Modes) Record(stage, rule, action string) bool {
+	mode := m.Mode(stage, rule)
+	metrics.Verdicts.Inc(stage, rule, action, mode)
+	return mode == config.ModeMonitor
+}
@@ -0,0 +1,57 @@
+package enforcement
+
+import (
+	"testing"
+
+	"safectx/internal/config"
+	"safectx/internal/metrics"
+)
+
+func TestModes(t *testing.T) {
+	modes := NewModes(&config.EnforcementConfig{
+		Mode:   config.ModeEnforce,
+		Stages: map[string]string{"detection": config.ModeMonitor},
+		Rules:  map[string]string{"new-rule": config.ModeMonitor, "prompt-injection": config.ModeEnforce},
+	})
+
+	tests := []struct {
+		stage string
+		rule  string
+		want  string
+	}{
+		{stage: "policy", rule: "deny-shell", want: config.ModeEnforce},
+		{stage: "policy", rule: "new-rule", want: config.ModeMonitor},
+		{stage: "detection", rule: "custom", want: config.ModeMonitor},
+		{stage: "detection", rule: "prompt-injection", want: config.ModeEnforce},
+	}
+	for _, tt := range tests {
+		if got := modes.Mode(tt.stage, tt.rule); got != tt.want {
+			t.Errorf("Mode(%s, %s) = %s, want %s", tt.stage, tt.rule, got, tt.want)
+		}
+	}
+
+	modes.Update(&config.EnforcementConfig{Mode: config.ModeMonitor})
+	if !modes.Monitor("policy", "deny-shell") {
+		t.Error("Update() did not switch the global mode to monitor")
+	}
+
+	var none *Modes
+	if none.Monitor("policy", "deny-shell") {
+		t.Error("Nil modes should enforce every rule")
+	}
+}
+
+func TestModesRecord(t *testing.T) {
+	modes := NewModes(&config.EnforcementConfig{Rules: map[string]string{"record-test": config.ModeMonitor}})
+
+	before := metrics.Verdicts.Value("policy", "record-test", "deny", config.ModeMonitor)
+	if !modes.Record("policy", "record-test", "deny") {
+		t.Error("Record() should report the monitored rule")
+	}
+	if modes.Record("policy", "other-rule", "deny") {
+		t.Error("Record() should report the enforced rule")
+	}
+	if got := metrics.Verdicts.Value("policy", "record-test", "deny", config.ModeMonitor); got != before+1 {
+		t.Errorf("Got %v monitored verdicts, want %v", got, before+1)
+	}
+}
@@ -0,0 +1,245 @@
+package handshake
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/pkg/schema"
+)
+
+// Sides of the handshake
+const (
+	Client = "client"
+	Server = "server"
+)
+
+// Requirement is the capability a method relies on
+type Requirement struct {
+	// Side is the peer that must have declared the capability
+	Side string
+	// Capability is a capability name, or a dotted path to one of its
+	// flags, e.g. "resources.subscribe"
+	Capability string
+}
+
+// requirements maps the MCP methods that rely on a capability to it
+var requirements = map[string]Requirement{
+	"tools/list":                           {Server, "tools"},
+	"tools/call":                           {Server, "tools"},
+	"prompts/list":                         {Server, "prompts"},
+	"prompts/get":                          {Server, "prompts"},
+	"resources/list":                       {Server, "resources"},
+	"resources/templates/list":             {Server, "resources"},
+	"resources/read":                       {Server, "resources"},
+	"resources/subscribe":                  {Server, "resources.subscribe"},
+	"resources/unsubscribe":                {Server, "resources.subscribe"},
+	"logging/setLevel":                     {Server, "logging"},
+	"completion/complete":                  {Server, "completions"},
+	"notifications/tools/list_changed":     {Server, "tools.listChanged"},
+	"notifications/prompts/list_changed":   {Server, "prompts.listChanged"},
+	"notifications/resources/list_changed": {Server, "resources.listChanged"},
+	"notifications/resources/updated":      {Server, "resources.subscribe"},
+	"notifications/message":                {Server, "logging"},
+	"sampling/createMessage":               {Client, "sampling"},
+	"elicitation/create":                   {Client, "elicitation"},
+	"roots/list":                           {Client, "roots"},
+	"notifications/roots/list_changed":     {Client, "roots.listChanged"},
+}
+
+// RequirementOf returns the capability a method relies on. It returns
+// false for methods available without one, e.g. ping.
+func RequirementOf(method string) (Requirement, bool) {
+	r, ok := requirements[method]
+	return r, ok
+}
+
+// Session is the state negotiated in the initialize handshake of an MCP
+// session, after the denied capabilities were stripped
+type Session struct {
+	ProtocolVersion string
+	Client          schema.Capabilities
+	Server          schema.Capabilities
+}
+
+// Has reports whether the session negotiated a requirement
+func (s *Session) Has(r Requirement) bool {
+	caps := s.Client
+	if r.Side == Server {
+		caps = s.Server
+	}
+	return declared(caps, r.Capability)
+}
+
+// Guard pins the protocol versions and strips denied capabilities during
+// the initialize handshake, and remembers what each session negotiated
+type Guard struct {
+	versions []string
+	deny     []string
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	sessions  map[string]*entry
+	lastSweep time.Time
+}
+
+// entry is a recorded session and the time it was last used
+type entry struct {
+	session  *Session
+	lastSeen time.Time
+}
+
+// NewGuard creates a guard from the handshake configuration
+func NewGuard(cfg *config.HandshakeConfig) *Guard {
+	return &Guard{
+		versions:  cfg.ProtocolVersions,
+		deny:      cfg.DenyCapabilities,
+		ttl:       cfg.SessionTTL,
+		sessions:  make(map[string]*entry),
+		lastSweep: time.Now(),
+	}
+}
+
+// VersionAllowed reports whether a protocol version may be negotiated
+func (g *Guard) VersionAllowed(version string) bool {
+	return len(g.versions) == 0 || slices.Contains(g.versions, version)
+}
+
+// Versions returns the allowed protocol versions, or nil if any version is
+// allowed
+func (g *Guard) Versions() []string {
+	return g.versions
+}
+
+// Denied reports whether a capability, or the capability it belongs to,
+// is denied
+func (g *Guard) Denied(capability string) bool {
+	for _, denied := range g.deny {
+		if capability == denied || strings.HasPrefix(capability, denied+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Strip returns a copy of caps without the denied capabilities, and the
+// denied capabilities that were declared. caps itself is not changed.
+func (g *Guard) Strip(caps schema.Capabilities) (schema.Capabilities, []string) {
+	var stripped []string
+	out := schema.Capabilities(copyMap(caps))
+	for _, denied := range g.deny {
+		if !declared(caps, denied) {
+			continue
+		}
+		stripped = append(stripped, denied)
+
+		// Copy the maps along the path so caps keeps its flags
+		parts := strings.Split(denied, ".")
+		m := map[string]interface{}(out)
+		for _, part := range parts[:len(parts)-1] {
+			child := copyMap(m[part].(map[string]interface{}))
+			m[part] = child
+			m = child
+		}
+		delete(m, parts[len(parts)-1])
+	}
+	return out, stripped
+}
+
+// Allowed reports whether a message of a session may use method. Methods
+// of a recorded session must rely on capabilities that were negotiated;
+// without a record, only denied capabilities are rejected. It returns the
+// requirement of a rejected method.
+func (g *Guard) Allowed(session, method string) (Requirement, bool) {
+	r, ok := RequirementOf(method)
+	if !ok {
+		return r, true
+	}
+	if s := g.Session(session); s != nil {
+		return r, s.Has(r)
+	}
+	return r, !g.Denied(r.Capability)
+}
+
+// Record stores the negotiated state of a session
+func (g *Guard) Record(id string, s *Session) {
+	if id == "" {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(g.lastSweep) >= g.ttl {
+		g.sweep(now)
+	}
+	g.sessions[id] = &entry{session: s, lastSeen: now}
+}
+
+// Session returns the negotiated state of a session, or nil if none was
+// recorded
+func (g *Guard) Session(id string) *Session {
+	if id == "" {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	e, ok := g.sessions[id]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(e.lastSeen) >= g.ttl {
+		delete(g.sessions, id)
+		return nil
+	}
+	e.lastSeen = now
+	return e.session
+}
+
+// End forgets the state of a session
+func (g *Guard) End(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.sessions, id)
+}
+
+// sweep removes the sessions idle for longer than the TTL
+func (g *Guard) sweep(now time.Time) {
+	for id, e := range g.sessions {
+		if now.Sub(e.lastSeen) >= g.ttl {
+			delete(g.sessions, id)
+		}
+	}
+	g.lastSweep = now
+}
+
+// declared reports whether caps declares a capability. Flags such as
+// "tools.listChanged" must be true.
+func declared(caps map[string]interface{}, capability string) bool {
+	parts := strings.Split(capability, ".")
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := caps[part].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		caps = child
+	}
+	value, ok := caps[parts[len(parts)-1]]
+	if flag, isFlag := value.(bool); isFlag {
+		return flag
+	}
+	return ok && value != nil
+}
+
+// copyMap returns a shallow copy of m
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
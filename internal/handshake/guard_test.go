@@ -0,0 +1,74 @@
+package handshake
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/pkg/schema"
+)
+
+func TestGuardStrip(t *testing.T) {
+	guard := NewGuard(&config.HandshakeConfig{
+		DenyCapabilities: []string{"sampling", "tools.listChanged", "elicitation"},
+		SessionTTL:       time.Hour,
+	})
+	caps := schema.Capabilities{
+		"sampling": map[string]interface{}{},
+		"tools":    map[string]interface{}{"listChanged": true},
+		"roots":    map[string]interface{}{"listChanged": true},
+	}
+
+	out, stripped := guard.Strip(caps)
+	want := schema.Capabilities{
+		"tools": map[string]interface{}{},
+		"roots": map[string]interface{}{"listChanged": true},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("Got capabilities %v, want %v", out, want)
+	}
+	if !reflect.DeepEqual(stripped, []string{"sampling", "tools.listChanged"}) {
+		t.Errorf("Got stripped %v, want the declared denied capabilities", stripped)
+	}
+	if caps["sampling"] == nil || caps["tools"].(map[string]interface{})["listChanged"] != true {
+		t.Errorf("Strip changed its input: %v", caps)
+	}
+}
+
+func TestGuardAllowed(t *testing.T) {
+	guard := NewGuard(&config.HandshakeConfig{
+		DenyCapabilities: []string{"sampling"},
+		SessionTTL:       time.Hour,
+	})
+	guard.Record("s1", &Session{
+		Client: schema.Capabilities{"roots": map[string]interface{}{"listChanged": false}},
+		Server: schema.Capabilities{"resources": map[string]interface{}{"subscribe": true}},
+	})
+
+	tests := []struct {
+		session string
+		method  string
+		want    bool
+	}{
+		{session: "s1", method: "ping", want: true},
+		{session: "s1", method: "resources/read", want: true},
+		{session: "s1", method: "resources/subscribe", want: true},
+		{session: "s1", method: "tools/call", want: false},
+		{session: "s1", method: "roots/list", want: true},
+		{session: "s1", method: "notifications/roots/list_changed", want: false},
+		{session: "s1", method: "sampling/createMessage", want: false},
+		{session: "", method: "tools/call", want: true},
+		{session: "", method: "sampling/createMessage", want: false},
+	}
+	for _, tt := range tests {
+		if _, got := guard.Allowed(tt.session, tt.method); got != tt.want {
+			t.Errorf("Allowed(%q, %s) = %v, want %v", tt.session, tt.method, got, tt.want)
+		}
+	}
+
+	guard.End("s1")
+	if _, ok := guard.Allowed("s1", "tools/call"); !ok {
+		t.Error("Allowed() used the state of an ended session")
+	}
+}
@@ -0,0 +1,690 @@
+// Package jsonschema validates JSON values against JSON Schema documents.
+// It implements the validation vocabulary of draft 2020-12 and the older
+// drafts MCP servers commonly publish: type, enum, const, the numeric,
+// string, array and object constraints, the applicators allOf, anyOf,
+// oneOf, not and if/then/else, and $ref to the $defs or definitions of the
+// same document. format and other annotations are ignored. Schemas using
+// any other keyword, references to other documents or malformed keyword
+// values are rejected when compiled, so that no constraint is silently
+// skipped.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"safectx/pkg/schema"
+)
+
+// maxDepth bounds how deeply schemas may nest while validating, so that a
+// reference cycle that never descends into the value cannot loop forever
+const maxDepth = 64
+
+// keywords are the keywords a schema may use: those validated, those
+// holding subschemas for references, and annotations, which constrain no
+// value
+var keywords = map[string]bool{
+	"$ref": true, "type": true, "enum": true, "const": true,
+	"allOf": true, "anyOf": true, "oneOf": true, "not": true,
+	"if": true, "then": true, "else": true,
+	"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true,
+	"multipleOf": true, "minLength": true, "maxLength": true, "pattern": true,
+	"prefixItems": true, "items": true, "additionalItems": true, "contains": true,
+	"minItems": true, "maxItems": true, "uniqueItems": true, "minContains": true, "maxContains": true,
+	"minProperties": true, "maxProperties": true, "required": true, "properties": true,
+	"patternProperties": true, "additionalProperties": true, "propertyNames": true,
+	"dependentRequired": true,
+
+	"$defs": true, "definitions": true,
+
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "format": true, "readOnly": true, "writeOnly": true,
+	"deprecated": true, "contentEncoding": true, "contentMediaType": true,
+}
+
+// typeNames are the values of type
+var typeNames = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+// Schema is a compiled JSON Schema
+type Schema struct {
+	// always is set for the boolean schemas true and false
+	always *bool
+
+	ref   *Schema
+	types []string
+	enum  []interface{}
+	// constant is the value of const, valid when hasConst is set
+	constant interface{}
+	hasConst bool
+
+	allOf []*Schema
+	anyOf []*Schema
+	oneOf []*Schema
+	not   *Schema
+	ifS   *Schema
+	then  *Schema
+	elseS *Schema
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	multipleOf                         *float64
+
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+
+	prefixItems       []*Schema
+	items             *Schema
+	contains          *Schema
+	minItems          *int
+	maxItems          *int
+	uniqueItems       bool
+	minContains       *int
+	maxContains       *int
+	minProperties     *int
+	maxProperties     *int
+	required          []string
+	properties        map[string]*Schema
+	patternProps      []patternSchema
+	additional        *Schema
+	propertyNames     *Schema
+	dependentRequired map[string][]string
+}
+
+// patternSchema applies to the properties whose name matches pattern
+type patternSchema struct {
+	pattern *regexp.Regexp
+	schema  *Schema
+}
+
+// Violation is a constraint a value does not satisfy
+type Violation struct {
+	// Path is the JSON pointer of the offending value within the
+	// validated value, empty for the value itself
+	Path string
+	// Message describes the constraint
+	Message string
+}
+
+func (v Violation) String() string {
+	path := v.Path
+	if path == "" {
+		path = "/"
+	}
+	return path + ": " + v.Message
+}
+
+// Compile parses and compiles a JSON Schema document
+func Compile(data []byte) (*Schema, error) {
+	var doc interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return CompileValue(doc)
+}
+
+// CompileValue compiles a JSON Schema document that was already decoded
+func CompileValue(doc interface{}) (*Schema, error) {
+	c := &compiler{root: doc, refs: make(map[string]*Schema)}
+	return c.compile(doc, "")
+}
+
+// compiler compiles the subschemas of a document, resolving references
+// against its root
+type compiler struct {
+	root interface{}
+	// refs holds the schemas compiled for each reference, so that
+	// recursive schemas are compiled once
+	refs map[string]*Schema
+}
+
+func (c *compiler) compile(v interface{}, at string) (*Schema, error) {
+	if b, ok := v.(bool); ok {
+		return &Schema{always: &b}, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema at %q must be an object or a boolean", pointerOrRoot(at))
+	}
+
+	if err := checkKeywords(m, at); err != nil {
+		return nil, err
+	}
+
+	s := &Schema{}
+	var err error
+	if ref, ok := m["$ref"].(string); ok {
+		if s.ref, err = c.resolve(ref); err != nil {
+			return nil, err
+		}
+	}
+
+	switch t := m["type"].(type) {
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("type at %q must be a string or an array of strings", pointerOrRoot(at))
+			}
+			s.types = append(s.types, name)
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("type at %q must be a string or an array of strings", pointerOrRoot(at))
+	}
+	for _, name := range s.types {
+		if !typeNames[name] {
+			return nil, fmt.Errorf("unknown type %q at %q", name, pointerOrRoot(at))
+		}
+	}
+	if enum, ok := m["enum"].([]interface{}); ok {
+		s.enum = enum
+	}
+	s.constant, s.hasConst = m["const"]
+
+	for _, kw := range []struct {
+		name string
+		dst  *[]*Schema
+	}{{"allOf", &s.allOf}, {"anyOf", &s.anyOf}, {"oneOf", &s.oneOf}, {"prefixItems", &s.prefixItems}} {
+		if *kw.dst, err = c.compileList(m, kw.name, at); err != nil {
+			return nil, err
+		}
+	}
+	for _, kw := range []struct {
+		name string
+		dst  **Schema
+	}{
+		{"not", &s.not}, {"if", &s.ifS}, {"then", &s.then}, {"else", &s.elseS},
+		{"contains", &s.contains}, {"propertyNames", &s.propertyNames},
+		{"additionalProperties", &s.additional},
+	} {
+		if *kw.dst, err = c.compileMember(m, kw.name, at); err != nil {
+			return nil, err
+		}
+	}
+
+	// Before draft 2020-12 an array of items was what prefixItems is now,
+	// and additionalItems applied to the rest
+	if _, ok := m["items"].([]interface{}); ok {
+		if s.prefixItems, err = c.compileList(m, "items", at); err != nil {
+			return nil, err
+		}
+		if s.items, err = c.compileMember(m, "additionalItems", at); err != nil {
+			return nil, err
+		}
+	} else if s.items, err = c.compileMember(m, "items", at); err != nil {
+		return nil, err
+	}
+
+	for _, kw := range []struct {
+		name string
+		dst  **float64
+	}{
+		{"minimum", &s.minimum}, {"maximum", &s.maximum},
+		{"exclusiveMinimum", &s.exclusiveMinimum}, {"exclusiveMaximum", &s.exclusiveMaximum},
+		{"multipleOf", &s.multipleOf},
+	} {
+		if f, ok := toFloat(m[kw.name]); ok {
+			*kw.dst = &f
+		}
+	}
+	// Draft 4 gave exclusiveMinimum and exclusiveMaximum as flags on
+	// minimum and maximum
+	if b, _ := m["exclusiveMinimum"].(bool); b && s.minimum != nil {
+		s.exclusiveMinimum, s.minimum = s.minimum, nil
+	}
+	if b, _ := m["exclusiveMaximum"].(bool); b && s.maximum != nil {
+		s.exclusiveMaximum, s.maximum = s.maximum, nil
+	}
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return nil, fmt.Errorf("multipleOf at %q must be greater than 0", pointerOrRoot(at))
+	}
+
+	for _, kw := range []struct {
+		name string
+		dst  **int
+	}{
+		{"minLength", &s.minLength}, {"maxLength", &s.maxLength},
+		{"minItems", &s.minItems}, {"maxItems", &s.maxItems},
+		{"minContains", &s.minContains}, {"maxContains", &s.maxContains},
+		{"minProperties", &s.minProperties}, {"maxProperties", &s.maxProperties},
+	} {
+		if f, ok := toFloat(m[kw.name]); ok {
+			n := int(f)
+			*kw.dst = &n
+		}
+	}
+	s.uniqueItems, _ = m["uniqueItems"].(bool)
+
+	if pattern, ok := m["pattern"].(string); ok {
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("pattern at %q: %w", pointerOrRoot(at), err)
+		}
+	}
+
+	if required, ok := m["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				s.required = append(s.required, name)
+			}
+		}
+	}
+	if props, ok := m["properties"].(map[string]interface{}); ok {
+		s.properties = make(map[string]*Schema, len(props))
+		for name, prop := range props {
+			if s.properties[name], err = c.compile(prop, at+"/properties/"+schema.EscapePointer(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if props, ok := m["patternProperties"].(map[string]interface{}); ok {
+		for pattern, prop := range props {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("patternProperties at %q: %w", pointerOrRoot(at), err)
+			}
+			sub, err := c.compile(prop, at+"/patternProperties/"+schema.EscapePointer(pattern))
+			if err != nil {
+				return nil, err
+			}
+			s.patternProps = append(s.patternProps, patternSchema{re, sub})
+		}
+	}
+	if deps, ok := m["dependentRequired"].(map[string]interface{}); ok {
+		s.dependentRequired = make(map[string][]string, len(deps))
+		for name, list := range deps {
+			items, _ := list.([]interface{})
+			for _, item := range items {
+				if dep, ok := item.(string); ok {
+					s.dependentRequired[name] = append(s.dependentRequired[name], dep)
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+// checkKeywords rejects the keywords of a schema object that are not
+// supported and the values of supported keywords that are malformed, which
+// would otherwise be ignored
+func checkKeywords(m map[string]interface{}, at string) error {
+	for name, value := range m {
+		if !keywords[name] || (name == "$id" && at != "") {
+			return fmt.Errorf("unsupported keyword %q at %q", name, pointerOrRoot(at))
+		}
+
+		valid := true
+		switch name {
+		case "$ref", "pattern":
+			_, valid = value.(string)
+		case "enum":
+			_, valid = value.([]interface{})
+		case "uniqueItems":
+			_, valid = value.(bool)
+		case "properties", "patternProperties", "dependentRequired":
+			_, valid = value.(map[string]interface{})
+		case "required":
+			valid = isStringArray(value)
+		case "minimum", "maximum", "multipleOf":
+			_, valid = toFloat(value)
+		case "exclusiveMinimum", "exclusiveMaximum":
+			_, isFlag := value.(bool)
+			_, isNumber := toFloat(value)
+			valid = isFlag || isNumber
+		case "minLength", "maxLength", "minItems", "maxItems", "minContains", "maxContains", "minProperties", "maxProperties":
+			f, ok := toFloat(value)
+			valid = ok && f >= 0 && f == math.Trunc(f)
+		}
+		if !valid {
+			return fmt.Errorf("invalid value of %s at %q", name, pointerOrRoot(at))
+		}
+	}
+	if deps, ok := m["dependentRequired"].(map[string]interface{}); ok {
+		for _, list := range deps {
+			if !isStringArray(list) {
+				return fmt.Errorf("invalid value of dependentRequired at %q", pointerOrRoot(at))
+			}
+		}
+	}
+	return nil
+}
+
+// isStringArray reports whether v is an array of strings
+func isStringArray(v interface{}) bool {
+	items, ok := v.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, item := range items {
+		if _, ok := item.(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compileMember compiles the subschema under the given keyword, if any
+func (c *compiler) compileMember(m map[string]interface{}, name, at string) (*Schema, error) {
+	v, ok := m[name]
+	if !ok {
+		return nil, nil
+	}
+	return c.compile(v, at+"/"+name)
+}
+
+// compileList compiles the array of subschemas under the given keyword
+func (c *compiler) compileList(m map[string]interface{}, name, at string) ([]*Schema, error) {
+	v, ok := m[name]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s at %q must be an array", name, pointerOrRoot(at))
+	}
+	schemas := make([]*Schema, len(list))
+	for i, item := range list {
+		var err error
+		if schemas[i], err = c.compile(item, fmt.Sprintf("%s/%s/%d", at, name, i)); err != nil {
+			return nil, err
+		}
+	}
+	return schemas, nil
+}
+
+// resolve compiles the schema a reference within the document points to
+func (c *compiler) resolve(ref string) (*Schema, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported reference %q: only references within the schema are supported", ref)
+	}
+	if s, ok := c.refs[ref]; ok {
+		return s, nil
+	}
+
+	target := c.root
+	pointer := strings.TrimPrefix(ref, "#")
+	if pointer != "" {
+		if !strings.HasPrefix(pointer, "/") {
+			return nil, fmt.Errorf("unsupported reference %q: only JSON pointers are supported", ref)
+		}
+		for _, token := range strings.Split(pointer[1:], "/") {
+			token = schema.UnescapePointer(token)
+			switch node := target.(type) {
+			case map[string]interface{}:
+				target = node[token]
+			case []interface{}:
+				i, err := strconv.Atoi(token)
+				if err != nil || i < 0 || i >= len(node) {
+					return nil, fmt.Errorf("unresolvable reference %q", ref)
+				}
+				target = node[i]
+			default:
+				target = nil
+			}
+			if target == nil {
+				return nil, fmt.Errorf("unresolvable reference %q", ref)
+			}
+		}
+	}
+
+	// The placeholder is registered before compiling, so that a schema
+	// referring to itself resolves to it
+	s := &Schema{}
+	c.refs[ref] = s
+	compiled, err := c.compile(target, pointer)
+	if err != nil {
+		return nil, err
+	}
+	*s = *compiled
+	return s, nil
+}
+
+// Validate returns the constraints of the schema that v violates. v is a
+// value decoded from JSON, with numbers as float64 or json.Number.
+func (s *Schema) Validate(v interface{}) []Violation {
+	var violations []Violation
+	s.validate(v, "", 0, &violations)
+	return violations
+}
+
+// Valid reports whether v satisfies the schema
+func (s *Schema) Valid(v interface{}) bool {
+	return len(s.Validate(v)) == 0
+}
+
+func (s *Schema) validate(v interface{}, path string, depth int, out *[]Violation) {
+	add := func(format string, args ...interface{}) {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if depth > maxDepth {
+		add("schema nested too deeply")
+		return
+	}
+	if s.always != nil {
+		if !*s.always {
+			add("no value is allowed")
+		}
+		return
+	}
+	if s.ref != nil {
+		s.ref.validate(v, path, depth+1, out)
+	}
+
+	if len(s.types) > 0 && !matchesType(v, s.types) {
+		add("must be %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+		return
+	}
+	if s.enum != nil && !containsValue(s.enum, v) {
+		add("must be one of %s", formatValues(s.enum))
+	}
+	if s.hasConst && !equal(s.constant, v) {
+		add("must be %s", formatValue(s.constant))
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, path, depth+1, out)
+	}
+	if len(s.anyOf) > 0 && count(s.anyOf, v, path, depth) == 0 {
+		add("must match at least one schema of anyOf")
+	}
+	if len(s.oneOf) > 0 {
+		if n := count(s.oneOf, v, path, depth); n != 1 {
+			add("must match exactly one schema of oneOf, matched %d", n)
+		}
+	}
+	if s.not != nil && s.not.matches(v, path, depth) {
+		add("must not match the schema of not")
+	}
+	if s.ifS != nil {
+		if s.ifS.matches(v, path, depth) {
+			if s.then != nil {
+				s.then.validate(v, path, depth+1, out)
+			}
+		} else if s.elseS != nil {
+			s.elseS.validate(v, path, depth+1, out)
+		}
+	}
+
+	switch value := v.(type) {
+	case string:
+		s.validateString(value, add)
+	case []interface{}:
+		s.validateArray(value, path, depth, out, add)
+	case map[string]interface{}:
+		s.validateObject(value, path, depth, out, add)
+	default:
+		if f, ok := toFloat(v); ok {
+			s.validateNumber(f, add)
+		}
+	}
+}
+
+func (s *Schema) validateNumber(f float64, add func(string, ...interface{})) {
+	if s.minimum != nil && f < *s.minimum {
+		add("must be at least %s", formatNumber(*s.minimum))
+	}
+	if s.maximum != nil && f > *s.maximum {
+		add("must be at most %s", formatNumber(*s.maximum))
+	}
+	if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+		add("must be greater than %s", formatNumber(*s.exclusiveMinimum))
+	}
+	if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+		add("must be less than %s", formatNumber(*s.exclusiveMaximum))
+	}
+	if s.multipleOf != nil {
+		// Allow for the rounding of decimal fractions, e.g. 0.3 / 0.1
+		if q := f / *s.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+			add("must be a multiple of %s", formatNumber(*s.multipleOf))
+		}
+	}
+}
+
+func (s *Schema) validateString(value string, add func(string, ...interface{})) {
+	length := len([]rune(value))
+	if s.minLength != nil && length < *s.minLength {
+		add("must be at least %d characters long", *s.minLength)
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		add("must be at most %d characters long", *s.maxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		add("must match pattern %s", s.pattern)
+	}
+}
+
+func (s *Schema) validateArray(items []interface{}, path string, depth int, out *[]Violation, add func(string, ...interface{})) {
+	if s.minItems != nil && len(items) < *s.minItems {
+		add("must have at least %d items", *s.minItems)
+	}
+	if s.maxItems != nil && len(items) > *s.maxItems {
+		add("must have at most %d items", *s.maxItems)
+	}
+	if s.uniqueItems {
+		for i := range items {
+			for j := i + 1; j < len(items); j++ {
+				if equal(items[i], items[j]) {
+					add("items %d and %d must be unique", i, j)
+				}
+			}
+		}
+	}
+
+	for i, item := range items {
+		itemPath := path + "/" + strconv.Itoa(i)
+		switch {
+		case i < len(s.prefixItems):
+			s.prefixItems[i].validate(item, itemPath, depth+1, out)
+		case s.items != nil:
+			s.items.validate(item, itemPath, depth+1, out)
+		}
+	}
+
+	if s.contains != nil {
+		n := 0
+		for i, item := range items {
+			if s.contains.matches(item, path+"/"+strconv.Itoa(i), depth) {
+				n++
+			}
+		}
+		minimum := 1
+		if s.minContains != nil {
+			minimum = *s.minContains
+		}
+		if n < minimum {
+			add("must contain at least %d matching items, found %d", minimum, n)
+		}
+		if s.maxContains != nil && n > *s.maxContains {
+			add("must contain at most %d matching items, found %d", *s.maxContains, n)
+		}
+	}
+}
+
+func (s *Schema) validateObject(props map[string]interface{}, path string, depth int, out *[]Violation, add func(string, ...interface{})) {
+	if s.minProperties != nil && len(props) < *s.minProperties {
+		add("must have at least %d properties", *s.minProperties)
+	}
+	if s.maxProperties != nil && len(props) > *s.maxProperties {
+		add("must have at most %d properties", *s.maxProperties)
+	}
+	for _, name := range s.required {
+		if _, ok := props[name]; !ok {
+			add("missing required property %q", name)
+		}
+	}
+	for name, deps := range s.dependentRequired {
+		if _, ok := props[name]; !ok {
+			continue
+		}
+		for _, dep := range deps {
+			if _, ok := props[dep]; !ok {
+				add("property %q requires property %q", name, dep)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(props) {
+		value := props[name]
+		propPath := path + "/" + schema.EscapePointer(name)
+		if s.propertyNames != nil && !s.propertyNames.matches(name, propPath, depth) {
+			*out = append(*out, Violation{Path: propPath, Message: "property name is not allowed"})
+		}
+
+		matched := false
+		if sub, ok := s.properties[name]; ok {
+			sub.validate(value, propPath, depth+1, out)
+			matched = true
+		}
+		for _, p := range s.patternProps {
+			if p.pattern.MatchString(name) {
+				p.schema.validate(value, propPath, depth+1, out)
+				matched = true
+			}
+		}
+		if matched || s.additional == nil {
+			continue
+		}
+		if s.additional.always != nil && !*s.additional.always {
+			*out = append(*out, Violation{Path: propPath, Message: "additional property is not allowed"})
+			continue
+		}
+		s.additional.validate(value, propPath, depth+1, out)
+	}
+}
+
+// matches reports whether v satisfies the schema, for the applicators
+// that only need to know
+func (s *Schema) matches(v interface{}, path string, depth int) bool {
+	var violations []Violation
+	s.validate(v, path, depth+1, &violations)
+	return len(violations) == 0
+}
+
+// count returns how many of the schemas v satisfies
+func count(schemas []*Schema, v interface{}, path string, depth int) int {
+	n := 0
+	for _, sub := range schemas {
+		if sub.matches(v, path, depth) {
+			n++
+		}
+	}
+	return n
+}
+
+// pointerOrRoot names the location of a subschema in compile errors
+func pointerOrRoot(at string) string {
+	if at == "" {
+		return "/"
+	}
+	return at
+}
@@ -0,0 +1,222 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	deploy := `{
+		"type": "object",
+		"properties": {
+			"env": {"type": "string", "enum": ["staging", "prod"]},
+			"version": {"type": "string", "pattern": "^v[0-9]+\\.[0-9]+$"},
+			"replicas": {"type": "integer", "minimum": 1, "maximum": 10},
+			"ratio": {"type": "number", "exclusiveMaximum": 1, "multipleOf": 0.1},
+			"tags": {"type": "array", "items": {"type": "string", "minLength": 2}, "maxItems": 2, "uniqueItems": true},
+			"a/b": {"type": "boolean"}
+		},
+		"required": ["env", "version"],
+		"additionalProperties": false
+	}`
+
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		want   []string
+	}{
+		{
+			name:   "valid",
+			schema: deploy,
+			value:  `{"env":"prod","version":"v1.2","replicas":3,"ratio":0.3,"tags":["eu","us"]}`,
+		},
+		{
+			name:   "annotations",
+			schema: `{"$schema":"https://json-schema.org/draft/2020-12/schema","title":"Query","type":"string","format":"email","default":"a","examples":["a@b.c"]}`,
+			value:  `"not an email"`,
+		},
+		{
+			name:   "missing required",
+			schema: deploy,
+			value:  `{"env":"prod"}`,
+			want:   []string{`/: missing required property "version"`},
+		},
+		{
+			name:   "wrong type",
+			schema: deploy,
+			value:  `{"env":"prod","version":"v1.2","replicas":"3"}`,
+			want:   []string{"/replicas: must be integer, got string"},
+		},
+		{
+			name:   "integer",
+			schema: deploy,
+			value:  `{"env":"prod","version":"v1.2","replicas":2.5}`,
+			want:   []string{"/replicas: must be integer, got number"},
+		},
+		{
+			name:   "enum and pattern",
+			schema: deploy,
+			value:  `{"env":"dev","version":"1.2; rm -rf /"}`,
+			want: []string{
+				`/env: must be one of ["staging", "prod"]`,
+				`/version: must match pattern ^v[0-9]+\.[0-9]+$`,
+			},
+		},
+		{
+			name:   "bounds",
+			schema: deploy,
+			value:  `{"env":"prod","version":"v1.2","replicas":11,"ratio":1}`,
+			want:   []string{"/ratio: must be less than 1", "/replicas: must be at most 10"},
+		},
+		{
+			name:   "array items",
+			schema: deploy,
+			value:  `{"env":"prod","version":"v1.2","tags":["eu","eu","x"]}`,
+			want: []string{
+				"/tags: must have at most 2 items",
+				"/tags: items 0 and 1 must be unique",
+				"/tags/2: must be at least 2 characters long",
+			},
+		},
+		{
+			name:   "additional property",
+			schema: deploy,
+			value:  `{"env":"prod","version":"v1.2","command":"sh"}`,
+			want:   []string{"/command: additional property is not allowed"},
+		},
+		{
+			name:   "escaped path",
+			schema: deploy,
+			value:  `{"env":"prod","version":"v1.2","a/b":1}`,
+			want:   []string{"/a~1b: must be boolean, got integer"},
+		},
+		{
+			name:   "additional property schema",
+			schema: `{"type":"object","additionalProperties":{"type":"string"}}`,
+			value:  `{"a":"x","b":1}`,
+			want:   []string{"/b: must be string, got integer"},
+		},
+		{
+			name:   "const and nullable type",
+			schema: `{"type":"object","properties":{"v":{"const":1},"n":{"type":["string","null"]}}}`,
+			value:  `{"v":1.0,"n":null}`,
+		},
+		{
+			name:   "oneOf",
+			schema: `{"oneOf":[{"type":"string"},{"type":"string","maxLength":3}]}`,
+			value:  `"ab"`,
+			want:   []string{"/: must match exactly one schema of oneOf, matched 2"},
+		},
+		{
+			name:   "anyOf",
+			schema: `{"anyOf":[{"type":"string"},{"type":"integer"}]}`,
+			value:  `true`,
+			want:   []string{"/: must match at least one schema of anyOf"},
+		},
+		{
+			name:   "not",
+			schema: `{"not":{"type":"string","pattern":"\\.\\."}}`,
+			value:  `"../etc/passwd"`,
+			want:   []string{"/: must not match the schema of not"},
+		},
+		{
+			name:   "if then else",
+			schema: `{"if":{"properties":{"kind":{"const":"file"}}},"then":{"required":["path"]},"else":{"required":["url"]}}`,
+			value:  `{"kind":"file","url":"x"}`,
+			want:   []string{`/: missing required property "path"`},
+		},
+		{
+			name:   "ref",
+			schema: `{"$defs":{"node":{"type":"object","properties":{"children":{"type":"array","items":{"$ref":"#/$defs/node"}},"name":{"type":"string"}}}},"$ref":"#/$defs/node"}`,
+			value:  `{"name":"a","children":[{"name":"b","children":[{"name":3}]}]}`,
+			want:   []string{"/children/0/children/0/name: must be string, got integer"},
+		},
+		{
+			name:   "draft 7 tuple items",
+			schema: `{"type":"array","items":[{"type":"string"}],"additionalItems":false}`,
+			value:  `["a",1]`,
+			want:   []string{"/1: no value is allowed"},
+		},
+		{
+			name:   "draft 4 exclusive minimum",
+			schema: `{"type":"number","minimum":0,"exclusiveMinimum":true}`,
+			value:  `0`,
+			want:   []string{"/: must be greater than 0"},
+		},
+		{
+			name:   "pattern properties",
+			schema: `{"type":"object","patternProperties":{"^x-":{"type":"string"}},"additionalProperties":false}`,
+			value:  `{"x-a":"1","y":"2"}`,
+			want:   []string{"/y: additional property is not allowed"},
+		},
+		{
+			name:   "self reference",
+			schema: `{"$ref":"#"}`,
+			value:  `{}`,
+			want:   []string{"/: schema nested too deeply"},
+		},
+		{
+			name:   "boolean schema",
+			schema: `true`,
+			value:  `{"anything":[1,2]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Compile([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			decoder := json.NewDecoder(strings.NewReader(tt.value))
+			decoder.UseNumber()
+			var value interface{}
+			if err := decoder.Decode(&value); err != nil {
+				t.Fatalf("Failed to decode %s: %v", tt.value, err)
+			}
+
+			var got []string
+			for _, v := range s.Validate(value) {
+				got = append(got, v.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{"invalid json", `{`},
+		{"not a schema", `[1]`},
+		{"invalid type", `{"type":1}`},
+		{"invalid pattern", `{"pattern":"("}`},
+		{"remote reference", `{"$ref":"https://example.com/schema.json"}`},
+		{"unresolvable reference", `{"$ref":"#/$defs/missing"}`},
+		{"invalid multipleOf", `{"multipleOf":0}`},
+		{"unknown type", `{"type":"int"}`},
+		{"unsupported keyword", `{"type":"object","unevaluatedProperties":false}`},
+		{"unsupported nested keyword", `{"properties":{"a":{"dependentSchemas":{"b":false}}}}`},
+		{"unknown keyword", `{"type":"string","maxlength":3}`},
+		{"nested identifier", `{"properties":{"a":{"$id":"a.json"}}}`},
+		{"string maximum", `{"maximum":"10"}`},
+		{"fractional length", `{"maxLength":1.5}`},
+		{"required not strings", `{"required":[1]}`},
+		{"required not an array", `{"required":"name"}`},
+		{"dependentRequired not strings", `{"dependentRequired":{"a":"b"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile([]byte(tt.schema)); err == nil {
+				t.Error("Compile() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// typeOf returns the JSON Schema type name of a decoded value
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	if f, ok := toFloat(v); ok {
+		if f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+// matchesType reports whether v has one of the given types. Integers are
+// numbers too.
+func matchesType(v interface{}, types []string) bool {
+	actual := typeOf(v)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// toFloat returns the value of a decoded JSON number
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// equal reports whether two decoded JSON values are equal. Numbers are
+// compared by value, whatever their representation.
+func equal(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	switch x := a.(type) {
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for name, value := range x {
+			other, ok := y[name]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// containsValue reports whether values holds v
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if equal(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatValue renders a value as JSON in violation messages
+func formatValue(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "?"
+	}
+	return string(data)
+}
+
+// formatValues renders the allowed values of an enum
+func formatValues(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = formatValue(v)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// formatNumber renders a numeric constraint
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// sortedKeys returns the property names of an object in order, so that
+// violations are reported in a stable order
+func sortedKeys(props map[string]interface{}) []string {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Counter is a monotonically increasing value partitioned by label values
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Registry holds the counters served by Handler
+type Registry struct {
+	mu       sync.Mutex
+	counters []*Counter
+}
+
+// Default is the registry used by the gateway
+var Default = &Registry{}
+
+// Verdicts counts the verdicts of the inspection stages. mode is "enforce"
+// when the verdict was applied and "monitor" when it was only recorded.
+var Verdicts = Default.NewCounter(
+	"safectx_verdicts_total",
+	"Verdicts of the inspection stages by stage, rule, action and enforcement mode",
+	"stage", "rule", "action", "mode",
+)
+
+// CacheLookups counts the lookups of the response cache by method and
+// result: "hit", "miss" or "error" when the store failed
+var CacheLookups = Default.NewCounter(
+	"safectx_cache_lookups_total",
+	"Lookups of the response cache by method and result",
+	"method", "result",
+)
+
+// ApprovalDecisions counts the decided approvals by policy rule and
+// decision: "approved", "denied" or "expired"
+var ApprovalDecisions = Default.NewCounter(
+	"safectx_approval_decisions_total",
+	"Decided approvals of held requests by rule and decision",
+	"rule", "decision",
+)
+
+// NewCounter creates a counter with the given label names and registers it
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+
+	r.mu.Lock()
+	r.counters = append(r.counters, c)
+	r.mu.Unlock()
+	return c
+}
+
+// Inc adds one to the counter for the given label values, which must be
+// in the order of the label names
+func (c *Counter) Inc(values ...string) {
+	key := c.key(values)
+
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+// Value returns the current value for the given label values
+func (c *Counter) Value(values ...string) float64 {
+	key := c.key(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+// key renders the label values in the exposition format
+func (c *Counter) key(values []string) string {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", c.name, len(c.labels), len(values)))
+	}
+
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = fmt.Sprintf("%s=%q", c.labels[i], value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Handler serves the counters of the registry in the Prometheus text
+// exposition format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		counters := append([]*Counter(nil), r.counters...)
+		r.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, c := range counters {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+
+			c.mu.Lock()
+			keys := make([]string, 0, len(c.values))
+			for key := range c.values {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Fprintf(w, "%s{%s} %g\n", c.name, key, c.values[key])
+			}
+			c.mu.Unlock()
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryHandler(t *testing.T) {
+	registry := &Registry{}
+	requests := registry.NewCounter("test_requests_total", "Requests by method", "method")
+	requests.Inc("tools/call")
+	requests.Inc("tools/call")
+	requests.Inc(`say "hi"`)
+
+	rr := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := strings.Join([]string{
+		"# HELP test_requests_total Requests by method",
+		"# TYPE test_requests_total counter",
+		`test_requests_total{method="say \"hi\""} 1`,
+		`test_requests_total{method="tools/call"} 2`,
+		"",
+	}, "\n")
+	if rr.Body.String() != want {
+		t.Errorf("Got metrics:\n%s\nwant:\n%s", rr.Body.String(), want)
+	}
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"slices"
 )
 
 // Authenticator defines the interface for authentication methods
@@ -33,12 +34,38 @@ func AuthMiddleware(auth Authenticator) Middleware {
 			}
 
 			// Add user to request context
-			ctx := context.WithValue(r.Context(), userContextKey, user)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
 		})
 	}
 }
 
+// RoleMiddleware creates a middleware that only lets through authenticated
+// users with any of the given roles, or any authenticated user if none are
+// given. It must run after AuthMiddleware.
+func RoleMiddleware(roles ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := GetUserFromContext(r)
+			if !ok || user == nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if len(roles) > 0 && !slices.ContainsFunc(user.Roles, func(role string) bool {
+				return slices.Contains(roles, role)
+			}) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithUser returns a copy of ctx carrying the authenticated user
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
 // GetUserFromContext retrieves the authenticated user from the request context
 func GetUserFromContext(r *http.Request) (*User, bool) {
 	user, ok := r.Context().Value(userContextKey).(*User)
@@ -92,3 +92,34 @@ func (m *mockAuthenticator) Authenticate(r *http.Request) (*User, error) {
 		Claims:     make(map[string]interface{}),
 	}, nil
 }
+
+func TestRoleMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		user           *User
+		roles          []string
+		expectedStatus int
+	}{
+		{name: "No user", roles: []string{"admin"}, expectedStatus: http.StatusUnauthorized},
+		{name: "Missing role", user: &User{ID: "bob", Roles: []string{"dev"}}, roles: []string{"admin"}, expectedStatus: http.StatusForbidden},
+		{name: "Matching role", user: &User{ID: "alice", Roles: []string{"dev", "admin"}}, roles: []string{"admin"}, expectedStatus: http.StatusOK},
+		{name: "No roles required", user: &User{ID: "bob"}, expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RoleMiddleware(tt.roles...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			if tt.user != nil {
+				req = req.WithContext(WithUser(req.Context(), tt.user))
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+}
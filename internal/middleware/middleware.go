@@ -25,7 +25,7 @@ type RequestContext struct {
 
 // GetRequestContext retrieves the request context from the request
 func GetRequestContext(r *http.Request) *RequestContext {
-	if ctx := r.Context().Value(contextKey); ctx != nil {
+	if ctx := r.Context().Value(contextKeyValue); ctx != nil {
 		return ctx.(*RequestContext)
 	}
 	return &RequestContext{}
@@ -40,8 +40,10 @@ func (a *SAMLAuthenticator) Authenticate(r *http.Request) (*User, error) {
 	}
 
 	// Add all attributes to the user's claims map
-	for _, attr := range session.(samlsp.SessionWithAttributes).GetAttributes().All() {
-		user.Claims[attr.Name] = attr.Values[0]
+	for name, values := range session.(samlsp.SessionWithAttributes).GetAttributes() {
+		if len(values) > 0 {
+			user.Claims[name] = values[0]
+		}
 	}
 
 	return user, nil
@@ -64,8 +66,7 @@ func (a *SAMLAuthenticator) HandleSAMLResponse(w http.ResponseWriter, r *http.Re
 	}
 
 	// Decode the assertion
-	assertion, err := base64.StdEncoding.DecodeString(response.Assertion)
-	if err != nil {
+	if _, err := base64.StdEncoding.DecodeString(response.Assertion); err != nil {
 		http.Error(w, "Invalid SAML assertion", http.StatusBadRequest)
 		return
 	}
@@ -0,0 +1,410 @@
+// Package openai fronts a model server speaking the OpenAI-compatible chat
+// completions API with the same inspection as MCP traffic
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+
+	"safectx/internal/config"
+	"safectx/internal/pipeline"
+	"safectx/internal/rpc"
+	"safectx/pkg/schema"
+)
+
+// Handler relays chat completion requests to the model server. A request
+// is screened as a chat/completions call: the system, developer and user
+// messages are checked for injections and redacted, tool results are
+// filtered like upstream responses, and the tools offered to the model are
+// limited to those the caller may call. The content of the completion is
+// filtered too, and every tool call the model makes is screened like a
+// tools/call request before it reaches the caller.
+type Handler struct {
+	cfg       *config.ChatCompletionsConfig
+	inspector *rpc.Inspector
+	client    *http.Client
+	apiKey    string
+	upstream  rpc.Forwarder
+}
+
+// NewHandler creates a chat completions handler. The API key of the model
+// server is read from the environment variable named in cfg.
+func NewHandler(cfg *config.ChatCompletionsConfig, inspector *rpc.Inspector) *Handler {
+	h := &Handler{
+		cfg:       cfg,
+		inspector: inspector,
+		client:    &http.Client{},
+	}
+	if cfg.APIKeyEnv != "" {
+		h.apiKey = os.Getenv(cfg.APIKeyEnv)
+	}
+	return h
+}
+
+// WithUpstream sets the MCP upstream the offered tools are served by, so
+// tools it renames are also checked under the upstream's own names
+func (h *Handler) WithUpstream(upstream rpc.Forwarder) *Handler {
+	h.upstream = upstream
+	return h
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, &schema.Error{Code: schema.CodeInvalidRequest, Message: "Method not allowed"})
+		return
+	}
+
+	body, err := decodeBody(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, &schema.Error{Code: schema.CodeInvalidRequest, Message: err.Error()})
+		return
+	}
+
+	correlationID := r.Header.Get("X-Request-ID")
+	if correlationID == "" {
+		correlationID = rpc.NewCorrelationID()
+	}
+	call := &pipeline.Call{
+		Request: &schema.MCPRequest{
+			JSONRPC: schema.Version,
+			ID:      schema.StringID(rpc.NewCorrelationID()),
+			Method:  schema.MethodChatCompletions,
+			Params:  body,
+		},
+		Subject:       rpc.SubjectFrom(r),
+		Path:          r.URL.Path,
+		CorrelationID: correlationID,
+	}
+
+	result, rpcErr := h.inspector.ScreenResult(call)
+	if rpcErr == nil {
+		rpcErr = h.inspector.Hold(r.Context(), call, result)
+	}
+	if rpcErr == nil {
+		rpcErr = h.inspectToolResults(call)
+	}
+	if rpcErr == nil {
+		rpcErr = h.filterTools(call, rpc.UpstreamTools(h.upstream, r))
+	}
+	if rpcErr != nil {
+		writeError(w, rpc.StatusForCode(rpcErr.Code), rpcErr)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.Timeout)
+	defer cancel()
+	resp, err := h.forward(ctx, r, body)
+	if err != nil {
+		log.Printf("Error forwarding chat completion %s: %v", correlationID, err)
+		writeError(w, 0, upstreamError(ctx, err))
+		return
+	}
+	defer resp.Body.Close()
+
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	rpc.StripHopHeaders(w.Header())
+	if resp.StatusCode >= http.StatusBadRequest {
+		// Errors of the model server may echo the prompt, so only their
+		// status is relayed
+		log.Printf("Model server answered chat completion %s with status %d", correlationID, resp.StatusCode)
+		writeError(w, resp.StatusCode, &schema.Error{Code: schema.CodeUpstreamError, Message: "Model server error: " + http.StatusText(resp.StatusCode)})
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/event-stream" {
+		h.relayStream(ctx, w, resp, call)
+		return
+	}
+
+	completion, err := decodeObject(resp.Body)
+	if err != nil {
+		log.Printf("Error reading chat completion %s: %v", correlationID, err)
+		writeError(w, 0, upstreamError(ctx, err))
+		return
+	}
+	if rpcErr := h.inspectCompletion(ctx, call, completion); rpcErr != nil {
+		writeError(w, 0, rpcErr)
+		return
+	}
+	w.Header().Del("Content-Length")
+	writeJSON(w, resp.StatusCode, completion)
+}
+
+// inspectToolResults runs the tool results of a chat/completions call
+// through the response filter, like the results of tools/call requests
+func (h *Handler) inspectToolResults(call *pipeline.Call) *schema.Error {
+	messages, _ := call.Request.ChatMessages()
+	for _, msg := range messages {
+		if msg.Role != schema.RoleTool {
+			continue
+		}
+		if rpcErr := h.inspectTexts(call, msg); rpcErr != nil {
+			return rpcErr
+		}
+	}
+	return nil
+}
+
+// filterTools removes the tools the caller may not call from the tools
+// offered to the model. Tools that require approval are kept, since the
+// model's calls of them are held until decided. Forcing the model to call a
+// removed tool is denied. upstreamTools resolves the upstream's own tool
+// names.
+// When no tools remain, the tool options are removed, unless a tool call is
+// required.
+func (h *Handler) filterTools(call *pipeline.Call, upstreamTools rpc.ToolResolver) *schema.Error {
+	params := call.Request.Params
+	if name := forcedTool(params["tool_choice"]); name != "" {
+		if rpcErr := h.inspector.AuthorizeTool(call.Subject, name, upstreamTools, call.CorrelationID); rpcErr != nil {
+			return rpcErr
+		}
+	}
+
+	tools, ok := params["tools"].([]interface{})
+	if !ok {
+		return nil
+	}
+	kept := make([]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		name := toolName(tool)
+		if rpcErr := h.inspector.AuthorizeTool(call.Subject, name, upstreamTools, call.CorrelationID); rpcErr != nil {
+			log.Printf("Request %s (%s): removed tool %s: %s", call.Request.ID, call.Request.Method, name, rpcErr.Message)
+			continue
+		}
+		kept = append(kept, tool)
+	}
+	if len(kept) > 0 {
+		params["tools"] = kept
+		return nil
+	}
+	if params["tool_choice"] == "required" {
+		return &schema.Error{Code: schema.CodePolicyDenied, Message: "No permitted tools"}
+	}
+	delete(params, "tools")
+	delete(params, "tool_choice")
+	delete(params, "parallel_tool_calls")
+	return nil
+}
+
+// inspectCompletion filters the messages of a chat completion and screens
+// the tool calls they make
+func (h *Handler) inspectCompletion(ctx context.Context, call *pipeline.Call, completion map[string]interface{}) *schema.Error {
+	choices, _ := completion["choices"].([]interface{})
+	for i, item := range choices {
+		choice, _ := item.(map[string]interface{})
+		msg, ok := choice["message"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if rpcErr := h.inspectMessage(ctx, call, schema.ParseChatMessage(msg, fmt.Sprintf("/choices/%d/message", i))); rpcErr != nil {
+			return rpcErr
+		}
+	}
+	return nil
+}
+
+// inspectMessage filters the texts of a message of the model and screens
+// its tool calls
+func (h *Handler) inspectMessage(ctx context.Context, call *pipeline.Call, msg schema.ChatMessage) *schema.Error {
+	if rpcErr := h.inspectTexts(call, msg); rpcErr != nil {
+		return rpcErr
+	}
+	for i := range msg.ToolCalls {
+		if rpcErr := h.screenToolCall(ctx, call, &msg.ToolCalls[i]); rpcErr != nil {
+			return rpcErr
+		}
+	}
+	return nil
+}
+
+// inspectTexts runs the texts of a message through the response filter and
+// replaces those it changes
+func (h *Handler) inspectTexts(call *pipeline.Call, msg schema.ChatMessage) *schema.Error {
+	for i := range msg.Texts {
+		text, rpcErr := h.inspector.InspectContent(call.Request, msg.Texts[i].Path, msg.Texts[i].Text)
+		if rpcErr != nil {
+			return rpcErr
+		}
+		if text != msg.Texts[i].Text {
+			msg.Texts[i].Set(text)
+		}
+	}
+	return nil
+}
+
+// screenToolCall screens a tool call of the model as a tools/call request
+// of the caller, and writes back the arguments if they are redacted. A call
+// that requires approval is held until it is decided.
+func (h *Handler) screenToolCall(ctx context.Context, call *pipeline.Call, tc *schema.ChatToolCall) *schema.Error {
+	params := map[string]interface{}{"name": tc.Name}
+	if tc.Arguments != nil {
+		params["arguments"] = tc.Arguments
+	} else if tc.RawArguments != "" {
+		// Arguments that are not an object are still scanned
+		params["arguments"] = tc.RawArguments
+	}
+	before, _ := json.Marshal(params["arguments"])
+
+	toolCall := &pipeline.Call{
+		Request: &schema.MCPRequest{
+			JSONRPC: schema.Version,
+			ID:      schema.StringID(rpc.NewCorrelationID()),
+			Method:  schema.MethodToolsCall,
+			Params:  params,
+		},
+		Subject:       call.Subject,
+		Path:          call.Path,
+		CorrelationID: call.CorrelationID,
+	}
+	result, rpcErr := h.inspector.ScreenResult(toolCall)
+	if rpcErr == nil {
+		rpcErr = h.inspector.Hold(ctx, toolCall, result)
+	}
+	if rpcErr != nil {
+		log.Printf("Request %s (%s): blocked tool call %s of the model: %s", call.Request.ID, call.Request.Method, tc.Name, rpcErr.Message)
+		return rpcErr
+	}
+
+	if args, ok := toolCall.Request.Params["arguments"].(map[string]interface{}); ok {
+		if after, _ := json.Marshal(args); !bytes.Equal(before, after) {
+			tc.Arguments = args
+			tc.Update()
+		}
+	}
+	return nil
+}
+
+// forward sends the screened request body to the model server
+func (h *Handler) forward(ctx context.Context, r *http.Request, body map[string]interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.Upstream, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if accept := r.Header.Get("Accept"); accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	// The credentials of the caller are never passed on
+	if h.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.apiKey)
+	}
+	return h.client.Do(req)
+}
+
+// decodeBody decodes a chat completion request, which must have a list of
+// messages
+func decodeBody(r io.Reader) (map[string]interface{}, error) {
+	body, err := decodeObject(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	if _, ok := body["messages"].([]interface{}); !ok {
+		return nil, errors.New("messages must be an array")
+	}
+	return body, nil
+}
+
+// decodeObject decodes a JSON object, keeping numbers as they were sent
+func decodeObject(r io.Reader) (map[string]interface{}, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, errors.New("expected a JSON object")
+	}
+	return obj, nil
+}
+
+// forcedTool returns the function a tool_choice forces the model to call,
+// or "" if it does not force one
+func forcedTool(choice interface{}) string {
+	c, _ := choice.(map[string]interface{})
+	function, _ := c["function"].(map[string]interface{})
+	name, _ := function["name"].(string)
+	return name
+}
+
+// toolName returns the name of a function in the tools of a request
+func toolName(tool interface{}) string {
+	t, _ := tool.(map[string]interface{})
+	function, _ := t["function"].(map[string]interface{})
+	name, _ := function["name"].(string)
+	return name
+}
+
+// upstreamError returns the error reported when the model server could not
+// be reached or its response could not be read
+func upstreamError(ctx context.Context, err error) *schema.Error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &schema.Error{Code: schema.CodeUpstreamTimeout, Message: "Upstream request timed out"}
+	}
+	if errors.Is(err, context.Canceled) {
+		return &schema.Error{Code: schema.CodeRequestCancelled, Message: "Request cancelled"}
+	}
+	return &schema.Error{Code: schema.CodeUpstreamError, Message: "Upstream unavailable"}
+}
+
+// apiError is an error in the format of the OpenAI API. The gateway error
+// code and its denial data are kept.
+type apiError struct {
+	Message string      `json:"message"`
+	Type    string      `json:"type"`
+	Code    int         `json:"code"`
+	Denial  interface{} `json:"denial,omitempty"`
+}
+
+// errorBody returns the response body reporting a gateway error
+func errorBody(status int, rpcErr *schema.Error) map[string]interface{} {
+	typ := "server_error"
+	switch {
+	case status == http.StatusForbidden:
+		typ = "permission_error"
+	case status == http.StatusTooManyRequests:
+		typ = "rate_limit_error"
+	case status < http.StatusInternalServerError:
+		typ = "invalid_request_error"
+	}
+	return map[string]interface{}{"error": apiError{
+		Message: rpcErr.Message,
+		Type:    typ,
+		Code:    rpcErr.Code,
+		Denial:  rpcErr.Data,
+	}}
+}
+
+// writeError writes a gateway error in the format of the OpenAI API. A zero
+// status is derived from the error code.
+func writeError(w http.ResponseWriter, status int, rpcErr *schema.Error) {
+	if status == 0 {
+		status = rpc.StatusForCode(rpcErr.Code)
+	}
+	w.Header().Del("Content-Length")
+	writeJSON(w, status, errorBody(status, rpcErr))
+}
+
+// writeJSON writes v as a JSON response with the given HTTP status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"safectx/internal/pipeline"
+	"safectx/internal/rpc"
+	"safectx/pkg/schema"
+)
+
+// envelopeKeys are the fields of a chunk copied into the chunks the
+// gateway sends itself
+var envelopeKeys = []string{"id", "object", "created", "model", "system_fingerprint"}
+
+// pendingCall is a tool call the model is still streaming
+type pendingCall struct {
+	id        string
+	name      string
+	arguments strings.Builder
+}
+
+// chatStream holds the state of a streamed chat completion being relayed
+type chatStream struct {
+	h    *Handler
+	ctx  context.Context
+	call *pipeline.Call
+	// pending holds the tool calls of each choice by their index
+	pending map[int]map[int]*pendingCall
+	// held holds the filtered content of each choice that is scanned again
+	// with the next delta before it is sent
+	held map[int]string
+	// envelope holds the envelope fields of the latest chunk
+	envelope map[string]interface{}
+}
+
+// relayStream relays a streamed chat completion. Content deltas are
+// filtered as they arrive, together with the end of the content before
+// them, which is held back so a pattern split across deltas is found.
+// Tool call deltas are held until their choice finishes, then the complete
+// calls are screened and sent in a single delta. A blocked stream ends
+// with an error event.
+func (h *Handler) relayStream(ctx context.Context, w http.ResponseWriter, resp *http.Response, call *pipeline.Call) {
+	w.Header().Del("Content-Length")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(resp.StatusCode)
+
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		log.Printf("Streaming response cannot be flushed: %v", err)
+	}
+
+	s := &chatStream{
+		h:        h,
+		ctx:      ctx,
+		call:     call,
+		pending:  make(map[int]map[int]*pendingCall),
+		held:     make(map[int]string),
+		envelope: make(map[string]interface{}),
+	}
+	events := rpc.NewEventReader(resp.Body)
+	for {
+		var out []*rpc.Event
+		var rpcErr *schema.Error
+		ev, err := events.Next()
+		switch {
+		case err == io.EOF:
+			// Release the calls of a stream that ended without finishing
+			out, rpcErr = s.releaseAll()
+		case err != nil:
+			log.Printf("Error reading chat completion stream: %v", err)
+			rpcErr = upstreamError(ctx, err)
+		default:
+			out, rpcErr = s.inspect(ev)
+		}
+
+		for _, ev := range out {
+			if err := rpc.WriteEvent(w, ev); err != nil {
+				log.Printf("Error writing event to client: %v", err)
+				return
+			}
+		}
+		if rpcErr != nil {
+			data, _ := json.Marshal(errorBody(rpc.StatusForCode(rpcErr.Code), rpcErr))
+			rpc.WriteEvent(w, &rpc.Event{Data: data})
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("Error flushing event to client: %v", err)
+			return
+		}
+		if err != nil || rpcErr != nil {
+			return
+		}
+	}
+}
+
+// inspect returns the events to send for an event of the model server
+func (s *chatStream) inspect(ev *rpc.Event) ([]*rpc.Event, *schema.Error) {
+	data := bytes.TrimSpace(ev.Data)
+	if len(data) == 0 {
+		return []*rpc.Event{ev}, nil
+	}
+	if string(data) == "[DONE]" {
+		out, rpcErr := s.releaseAll()
+		return append(out, ev), rpcErr
+	}
+
+	chunk, err := decodeObject(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Error reading chat completion chunk: %v", err)
+		return nil, &schema.Error{Code: schema.CodeUpstreamError, Message: "Invalid upstream response"}
+	}
+	for _, key := range envelopeKeys {
+		if v, ok := chunk[key]; ok {
+			s.envelope[key] = v
+		}
+	}
+
+	var out []*rpc.Event
+	choices, _ := chunk["choices"].([]interface{})
+	for _, item := range choices {
+		choice, _ := item.(map[string]interface{})
+		index := toIndex(choice["index"])
+		finished := choice["finish_reason"] != nil
+		delta, _ := choice["delta"].(map[string]interface{})
+		if text, _ := delta["content"].(string); text != "" || (finished && s.held[index] != "") {
+			content, rpcErr := s.content(index, text, finished)
+			if rpcErr != nil {
+				return out, rpcErr
+			}
+			if delta == nil {
+				delta = make(map[string]interface{})
+				choice["delta"] = delta
+			}
+			delta["content"] = content
+		}
+		if calls, ok := delta["tool_calls"].([]interface{}); ok {
+			s.hold(index, calls)
+			delete(delta, "tool_calls")
+		}
+		if finished && len(s.pending[index]) > 0 {
+			released, rpcErr := s.release(index)
+			if rpcErr != nil {
+				return out, rpcErr
+			}
+			out = append(out, released)
+		}
+	}
+
+	if ev.Data, err = json.Marshal(chunk); err != nil {
+		return out, &schema.Error{Code: schema.CodeUpstreamError, Message: "Invalid upstream response"}
+	}
+	return append(out, ev), nil
+}
+
+// content filters a content delta of a choice together with the content
+// held back before it, and returns the content to send. Unless the choice
+// finished, the end of the content is held back for the next delta: the
+// last characters up to the configured holdback, as long as the filter
+// left them unchanged.
+func (s *chatStream) content(index int, text string, finished bool) (string, *schema.Error) {
+	window := s.held[index] + text
+	delete(s.held, index)
+	filtered, rpcErr := s.h.inspector.InspectContent(s.call.Request, fmt.Sprintf("/choices/%d/delta/content", index), window)
+	if rpcErr != nil || finished {
+		return filtered, rpcErr
+	}
+
+	cut := len(filtered) - commonSuffix(window, filtered)
+	if start := len(filtered) - holdbackBytes(filtered, s.h.cfg.StreamHoldback); start > cut {
+		cut = start
+	}
+	for cut < len(filtered) && !utf8.RuneStart(filtered[cut]) {
+		cut++
+	}
+	if cut < len(filtered) {
+		s.held[index] = filtered[cut:]
+	}
+	return filtered[:cut], nil
+}
+
+// commonSuffix returns the length in bytes of the longest common suffix of
+// a and b
+func commonSuffix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
+
+// holdbackBytes returns the length in bytes of the last n characters of s
+func holdbackBytes(s string, n int) int {
+	size := 0
+	for ; n > 0 && size < len(s); n-- {
+		_, width := utf8.DecodeLastRuneInString(s[:len(s)-size])
+		size += width
+	}
+	return size
+}
+
+// hold adds the tool call deltas of a choice to its pending calls. The ID
+// and name arrive with the first delta of a call, its arguments in pieces.
+func (s *chatStream) hold(index int, deltas []interface{}) {
+	calls := s.pending[index]
+	if calls == nil {
+		calls = make(map[int]*pendingCall)
+		s.pending[index] = calls
+	}
+	for _, item := range deltas {
+		delta, _ := item.(map[string]interface{})
+		i := toIndex(delta["index"])
+		p := calls[i]
+		if p == nil {
+			p = &pendingCall{}
+			calls[i] = p
+		}
+		if id, ok := delta["id"].(string); ok && id != "" {
+			p.id = id
+		}
+		function, _ := delta["function"].(map[string]interface{})
+		if name, ok := function["name"].(string); ok && name != "" {
+			p.name = name
+		}
+		if args, ok := function["arguments"].(string); ok {
+			p.arguments.WriteString(args)
+		}
+	}
+}
+
+// release screens the pending tool calls of a choice and returns the chunk
+// sending them whole
+func (s *chatStream) release(index int) (*rpc.Event, *schema.Error) {
+	calls := s.pending[index]
+	delete(s.pending, index)
+
+	order := make([]int, 0, len(calls))
+	for i := range calls {
+		order = append(order, i)
+	}
+	sort.Ints(order)
+	list := make([]interface{}, 0, len(calls))
+	for _, i := range order {
+		list = append(list, map[string]interface{}{
+			"index": i,
+			"id":    calls[i].id,
+			"type":  "function",
+			"function": map[string]interface{}{
+				"name":      calls[i].name,
+				"arguments": calls[i].arguments.String(),
+			},
+		})
+	}
+
+	msg := map[string]interface{}{"role": schema.RoleAssistant, "tool_calls": list}
+	if rpcErr := s.h.inspectMessage(s.ctx, s.call, schema.ParseChatMessage(msg, fmt.Sprintf("/choices/%d/delta", index))); rpcErr != nil {
+		return nil, rpcErr
+	}
+	return s.chunk(index, map[string]interface{}{"tool_calls": list})
+}
+
+// chunk returns a chunk sending a delta of a choice
+func (s *chatStream) chunk(index int, delta map[string]interface{}) (*rpc.Event, *schema.Error) {
+	chunk := make(map[string]interface{}, len(s.envelope)+1)
+	for key, v := range s.envelope {
+		chunk[key] = v
+	}
+	chunk["choices"] = []interface{}{map[string]interface{}{
+		"index":         index,
+		"delta":         delta,
+		"finish_reason": nil,
+	}}
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return nil, &schema.Error{Code: schema.CodeUpstreamError, Message: "Invalid upstream response"}
+	}
+	return &rpc.Event{Data: data}, nil
+}
+
+// releaseAll sends the held content and releases the pending tool calls
+// of every choice
+func (s *chatStream) releaseAll() ([]*rpc.Event, *schema.Error) {
+	order := make([]int, 0, len(s.pending)+len(s.held))
+	for index := range s.held {
+		order = append(order, index)
+	}
+	for index := range s.pending {
+		if _, ok := s.held[index]; !ok {
+			order = append(order, index)
+		}
+	}
+	sort.Ints(order)
+
+	var out []*rpc.Event
+	for _, index := range order {
+		if content, ok := s.held[index]; ok {
+			delete(s.held, index)
+			ev, rpcErr := s.chunk(index, map[string]interface{}{"content": content})
+			if rpcErr != nil {
+				return out, rpcErr
+			}
+			out = append(out, ev)
+		}
+		if len(s.pending[index]) == 0 {
+			continue
+		}
+		ev, rpcErr := s.release(index)
+		if rpcErr != nil {
+			return out, rpcErr
+		}
+		out = append(out, ev)
+	}
+	return out, nil
+}
+
+// toIndex returns the value of a decoded index field, or 0
+func toIndex(v interface{}) int {
+	switch n := v.(type) {
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
+	case float64:
+		return int(n)
+	case int:
+		return n
+	default:
+		return 0
+	}
+}
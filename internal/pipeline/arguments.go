@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"safectx/internal/config"
+	"safectx/internal/jsonschema"
+	"safectx/internal/toolschema"
+	"safectx/pkg/schema"
+)
+
+// StageArguments is the name of the arguments stage
+const StageArguments = config.ArgumentsStage
+
+// Rules of the arguments stage
+const (
+	// InvalidArgumentsRuleID denies a tools/call whose arguments violate
+	// the input schema of the tool
+	InvalidArgumentsRuleID = "invalid-arguments"
+	// UnknownToolSchemaRuleID denies a tools/call of a tool without a
+	// known schema, when configured to
+	UnknownToolSchemaRuleID = "unknown-tool-schema"
+)
+
+// maxReportedViolations bounds the schema violations listed in a reason
+const maxReportedViolations = 5
+
+// NewArgumentsStage creates the stage validating the arguments of
+// tools/call requests against the input schema the registry holds for the
+// tool. Violations are reported with the JSON pointer of the offending
+// argument. Learned schemas are those of the upstream the call is routed
+// to, under its own name of the tool. A tool exposed under another name is
+// checked against the schemas pinned for both names. Calls of tools without a known schema are allowed
+// unless the registry denies them. Messages from the server are not checked.
+func NewArgumentsStage(registry *toolschema.Registry) Stage {
+	return NewStage(StageArguments, func(call *Call) Verdict {
+		req := call.Request
+		if call.FromServer || req.Method != schema.MethodToolsCall {
+			return Verdict{Outcome: Allow}
+		}
+		name, ok := req.Params["name"].(string)
+		if !ok {
+			return Verdict{Outcome: Allow}
+		}
+
+		// A tool exposed under another name must satisfy the schema pinned
+		// under each name
+		var schemas []*jsonschema.Schema
+		for _, tool := range []string{name, call.Tool} {
+			if s, ok := registry.Pinned(tool); ok && tool != "" {
+				schemas = append(schemas, s)
+			}
+		}
+		tool := call.Tool
+		if tool == "" {
+			tool = name
+		}
+		if s, ok := registry.Learned(call.Upstream, tool); ok {
+			schemas = append(schemas, s)
+		}
+		if len(schemas) == 0 {
+			if !registry.DenyUnknown() {
+				return Verdict{Outcome: Allow}
+			}
+			return Verdict{
+				Outcome:  Deny,
+				RuleID:   UnknownToolSchemaRuleID,
+				Reason:   fmt.Sprintf("tool %s has no known input schema", name),
+				Severity: SeverityMedium,
+				Path:     "/name",
+				Code:     schema.CodePolicyDenied,
+				Message:  "Unknown tool",
+			}
+		}
+
+		// Omitted arguments are validated as an empty object, so required
+		// arguments are still enforced
+		args, ok := req.Params["arguments"]
+		if !ok {
+			args = map[string]interface{}{}
+		}
+		var violations []jsonschema.Violation
+		for _, s := range schemas {
+			if violations = s.Validate(args); len(violations) > 0 {
+				break
+			}
+		}
+		if len(violations) == 0 {
+			return Verdict{Outcome: Allow}
+		}
+
+		reported := make([]string, 0, maxReportedViolations)
+		for _, v := range violations {
+			if len(reported) == maxReportedViolations {
+				reported = append(reported, fmt.Sprintf("and %d more", len(violations)-maxReportedViolations))
+				break
+			}
+			reported = append(reported, fmt.Sprintf("/arguments%s: %s", v.Path, v.Message))
+		}
+		return Verdict{
+			Outcome:  Deny,
+			RuleID:   InvalidArgumentsRuleID,
+			Reason:   fmt.Sprintf("arguments of tool %s violate its input schema: %s", name, strings.Join(reported, "; ")),
+			Severity: SeverityMedium,
+			Path:     "/arguments" + violations[0].Path,
+			Code:     schema.CodeInvalidParams,
+			Message:  "Invalid tool arguments",
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"safectx/internal/config"
+	"safectx/internal/handshake"
+	"safectx/pkg/schema"
+)
+
+// StageHandshake is the name of the handshake stage
+const StageHandshake = config.HandshakeStage
+
+// Rules of the handshake stage
+const (
+	// ProtocolVersionRuleID denies an initialize request, or blocks its
+	// result, for a protocol version that is not allowed
+	ProtocolVersionRuleID = "protocol-version"
+	// StripCapabilitiesRuleID removes denied capabilities from an
+	// initialize request or result
+	StripCapabilitiesRuleID = "strip-capabilities"
+	// CapabilityRuleID denies a message relying on a capability the
+	// session did not negotiate
+	CapabilityRuleID = "capability-not-negotiated"
+)
+
+// NewHandshakeStage creates the stage governing the MCP handshake. It
+// denies initialize requests for a protocol version the guard does not
+// allow and strips the denied client capabilities from them. Later
+// messages in either direction are denied if they rely on a capability
+// the session did not negotiate. The server side of the handshake is
+// handled when its initialize result is inspected.
+func NewHandshakeStage(guard *handshake.Guard) Stage {
+	return NewStage(StageHandshake, func(call *Call) Verdict {
+		req := call.Request
+		if params, ok := req.Initialize(); ok && !call.FromServer {
+			if !guard.VersionAllowed(params.ProtocolVersion) {
+				return Verdict{
+					Outcome:  Deny,
+					RuleID:   ProtocolVersionRuleID,
+					Reason:   fmt.Sprintf("protocol version %s is not allowed, supported: %s", params.ProtocolVersion, strings.Join(guard.Versions(), ", ")),
+					Severity: SeverityMedium,
+					Path:     "/protocolVersion",
+					Code:     schema.CodeInvalidParams,
+					Message:  "Unsupported protocol version",
+				}
+			}
+
+			caps, stripped := guard.Strip(params.Capabilities)
+			if len(stripped) == 0 {
+				return Verdict{Outcome: Allow}
+			}
+			return Verdict{
+				Outcome:  Mutate,
+				RuleID:   StripCapabilitiesRuleID,
+				Reason:   "stripped client capabilities: " + strings.Join(stripped, ", "),
+				Severity: SeverityLow,
+				Path:     "/capabilities",
+				Apply: func(req *schema.MCPRequest) {
+					req.Params["capabilities"] = map[string]interface{}(caps)
+				},
+			}
+		}
+
+		if r, ok := guard.Allowed(call.Session, req.Method); !ok {
+			return Verdict{
+				Outcome:  Deny,
+				RuleID:   CapabilityRuleID,
+				Reason:   fmt.Sprintf("%s relies on the %s capability %s, which the session did not negotiate", req.Method, r.Side, r.Capability),
+				Severity: SeverityMedium,
+				Code:     schema.CodePolicyDenied,
+				Message:  "Capability not negotiated",
+			}
+		}
+		return Verdict{Outcome: Allow}
+	})
+}
@@ -0,0 +1,306 @@
+package pipeline
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"safectx/internal/enforcement"
+	"safectx/internal/policy"
+	"safectx/pkg/schema"
+)
+
+// Outcome is the decision of a stage about a request
+type Outcome string
+
+// Stage outcomes
+const (
+	// Allow lets the request continue unchanged
+	Allow Outcome = "allow"
+	// Deny stops the request
+	Deny Outcome = "deny"
+	// Mutate changes the request before it continues
+	Mutate Outcome = "mutate"
+	// Flag lets the request continue but records a finding
+	Flag Outcome = "flag"
+	// Approve holds the request until a human approves it. The remaining
+	// stages still run, and a later denial wins.
+	Approve Outcome = "approve"
+)
+
+// Severity rates how serious the finding behind a verdict is
+const (
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// ScoreRuleID identifies denials caused by the total score
+const ScoreRuleID = "score-threshold"
+
+// Call is a request passing through the pipeline
+type Call struct {
+	// Request is the validated JSON-RPC request
+	Request *schema.MCPRequest
+	// Subject is the authenticated caller, or nil
+	Subject *policy.Subject
+	// Path is the URL path the request was sent to. It is empty for
+	// transports without paths, e.g. stdio.
+	Path string
+	// CorrelationID identifies the request in logs and in the error data
+	// of a denial
+	CorrelationID string
+	// Session identifies the MCP session or connection the request was
+	// sent on, if any
+	Session string
+	// FromServer is set for requests and notifications the upstream server
+	// sends to the client
+	FromServer bool
+	// Upstream names the upstream a message from the server came from, or
+	// the one a tools/call is routed to, if known
+	Upstream string
+	// Tool is the upstream's own name of the tool a tools/call names, when
+	// the gateway exposes it under another name, e.g. with the upstream
+	// prefix. Stages matching tool names check both names.
+	Tool string
+	// DryRun is set when the call is only explained against the live
+	// gateway, e.g. on /explain. Stages that keep state must not change it.
+	DryRun bool
+}
+
+// Verdict is the structured decision of a stage
+type Verdict struct {
+	Outcome Outcome
+	// Stage is the name of the stage that returned the verdict. It is set
+	// by the pipeline.
+	Stage string
+	// RuleID identifies the rule that decided within the stage. The stage
+	// name is used when it is empty.
+	RuleID string
+	// Reason explains the verdict in logs
+	Reason string
+	// Severity rates the finding, e.g. SeverityHigh
+	Severity string
+	// Path is the JSON pointer of the field within params that the verdict
+	// is about, if any
+	Path string
+	// Code and Message are the JSON-RPC error reported for a denial
+	Code    int
+	Message string
+	// Score is the risk attributed to the request, added up across stages
+	Score float64
+	// Apply makes the change of a Mutate verdict. Stages never change the
+	// request themselves, so the pipeline can skip the change when the
+	// stage is only monitored.
+	Apply func(req *schema.MCPRequest)
+	// Monitored is set by the pipeline when the verdict was recorded but
+	// not enforced
+	Monitored bool
+}
+
+// Stage is a single check of the request pipeline
+type Stage interface {
+	// Name identifies the stage in configuration, logs, metrics and
+	// enforcement modes
+	Name() string
+	// Inspect examines a request and returns its verdict. It must not
+	// change the request; changes are returned in a Mutate verdict.
+	Inspect(call *Call) Verdict
+}
+
+// Result is the outcome of running a request through the pipeline
+type Result struct {
+	// Verdicts lists every verdict other than Allow, in stage order
+	Verdicts []Verdict
+	// Score is the total score of the verdicts
+	Score float64
+	// Denial is the enforced denial, or nil if the request may continue
+	Denial *Verdict
+	// Approval is the enforced verdict holding the request for approval,
+	// or nil if it needs none
+	Approval *Verdict
+	// Trace lists the verdict of every stage that ran, including allows.
+	// It is only set by Explain.
+	Trace []Verdict
+}
+
+// Route runs its own stages for matching requests
+type Route struct {
+	// PathPrefix matches the URL path of the call
+	PathPrefix string
+	// Methods are JSON-RPC method names or path.Match patterns
+	Methods []string
+	// Stages replace the default stages of the pipeline
+	Stages []Stage
+}
+
+// Pipeline runs requests through an ordered list of stages
+type Pipeline struct {
+	stages    []Stage
+	routes    []Route
+	denyScore float64
+}
+
+// New creates a pipeline running the given stages in order
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// WithRoute adds a route. Routes are matched in the order they were added
+// and the first match picks the stages.
+func (p *Pipeline) WithRoute(route Route) *Pipeline {
+	p.routes = append(p.routes, route)
+	return p
+}
+
+// WithDenyScore denies requests whose total score reaches score. 0
+// disables the threshold.
+func (p *Pipeline) WithDenyScore(score float64) *Pipeline {
+	p.denyScore = score
+	return p
+}
+
+// Stages returns the names of the stages that run for a call
+func (p *Pipeline) Stages(call *Call) []string {
+	stages := p.stagesFor(call)
+	names := make([]string, len(stages))
+	for i, stage := range stages {
+		names[i] = stage.Name()
+	}
+	return names
+}
+
+// Run runs a call through the stages of its route. A denial that is
+// enforced stops the pipeline; monitored verdicts are only recorded.
+// Mutations are applied to the request as the stages return them.
+func (p *Pipeline) Run(call *Call, modes *enforcement.Modes) *Result {
+	return p.run(call, modes, false)
+}
+
+// Explain runs a call like Run, without logging or counting the verdicts,
+// and traces every stage that ran
+func (p *Pipeline) Explain(call *Call, modes *enforcement.Modes) *Result {
+	return p.run(call, modes, true)
+}
+
+// run runs a call through the stages of its route. A dry run records
+// nothing and fills the trace.
+func (p *Pipeline) run(call *Call, modes *enforcement.Modes, dry bool) *Result {
+	record := func(v *Verdict) {
+		if dry {
+			v.Monitored = modes.Monitor(v.Stage, v.RuleID)
+			return
+		}
+		v.Monitored = modes.Record(v.Stage, v.RuleID, string(v.Outcome))
+		logVerdict(call, v)
+	}
+
+	result := &Result{}
+	for _, stage := range p.stagesFor(call) {
+		verdict := stage.Inspect(call)
+		verdict.Stage = stage.Name()
+		if verdict.Outcome == "" || verdict.Outcome == Allow {
+			if dry {
+				verdict.Outcome = Allow
+				result.Trace = append(result.Trace, verdict)
+			}
+			continue
+		}
+		if verdict.RuleID == "" {
+			verdict.RuleID = verdict.Stage
+		}
+		record(&verdict)
+		result.Score += verdict.Score
+		result.Verdicts = append(result.Verdicts, verdict)
+		if dry {
+			result.Trace = append(result.Trace, verdict)
+		}
+
+		switch {
+		case verdict.Monitored:
+		case verdict.Outcome == Deny:
+			result.Denial = &result.Verdicts[len(result.Verdicts)-1]
+			return result
+		case verdict.Outcome == Mutate && verdict.Apply != nil:
+			verdict.Apply(call.Request)
+		case verdict.Outcome == Approve && result.Approval == nil:
+			result.Approval = &result.Verdicts[len(result.Verdicts)-1]
+		}
+	}
+
+	if p.denyScore > 0 && result.Score >= p.denyScore {
+		verdict := Verdict{
+			Outcome:  Deny,
+			Stage:    "pipeline",
+			RuleID:   ScoreRuleID,
+			Reason:   fmt.Sprintf("score %.2f reached %.2f", result.Score, p.denyScore),
+			Severity: SeverityHigh,
+			Code:     schema.CodePolicyDenied,
+			Message:  "Request risk score too high",
+			Score:    result.Score,
+		}
+		record(&verdict)
+		result.Verdicts = append(result.Verdicts, verdict)
+		if dry {
+			result.Trace = append(result.Trace, verdict)
+		}
+		if !verdict.Monitored {
+			result.Denial = &result.Verdicts[len(result.Verdicts)-1]
+		}
+	}
+	return result
+}
+
+// stagesFor returns the stages of the first route matching the call, or
+// the default stages
+func (p *Pipeline) stagesFor(call *Call) []Stage {
+	for _, route := range p.routes {
+		if route.matches(call) {
+			return route.Stages
+		}
+	}
+	return p.stages
+}
+
+// matches reports whether every criterion set on the route matches
+func (r *Route) matches(call *Call) bool {
+	if r.PathPrefix != "" && !strings.HasPrefix(call.Path, r.PathPrefix) {
+		return false
+	}
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, pattern := range r.Methods {
+		if ok, _ := path.Match(pattern, call.Request.Method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pastTense describes enforced outcomes in logs
+var pastTense = map[Outcome]string{
+	Deny:    "Denied",
+	Mutate:  "Mutated",
+	Flag:    "Flagged",
+	Approve: "Held for approval",
+}
+
+// logVerdict logs a verdict together with the request it applies to
+func logVerdict(call *Call, v *Verdict) {
+	action, ok := pastTense[v.Outcome]
+	switch {
+	case v.Monitored:
+		action = "Monitor mode: would " + string(v.Outcome)
+	case !ok:
+		action = string(v.Outcome)
+	}
+	reason := v.Reason
+	if v.Path != "" {
+		reason += " at " + v.Path
+	}
+	req := call.Request
+	log.Printf("%s %s (%s) by %s rule %s: %s (severity %s, score %.2f, request %s)",
+		action, req.Method, req.ID, v.Stage, v.RuleID, reason, v.Severity, v.Score, call.CorrelationID)
+}
@@ -0,0 +1,575 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/internal/dedup"
+	"safectx/internal/enforcement"
+	"safectx/internal/metrics"
+	"safectx/internal/policy"
+	"safectx/internal/toolschema"
+	"safectx/pkg/schema"
+)
+
+// fixedStage returns the same verdict for every call and records that it ran
+func fixedStage(name string, verdict Verdict, ran *[]string) Stage {
+	return NewStage(name, func(call *Call) Verdict {
+		*ran = append(*ran, name)
+		return verdict
+	})
+}
+
+func newCall(method string, params map[string]interface{}) *Call {
+	return &Call{Request: &schema.MCPRequest{JSONRPC: schema.Version, ID: schema.NumberID(1), Method: method, Params: params}}
+}
+
+func TestPipelineRun(t *testing.T) {
+	setTag := func(req *schema.MCPRequest) { req.Params["tag"] = "mutated" }
+
+	tests := []struct {
+		name       string
+		verdicts   []Verdict
+		denyScore  float64
+		modes      *config.EnforcementConfig
+		wantRan    []string
+		wantDenial string
+		wantTag    string
+	}{
+		{
+			name:     "all allow",
+			verdicts: []Verdict{{Outcome: Allow}, {}, {Outcome: Allow}},
+			wantRan:  []string{"a", "b", "c"},
+		},
+		{
+			name:       "deny stops the pipeline",
+			verdicts:   []Verdict{{Outcome: Allow}, {Outcome: Deny, RuleID: "no"}, {Outcome: Mutate, Apply: setTag}},
+			wantRan:    []string{"a", "b"},
+			wantDenial: "b/no",
+		},
+		{
+			name:     "mutation is applied",
+			verdicts: []Verdict{{Outcome: Flag}, {Outcome: Mutate, Apply: setTag}, {Outcome: Allow}},
+			wantRan:  []string{"a", "b", "c"},
+			wantTag:  "mutated",
+		},
+		{
+			name:     "monitored stages only record",
+			verdicts: []Verdict{{Outcome: Deny}, {Outcome: Mutate, Apply: setTag}, {Outcome: Allow}},
+			modes:    &config.EnforcementConfig{Mode: config.ModeMonitor},
+			wantRan:  []string{"a", "b", "c"},
+		},
+		{
+			name:       "score threshold",
+			verdicts:   []Verdict{{Outcome: Flag, Score: 0.5}, {Outcome: Flag, Score: 0.6}, {Outcome: Allow}},
+			denyScore:  1,
+			wantRan:    []string{"a", "b", "c"},
+			wantDenial: "pipeline/" + ScoreRuleID,
+		},
+		{
+			name:      "score below threshold",
+			verdicts:  []Verdict{{Outcome: Flag, Score: 0.5}, {}, {}},
+			denyScore: 1,
+			wantRan:   []string{"a", "b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran []string
+			p := New(
+				fixedStage("a", tt.verdicts[0], &ran),
+				fixedStage("b", tt.verdicts[1], &ran),
+				fixedStage("c", tt.verdicts[2], &ran),
+			).WithDenyScore(tt.denyScore)
+
+			var modes *enforcement.Modes
+			if tt.modes != nil {
+				modes = enforcement.NewModes(tt.modes)
+			}
+			call := newCall("tools/call", map[string]interface{}{"tag": "original"})
+			result := p.Run(call, modes)
+
+			if !reflect.DeepEqual(ran, tt.wantRan) {
+				t.Errorf("Stages ran %v, want %v", ran, tt.wantRan)
+			}
+			denial := ""
+			if result.Denial != nil {
+				denial = result.Denial.Stage + "/" + result.Denial.RuleID
+			}
+			if denial != tt.wantDenial {
+				t.Errorf("Got denial %q, want %q", denial, tt.wantDenial)
+			}
+			wantTag := tt.wantTag
+			if wantTag == "" {
+				wantTag = "original"
+			}
+			if got := call.Request.Params["tag"]; got != wantTag {
+				t.Errorf("Got tag %v, want %s", got, wantTag)
+			}
+		})
+	}
+}
+
+func TestPipelineExplain(t *testing.T) {
+	var ran []string
+	p := New(
+		fixedStage("a", Verdict{}, &ran),
+		fixedStage("b", Verdict{Outcome: Flag, RuleID: "odd", Score: 0.5}, &ran),
+		fixedStage("c", Verdict{Outcome: Deny, RuleID: "no"}, &ran),
+	)
+	before := metrics.Verdicts.Value("c", "no", "deny", config.ModeEnforce)
+
+	result := p.Explain(newCall("tools/call", nil), nil)
+
+	var trace []string
+	for _, v := range result.Trace {
+		trace = append(trace, v.Stage+"/"+string(v.Outcome))
+	}
+	if want := []string{"a/allow", "b/flag", "c/deny"}; !reflect.DeepEqual(trace, want) {
+		t.Errorf("Got trace %v, want %v", trace, want)
+	}
+	if result.Denial == nil || result.Denial.Stage != "c" {
+		t.Errorf("Got denial %+v, want stage c", result.Denial)
+	}
+	if got := metrics.Verdicts.Value("c", "no", "deny", config.ModeEnforce); got != before {
+		t.Errorf("Explain counted the denial in the metrics")
+	}
+}
+
+func TestPipelineRoutes(t *testing.T) {
+	var ran []string
+	a := fixedStage("a", Verdict{}, &ran)
+	b := fixedStage("b", Verdict{}, &ran)
+	p := New(a, b).
+		WithRoute(Route{PathPrefix: "/internal", Stages: []Stage{}}).
+		WithRoute(Route{Methods: []string{"resources/*"}, Stages: []Stage{b}})
+
+	tests := []struct {
+		path   string
+		method string
+		want   []string
+	}{
+		{path: "/", method: "tools/call", want: []string{"a", "b"}},
+		{path: "/", method: "resources/read", want: []string{"b"}},
+		{path: "/internal/mcp", method: "resources/read", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		call := newCall(tt.method, nil)
+		call.Path = tt.path
+		if got := p.Stages(call); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Stages(%s %s) = %v, want %v", tt.path, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryBuild(t *testing.T) {
+	engine := policy.NewEngineFromConfig(&config.PolicyConfig{DefaultEffect: "allow"})
+
+	t.Run("custom stage in configured order", func(t *testing.T) {
+		r := NewDefaultRegistry(engine)
+		audit := NewStage("audit", func(call *Call) Verdict { return Verdict{Outcome: Flag} })
+		if err := r.Register(audit); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+
+		p, err := r.Build(&config.PipelineConfig{
+			Stages: []string{"audit", "policy"},
+			Routes: []config.PipelineRoute{{Methods: []string{"ping"}, Stages: []string{}}},
+		})
+		if err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		if got := p.Stages(newCall("tools/call", nil)); strings.Join(got, ",") != "audit,policy" {
+			t.Errorf("Got stages %v, want audit,policy", got)
+		}
+		if got := p.Stages(newCall("ping", nil)); len(got) != 0 {
+			t.Errorf("Got stages %v for the ping route, want none", got)
+		}
+	})
+
+	t.Run("default order", func(t *testing.T) {
+		p, err := NewDefaultRegistry(engine).Build(&config.PipelineConfig{})
+		if err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		if got := p.Stages(newCall("tools/call", nil)); !reflect.DeepEqual(got, DefaultStages) {
+			t.Errorf("Got stages %v, want %v", got, DefaultStages)
+		}
+	})
+
+	t.Run("unknown stage", func(t *testing.T) {
+		_, err := NewDefaultRegistry(engine).Build(&config.PipelineConfig{
+			Routes: []config.PipelineRoute{{Stages: []string{"audit"}}},
+		})
+		if err == nil {
+			t.Error("Build() accepted an unknown stage")
+		}
+	})
+
+	t.Run("duplicate registration", func(t *testing.T) {
+		if err := NewDefaultRegistry(engine).Register(NewDetectionStage()); err == nil {
+			t.Error("Register() accepted a second detection stage")
+		}
+	})
+}
+
+func TestBuiltinStages(t *testing.T) {
+	engine := policy.NewEngineFromConfig(&config.PolicyConfig{
+		DefaultEffect: "allow",
+		Rules:         []config.PolicyRule{{ID: "deny-deploy", Tools: []string{"deploy"}, Effect: "deny"}},
+	})
+	p := New(NewDetectionStage(), NewPolicyStage(engine), NewRedactionStage())
+
+	tests := []struct {
+		name       string
+		params     map[string]interface{}
+		tool       string
+		wantDenial string
+		wantCode   int
+		wantMutate bool
+	}{
+		{name: "clean", params: map[string]interface{}{"name": "echo"}},
+		{
+			name:       "injection",
+			params:     map[string]interface{}{"name": "run", "arguments": map[string]interface{}{"q": "DROP TABLE users"}},
+			wantDenial: "detection/prompt-injection",
+			wantCode:   schema.CodeInjectionDetected,
+		},
+		{
+			name:       "policy",
+			params:     map[string]interface{}{"name": "deploy"},
+			wantDenial: "policy/deny-deploy",
+			wantCode:   schema.CodePolicyDenied,
+		},
+		{
+			name:       "policy on the upstream's tool name",
+			params:     map[string]interface{}{"name": "github__deploy"},
+			tool:       "deploy",
+			wantDenial: "policy/deny-deploy",
+			wantCode:   schema.CodePolicyDenied,
+		},
+		{
+			name:       "redaction",
+			params:     map[string]interface{}{"name": "login", "arguments": map[string]interface{}{"password": "hunter2"}},
+			wantMutate: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := newCall("tools/call", tt.params)
+			call.Tool = tt.tool
+			result := p.Run(call, nil)
+
+			denial, code := "", 0
+			if result.Denial != nil {
+				denial, code = result.Denial.Stage+"/"+result.Denial.RuleID, result.Denial.Code
+			}
+			if denial != tt.wantDenial || code != tt.wantCode {
+				t.Errorf("Got denial %q with code %d, want %q with code %d", denial, code, tt.wantDenial, tt.wantCode)
+			}
+			mutated := len(result.Verdicts) > 0 && result.Verdicts[len(result.Verdicts)-1].Outcome == Mutate
+			if mutated != tt.wantMutate {
+				t.Errorf("Got verdicts %+v, want mutate %v", result.Verdicts, tt.wantMutate)
+			}
+		})
+	}
+}
+
+func TestReplayStage(t *testing.T) {
+	stage := NewReplayStage(dedup.NewMemoryStore(time.Minute), time.Minute)
+	alice := &policy.Subject{ID: "alice"}
+	bob := &policy.Subject{ID: "bob"}
+
+	call := func(session string, subject *policy.Subject, id int64, meta map[string]interface{}) *Call {
+		c := newCall("tools/call", map[string]interface{}{"name": "echo"})
+		c.Request.ID = schema.NumberID(id)
+		if meta != nil {
+			c.Request.Params["_meta"] = meta
+		}
+		c.Session, c.Subject = session, subject
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		call     *Call
+		wantRule string
+	}{
+		{name: "first request", call: call("s1", nil, 1, nil)},
+		{name: "same ID in session", call: call("s1", nil, 1, nil), wantRule: DuplicateRequestRuleID},
+		{name: "same ID in another session", call: call("s2", nil, 1, nil)},
+		{name: "first request of user", call: call("", alice, 1, nil)},
+		{name: "same ID of user", call: call("", alice, 1, nil), wantRule: DuplicateRequestRuleID},
+		{name: "user in session", call: call("s4", alice, 1, nil)},
+		{name: "same ID of user in session", call: call("s4", alice, 1, nil), wantRule: DuplicateRequestRuleID},
+		{name: "same ID of another user claiming the session", call: call("s4", bob, 1, nil)},
+		{name: "anonymous ID is not tracked", call: call("", nil, 1, nil)},
+		{name: "anonymous ID again", call: call("", nil, 1, nil)},
+		{name: "nonce", call: call("", nil, 2, map[string]interface{}{NonceMetaKey: "n-1"})},
+		{name: "same nonce", call: call("", nil, 3, map[string]interface{}{NonceMetaKey: "n-1"}), wantRule: DuplicateNonceRuleID},
+		{name: "fresh timestamp", call: call("s1", nil, 4, map[string]interface{}{TimestampMetaKey: time.Now().UTC().Format(time.RFC3339)})},
+		{name: "stale timestamp", call: call("s1", nil, 5, map[string]interface{}{TimestampMetaKey: float64(time.Now().Add(-time.Hour).Unix())}), wantRule: StaleTimestampRuleID},
+		{
+			name: "dry run is not recorded",
+			call: func() *Call { c := call("s3", nil, 1, nil); c.DryRun = true; return c }(),
+		},
+		{name: "after dry run", call: call("s3", nil, 1, nil)},
+		{
+			name: "server message",
+			call: func() *Call { c := call("s1", nil, 1, nil); c.FromServer = true; return c }(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := stage.Inspect(tt.call)
+			rule := ""
+			if verdict.Outcome == Deny {
+				rule = verdict.RuleID
+				if verdict.Code != schema.CodeReplayDetected {
+					t.Errorf("Got code %d, want %d", verdict.Code, schema.CodeReplayDetected)
+				}
+			}
+			if rule != tt.wantRule {
+				t.Errorf("Got verdict %+v, want denial by %q", verdict, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestArgumentsStage(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "deploy.json")
+	pinned := `{"type":"object","properties":{"env":{"enum":["staging","prod"]}},"required":["env"],"additionalProperties":false}`
+	if err := os.WriteFile(file, []byte(pinned), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultArgumentsConfig()
+	cfg.Schemas = map[string]string{"deploy": file}
+	registry, err := toolschema.NewRegistry(&cfg)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	// The upstream's looser schema of a pinned tool is ignored
+	registry.Learn("github", []toolschema.Tool{
+		{Name: "search", InputSchema: json.RawMessage(`{"type":"object","properties":{"q":{"type":"string","maxLength":10}}}`)},
+		{Name: "deploy", InputSchema: json.RawMessage(`{"type":"object"}`)},
+	})
+	stage := NewArgumentsStage(registry)
+
+	tests := []struct {
+		name     string
+		params   map[string]interface{}
+		upstream string
+		tool     string
+		wantRule string
+		wantPath string
+	}{
+		{name: "valid", params: map[string]interface{}{"name": "deploy", "arguments": map[string]interface{}{"env": "prod"}}},
+		{
+			name:     "pinned schema",
+			params:   map[string]interface{}{"name": "deploy", "arguments": map[string]interface{}{"env": "prod", "force": true}},
+			wantRule: InvalidArgumentsRuleID,
+			wantPath: "/arguments/force",
+		},
+		{
+			name:     "missing arguments",
+			params:   map[string]interface{}{"name": "deploy"},
+			wantRule: InvalidArgumentsRuleID,
+			wantPath: "/arguments",
+		},
+		{
+			name:     "arguments not an object",
+			params:   map[string]interface{}{"name": "search", "arguments": "q"},
+			upstream: "github",
+			wantRule: InvalidArgumentsRuleID,
+			wantPath: "/arguments",
+		},
+		{
+			name:     "learned schema",
+			params:   map[string]interface{}{"name": "search", "arguments": map[string]interface{}{"q": "a very long query"}},
+			upstream: "github",
+			wantRule: InvalidArgumentsRuleID,
+			wantPath: "/arguments/q",
+		},
+		{
+			name:     "learned schema of the upstream's tool name",
+			params:   map[string]interface{}{"name": "github__search", "arguments": map[string]interface{}{"q": "a very long query"}},
+			upstream: "github",
+			tool:     "search",
+			wantRule: InvalidArgumentsRuleID,
+			wantPath: "/arguments/q",
+		},
+		{
+			name:     "schema learned from another upstream",
+			params:   map[string]interface{}{"name": "search", "arguments": map[string]interface{}{"q": "a very long query"}},
+			upstream: "gitlab",
+		},
+		{
+			name:     "pinned schema of the upstream's tool name",
+			params:   map[string]interface{}{"name": "github__deploy", "arguments": map[string]interface{}{"env": "dev"}},
+			tool:     "deploy",
+			wantRule: InvalidArgumentsRuleID,
+			wantPath: "/arguments/env",
+		},
+		{name: "unknown tool", params: map[string]interface{}{"name": "echo", "arguments": map[string]interface{}{"x": 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := newCall("tools/call", tt.params)
+			call.Upstream, call.Tool = tt.upstream, tt.tool
+			verdict := stage.Inspect(call)
+			rule := ""
+			if verdict.Outcome == Deny {
+				rule = verdict.RuleID
+				if verdict.Code != schema.CodeInvalidParams {
+					t.Errorf("Got code %d, want %d", verdict.Code, schema.CodeInvalidParams)
+				}
+			}
+			if rule != tt.wantRule || verdict.Path != tt.wantPath {
+				t.Errorf("Got verdict %+v, want denial by %q at %q", verdict, tt.wantRule, tt.wantPath)
+			}
+		})
+	}
+
+	t.Run("deny unknown tools", func(t *testing.T) {
+		cfg := config.DefaultArgumentsConfig()
+		cfg.UnknownTools = config.UnknownToolsDeny
+		registry, _ := toolschema.NewRegistry(&cfg)
+		verdict := NewArgumentsStage(registry).Inspect(newCall("tools/call", map[string]interface{}{"name": "echo"}))
+		if verdict.Outcome != Deny || verdict.RuleID != UnknownToolSchemaRuleID {
+			t.Errorf("Got verdict %+v, want denial by %q", verdict, UnknownToolSchemaRuleID)
+		}
+	})
+}
+
+func TestServerRequestsStage(t *testing.T) {
+	cfg := config.DefaultServerRequestsConfig()
+	cfg.Sampling.Deny = []string{"untrusted"}
+	cfg.Sampling.MaxTokens = 500
+	cfg.Sampling.Models = []string{"claude-*"}
+	cfg.Elicitation.Allow = []string{"crm"}
+	stage, err := NewServerRequestsStage(&cfg)
+	if err != nil {
+		t.Fatalf("NewServerRequestsStage() error = %v", err)
+	}
+
+	hints := map[string]interface{}{"hints": []interface{}{
+		map[string]interface{}{"name": "claude-sonnet"},
+		map[string]interface{}{"name": "gpt-4o"},
+	}}
+	tests := []struct {
+		name        string
+		method      string
+		upstream    string
+		params      map[string]interface{}
+		wantOutcome Outcome
+		wantRule    string
+		wantPath    string
+	}{
+		{
+			name:        "sampling within limits",
+			method:      "sampling/createMessage",
+			upstream:    "docs",
+			params:      map[string]interface{}{"messages": []interface{}{}, "maxTokens": json.Number("100")},
+			wantOutcome: Allow,
+		},
+		{
+			name:        "sampling from denied upstream",
+			method:      "sampling/createMessage",
+			upstream:    "untrusted",
+			params:      map[string]interface{}{"messages": []interface{}{}, "maxTokens": json.Number("100")},
+			wantOutcome: Deny,
+			wantRule:    ServerRequestUpstreamRuleID,
+		},
+		{
+			name:        "sampling all servers",
+			method:      "sampling/createMessage",
+			upstream:    "docs",
+			params:      map[string]interface{}{"messages": []interface{}{}, "maxTokens": json.Number("100"), "includeContext": "allServers"},
+			wantOutcome: Deny,
+			wantRule:    SamplingContextRuleID,
+			wantPath:    "/includeContext",
+		},
+		{
+			name:        "sampling over limits",
+			method:      "sampling/createMessage",
+			upstream:    "docs",
+			params:      map[string]interface{}{"messages": []interface{}{}, "maxTokens": json.Number("4096"), "modelPreferences": hints},
+			wantOutcome: Mutate,
+			wantRule:    SamplingLimitsRuleID,
+		},
+		{
+			name:        "elicitation from allowed upstream",
+			method:      "elicitation/create",
+			upstream:    "crm",
+			params:      map[string]interface{}{"message": "Which region?", "requestedSchema": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"region": map[string]interface{}{"type": "string"}}}},
+			wantOutcome: Allow,
+		},
+		{
+			name:        "elicitation from other upstream",
+			method:      "elicitation/create",
+			upstream:    "docs",
+			params:      map[string]interface{}{"message": "Which region?"},
+			wantOutcome: Deny,
+			wantRule:    ServerRequestUpstreamRuleID,
+		},
+		{
+			name:     "elicitation of a credential",
+			method:   "elicitation/create",
+			upstream: "crm",
+			params: map[string]interface{}{"message": "Sign in", "requestedSchema": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+				"user": map[string]interface{}{"type": "string"},
+				"pw":   map[string]interface{}{"type": "string", "title": "Password"},
+			}}},
+			wantOutcome: Deny,
+			wantRule:    ElicitationFieldRuleID,
+			wantPath:    "/requestedSchema/properties/pw",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := newCall(tt.method, tt.params)
+			call.FromServer = true
+			call.Upstream = tt.upstream
+			verdict := stage.Inspect(call)
+			if verdict.Outcome != tt.wantOutcome || verdict.RuleID != tt.wantRule || verdict.Path != tt.wantPath {
+				t.Errorf("Got verdict %+v, want %v by %q at %q", verdict, tt.wantOutcome, tt.wantRule, tt.wantPath)
+			}
+		})
+	}
+
+	t.Run("limits applied", func(t *testing.T) {
+		call := newCall("sampling/createMessage", map[string]interface{}{"messages": []interface{}{}, "modelPreferences": hints})
+		call.FromServer = true
+		verdict := stage.Inspect(call)
+		if verdict.Apply == nil {
+			t.Fatalf("Got verdict %+v, want a mutation", verdict)
+		}
+		verdict.Apply(call.Request)
+		if got := call.Request.Params["maxTokens"]; got != 500 {
+			t.Errorf("maxTokens = %v, want 500", got)
+		}
+		kept := call.Request.Params["modelPreferences"].(map[string]interface{})["hints"].([]interface{})
+		if len(kept) != 1 || kept[0].(map[string]interface{})["name"] != "claude-sonnet" {
+			t.Errorf("hints = %v, want only claude-sonnet", kept)
+		}
+	})
+
+	t.Run("client requests", func(t *testing.T) {
+		verdict := stage.Inspect(newCall("elicitation/create", map[string]interface{}{"message": "x"}))
+		if verdict.Outcome != Allow {
+			t.Errorf("Got verdict %+v for a client request, want allow", verdict)
+		}
+	})
+}
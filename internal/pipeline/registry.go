@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"safectx/internal/config"
+	"safectx/internal/policy"
+)
+
+// Registry holds the stages that pipelines can be built from, by name
+type Registry struct {
+	stages map[string]Stage
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{stages: make(map[string]Stage)}
+}
+
+// NewDefaultRegistry creates a registry with the built-in detection, policy
+// and redaction stages
+func NewDefaultRegistry(engine policy.Engine) *Registry {
+	r := NewRegistry()
+	r.Register(NewDetectionStage())
+	r.Register(NewPolicyStage(engine))
+	r.Register(NewRedactionStage())
+	return r
+}
+
+// Register adds a stage under its name. It fails if the name is taken.
+func (r *Registry) Register(stage Stage) error {
+	name := stage.Name()
+	if _, exists := r.stages[name]; exists {
+		return fmt.Errorf("stage %s is already registered", name)
+	}
+	r.stages[name] = stage
+	return nil
+}
+
+// Build creates a pipeline from the configured stage order and routes
+func (r *Registry) Build(cfg *config.PipelineConfig) (*Pipeline, error) {
+	names := cfg.Stages
+	if names == nil {
+		names = DefaultStages
+	}
+	stages, err := r.resolve(names)
+	if err != nil {
+		return nil, err
+	}
+
+	p := New(stages...).WithDenyScore(cfg.DenyScore)
+	for i, route := range cfg.Routes {
+		stages, err := r.resolve(route.Stages)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline route %d: %w", i, err)
+		}
+		p.WithRoute(Route{PathPrefix: route.PathPrefix, Methods: route.Methods, Stages: stages})
+	}
+	return p, nil
+}
+
+// resolve looks up stages by name
+func (r *Registry) resolve(names []string) ([]Stage, error) {
+	stages := make([]Stage, 0, len(names))
+	for _, name := range names {
+		stage, ok := r.stages[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown stage %s", name)
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
@@ -0,0 +1,155 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/internal/dedup"
+	"safectx/pkg/schema"
+)
+
+// StageReplay is the name of the replay stage
+const StageReplay = config.ReplayStage
+
+// Rules of the replay stage
+const (
+	// DuplicateRequestRuleID denies a request ID used twice in a session,
+	// or by a user
+	DuplicateRequestRuleID = "duplicate-request"
+	// DuplicateNonceRuleID denies a client nonce used twice
+	DuplicateNonceRuleID = "duplicate-nonce"
+	// StaleTimestampRuleID denies a client timestamp outside the window
+	StaleTimestampRuleID = "stale-timestamp"
+	// ReplayStoreRuleID flags requests that could not be checked because
+	// the store failed
+	ReplayStoreRuleID = "replay-store-unavailable"
+)
+
+// Keys of params._meta holding the optional client nonce and timestamp
+const (
+	NonceMetaKey     = "safectx/nonce"
+	TimestampMetaKey = "safectx/timestamp"
+)
+
+// replayStoreTimeout bounds a single lookup in the replay store
+const replayStoreTimeout = time.Second
+
+// NewReplayStage creates the stage denying requests that reuse a request
+// ID by the same user within the same session, or by the same user or in
+// the same anonymous session when the other is unknown, and requests that
+// reuse a client nonce. IDs and nonces are
+// remembered in store for window. A client timestamp further than window
+// from the gateway clock is denied too. Requests of anonymous callers
+// outside a session only have their nonce checked, since their IDs cannot
+// be told apart. Messages from the server are not checked.
+func NewReplayStage(store dedup.Store, window time.Duration) Stage {
+	return NewStage(StageReplay, func(call *Call) Verdict {
+		if call.FromServer {
+			return Verdict{Outcome: Allow}
+		}
+		req := call.Request
+		meta, _ := req.Params["_meta"].(map[string]interface{})
+
+		if value, ok := meta[TimestampMetaKey]; ok {
+			sent, ok := parseTimestamp(value)
+			if age := time.Since(sent); !ok || age > window || age < -window {
+				return replayVerdict(StaleTimestampRuleID, "timestamp outside the replay window", "/_meta/safectx~1timestamp")
+			}
+		}
+
+		scope := replayScope(call)
+		type check struct{ key, rule, path string }
+		var checks []check
+		if nonce, ok := meta[NonceMetaKey].(string); ok && nonce != "" {
+			nonceScope := scope
+			if nonceScope == "" {
+				nonceScope = "anonymous"
+			}
+			checks = append(checks, check{nonceScope + "|nonce|" + nonce, DuplicateNonceRuleID, "/_meta/safectx~1nonce"})
+		}
+		if scope != "" && !req.IsNotification() {
+			id, _ := json.Marshal(req.ID)
+			checks = append(checks, check{scope + "|id|" + string(id), DuplicateRequestRuleID, ""})
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), replayStoreTimeout)
+		defer cancel()
+		for _, c := range checks {
+			fresh, err := addKey(ctx, store, c.key, window, call.DryRun)
+			if err != nil {
+				log.Printf("Replay store unavailable for %s (%s): %v", req.Method, req.ID, err)
+				return Verdict{
+					Outcome:  Flag,
+					RuleID:   ReplayStoreRuleID,
+					Reason:   "replay store unavailable: " + err.Error(),
+					Severity: SeverityLow,
+				}
+			}
+			if !fresh {
+				return replayVerdict(c.rule, "already seen within the replay window", c.path)
+			}
+		}
+		return Verdict{Outcome: Allow}
+	})
+}
+
+// addKey records key in store and reports whether it was new. A dry run
+// only looks the key up.
+func addKey(ctx context.Context, store dedup.Store, key string, ttl time.Duration, dry bool) (bool, error) {
+	if !dry {
+		return store.Add(ctx, key, ttl)
+	}
+	seen, err := store.Contains(ctx, key)
+	return !seen, err
+}
+
+// replayScope returns the scope request IDs are unique in: the
+// authenticated user, within their session if known. The session ID is
+// chosen by the client, so it only scopes the IDs of anonymous callers.
+// It is empty for anonymous callers outside a session.
+func replayScope(call *Call) string {
+	switch {
+	case call.Subject != nil && call.Subject.ID != "" && call.Session != "":
+		return "user:" + call.Subject.ID + "|session:" + call.Session
+	case call.Subject != nil && call.Subject.ID != "":
+		return "user:" + call.Subject.ID
+	case call.Session != "":
+		return "session:" + call.Session
+	default:
+		return ""
+	}
+}
+
+// parseTimestamp reads a client timestamp given as Unix seconds or as an
+// RFC 3339 string
+func parseTimestamp(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		seconds, err := v.Float64()
+		return time.Unix(0, int64(seconds*float64(time.Second))), err == nil
+	case float64:
+		return time.Unix(0, int64(v*float64(time.Second))), true
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		return t, err == nil
+	default:
+		return time.Time{}, false
+	}
+}
+
+// replayVerdict denies a replayed request
+func replayVerdict(rule, reason, path string) Verdict {
+	return Verdict{
+		Outcome:  Deny,
+		RuleID:   rule,
+		Reason:   reason,
+		Severity: SeverityHigh,
+		Path:     path,
+		Code:     schema.CodeReplayDetected,
+		Message:  "Replayed request",
+		Score:    1,
+	}
+}
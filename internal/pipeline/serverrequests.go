@@ -0,0 +1,234 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"safectx/internal/config"
+	"safectx/pkg/schema"
+)
+
+// StageServerRequests is the name of the server-requests stage
+const StageServerRequests = config.ServerRequestsStage
+
+// Rules of the server-requests stage
+const (
+	// ServerRequestUpstreamRuleID denies a sampling or elicitation request
+	// from an upstream that may not send it
+	ServerRequestUpstreamRuleID = "server-request-upstream"
+	// SamplingContextRuleID denies a sampling request for context the
+	// server may not include
+	SamplingContextRuleID = "sampling-context"
+	// SamplingLimitsRuleID lowers the maxTokens of a sampling request and
+	// removes the model hints that are not allowed
+	SamplingLimitsRuleID = "sampling-limits"
+	// ElicitationFieldRuleID denies an elicitation request asking the user
+	// for a credential-like field
+	ElicitationFieldRuleID = "elicitation-field"
+)
+
+// serverRequests holds the compiled configuration of the stage
+type serverRequests struct {
+	sampling    config.SamplingConfig
+	elicitation config.ElicitationConfig
+	denyFields  []*regexp.Regexp
+}
+
+// NewServerRequestsStage creates the stage governing the sampling and
+// elicitation requests servers send to the client. Requests are denied
+// when their upstream is not allowed to send them, when sampling asks for
+// context the server may not include, or when elicitation asks for a field
+// matching a denied pattern. Sampling requests are otherwise limited to
+// the configured maxTokens and model hints. Requests from the client are
+// not checked. It fails if a field pattern does not compile.
+func NewServerRequestsStage(cfg *config.ServerRequestsConfig) (Stage, error) {
+	s := &serverRequests{sampling: cfg.Sampling, elicitation: cfg.Elicitation}
+	for _, pattern := range cfg.Elicitation.DenyFields {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("elicitation field pattern %s: %w", pattern, err)
+		}
+		s.denyFields = append(s.denyFields, re)
+	}
+
+	return NewStage(StageServerRequests, func(call *Call) Verdict {
+		if !call.FromServer {
+			return Verdict{Outcome: Allow}
+		}
+		switch call.Request.Method {
+		case schema.MethodCreateMessage:
+			if !upstreamAllowed(&s.sampling.UpstreamFilter, call.Upstream) {
+				return upstreamDenial(call)
+			}
+			return s.inspectSampling(call.Request)
+		case schema.MethodElicit:
+			if !upstreamAllowed(&s.elicitation.UpstreamFilter, call.Upstream) {
+				return upstreamDenial(call)
+			}
+			return s.inspectElicitation(call.Request)
+		default:
+			return Verdict{Outcome: Allow}
+		}
+	}), nil
+}
+
+// upstreamAllowed reports whether the filter lets an upstream send a
+// request. Deny patterns take precedence over allow patterns.
+func upstreamAllowed(filter *config.UpstreamFilter, upstream string) bool {
+	match := func(pattern string) bool {
+		ok, _ := path.Match(pattern, upstream)
+		return ok
+	}
+	if slices.ContainsFunc(filter.Deny, match) {
+		return false
+	}
+	return len(filter.Allow) == 0 || slices.ContainsFunc(filter.Allow, match)
+}
+
+// upstreamDenial returns the denial of a request its upstream may not send
+func upstreamDenial(call *Call) Verdict {
+	upstream := call.Upstream
+	if upstream == "" {
+		upstream = "an unknown upstream"
+	}
+	return Verdict{
+		Outcome:  Deny,
+		RuleID:   ServerRequestUpstreamRuleID,
+		Reason:   fmt.Sprintf("%s is not allowed from %s", call.Request.Method, upstream),
+		Severity: SeverityMedium,
+		Code:     schema.CodePolicyDenied,
+		Message:  "Server request denied",
+	}
+}
+
+// inspectSampling checks the context a sampling request asks for, and
+// limits its maxTokens and model hints
+func (s *serverRequests) inspectSampling(req *schema.MCPRequest) Verdict {
+	include, _ := req.Params["includeContext"].(string)
+	if include == "" {
+		include = "none"
+	}
+	if len(s.sampling.IncludeContext) > 0 && !slices.Contains(s.sampling.IncludeContext, include) {
+		return Verdict{
+			Outcome:  Deny,
+			RuleID:   SamplingContextRuleID,
+			Reason:   fmt.Sprintf("sampling with context %s is not allowed, allowed: %s", include, strings.Join(s.sampling.IncludeContext, ", ")),
+			Severity: SeverityMedium,
+			Path:     "/includeContext",
+			Code:     schema.CodePolicyDenied,
+			Message:  "Server request denied",
+		}
+	}
+
+	var changes []string
+	var applies []func(req *schema.MCPRequest)
+
+	if limit := s.sampling.MaxTokens; limit > 0 {
+		requested, ok := toNumber(req.Params["maxTokens"])
+		if !ok || requested > float64(limit) {
+			changes = append(changes, fmt.Sprintf("maxTokens lowered to %d", limit))
+			applies = append(applies, func(req *schema.MCPRequest) {
+				req.Params["maxTokens"] = limit
+			})
+		}
+	}
+
+	if len(s.sampling.Models) > 0 {
+		prefs, _ := req.Params["modelPreferences"].(map[string]interface{})
+		hints, _ := prefs["hints"].([]interface{})
+		kept := make([]interface{}, 0, len(hints))
+		var removed []string
+		for _, hint := range hints {
+			h, _ := hint.(map[string]interface{})
+			name, _ := h["name"].(string)
+			if slices.ContainsFunc(s.sampling.Models, func(pattern string) bool {
+				ok, _ := path.Match(pattern, name)
+				return ok
+			}) {
+				kept = append(kept, hint)
+				continue
+			}
+			removed = append(removed, name)
+		}
+		if len(removed) > 0 {
+			changes = append(changes, "removed model hints "+strings.Join(removed, ", "))
+			applies = append(applies, func(req *schema.MCPRequest) {
+				prefs := req.Params["modelPreferences"].(map[string]interface{})
+				prefs["hints"] = kept
+			})
+		}
+	}
+
+	if len(changes) == 0 {
+		return Verdict{Outcome: Allow}
+	}
+	return Verdict{
+		Outcome:  Mutate,
+		RuleID:   SamplingLimitsRuleID,
+		Reason:   strings.Join(changes, "; "),
+		Severity: SeverityLow,
+		Apply: func(req *schema.MCPRequest) {
+			for _, apply := range applies {
+				apply(req)
+			}
+		},
+	}
+}
+
+// inspectElicitation denies an elicitation request whose requested schema
+// has a field matching a denied pattern by its name, title or description
+func (s *serverRequests) inspectElicitation(req *schema.MCPRequest) Verdict {
+	requested, _ := req.Params["requestedSchema"].(map[string]interface{})
+	props, _ := requested["properties"].(map[string]interface{})
+
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		texts := []string{name}
+		if prop, ok := props[name].(map[string]interface{}); ok {
+			for _, key := range []string{"title", "description"} {
+				if text, ok := prop[key].(string); ok {
+					texts = append(texts, text)
+				}
+			}
+		}
+		for _, re := range s.denyFields {
+			if !slices.ContainsFunc(texts, re.MatchString) {
+				continue
+			}
+			return Verdict{
+				Outcome:  Deny,
+				RuleID:   ElicitationFieldRuleID,
+				Reason:   fmt.Sprintf("elicitation asks for field %s, which matches %s", name, re),
+				Severity: SeverityHigh,
+				Path:     "/requestedSchema/properties/" + schema.EscapePointer(name),
+				Code:     schema.CodePolicyDenied,
+				Message:  "Server request denied",
+				Score:    1,
+			}
+		}
+	}
+	return Verdict{Outcome: Allow}
+}
+
+// toNumber returns the value of a decoded JSON number
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
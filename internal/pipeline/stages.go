@@ -0,0 +1,155 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+
+	"safectx/internal/contextfilter"
+	"safectx/internal/detection"
+	"safectx/internal/policy"
+	"safectx/pkg/schema"
+)
+
+// Names of the built-in stages
+const (
+	StageDetection = "detection"
+	StagePolicy    = "policy"
+	StageRedaction = "redaction"
+)
+
+// DefaultStages is the order the built-in stages run in when none is
+// configured
+var DefaultStages = []string{StageDetection, StagePolicy, StageRedaction}
+
+// funcStage adapts a function to the Stage interface
+type funcStage struct {
+	name    string
+	inspect func(call *Call) Verdict
+}
+
+// NewStage creates a stage from a function
+func NewStage(name string, inspect func(call *Call) Verdict) Stage {
+	return &funcStage{name: name, inspect: inspect}
+}
+
+func (s *funcStage) Name() string               { return s.name }
+func (s *funcStage) Inspect(call *Call) Verdict { return s.inspect(call) }
+
+// NewDetectionStage creates the stage denying requests that contain prompt
+// injection patterns
+func NewDetectionStage() Stage {
+	return NewStage(StageDetection, func(call *Call) Verdict {
+		path, found := detection.FindInjection(call.Request)
+		if !found {
+			return Verdict{Outcome: Allow}
+		}
+		return Verdict{
+			Outcome:  Deny,
+			RuleID:   detection.InjectionRuleID,
+			Reason:   "prompt injection pattern matched",
+			Severity: SeverityHigh,
+			Path:     path,
+			Code:     schema.CodeInjectionDetected,
+			Message:  "Potential prompt injection detected",
+			Score:    1,
+		}
+	})
+}
+
+// NewPolicyStage creates the stage denying requests the policy engine does
+// not allow for the caller, and holding those it requires approval for. A
+// tool exposed under another name must be allowed under both names.
+func NewPolicyStage(engine policy.Engine) Stage {
+	return NewStage(StagePolicy, func(call *Call) Verdict {
+		verdict := evaluatePolicy(engine, call.Subject, call.Request)
+		if verdict.Outcome == Deny || call.Tool == "" {
+			return verdict
+		}
+		if own := evaluatePolicy(engine, call.Subject, withToolName(call.Request, call.Tool)); own.Outcome != Allow {
+			return own
+		}
+		return verdict
+	})
+}
+
+// evaluatePolicy returns the verdict of the policy engine on a request
+func evaluatePolicy(engine policy.Engine, subject *policy.Subject, req *schema.MCPRequest) Verdict {
+	allowed, err := engine.Evaluate(subject, req)
+	if allowed {
+		return Verdict{Outcome: Allow}
+	}
+
+	var held *policy.ApprovalRequiredError
+	if errors.As(err, &held) {
+		return Verdict{
+			Outcome:  Approve,
+			RuleID:   held.RuleID,
+			Reason:   err.Error(),
+			Severity: SeverityMedium,
+			Path:     targetPath(req),
+		}
+	}
+
+	rule := policy.DefaultRuleID
+	var denied *policy.DeniedError
+	if errors.As(err, &denied) && denied.RuleID != "" {
+		rule = denied.RuleID
+	}
+	reason := "request denied"
+	if err != nil {
+		reason = err.Error()
+	}
+	return Verdict{
+		Outcome:  Deny,
+		RuleID:   rule,
+		Reason:   reason,
+		Severity: SeverityMedium,
+		Path:     targetPath(req),
+		Code:     schema.CodePolicyDenied,
+		Message:  "Policy denied request",
+	}
+}
+
+// withToolName returns a copy of a tools/call request naming another tool
+func withToolName(req *schema.MCPRequest, name string) *schema.MCPRequest {
+	out := *req
+	out.Params = make(map[string]interface{}, len(req.Params))
+	for key, value := range req.Params {
+		out.Params[key] = value
+	}
+	out.Params["name"] = name
+	return &out
+}
+
+// targetPath returns the JSON pointer of the param naming the tool, prompt
+// or resource a request addresses, which policy rules match on
+func targetPath(req *schema.MCPRequest) string {
+	if _, ok := req.ToolCall(); ok {
+		return "/name"
+	}
+	if _, ok := req.PromptGet(); ok {
+		return "/name"
+	}
+	if _, ok := req.ResourceRead(); ok {
+		return "/uri"
+	}
+	return ""
+}
+
+// NewRedactionStage creates the stage redacting sensitive params and
+// arguments
+func NewRedactionStage() Stage {
+	return NewStage(StageRedaction, func(call *Call) Verdict {
+		paths := contextfilter.SensitivePaths(call.Request)
+		if len(paths) == 0 {
+			return Verdict{Outcome: Allow}
+		}
+		return Verdict{
+			Outcome:  Mutate,
+			RuleID:   contextfilter.SensitiveKeysRuleID,
+			Reason:   "sensitive values at " + strings.Join(paths, ", "),
+			Severity: SeverityLow,
+			Apply:    func(req *schema.MCPRequest) { contextfilter.Redact(req) },
+		}
+	})
+}
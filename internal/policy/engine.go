@@ -1,25 +1,292 @@
 package policy
 
-import "safectx/pkg/schema"
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"safectx/internal/config"
+	"safectx/pkg/schema"
+)
 
 // Engine defines the interface for policy evaluation
 type Engine interface {
-	// Evaluate checks if a request is allowed based on the policy rules
-	Evaluate(req *schema.MCPRequest) (bool, error)
+	// Evaluate checks if a request made by subject is allowed based on the
+	// policy rules. subject is nil for unauthenticated callers.
+	Evaluate(subject *Subject, req *schema.MCPRequest) (bool, error)
+}
+
+// Subject is the authenticated caller a request is evaluated for
+type Subject struct {
+	ID     string
+	Roles  []string
+	Claims map[string]interface{}
+}
+
+// HasRole reports whether the subject has the given role
+func (s *Subject) HasRole(role string) bool {
+	if s == nil {
+		return false
+	}
+	for _, r := range s.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasClaim reports whether the named claim has the given value, or
+// contains it if the claim is a list
+func (s *Subject) HasClaim(name, value string) bool {
+	if s == nil {
+		return false
+	}
+	switch claim := s.Claims[name].(type) {
+	case string:
+		return claim == value
+	case []string:
+		for _, v := range claim {
+			if v == value {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range claim {
+			if v == value {
+				return true
+			}
+		}
+	case nil:
+		return false
+	default:
+		return fmt.Sprint(claim) == value
+	}
+	return false
+}
+
+// DefaultRuleID identifies the default effect in logs, metrics and
+// enforcement modes
+const DefaultRuleID = "default"
+
+// DeniedError reports the rule that denied a request
+type DeniedError struct {
+	RuleID string
+	Method string
+	// Target is the tool, prompt or resource the request addressed, if any
+	Target string
+}
+
+func (e *DeniedError) Error() string {
+	subject := "method " + e.Method
+	if e.Target != "" {
+		subject = fmt.Sprintf("%s %s", e.Method, e.Target)
+	}
+	if e.RuleID == "" {
+		return fmt.Sprintf("%s denied by default policy", subject)
+	}
+	return fmt.Sprintf("%s denied by rule %s", subject, e.RuleID)
+}
+
+// ApprovalRequiredError reports the rule that holds a request until a human
+// approves it. Evaluate returns it with false, so engines and callers that
+// do not know about approvals deny the request.
+type ApprovalRequiredError struct {
+	RuleID string
+	Method string
+	// Target is the tool, prompt or resource the request addressed, if any
+	Target string
 }
 
-// DefaultEngine implements the Engine interface
+func (e *ApprovalRequiredError) Error() string {
+	subject := "method " + e.Method
+	if e.Target != "" {
+		subject = fmt.Sprintf("%s %s", e.Method, e.Target)
+	}
+	return fmt.Sprintf("%s requires approval by rule %s", subject, e.RuleID)
+}
+
+// DefaultEngine implements the Engine interface using ordered method rules
 type DefaultEngine struct {
-	// Add any necessary fields here
+	rules         []config.PolicyRule
+	defaultEffect string
 }
 
-// NewDefaultEngine creates a new instance of DefaultEngine
+// NewDefaultEngine creates a new instance of DefaultEngine that allows
+// every request
 func NewDefaultEngine() *DefaultEngine {
-	return &DefaultEngine{}
+	return &DefaultEngine{defaultEffect: "allow"}
+}
+
+// NewEngineFromConfig creates a DefaultEngine evaluating the configured rules
+func NewEngineFromConfig(cfg *config.PolicyConfig) *DefaultEngine {
+	effect := cfg.DefaultEffect
+	if effect == "" {
+		effect = "allow"
+	}
+
+	return &DefaultEngine{
+		rules:         cfg.Rules,
+		defaultEffect: effect,
+	}
+}
+
+// Evaluate implements the Engine interface. Rules are checked in order and
+// the first rule matching the request decides.
+func (e *DefaultEngine) Evaluate(subject *Subject, req *schema.MCPRequest) (bool, error) {
+	rule := e.match(subject, req)
+	if rule == nil {
+		if e.defaultEffect == "allow" {
+			return true, nil
+		}
+		return false, &DeniedError{Method: req.Method, Target: targetOf(req)}
+	}
+	switch rule.Effect {
+	case "allow":
+		return true, nil
+	case config.EffectApprove:
+		return false, &ApprovalRequiredError{RuleID: rule.ID, Method: req.Method, Target: targetOf(req)}
+	}
+	return false, &DeniedError{RuleID: rule.ID, Method: req.Method, Target: targetOf(req)}
+}
+
+// match returns the first rule matching the request, or nil if the default
+// effect applies
+func (e *DefaultEngine) match(subject *Subject, req *schema.MCPRequest) *config.PolicyRule {
+	for i := range e.rules {
+		rule := &e.rules[i]
+		if matchRule(rule, req) && matchSubject(rule, subject) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Decision is the outcome of evaluating a request, with the rule that
+// decided it
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// Approval is set when the request is held until a human approves it
+	Approval bool   `json:"approval,omitempty"`
+	RuleID   string `json:"rule,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Decide evaluates a request like engine.Evaluate and reports the deciding
+// rule. The default effect is reported as DefaultRuleID. Engines other
+// than DefaultEngine only report the rules of denials.
+func Decide(engine Engine, subject *Subject, req *schema.MCPRequest) Decision {
+	allowed, err := engine.Evaluate(subject, req)
+	decision := Decision{Allowed: allowed}
+	if err != nil {
+		decision.Reason = err.Error()
+	}
+	var held *ApprovalRequiredError
+	decision.Approval = errors.As(err, &held)
+
+	if e, ok := engine.(*DefaultEngine); ok {
+		decision.RuleID = DefaultRuleID
+		if rule := e.match(subject, req); rule != nil && rule.ID != "" {
+			decision.RuleID = rule.ID
+		}
+		return decision
+	}
+	var denied *DeniedError
+	if errors.As(err, &denied) {
+		decision.RuleID = denied.RuleID
+	} else if held != nil {
+		decision.RuleID = held.RuleID
+	}
+	if !allowed && decision.RuleID == "" {
+		decision.RuleID = DefaultRuleID
+	}
+	return decision
+}
+
+// matchRule reports whether every criterion set on the rule matches
+func matchRule(rule *config.PolicyRule, req *schema.MCPRequest) bool {
+	if len(rule.Methods) > 0 && !matchMethod(rule.Methods, req.Method) {
+		return false
+	}
+	if len(rule.Tools) > 0 {
+		call, ok := req.ToolCall()
+		if !ok || !matchMethod(rule.Tools, call.Name) {
+			return false
+		}
+	}
+	if len(rule.Prompts) > 0 {
+		prompt, ok := req.PromptGet()
+		if !ok || !matchMethod(rule.Prompts, prompt.Name) {
+			return false
+		}
+	}
+	if len(rule.Resources) > 0 {
+		read, ok := req.ResourceRead()
+		if !ok || !matchPrefix(rule.Resources, read.URI) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchSubject reports whether the subject has one of the rule's roles and
+// all of its claims
+func matchSubject(rule *config.PolicyRule, subject *Subject) bool {
+	if len(rule.Roles) > 0 {
+		matched := false
+		for _, role := range rule.Roles {
+			if subject.HasRole(role) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for name, value := range rule.Claims {
+		if !subject.HasClaim(name, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// targetOf returns the tool, prompt or resource addressed by a request
+func targetOf(req *schema.MCPRequest) string {
+	if call, ok := req.ToolCall(); ok {
+		return call.Name
+	}
+	if prompt, ok := req.PromptGet(); ok {
+		return prompt.Name
+	}
+	if read, ok := req.ResourceRead(); ok {
+		return read.URI
+	}
+	return ""
+}
+
+// matchMethod reports whether method matches any of the patterns
+func matchMethod(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+	}
+	return false
 }
 
-// Evaluate implements the Engine interface
-func (e *DefaultEngine) Evaluate(req *schema.MCPRequest) (bool, error) {
-	// TODO: Implement policy evaluation logic
-	return true, nil
+// matchPrefix reports whether the normalized URI value starts with any of
+// the prefixes, normalized the same way
+func matchPrefix(prefixes []string, value string) bool {
+	for _, prefix := range prefixes {
+		if normalized, err := schema.NormalizeURI(prefix); err == nil {
+			prefix = normalized
+		}
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
 }
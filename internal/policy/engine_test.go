@@ -0,0 +1,255 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	"safectx/internal/config"
+	"safectx/pkg/schema"
+)
+
+func TestDefaultEngineEvaluate(t *testing.T) {
+	cfg := &config.PolicyConfig{
+		DefaultEffect: "deny",
+		Rules: []config.PolicyRule{
+			{ID: "allow-lifecycle", Methods: []string{"notifications/initialized", "notifications/cancelled"}, Effect: "allow"},
+			{ID: "deny-notifications", Methods: []string{"notifications/*"}, Effect: "deny"},
+			{ID: "allow-tools", Methods: []string{"tools/*"}, Effect: "allow"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		expected bool
+		ruleID   string
+	}{
+		{
+			name:     "Allowed notification",
+			method:   "notifications/initialized",
+			expected: true,
+		},
+		{
+			name:     "Denied notification",
+			method:   "notifications/progress",
+			expected: false,
+			ruleID:   "deny-notifications",
+		},
+		{
+			name:     "Allowed method",
+			method:   "tools/call",
+			expected: true,
+		},
+		{
+			name:     "Default effect",
+			method:   "sampling/createMessage",
+			expected: false,
+		},
+	}
+
+	engine := NewEngineFromConfig(cfg)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := engine.Evaluate(nil, &schema.MCPRequest{Method: tt.method})
+			if allowed != tt.expected {
+				t.Errorf("Evaluate() = %v, want %v", allowed, tt.expected)
+			}
+
+			if !tt.expected {
+				var denied *DeniedError
+				if !errors.As(err, &denied) {
+					t.Fatalf("Evaluate() error = %v, want DeniedError", err)
+				}
+				if denied.RuleID != tt.ruleID {
+					t.Errorf("Denied by rule %q, want %q", denied.RuleID, tt.ruleID)
+				}
+			}
+		})
+	}
+}
+
+func TestNewDefaultEngineAllowsAll(t *testing.T) {
+	allowed, err := NewDefaultEngine().Evaluate(nil, &schema.MCPRequest{Method: "anything"})
+	if !allowed || err != nil {
+		t.Errorf("Evaluate() = %v, %v, want true, nil", allowed, err)
+	}
+}
+
+func TestDefaultEngineTargets(t *testing.T) {
+	cfg := &config.PolicyConfig{
+		DefaultEffect: "allow",
+		Rules: []config.PolicyRule{
+			{ID: "deny-shell", Tools: []string{"shell_*"}, Effect: "deny"},
+			{ID: "deny-etc", Resources: []string{"file:///etc/"}, Effect: "deny"},
+			{ID: "deny-admin-prompts", Prompts: []string{"admin"}, Effect: "deny"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		req    *schema.MCPRequest
+		ruleID string
+		target string
+	}{
+		{
+			name:   "Denied tool",
+			req:    &schema.MCPRequest{Method: "tools/call", Params: map[string]interface{}{"name": "shell_exec"}},
+			ruleID: "deny-shell",
+			target: "shell_exec",
+		},
+		{
+			name: "Allowed tool",
+			req:  &schema.MCPRequest{Method: "tools/call", Params: map[string]interface{}{"name": "search"}},
+		},
+		{
+			name:   "Denied resource",
+			req:    &schema.MCPRequest{Method: "resources/read", Params: map[string]interface{}{"uri": "file:///etc/passwd"}},
+			ruleID: "deny-etc",
+			target: "file:///etc/passwd",
+		},
+		{
+			name:   "Denied resource with dot segments",
+			req:    &schema.MCPRequest{Method: "resources/read", Params: map[string]interface{}{"uri": "file:///tmp/../etc/passwd"}},
+			ruleID: "deny-etc",
+			target: "file:///etc/passwd",
+		},
+		{
+			name:   "Denied resource with repeated slashes",
+			req:    &schema.MCPRequest{Method: "resources/read", Params: map[string]interface{}{"uri": "file:////etc/passwd"}},
+			ruleID: "deny-etc",
+			target: "file:///etc/passwd",
+		},
+		{
+			name:   "Denied resource with uppercase scheme",
+			req:    &schema.MCPRequest{Method: "resources/read", Params: map[string]interface{}{"uri": "FILE:///etc/passwd"}},
+			ruleID: "deny-etc",
+			target: "file:///etc/passwd",
+		},
+		{
+			name:   "Denied resource with localhost host",
+			req:    &schema.MCPRequest{Method: "resources/read", Params: map[string]interface{}{"uri": "file://localhost/etc/passwd"}},
+			ruleID: "deny-etc",
+			target: "file:///etc/passwd",
+		},
+		{
+			name:   "Denied resource with escaped path",
+			req:    &schema.MCPRequest{Method: "resources/read", Params: map[string]interface{}{"uri": "file:///%65tc/passwd"}},
+			ruleID: "deny-etc",
+			target: "file:///etc/passwd",
+		},
+		{
+			name: "Allowed resource",
+			req:  &schema.MCPRequest{Method: "resources/read", Params: map[string]interface{}{"uri": "file:///home/a.txt"}},
+		},
+		{
+			name:   "Denied prompt",
+			req:    &schema.MCPRequest{Method: "prompts/get", Params: map[string]interface{}{"name": "admin"}},
+			ruleID: "deny-admin-prompts",
+			target: "admin",
+		},
+		{
+			name: "Tool rule ignores other methods",
+			req:  &schema.MCPRequest{Method: "prompts/get", Params: map[string]interface{}{"name": "shell_exec"}},
+		},
+	}
+
+	engine := NewEngineFromConfig(cfg)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := engine.Evaluate(nil, tt.req)
+			if allowed != (tt.ruleID == "") {
+				t.Fatalf("Evaluate() = %v, %v", allowed, err)
+			}
+			if tt.ruleID == "" {
+				return
+			}
+
+			var denied *DeniedError
+			if !errors.As(err, &denied) || denied.RuleID != tt.ruleID || denied.Target != tt.target {
+				t.Errorf("Got error %+v, want rule %s for %s", err, tt.ruleID, tt.target)
+			}
+		})
+	}
+}
+
+func TestDefaultEngineSubjects(t *testing.T) {
+	cfg := &config.PolicyConfig{
+		DefaultEffect: "deny",
+		Rules: []config.PolicyRule{
+			{ID: "admins", Methods: []string{"tools/*"}, Roles: []string{"admin", "ops"}, Effect: "allow"},
+			{ID: "eu-search", Tools: []string{"search"}, Claims: map[string]string{"region": "eu", "tier": "pro"}, Effect: "allow"},
+			{ID: "listing", Methods: []string{"tools/list"}, Effect: "allow"},
+		},
+	}
+	search := &schema.MCPRequest{Method: "tools/call", Params: map[string]interface{}{"name": "search"}}
+
+	tests := []struct {
+		name    string
+		subject *Subject
+		req     *schema.MCPRequest
+		want    bool
+	}{
+		{name: "Anonymous caller", req: search},
+		{name: "Any listed role", subject: &Subject{ID: "a", Roles: []string{"ops"}}, req: search, want: true},
+		{name: "Other role", subject: &Subject{ID: "b", Roles: []string{"viewer"}}, req: search},
+		{
+			name:    "All claims match",
+			subject: &Subject{ID: "c", Claims: map[string]interface{}{"region": "eu", "tier": "pro"}},
+			req:     search,
+			want:    true,
+		},
+		{
+			name:    "Claim list contains value",
+			subject: &Subject{ID: "d", Claims: map[string]interface{}{"region": []interface{}{"us", "eu"}, "tier": "pro"}},
+			req:     search,
+			want:    true,
+		},
+		{
+			name:    "Missing claim",
+			subject: &Subject{ID: "e", Claims: map[string]interface{}{"region": "eu"}},
+			req:     search,
+		},
+		{name: "Rule without subject criteria", req: &schema.MCPRequest{Method: "tools/list"}, want: true},
+	}
+
+	engine := NewEngineFromConfig(cfg)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed, err := engine.Evaluate(tt.subject, tt.req); allowed != tt.want {
+				t.Errorf("Evaluate() = %v, %v, want %v", allowed, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecide(t *testing.T) {
+	engine := NewEngineFromConfig(&config.PolicyConfig{
+		DefaultEffect: "deny",
+		Rules: []config.PolicyRule{
+			{ID: "approve-deploy", Tools: []string{"deploy"}, Effect: "approve"},
+			{ID: "allow-tools", Methods: []string{"tools/*"}, Effect: "allow"},
+		},
+	})
+
+	tests := []struct {
+		method string
+		params map[string]interface{}
+		want   Decision
+	}{
+		{method: "tools/list", want: Decision{Allowed: true, RuleID: "allow-tools"}},
+		{method: "ping", want: Decision{RuleID: DefaultRuleID, Reason: "method ping denied by default policy"}},
+		{
+			method: "tools/call",
+			params: map[string]interface{}{"name": "deploy"},
+			want:   Decision{RuleID: "approve-deploy", Reason: "tools/call deploy requires approval by rule approve-deploy", Approval: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			if got := Decide(engine, nil, &schema.MCPRequest{Method: tt.method, Params: tt.params}); got != tt.want {
+				t.Errorf("Decide() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
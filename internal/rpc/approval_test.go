@@ -0,0 +1,148 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safectx/internal/approval"
+	"safectx/internal/config"
+	"safectx/internal/middleware"
+	"safectx/internal/policy"
+	"safectx/pkg/schema"
+)
+
+func TestGatewayApproval(t *testing.T) {
+	server := &fakeMCPServer{name: "tools", tools: []string{"search", "deploy"}}
+	upstream := httptest.NewServer(server)
+	defer upstream.Close()
+
+	engine := policy.NewEngineFromConfig(&config.PolicyConfig{
+		DefaultEffect: "allow",
+		Rules:         []config.PolicyRule{{ID: "approve-deploy", Tools: []string{"deploy"}, Effect: "approve"}},
+	})
+	cfg := config.DefaultApprovalConfig()
+	cfg.Timeout = 200 * time.Millisecond
+	queue := approval.NewQueue(&cfg, approval.NewMemoryStore())
+	gateway := NewGatewayHandler(newTestProxy(t, upstream.URL, time.Second)).
+		WithPolicyEngine(engine).
+		WithApprovals(queue).
+		WithDenialDetail(config.DetailFull)
+
+	alice := &middleware.User{ID: "alice"}
+	send := func(body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req = req.WithContext(middleware.WithUser(req.Context(), alice))
+		rr := httptest.NewRecorder()
+		gateway.ServeHTTP(rr, req)
+
+		var resp map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to unmarshal response %q: %v", rr.Body.String(), err)
+		}
+		return rr.Code, resp
+	}
+	// decide approves or denies the next approval requested
+	decide := func(approve bool, comment string) {
+		go func() {
+			for {
+				pending, _ := queue.List(context.Background(), approval.Pending)
+				if len(pending) > 0 {
+					queue.Decide(context.Background(), pending[0].ID, approve, &policy.Subject{ID: "carol", Roles: []string{"admin"}}, comment)
+					return
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}()
+	}
+	deployed := func() int {
+		calls, _ := server.received()
+		n := 0
+		for _, c := range calls {
+			if c == "tools/call deploy" {
+				n++
+			}
+		}
+		return n
+	}
+
+	tests := []struct {
+		name       string
+		tool       string
+		decide     func()
+		wantStatus int
+		wantCode   int
+		wantReason string
+	}{
+		{
+			name:       "not held",
+			tool:       "search",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "approved",
+			tool:       "deploy",
+			decide:     func() { decide(true, "") },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "denied",
+			tool:       "deploy",
+			decide:     func() { decide(false, "not during the freeze") },
+			wantStatus: http.StatusForbidden,
+			wantCode:   schema.CodeApprovalDenied,
+			wantReason: "not during the freeze",
+		},
+		{
+			name:       "timed out",
+			tool:       "deploy",
+			wantStatus: http.StatusRequestTimeout,
+			wantCode:   schema.CodeApprovalTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := deployed()
+			if tt.decide != nil {
+				tt.decide()
+			}
+
+			status, resp := send(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"` + tt.tool + `"}}`)
+			if status != tt.wantStatus {
+				t.Errorf("Got status %d, want %d: %v", status, tt.wantStatus, resp)
+			}
+			if tt.wantCode == 0 {
+				if resp["error"] != nil {
+					t.Errorf("Got response %v, want a result", resp)
+				}
+				return
+			}
+
+			errObj, _ := resp["error"].(map[string]interface{})
+			data, _ := errObj["data"].(map[string]interface{})
+			if errObj["code"] != float64(tt.wantCode) || data["rule"] != "approve-deploy" || data["approvalId"] == nil {
+				t.Errorf("Got error %v, want code %d identifying the rule and approval", errObj, tt.wantCode)
+			}
+			if tt.wantReason != "" && data["reason"] != tt.wantReason {
+				t.Errorf("Got reason %v, want the approver's comment %q", data["reason"], tt.wantReason)
+			}
+			if deployed() != before {
+				t.Error("Request was forwarded without approval")
+			}
+		})
+	}
+
+	t.Run("notification", func(t *testing.T) {
+		before := deployed()
+		call(t, gateway, "/", "", `{"jsonrpc":"2.0","method":"tools/call","params":{"name":"deploy"}}`)
+		if deployed() != before {
+			t.Error("Notification requiring approval was forwarded")
+		}
+	})
+}
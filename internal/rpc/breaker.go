@@ -0,0 +1,125 @@
+package rpc
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops sending requests to an endpoint after consecutive
+// failures. Once OpenDuration has passed a single probe request is let
+// through: if it succeeds the circuit closes again, otherwise it reopens.
+type CircuitBreaker struct {
+	threshold int
+	openFor   time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker creates a closed circuit breaker that opens after
+// threshold consecutive failures. A threshold of 0 disables the breaker.
+func NewCircuitBreaker(threshold int, openFor time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		openFor:   openFor,
+		state:     CircuitClosed,
+	}
+}
+
+// Ready reports whether a request could be let through now, without
+// reserving the half-open probe
+func (b *CircuitBreaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		return time.Since(b.openedAt) >= b.openFor
+	case CircuitHalfOpen:
+		return !b.probing
+	default:
+		return true
+	}
+}
+
+// Allow reports whether a request may be sent. In the half-open state only
+// the first caller is allowed until it reports its outcome.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.openFor {
+			return false
+		}
+		b.state = CircuitHalfOpen
+		b.probing = true
+		return true
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Success records a successful request and closes the circuit
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = CircuitClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure records a failed request. The circuit opens when the failure
+// threshold is reached or a half-open probe fails.
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.threshold <= 0 {
+		return
+	}
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release gives up an allowed request without recording an outcome, e.g.
+// when the client went away before the endpoint answered
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// State returns the current state of the circuit
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.openFor {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
@@ -0,0 +1,50 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker(2, 20*time.Millisecond)
+
+	b.Failure()
+	if !b.Allow() || b.State() != CircuitClosed {
+		t.Fatalf("Circuit opened below the threshold: %s", b.State())
+	}
+	b.Failure()
+	if b.Allow() || b.Ready() || b.State() != CircuitOpen {
+		t.Fatalf("Circuit did not open at the threshold: %s", b.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.Ready() || !b.Allow() {
+		t.Fatal("Circuit did not let a probe through after the open duration")
+	}
+	if b.Allow() {
+		t.Error("Circuit let a second request through while probing")
+	}
+
+	// A failed probe reopens the circuit at once
+	b.Failure()
+	if b.State() != CircuitOpen {
+		t.Errorf("Got state %s after a failed probe, want open", b.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	b.Allow()
+	b.Success()
+	if b.State() != CircuitClosed || !b.Allow() {
+		t.Errorf("Got state %s after a successful probe, want closed", b.State())
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := NewCircuitBreaker(0, time.Minute)
+	for i := 0; i < 10; i++ {
+		b.Failure()
+	}
+	if !b.Allow() || b.State() != CircuitClosed {
+		t.Errorf("Disabled breaker opened: %s", b.State())
+	}
+}
@@ -0,0 +1,215 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"safectx/internal/cache"
+	"safectx/internal/config"
+	"safectx/internal/metrics"
+	"safectx/pkg/schema"
+)
+
+// cacheTimeout bounds storing a result and invalidating cached results,
+// which happen outside of the request that caused them
+const cacheTimeout = time.Second
+
+// invalidations maps the server notifications that report a change to the
+// cache topic they invalidate
+var invalidations = map[string]string{
+	"notifications/tools/list_changed":     "tools",
+	"notifications/prompts/list_changed":   "prompts",
+	"notifications/resources/list_changed": "resources",
+}
+
+// ResponseCache answers idempotent requests, e.g. tools/list, from a store
+// instead of the upstream. Results are cached as the upstream sent them, so
+// a cached response still runs through the response filter for every
+// caller it is served to, and one user's view never leaks to another. A
+// nil cache caches nothing.
+type ResponseCache struct {
+	cfg   *config.CacheConfig
+	store cache.Store
+}
+
+// NewResponseCache creates a cache for the methods configured in cfg,
+// keeping the results in store
+func NewResponseCache(cfg *config.CacheConfig, store cache.Store) *ResponseCache {
+	return &ResponseCache{cfg: cfg, store: store}
+}
+
+// Lookup returns the cached response to req, which the client request r
+// sends to upstream, or nil if there is none. On a miss it returns the key
+// Fill stores the response under, which is empty if req is not cached.
+// Lists a Router aggregates are never cached.
+func (c *ResponseCache) Lookup(upstream Forwarder, r *http.Request, req *schema.MCPRequest) (*UpstreamResponse, string) {
+	if c == nil || req.IsNotification() {
+		return nil, ""
+	}
+	if _, ok := c.cfg.TTL(req.Method); !ok {
+		return nil, ""
+	}
+	// The router learns the upstream of each tool and resource from the
+	// lists it aggregates, so they must reach it
+	if _, aggregated := aggregatedLists[req.Method]; aggregated {
+		if _, ok := upstream.(*Router); ok {
+			return nil, ""
+		}
+	}
+
+	key, err := c.key(upstream, r, req)
+	if err != nil {
+		metrics.CacheLookups.Inc(req.Method, "error")
+		log.Printf("Cache unavailable for request %s (%s): %v", req.ID, req.Method, err)
+		return nil, ""
+	}
+	result, ok, err := c.store.Get(r.Context(), key)
+	if err != nil {
+		metrics.CacheLookups.Inc(req.Method, "error")
+		log.Printf("Cache unavailable for request %s (%s): %v", req.ID, req.Method, err)
+		return nil, ""
+	}
+	if !ok {
+		metrics.CacheLookups.Inc(req.Method, "miss")
+		return nil, key
+	}
+
+	metrics.CacheLookups.Inc(req.Method, "hit")
+	data, _ := json.Marshal(&schema.MCPResponse{JSONRPC: schema.Version, ID: req.ID, Result: result})
+	return &UpstreamResponse{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+	}, ""
+}
+
+// Fill stores the result of resp under key once its body has been read to
+// the end. Only successful JSON responses are stored; streamed responses
+// and errors are not.
+func (c *ResponseCache) Fill(key string, req *schema.MCPRequest, resp *UpstreamResponse) {
+	if c == nil || key == "" || resp.StatusCode != http.StatusOK || !isJSON(resp.Header) {
+		return
+	}
+	ttl, _ := c.cfg.TTL(req.Method)
+	resp.Body = &cachingBody{ReadCloser: resp.Body, store: func(data []byte) {
+		var msg schema.MCPResponse
+		if json.Unmarshal(data, &msg) != nil || msg.Error != nil || len(msg.Result) == 0 {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+		defer cancel()
+		if err := c.store.Set(ctx, key, msg.Result, ttl); err != nil {
+			log.Printf("Failed to cache response %s (%s): %v", req.ID, req.Method, err)
+		}
+	}}
+}
+
+// Invalidate drops the cached results a server notification reports as
+// changed: list_changed notifications invalidate the list, and
+// notifications/resources/updated the contents of the resource
+func (c *ResponseCache) Invalidate(msg *schema.MCPRequest) {
+	if c == nil {
+		return
+	}
+	topic, ok := invalidations[msg.Method]
+	if msg.Method == "notifications/resources/updated" {
+		uri, _ := msg.Params["uri"].(string)
+		topic, ok = "resource:"+uri, uri != ""
+	}
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+	if err := c.store.Invalidate(ctx, topic); err != nil {
+		log.Printf("Failed to invalidate cached %s: %v", topic, err)
+		return
+	}
+	log.Printf("Invalidated cached %s after %s", topic, msg.Method)
+}
+
+// key returns the key a response to req is cached under. It identifies
+// the upstream, the method and its canonical params, and the caller for
+// methods cached per user. The generation of the topic is part of the key,
+// so invalidation makes older results unreachable.
+func (c *ResponseCache) key(upstream Forwarder, r *http.Request, req *schema.MCPRequest) (string, error) {
+	topic := cacheTopic(req)
+	gen, err := c.store.Generation(r.Context(), topic)
+	if err != nil {
+		return "", err
+	}
+
+	target := ""
+	if resolver, ok := upstream.(Resolver); ok {
+		if route, err := resolver.Resolve(r, req); err == nil {
+			target = strings.Join(route.Upstreams, ",")
+		}
+	}
+	user := ""
+	if slices.Contains(c.cfg.PerUser, req.Method) {
+		if subject := SubjectFrom(r); subject != nil {
+			user = subject.ID
+		}
+	}
+
+	// Metadata such as progress tokens does not change the result. Maps
+	// are encoded with sorted keys, so equal params give equal keys.
+	params := make(map[string]interface{}, len(req.Params))
+	for name, value := range req.Params {
+		if name != "_meta" {
+			params[name] = value
+		}
+	}
+	identity, err := json.Marshal([]interface{}{target, req.Method, user, params})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(identity)
+	return topic + ":" + strconv.FormatInt(gen, 10) + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+// cacheTopic returns the topic the result of req belongs to
+func cacheTopic(req *schema.MCPRequest) string {
+	switch req.Method {
+	case "tools/list":
+		return "tools"
+	case "prompts/list":
+		return "prompts"
+	case "resources/list", "resources/templates/list":
+		return "resources"
+	default:
+		uri, _ := req.Params["uri"].(string)
+		return "resource:" + uri
+	}
+}
+
+// cachingBody passes a response body through and hands it to store once it
+// has been read to the end
+type cachingBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	once  sync.Once
+	store func([]byte)
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.once.Do(func() { b.store(b.buf.Bytes()) })
+	}
+	return n, err
+}
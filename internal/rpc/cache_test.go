@@ -0,0 +1,111 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safectx/internal/cache"
+	"safectx/internal/config"
+	"safectx/internal/middleware"
+	"safectx/internal/policy"
+)
+
+func TestGatewayCache(t *testing.T) {
+	server := &fakeMCPServer{name: "tools", tools: []string{"search", "deploy"}}
+	upstream := httptest.NewServer(server)
+	defer upstream.Close()
+
+	engine := policy.NewEngineFromConfig(&config.PolicyConfig{
+		DefaultEffect: "allow",
+		Rules: []config.PolicyRule{
+			{ID: "ops-deploy", Tools: []string{"deploy"}, Roles: []string{"ops"}, Effect: "allow"},
+			{ID: "deny-deploy", Tools: []string{"deploy"}, Effect: "deny"},
+		},
+	})
+	cfg := config.DefaultCacheConfig()
+	cfg.Methods = map[string]time.Duration{"tools/list": time.Minute, "resources/read": time.Minute}
+	gateway := NewGatewayHandler(newTestProxy(t, upstream.URL, time.Second)).
+		WithPolicyEngine(engine).
+		WithCache(NewResponseCache(&cfg, cache.NewMemoryStore(100)))
+
+	alice := &middleware.User{ID: "alice", Roles: []string{"ops"}}
+	bob := &middleware.User{ID: "bob"}
+	send := func(user *middleware.User, body string) map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req = req.WithContext(middleware.WithUser(req.Context(), user))
+		rr := httptest.NewRecorder()
+		gateway.ServeHTTP(rr, req)
+
+		var resp map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to unmarshal response %q: %v", rr.Body.String(), err)
+		}
+		return resp
+	}
+	tools := func(resp map[string]interface{}) string {
+		result, _ := resp["result"].(map[string]interface{})
+		items, _ := result["tools"].([]interface{})
+		var names []string
+		for _, item := range items {
+			names = append(names, item.(map[string]interface{})["name"].(string))
+		}
+		return strings.Join(names, ",")
+	}
+	forwarded := func(method string) int {
+		calls, _ := server.received()
+		n := 0
+		for _, c := range calls {
+			if strings.HasPrefix(c, method+" ") {
+				n++
+			}
+		}
+		return n
+	}
+
+	// A shared result is reduced to each caller's view
+	if got := tools(send(alice, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)); got != "search,deploy" {
+		t.Errorf("alice got tools %s, want search,deploy", got)
+	}
+	resp := send(bob, `{"jsonrpc":"2.0","id":2,"method":"tools/list","params":{"_meta":{"progressToken":7}}}`)
+	if got := tools(resp); got != "search" {
+		t.Errorf("bob got cached tools %s, want search", got)
+	}
+	if resp["id"] != float64(2) {
+		t.Errorf("Cached response has ID %v, want 2", resp["id"])
+	}
+	if n := forwarded("tools/list"); n != 1 {
+		t.Errorf("tools/list was forwarded %d times, want 1", n)
+	}
+
+	// Per-user results are not shared
+	read := `{"jsonrpc":"2.0","id":3,"method":"resources/read","params":{"uri":"file:///a.txt"}}`
+	send(alice, read)
+	send(bob, read)
+	send(alice, read)
+	if n := forwarded("resources/read"); n != 2 {
+		t.Errorf("resources/read was forwarded %d times, want 2", n)
+	}
+
+	// Methods that are not configured are not cached
+	send(alice, `{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"search"}}`)
+	send(alice, `{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"search"}}`)
+	if n := forwarded("tools/call"); n != 2 {
+		t.Errorf("tools/call was forwarded %d times, want 2", n)
+	}
+
+	// Server notifications invalidate what they report as changed
+	gateway.inspector.InspectServerMessage(nil, "", "", []byte(`{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`), nil, nil)
+	gateway.inspector.InspectServerMessage(nil, "", "", []byte(`{"jsonrpc":"2.0","method":"notifications/resources/updated","params":{"uri":"file:///a.txt"}}`), nil, nil)
+	send(bob, `{"jsonrpc":"2.0","id":6,"method":"tools/list"}`)
+	send(alice, read)
+	if n := forwarded("tools/list"); n != 2 {
+		t.Errorf("tools/list was forwarded %d times after list_changed, want 2", n)
+	}
+	if n := forwarded("resources/read"); n != 3 {
+		t.Errorf("resources/read was forwarded %d times after resources/updated, want 3", n)
+	}
+}
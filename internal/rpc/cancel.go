@@ -0,0 +1,188 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/pkg/schema"
+)
+
+// statusClientClosedRequest is the non-standard HTTP status reported for
+// requests the client cancelled
+const statusClientClosedRequest = 499
+
+// cancelNotifyTimeout bounds telling the upstream about an abandoned
+// request
+const cancelNotifyTimeout = 5 * time.Second
+
+// InFlight tracks the requests forwarded upstream, so that a
+// notifications/cancelled from the client can stop them
+type InFlight struct {
+	mu    sync.Mutex
+	calls map[string]*Flight
+}
+
+// Flight is a request forwarded upstream
+type Flight struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// byClient is set when the client cancelled the request
+	byClient atomic.Bool
+}
+
+// NewInFlight creates an empty tracker
+func NewInFlight() *InFlight {
+	return &InFlight{calls: make(map[string]*Flight)}
+}
+
+// Start derives the context of a forwarded request from parent, bounded
+// by deadline unless it is 0. The request is tracked under key; requests
+// with an empty key cannot be cancelled by the client.
+func (f *InFlight) Start(parent context.Context, key string, deadline time.Duration) *Flight {
+	fl := &Flight{}
+	if deadline > 0 {
+		fl.ctx, fl.cancel = context.WithTimeout(parent, deadline)
+	} else {
+		fl.ctx, fl.cancel = context.WithCancel(parent)
+	}
+	if key != "" {
+		f.mu.Lock()
+		f.calls[key] = fl
+		f.mu.Unlock()
+	}
+	return fl
+}
+
+// Cancel stops the request tracked under key on behalf of the client. It
+// reports whether the request was still in flight.
+func (f *InFlight) Cancel(key string) bool {
+	f.mu.Lock()
+	fl, ok := f.calls[key]
+	delete(f.calls, key)
+	f.mu.Unlock()
+	if !ok {
+		return false
+	}
+	fl.byClient.Store(true)
+	fl.cancel()
+	return true
+}
+
+// Finish stops tracking the request under key and releases its context
+func (f *InFlight) Finish(key string, fl *Flight) {
+	f.mu.Lock()
+	if f.calls[key] == fl {
+		delete(f.calls, key)
+	}
+	f.mu.Unlock()
+	fl.cancel()
+}
+
+// Context returns the context the request is forwarded with
+func (fl *Flight) Context() context.Context {
+	return fl.ctx
+}
+
+// Err returns why the request ended before its response was complete:
+// ErrRequestCancelled if the client cancelled it or went away, and
+// ErrUpstreamTimeout if its deadline passed. It returns nil while the
+// request may continue.
+func (fl *Flight) Err() error {
+	switch {
+	case fl.byClient.Load():
+		return fmt.Errorf("%w by the client", ErrRequestCancelled)
+	case errors.Is(fl.ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: deadline exceeded", ErrUpstreamTimeout)
+	case fl.ctx.Err() != nil:
+		return fmt.Errorf("%w: client disconnected", ErrRequestCancelled)
+	default:
+		return nil
+	}
+}
+
+// Abandoned reports whether the upstream must be told that the request
+// was given up. Requests the client cancelled are not, since the client's
+// own notification is forwarded.
+func (fl *Flight) Abandoned() bool {
+	return fl.ctx.Err() != nil && !fl.byClient.Load()
+}
+
+// flightBody reports the errors of a response body read after its request
+// ended early as the reason the request ended, and calls done once when
+// it is closed
+type flightBody struct {
+	io.ReadCloser
+	flight *Flight
+	once   sync.Once
+	done   func()
+}
+
+func (b *flightBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		if ferr := b.flight.Err(); ferr != nil {
+			err = ferr
+		}
+	}
+	return n, err
+}
+
+func (b *flightBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// Deadline returns the deadline configured for req, or 0 if it has none
+func Deadline(cfg *config.DeadlineConfig, req *schema.MCPRequest) time.Duration {
+	tool := ""
+	if params, ok := req.ToolCall(); ok {
+		tool = params.Name
+	}
+	return cfg.Deadline(req.Method, tool)
+}
+
+// NotifyCancelled tells the upstream that the request with the given ID,
+// sent on behalf of the client request r, was abandoned
+func NotifyCancelled(upstream Forwarder, r *http.Request, id schema.ID, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cancelNotifyTimeout)
+	defer cancel()
+
+	notification := &schema.MCPRequest{
+		JSONRPC: schema.Version,
+		Method:  schema.MethodCancelled,
+		Params:  map[string]interface{}{"requestId": id, "reason": reason},
+	}
+	resp, err := upstream.Forward(r.WithContext(ctx), notification)
+	if err != nil {
+		log.Printf("Failed to cancel request %s upstream: %v", id, err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	log.Printf("Cancelled request %s upstream: %s", id, reason)
+}
+
+// forwardError returns the HTTP status, JSON-RPC code and message
+// reported for a request that could not be forwarded, or whose response
+// could not be read
+func forwardError(err error) (int, int, string) {
+	switch {
+	case errors.Is(err, ErrRequestCancelled):
+		return statusClientClosedRequest, schema.CodeRequestCancelled, "Request cancelled"
+	case errors.Is(err, ErrUpstreamTimeout):
+		return http.StatusGatewayTimeout, schema.CodeUpstreamTimeout, "Upstream request timed out"
+	case errors.Is(err, ErrNoRoute):
+		return http.StatusNotFound, schema.CodeMethodNotFound, "Method not found"
+	default:
+		return http.StatusBadGateway, schema.CodeUpstreamError, "Upstream unavailable"
+	}
+}
@@ -0,0 +1,153 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/pkg/schema"
+)
+
+// newSlowUpstream starts an upstream that holds tools/call requests until
+// they are cancelled, answers the tool "stream" with an SSE stream that
+// never sends the response, and reports every notifications/cancelled it
+// receives on the returned channel. Calls are reported on started.
+func newSlowUpstream(t *testing.T) (*Proxy, chan map[string]interface{}, chan struct{}) {
+	t.Helper()
+
+	cancelled := make(chan map[string]interface{}, 10)
+	started := make(chan struct{}, 10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage        `json:"id"`
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		switch {
+		case req.Method == schema.MethodCancelled:
+			cancelled <- req.Params
+			w.WriteHeader(http.StatusAccepted)
+		case req.Method == schema.MethodToolsCall && req.Params["name"] == "stream":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintf(w, "data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\",\"params\":{}}\n\n")
+			w.(http.Flusher).Flush()
+			started <- struct{}{}
+			<-r.Context().Done()
+		case req.Method == schema.MethodToolsCall:
+			started <- struct{}{}
+			<-r.Context().Done()
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":{}}`, req.ID)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	return newTestProxy(t, upstream.URL, time.Second), cancelled, started
+}
+
+func TestGatewayDeadlines(t *testing.T) {
+	proxy, cancelled, _ := newSlowUpstream(t)
+	gateway := NewGatewayHandler(proxy).WithDeadlines(&config.DeadlineConfig{
+		Methods: map[string]time.Duration{"tools/call": time.Hour, "ping": time.Hour},
+		Tools:   map[string]time.Duration{"build": 50 * time.Millisecond, "stream": 50 * time.Millisecond},
+	})
+
+	t.Run("within deadline", func(t *testing.T) {
+		_, resp := call(t, gateway, "/", "", `{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+		if resp["error"] != nil {
+			t.Errorf("Got response %v, want a result", resp)
+		}
+	})
+
+	t.Run("tool deadline", func(t *testing.T) {
+		start := time.Now()
+		rr, resp := call(t, gateway, "/", "", `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"build"}}`)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Request took %s, want the tool deadline", elapsed)
+		}
+		errObj, _ := resp["error"].(map[string]interface{})
+		if rr.Code != http.StatusGatewayTimeout || errObj["code"] != float64(schema.CodeUpstreamTimeout) {
+			t.Errorf("Got %d %v, want %d with code %d", rr.Code, resp, http.StatusGatewayTimeout, schema.CodeUpstreamTimeout)
+		}
+		select {
+		case params := <-cancelled:
+			if params["requestId"] != float64(2) {
+				t.Errorf("Upstream got cancellation %v, want request 2", params)
+			}
+		case <-time.After(time.Second):
+			t.Error("Upstream was not told about the abandoned request")
+		}
+	})
+
+	t.Run("streamed response", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"stream"}}`))
+		rr := httptest.NewRecorder()
+		gateway.ServeHTTP(rr, req)
+
+		body := rr.Body.String()
+		if !strings.Contains(body, "notifications/progress") || !strings.Contains(body, fmt.Sprintf(`"id":3,"error":{"code":%d`, schema.CodeUpstreamTimeout)) {
+			t.Errorf("Got stream %q, want the progress event followed by a timeout error", body)
+		}
+		<-cancelled
+	})
+}
+
+func TestGatewayClientCancellation(t *testing.T) {
+	proxy, cancelled, started := newSlowUpstream(t)
+	gateway := NewGatewayHandler(proxy)
+
+	type response struct {
+		code int
+		body map[string]interface{}
+	}
+	done := make(chan response, 1)
+	go func() {
+		rr, resp := call(t, gateway, "/", "s1", `{"jsonrpc":"2.0","id":"a","method":"tools/call","params":{"name":"build"}}`)
+		done <- response{rr.Code, resp}
+	}()
+	<-started
+
+	// A cancellation in another session does not match
+	call(t, gateway, "/", "s2", `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"a"}}`)
+	<-cancelled
+	select {
+	case <-done:
+		t.Fatal("Request was cancelled from another session")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	rr, _ := call(t, gateway, "/", "s1", `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"a","reason":"user abort"}}`)
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("Got status %d for the cancellation, want %d", rr.Code, http.StatusAccepted)
+	}
+
+	select {
+	case resp := <-done:
+		errObj, _ := resp.body["error"].(map[string]interface{})
+		if resp.code != statusClientClosedRequest || errObj["code"] != float64(schema.CodeRequestCancelled) {
+			t.Errorf("Got %d %v, want code %d", resp.code, resp.body, schema.CodeRequestCancelled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Cancelled request is still in flight")
+	}
+
+	// The upstream gets the client's notification, and no second one from
+	// the gateway
+	if params := <-cancelled; params["reason"] != "user abort" {
+		t.Errorf("Upstream got cancellation %v, want the client's", params)
+	}
+	select {
+	case params := <-cancelled:
+		t.Errorf("Upstream got a second cancellation %v", params)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,265 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"safectx/internal/pipeline"
+	"safectx/internal/policy"
+	"safectx/pkg/schema"
+)
+
+// Explanation traces what the gateway would do with a request
+type Explanation struct {
+	Schema SchemaTrace `json:"schema"`
+	// Stages lists the verdict of every stage that ran, in order
+	Stages []StageTrace `json:"stages,omitempty"`
+	// Skipped lists the stages that did not run because the request was
+	// denied first
+	Skipped []string `json:"skipped,omitempty"`
+	// Score is the total score of the verdicts
+	Score float64 `json:"score"`
+	// Policy is the decision of the policy engine, whether or not the
+	// policy stage runs for the request
+	Policy *policy.Decision `json:"policy,omitempty"`
+	// Changes lists the changes the stages made to the params
+	Changes []ParamChange `json:"changes,omitempty"`
+	// Decision is "forward", "approve" when the request would be held
+	// for approval before it is forwarded, "deny" or "invalid"
+	Decision string `json:"decision"`
+	// Error is the error response the client would get
+	Error *schema.Error `json:"error,omitempty"`
+	// Route is where the request would be forwarded
+	Route *RouteTarget `json:"route,omitempty"`
+	// RouteError explains why the request could not be routed
+	RouteError string `json:"routeError,omitempty"`
+}
+
+// SchemaTrace is the outcome of validating a request
+type SchemaTrace struct {
+	Valid  bool   `json:"valid"`
+	Code   int    `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Method string `json:"method,omitempty"`
+}
+
+// StageTrace is the verdict of a single stage
+type StageTrace struct {
+	Stage     string  `json:"stage"`
+	Outcome   string  `json:"outcome"`
+	Rule      string  `json:"rule,omitempty"`
+	Severity  string  `json:"severity,omitempty"`
+	Path      string  `json:"path,omitempty"`
+	Reason    string  `json:"reason,omitempty"`
+	Score     float64 `json:"score,omitempty"`
+	Monitored bool    `json:"monitored,omitempty"`
+}
+
+// ParamChange is a value of the params changed by a stage
+type ParamChange struct {
+	// Path is the JSON pointer of the value within params
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// ExplainHandler serves POST /explain on the admin server. The body is a
+// single JSON-RPC message; the response traces it through validation, the
+// pipeline, the policy engine and routing without forwarding it. The
+// caller to evaluate for is given by the query parameters subject, role
+// (repeated) and claim (repeated, name:value), and the client URL path by
+// path.
+func (g *Gateway) ExplainHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, g.explain(r, body))
+	})
+}
+
+// explain traces a single message
+func (g *Gateway) explain(r *http.Request, body []byte) *Explanation {
+	exp := &Explanation{Decision: "invalid"}
+
+	req, err := schema.ParseRequest(body)
+	if err != nil {
+		code := schema.CodeInvalidRequest
+		var verr *schema.ValidationError
+		if errors.As(err, &verr) {
+			code = verr.Code()
+		}
+		exp.Schema = SchemaTrace{Code: code, Error: err.Error()}
+		exp.Error = &schema.Error{Code: code, Message: err.Error()}
+		return exp
+	}
+	exp.Schema = SchemaTrace{Valid: true, Method: req.Method}
+
+	query := r.URL.Query()
+	clientPath := query.Get("path")
+	if clientPath == "" {
+		clientPath = "/"
+	}
+	subject := explainSubject(query["subject"], query["role"], query["claim"])
+	original := cloneParams(req.Params)
+
+	// Routing only looks at the URL path and session of the client request
+	target, targetErr := http.NewRequestWithContext(r.Context(), http.MethodPost, clientPath, nil)
+	if targetErr == nil {
+		target.Header.Set("Mcp-Session-Id", r.Header.Get("Mcp-Session-Id"))
+	}
+
+	call := &pipeline.Call{Request: req, Subject: subject, Path: clientPath, CorrelationID: NewCorrelationID(), DryRun: true}
+	if targetErr == nil {
+		call.Upstream, call.Tool = g.upstreamTool(target, req)
+	}
+	decision := policy.Decide(g.inspector.Policy(), subject, req)
+	exp.Policy = &decision
+
+	stages := g.inspector.Stages(call)
+	result, rpcErr := g.inspector.Explain(call)
+	for _, v := range result.Trace {
+		exp.Stages = append(exp.Stages, StageTrace{
+			Stage:     v.Stage,
+			Outcome:   string(v.Outcome),
+			Rule:      v.RuleID,
+			Severity:  v.Severity,
+			Path:      v.Path,
+			Reason:    v.Reason,
+			Score:     v.Score,
+			Monitored: v.Monitored,
+		})
+	}
+	ran := make(map[string]bool, len(result.Trace))
+	for _, v := range result.Trace {
+		ran[v.Stage] = true
+	}
+	for _, name := range stages {
+		if !ran[name] {
+			exp.Skipped = append(exp.Skipped, name)
+		}
+	}
+	exp.Score = result.Score
+	exp.Changes = diffParams(original, req.Params, "")
+
+	if rpcErr != nil {
+		exp.Decision = "deny"
+		exp.Error = rpcErr
+		return exp
+	}
+	exp.Decision = "forward"
+	if result.Approval != nil {
+		exp.Decision = "approve"
+	}
+
+	resolver, ok := g.upstream.(Resolver)
+	if !ok {
+		return exp
+	}
+	err = targetErr
+	if err == nil {
+		exp.Route, err = resolver.Resolve(target, req)
+	}
+	if err != nil {
+		exp.RouteError = err.Error()
+		if errors.Is(err, ErrNoRoute) {
+			exp.Error = &schema.Error{Code: schema.CodeMethodNotFound, Message: "Method not found"}
+		}
+	}
+	return exp
+}
+
+// explainSubject builds the subject to evaluate from the query parameters.
+// It returns nil for an anonymous caller.
+func explainSubject(ids, roles, claims []string) *policy.Subject {
+	if len(ids) == 0 && len(roles) == 0 && len(claims) == 0 {
+		return nil
+	}
+
+	subject := &policy.Subject{Roles: roles, Claims: make(map[string]interface{})}
+	if len(ids) > 0 {
+		subject.ID = ids[0]
+	}
+	for _, claim := range claims {
+		name, value, _ := strings.Cut(claim, ":")
+		switch existing := subject.Claims[name].(type) {
+		case nil:
+			subject.Claims[name] = value
+		case string:
+			subject.Claims[name] = []string{existing, value}
+		case []string:
+			subject.Claims[name] = append(existing, value)
+		}
+	}
+	return subject
+}
+
+// cloneParams deep copies params so changes made by the stages can be
+// compared with the original
+func cloneParams(params map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		return nil
+	}
+	data, _ := json.Marshal(params)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var clone map[string]interface{}
+	decoder.Decode(&clone)
+	return clone
+}
+
+// diffParams lists the values that differ between two decoded JSON values
+func diffParams(before, after interface{}, path string) []ParamChange {
+	beforeObj, ok1 := before.(map[string]interface{})
+	afterObj, ok2 := after.(map[string]interface{})
+	if ok1 && ok2 {
+		keys := make(map[string]bool)
+		for key := range beforeObj {
+			keys[key] = true
+		}
+		for key := range afterObj {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		var changes []ParamChange
+		for _, key := range sorted {
+			changes = append(changes, diffParams(beforeObj[key], afterObj[key], path+"/"+schema.EscapePointer(key))...)
+		}
+		return changes
+	}
+
+	beforeArr, ok1 := before.([]interface{})
+	afterArr, ok2 := after.([]interface{})
+	if ok1 && ok2 && len(beforeArr) == len(afterArr) {
+		var changes []ParamChange
+		for i := range beforeArr {
+			changes = append(changes, diffParams(beforeArr[i], afterArr[i], fmt.Sprintf("%s/%d", path, i))...)
+		}
+		return changes
+	}
+
+	if reflect.DeepEqual(before, after) {
+		return nil
+	}
+	return []ParamChange{{Path: path, Before: before, After: after}}
+}
@@ -0,0 +1,136 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"safectx/internal/config"
+	"safectx/internal/policy"
+)
+
+func TestExplainHandler(t *testing.T) {
+	github := &fakeMCPServer{name: "github", tools: []string{"create_issue"}}
+	db := &fakeMCPServer{name: "db"}
+	gateway := newTestRouter(t, &config.RoutingConfig{
+		Routes: []config.RouteConfig{
+			{Upstream: "db", Tools: []string{"sql_*"}},
+			{Upstream: "github", Methods: []string{"tools/*"}},
+		},
+	}, github, db).WithPolicyEngine(policy.NewEngineFromConfig(&config.PolicyConfig{
+		DefaultEffect: "allow",
+		Rules: []config.PolicyRule{
+			{ID: "ops-deploy", Tools: []string{"deploy"}, Roles: []string{"ops"}, Effect: "allow"},
+			{ID: "deny-deploy", Tools: []string{"deploy"}, Effect: "deny"},
+		},
+	}))
+
+	tests := []struct {
+		name         string
+		query        string
+		body         string
+		wantDecision string
+		wantStages   []string
+		wantSkipped  []string
+		wantRule     string
+		wantChanges  []string
+		wantRoute    []string
+	}{
+		{
+			name:         "invalid request",
+			body:         `{"jsonrpc":"1.0","id":1,"method":"ping"}`,
+			wantDecision: "invalid",
+		},
+		{
+			name:         "injection",
+			body:         `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"create_issue","arguments":{"body":"DROP TABLE users"}}}`,
+			wantDecision: "deny",
+			wantStages:   []string{"detection/deny/prompt-injection"},
+			wantSkipped:  []string{"policy", "redaction"},
+			wantRule:     "default",
+		},
+		{
+			name:         "policy denial",
+			body:         `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"deploy"}}`,
+			wantDecision: "deny",
+			wantStages:   []string{"detection/allow/", "policy/deny/deny-deploy"},
+			wantSkipped:  []string{"redaction"},
+			wantRule:     "deny-deploy",
+		},
+		{
+			name:         "policy allows role",
+			query:        "?subject=alice&role=ops",
+			body:         `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"deploy"}}`,
+			wantDecision: "forward",
+			wantStages:   []string{"detection/allow/", "policy/allow/", "redaction/allow/"},
+			wantRule:     "ops-deploy",
+			wantRoute:    []string{"github"},
+		},
+		{
+			name:         "redaction and routing",
+			body:         `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"sql_query","arguments":{"password":"hunter2"}}}`,
+			wantDecision: "forward",
+			wantStages:   []string{"detection/allow/", "policy/allow/", "redaction/mutate/sensitive-keys"},
+			wantRule:     "default",
+			wantChanges:  []string{"/arguments/password: hunter2 -> [REDACTED]"},
+			wantRoute:    []string{"db"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/explain"+tt.query, strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			gateway.ExplainHandler().ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Got status %d, want 200: %s", rr.Code, rr.Body.String())
+			}
+			var exp Explanation
+			if err := json.Unmarshal(rr.Body.Bytes(), &exp); err != nil {
+				t.Fatalf("Failed to unmarshal explanation: %v", err)
+			}
+
+			if exp.Decision != tt.wantDecision {
+				t.Errorf("Got decision %q, want %q", exp.Decision, tt.wantDecision)
+			}
+			var stages []string
+			for _, s := range exp.Stages {
+				stages = append(stages, s.Stage+"/"+s.Outcome+"/"+s.Rule)
+			}
+			if !reflect.DeepEqual(stages, tt.wantStages) {
+				t.Errorf("Got stages %v, want %v", stages, tt.wantStages)
+			}
+			if !reflect.DeepEqual(exp.Skipped, tt.wantSkipped) {
+				t.Errorf("Got skipped %v, want %v", exp.Skipped, tt.wantSkipped)
+			}
+			if tt.wantRule != "" && (exp.Policy == nil || exp.Policy.RuleID != tt.wantRule) {
+				t.Errorf("Got policy decision %+v, want rule %s", exp.Policy, tt.wantRule)
+			}
+			var changes []string
+			for _, c := range exp.Changes {
+				changes = append(changes, c.Path+": "+c.Before.(string)+" -> "+c.After.(string))
+			}
+			if !reflect.DeepEqual(changes, tt.wantChanges) {
+				t.Errorf("Got changes %v, want %v", changes, tt.wantChanges)
+			}
+			var route []string
+			if exp.Route != nil {
+				route = exp.Route.Upstreams
+			}
+			if !reflect.DeepEqual(route, tt.wantRoute) {
+				t.Errorf("Got route %v, want %v", route, tt.wantRoute)
+			}
+		})
+	}
+
+	// Nothing is forwarded upstream
+	for _, server := range []*fakeMCPServer{github, db} {
+		if calls, _ := server.received(); len(calls) != 0 {
+			t.Errorf("Upstream %s received %v", server.name, calls)
+		}
+	}
+}
@@ -1,66 +1,549 @@
 package rpc
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"safectx/internal/approval"
+	"safectx/internal/capture"
+	"safectx/internal/config"
 	"safectx/internal/contextfilter"
-	"safectx/internal/detection"
+	"safectx/internal/enforcement"
+	"safectx/internal/handshake"
+	"safectx/internal/middleware"
+	"safectx/internal/pipeline"
 	"safectx/internal/policy"
+	"safectx/internal/toolschema"
 	"safectx/pkg/schema"
+	"sync"
 )
 
+// defaultMaxBatchSize is the batch limit used when none is configured
+const defaultMaxBatchSize = 50
+
+// Gateway is the main SafeCtx HTTP handler. It validates, inspects and
+// sanitizes incoming requests before forwarding them upstream.
+type Gateway struct {
+	upstream     Forwarder
+	inspector    *Inspector
+	maxBatchSize int
+	capture      *capture.Writer
+	deadlines    *config.DeadlineConfig
+	inflight     *InFlight
+	cache        *ResponseCache
+	origins      *ServerOrigins
+}
+
 // NewGatewayHandler returns the main SafeCtx HTTP handler
-func NewGatewayHandler() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var req schema.MCPRequest
-
-		// Decode the incoming request
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid JSON format", http.StatusBadRequest)
-			log.Printf("Error decoding request: %v", err)
-			return
+func NewGatewayHandler(upstream Forwarder) *Gateway {
+	return &Gateway{
+		upstream:     upstream,
+		inspector:    NewInspector(policy.NewDefaultEngine()),
+		maxBatchSize: defaultMaxBatchSize,
+		deadlines:    &config.DeadlineConfig{},
+		inflight:     NewInFlight(),
+		origins:      NewServerOrigins(),
+	}
+}
+
+// WithInspector screens messages with inspector instead of one configured
+// through the gateway, so that it is shared with other handlers. Its
+// response cache also answers the gateway's requests.
+func (g *Gateway) WithInspector(inspector *Inspector) *Gateway {
+	g.inspector = inspector
+	g.cache = inspector.cache
+	return g
+}
+
+// WithPolicyEngine sets the policy engine used to authorize requests
+func (g *Gateway) WithPolicyEngine(engine policy.Engine) *Gateway {
+	g.inspector.WithPolicyEngine(engine)
+	return g
+}
+
+// WithPipeline sets the pipeline requests run through before they are
+// forwarded
+func (g *Gateway) WithPipeline(p *pipeline.Pipeline) *Gateway {
+	g.inspector.WithPipeline(p)
+	return g
+}
+
+// WithResponseFilter sets the filter applied to upstream responses
+func (g *Gateway) WithResponseFilter(filter *contextfilter.ResponseFilter) *Gateway {
+	g.inspector.WithResponseFilter(filter)
+	return g
+}
+
+// WithEnforcement sets the modes deciding which verdicts are enforced and
+// which are only monitored
+func (g *Gateway) WithEnforcement(modes *enforcement.Modes) *Gateway {
+	g.inspector.WithEnforcement(modes)
+	return g
+}
+
+// WithDenialDetail sets how much of a denial is exposed to clients in the
+// error data
+func (g *Gateway) WithDenialDetail(detail string) *Gateway {
+	g.inspector.WithDenialDetail(detail)
+	return g
+}
+
+// WithHandshake applies the handshake policy of guard to initialize
+// results and records what each session negotiated
+func (g *Gateway) WithHandshake(guard *handshake.Guard) *Gateway {
+	g.inspector.WithHandshake(guard)
+	return g
+}
+
+// WithApprovals holds the requests a policy rule requires approval for in
+// queue until they are decided
+func (g *Gateway) WithApprovals(queue *approval.Queue) *Gateway {
+	g.inspector.WithApprovals(queue)
+	return g
+}
+
+// WithToolSchemas records the input schemas of the tools in tools/list
+// results in registry, for the arguments stage
+func (g *Gateway) WithToolSchemas(registry *toolschema.Registry) *Gateway {
+	g.inspector.WithToolSchemas(registry)
+	return g
+}
+
+// WithMaxBatchSize sets the maximum number of messages accepted in a batch
+func (g *Gateway) WithMaxBatchSize(n int) *Gateway {
+	g.maxBatchSize = n
+	return g
+}
+
+// WithCapture records every valid request, its verdicts and the upstream
+// response to w
+func (g *Gateway) WithCapture(w *capture.Writer) *Gateway {
+	g.capture = w
+	return g
+}
+
+// WithDeadlines bounds forwarded requests by method and tool
+func (g *Gateway) WithDeadlines(cfg *config.DeadlineConfig) *Gateway {
+	g.deadlines = cfg
+	return g
+}
+
+// WithCache answers idempotent requests from c, and invalidates its results
+// when the upstream reports a change
+func (g *Gateway) WithCache(c *ResponseCache) *Gateway {
+	g.cache = c
+	g.inspector.WithCache(c)
+	return g
+}
+
+// result is the outcome of processing a single JSON-RPC message. At most one
+// of response and upstream is set; neither is set for an accepted
+// notification.
+type result struct {
+	// status is the HTTP status used when the message was sent on its own
+	status int
+	// response is an error response generated by the gateway
+	response *schema.MCPResponse
+	// upstream is the response relayed from the upstream server
+	upstream *UpstreamResponse
+	// id is the ID of the request the result belongs to
+	id schema.ID
+	// request is the validated request, if it could be parsed
+	request *schema.MCPRequest
+	// subject is the authenticated caller, if any
+	subject *policy.Subject
+	// notification is set when the message was a valid notification
+	notification bool
+	// record is the capture record of the request, if traffic is captured
+	record *capture.Record
+}
+
+// ServeHTTP implements the http.Handler interface
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodGet, http.MethodDelete:
+		g.serveSession(w, r)
+		return
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, schema.ID{}, schema.CodeParseError, "Failed to read request body")
+		log.Printf("Error reading request: %v", err)
+		return
+	}
+
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		g.serveBatch(w, r, trimmed)
+		return
+	}
+
+	res := g.process(r, body)
+	defer g.record(res)
+	if res.response != nil {
+		writeJSON(w, res.status, res.response)
+		return
+	}
+	if res.upstream == nil {
+		w.WriteHeader(res.status)
+		return
+	}
+	defer res.upstream.Body.Close()
+
+	// Relay the upstream response to the caller
+	session := responseSession(r, res.upstream)
+	if isEventStream(res.upstream.Header) {
+		g.relayStream(w, r, res.upstream, session, res.request, res.record)
+		return
+	}
+	g.relayBody(w, r, res.upstream, session, res.request, res.record)
+}
+
+// serveBatch processes every element of a JSON-RPC batch independently and
+// writes the combined response
+func (g *Gateway) serveBatch(w http.ResponseWriter, r *http.Request, body []byte) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(body, &elements); err != nil {
+		writeError(w, http.StatusBadRequest, schema.ID{}, schema.CodeParseError, schema.ErrParse.Error())
+		log.Printf("Error decoding batch: %v", err)
+		return
+	}
+
+	if len(elements) == 0 {
+		writeError(w, http.StatusBadRequest, schema.ID{}, schema.CodeInvalidRequest, "invalid request: empty batch")
+		return
+	}
+
+	if len(elements) > g.maxBatchSize {
+		writeError(w, http.StatusBadRequest, schema.ID{}, schema.CodeInvalidRequest,
+			fmt.Sprintf("invalid request: batch of %d messages exceeds limit of %d", len(elements), g.maxBatchSize))
+		log.Printf("Rejected batch of %d messages", len(elements))
+		return
+	}
+
+	// Every element gets its own verdict, so a blocked element is replaced
+	// by an error without affecting its siblings
+	results := make([]json.RawMessage, len(elements))
+	var wg sync.WaitGroup
+	for i, element := range elements {
+		wg.Add(1)
+		go func(i int, element json.RawMessage) {
+			defer wg.Done()
+			res := g.process(r, element)
+			results[i] = g.batchResponse(r, res)
+			g.record(res)
+		}(i, element)
+	}
+	wg.Wait()
+
+	// Notifications have no entry in the batch response
+	responses := make([]json.RawMessage, 0, len(results))
+	for _, resp := range results {
+		if resp != nil {
+			responses = append(responses, resp)
 		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, responses)
+}
+
+// batchResponse converts the result of a batch element into its entry in
+// the batch response. It returns nil for elements that get no response.
+func (g *Gateway) batchResponse(r *http.Request, res *result) json.RawMessage {
+	if res.notification {
+		return nil
+	}
+	if res.response != nil {
+		data, _ := json.Marshal(res.response)
+		return data
+	}
+	defer res.upstream.Body.Close()
+
+	var data []byte
+	var err error
+	session := responseSession(r, res.upstream)
+	if isEventStream(res.upstream.Header) {
+		data, err = g.readStreamResponse(r, res.upstream, session, res.request, res.record)
+	} else if data, err = io.ReadAll(res.upstream.Body); err == nil {
+		raw := data
+		var verdict *contextfilter.ResponseVerdict
+		data, verdict = g.inspector.InspectResponse(res.subject, session, data, res.request, UpstreamTools(g.upstream, r))
+		res.record.Respond(raw, verdict)
+	}
+	if err != nil && ended(err) {
+		log.Printf("Upstream response in batch ended early: %v", err)
+		_, code, message := forwardError(err)
+		data, _ = json.Marshal(schema.NewErrorResponse(res.id, code, message))
+	} else if err != nil || !json.Valid(data) {
+		log.Printf("Invalid upstream response in batch (status %d): %v", res.upstream.StatusCode, err)
+		data, _ = json.Marshal(schema.NewErrorResponse(res.id, schema.CodeUpstreamError, "Invalid upstream response"))
+	}
+	return bytes.TrimSpace(data)
+}
+
+// upstreamTool returns the upstream a tools/call is routed to and its own
+// name of the tool, if the upstream exposes it under another name
+func (g *Gateway) upstreamTool(r *http.Request, req *schema.MCPRequest) (upstream, tool string) {
+	return UpstreamTools(g.upstream, r).Route(req)
+}
 
-		// Validate schema
-		if err := schema.Validate(&req); err != nil {
-			http.Error(w, "Schema validation failed: "+err.Error(), http.StatusBadRequest)
-			log.Printf("Schema validation failed: %v", err)
-			return
+// ToolResolver returns the upstream serving the tool listed to the client as
+// name, and the upstream's own name of it, or "" if the upstream exposes
+// the tool under that name. The upstream is empty if the name resolves to
+// no single upstream. A nil ToolResolver resolves every name to the only upstream.
+type ToolResolver func(name string) (upstream, tool string)
+
+// UpstreamTools returns the ToolResolver of the upstream forwarder for
+// requests on behalf of r, or nil if the forwarder is not a Resolver
+func UpstreamTools(upstream Forwarder, r *http.Request) ToolResolver {
+	resolver, ok := upstream.(Resolver)
+	if !ok {
+		return nil
+	}
+	return func(name string) (string, string) {
+		// Notifications are broadcast, so only a request resolves a tool
+		req := toolCall(name)
+		req.ID = schema.NumberID(0)
+		target, err := resolver.Resolve(r, req)
+		if err != nil {
+			return "", ""
+		}
+		if len(target.Upstreams) != 1 {
+			return "", target.Tool
 		}
+		return target.Upstreams[0], target.Tool
+	}
+}
+
+// Route returns the upstream a tools/call is routed to and its own name of
+// the tool, if the upstream exposes it under another name. Other requests
+// resolve to no upstream.
+func (t ToolResolver) Route(req *schema.MCPRequest) (upstream, tool string) {
+	call, ok := req.ToolCall()
+	if !ok {
+		return "", ""
+	}
+	return t.resolve(call.Name)
+}
 
-		// Check for prompt injection
-		if detection.CheckForInjection(&req) {
-			http.Error(w, "Potential prompt injection detected", http.StatusForbidden)
-			log.Printf("Prompt injection detected in request: %+v", req)
-			return
+// resolve is like calling t, but resolves names to the only upstream if t
+// is nil
+func (t ToolResolver) resolve(name string) (upstream, tool string) {
+	if t == nil {
+		return DefaultUpstreamName, ""
+	}
+	return t(name)
+}
+
+// process runs a single JSON-RPC message through validation, detection,
+// policy and redaction, and forwards it upstream if it is allowed
+func (g *Gateway) process(r *http.Request, body []byte) *result {
+	// Responses answer requests the server sent on an SSE stream
+	if isClientResponse(body) {
+		return g.forwardResponse(r, body)
+	}
+
+	// Decode and validate the incoming request
+	req, err := schema.ParseRequest(body)
+	if err != nil {
+		code := schema.CodeInvalidRequest
+		var verr *schema.ValidationError
+		if errors.As(err, &verr) {
+			code = verr.Code()
 		}
+		log.Printf("Schema validation failed: %v", err)
+		return errorResult(http.StatusBadRequest, req.ID, code, err.Error())
+	}
+	subject := SubjectFrom(r)
+	call := &pipeline.Call{
+		Request:       req,
+		Subject:       subject,
+		Path:          r.URL.Path,
+		CorrelationID: correlationID(r),
+		Session:       r.Header.Get("Mcp-Session-Id"),
+	}
+	call.Upstream, call.Tool = g.upstreamTool(r, req)
+	var rec *capture.Record
+	if g.capture != nil {
+		rec = capture.Start(call)
+	}
+	res := g.inspect(r, call, rec)
+	res.record = rec
+	res.id = req.ID
+	res.request = req
+	res.subject = subject
+	res.notification = req.IsNotification()
+	return res
+}
 
-		// Evaluate policy
-		policyEngine := policy.NewDefaultEngine()
-		allowed, err := policyEngine.Evaluate(&req)
-		if err != nil || !allowed {
-			http.Error(w, "Policy denied request", http.StatusForbidden)
-			log.Printf("Policy denied request: %v", err)
-			return
+// inspect screens a valid request and forwards it upstream if it is
+// allowed. The verdicts are recorded in rec.
+func (g *Gateway) inspect(r *http.Request, call *pipeline.Call, rec *capture.Record) *result {
+	req := call.Request
+	screened, rpcErr := g.inspector.ScreenResult(call)
+	rec.Screened(screened)
+	if rpcErr == nil {
+		// Requests a policy rule requires approval for wait for a decision
+		rpcErr = g.inspector.Hold(r.Context(), call, screened)
+	}
+	if rpcErr != nil {
+		return &result{
+			status:   StatusForCode(rpcErr.Code),
+			response: &schema.MCPResponse{JSONRPC: schema.Version, ID: req.ID, Error: rpcErr},
 		}
+	}
 
-		// Redact sensitive content
-		contextfilter.Redact(&req)
+	if cancelled, ok := req.Cancelled(); ok {
+		g.cancel(call, cancelled)
+	}
 
-		// Log successful request processing
-		log.Printf("Successfully processed request: %+v", req)
+	// Idempotent requests may be answered without the upstream
+	cached, cacheKey := g.cache.Lookup(g.upstream, r, req)
+	if cached != nil {
+		log.Printf("Served request %s (%s) from cache", req.ID, req.Method)
+		return &result{status: cached.StatusCode, upstream: cached}
+	}
 
-		// Respond with a success message
-		w.Header().Set("Content-Type", "application/json")
-		response := map[string]interface{}{
-			"status":  "ok",
-			"message": "Request processed by SafeCtx",
-			"data":    req.Params,
+	// Forward the sanitized request upstream, within its deadline
+	key := ""
+	if !req.IsNotification() {
+		key = flightKey(call, req.ID)
+	}
+	flight := g.inflight.Start(r.Context(), key, Deadline(g.deadlines, req))
+	done := func() {
+		abandoned := flight.Abandoned()
+		g.inflight.Finish(key, flight)
+		if abandoned && !req.IsNotification() {
+			go NotifyCancelled(g.upstream, r, req.ID, flight.Err().Error())
+		}
+	}
+	resp, err := g.upstream.Forward(r.WithContext(flight.Context()), req)
+	if err != nil {
+		if ferr := flight.Err(); ferr != nil {
+			err = ferr
 		}
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-			log.Printf("Error encoding response: %v", err)
+		done()
+		log.Printf("Error forwarding request %s: %v", req.ID, err)
+		status, code, message := forwardError(err)
+		return errorResult(status, req.ID, code, message)
+	}
+	resp.Body = &flightBody{ReadCloser: resp.Body, flight: flight, done: done}
+	g.cache.Fill(cacheKey, req, resp)
+	rec.Forwarded(resp.StatusCode)
+
+	// Notifications get no response, so the upstream reply is discarded
+	if req.IsNotification() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			log.Printf("Upstream rejected notification %s with status %d", req.Method, resp.StatusCode)
+		} else {
+			log.Printf("Forwarded notification %s", req.Method)
 		}
-	})
+		return &result{status: http.StatusAccepted}
+	}
+
+	log.Printf("Forwarded request %s (%s), upstream status %d", req.ID, req.Method, resp.StatusCode)
+	return &result{status: resp.StatusCode, upstream: resp}
+}
+
+// cancel stops the in-flight request a notifications/cancelled from the
+// client refers to. The notification is still forwarded, so the upstream
+// stops working on the request too.
+func (g *Gateway) cancel(call *pipeline.Call, params *schema.CancelledParams) {
+	key := flightKey(call, params.RequestID)
+	if key == "" || !g.inflight.Cancel(key) {
+		return
+	}
+	log.Printf("Client cancelled request %s: %s", params.RequestID, params.Reason)
+}
+
+// flightKey identifies a request of the caller among the requests in
+// flight: by MCP session, or by user outside a session. It is empty for
+// anonymous callers outside a session, whose requests cannot be told
+// apart.
+func flightKey(call *pipeline.Call, id schema.ID) string {
+	raw, _ := json.Marshal(id)
+	switch {
+	case call.Session != "":
+		return "session:" + call.Session + "|" + string(raw)
+	case call.Subject != nil && call.Subject.ID != "":
+		return "user:" + call.Subject.ID + "|" + string(raw)
+	default:
+		return ""
+	}
+}
+
+// record writes the capture record of a result once its response was
+// relayed
+func (g *Gateway) record(res *result) {
+	if res.record == nil {
+		return
+	}
+	var rpcErr *schema.Error
+	if res.response != nil {
+		rpcErr = res.response.Error
+	}
+	res.record.Finish(rpcErr)
+	g.capture.Write(res.record)
+}
+
+// correlationID returns the X-Request-ID of the client request, or a new ID
+// if the client sent none
+func correlationID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	return NewCorrelationID()
+}
+
+// responseSession returns the MCP session an upstream response belongs
+// to. The response to initialize carries the ID of the new session.
+func responseSession(r *http.Request, resp *UpstreamResponse) string {
+	if session := resp.Header.Get("Mcp-Session-Id"); session != "" {
+		return session
+	}
+	return r.Header.Get("Mcp-Session-Id")
+}
+
+// SubjectFrom returns the policy subject for the authenticated user of the
+// request, or nil if the request is not authenticated
+func SubjectFrom(r *http.Request) *policy.Subject {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		return nil
+	}
+	return &policy.Subject{ID: user.ID, Roles: user.Roles, Claims: user.Claims}
+}
+
+// errorResult creates a result holding a gateway error response
+func errorResult(status int, id schema.ID, code int, message string) *result {
+	return &result{status: status, response: schema.NewErrorResponse(id, code, message)}
+}
+
+// writeError writes a JSON-RPC error response with the given HTTP status
+func writeError(w http.ResponseWriter, status int, id schema.ID, code int, message string) {
+	writeJSON(w, status, schema.NewErrorResponse(id, code, message))
+}
+
+// writeJSON writes v as a JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
 }
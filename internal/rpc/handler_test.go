@@ -5,13 +5,20 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/internal/policy"
+	"safectx/pkg/schema"
 )
 
 type testCase struct {
 	name           string
 	requestBody    map[string]interface{}
 	expectedStatus int
+	expectedCode   int
 	expectedBody   map[string]interface{}
 }
 
@@ -27,23 +34,24 @@ func TestNewGatewayHandler(t *testing.T) {
 				{
 					name: "Basic valid request",
 					requestBody: map[string]interface{}{
-						"id":     "123",
-						"method": "test",
+						"jsonrpc": "2.0",
+						"id":      "123",
+						"method":  "test",
 						"params": map[string]interface{}{
 							"prompt": "Hello, world!",
 						},
 					},
 					expectedStatus: http.StatusOK,
 					expectedBody: map[string]interface{}{
-						"status":  "ok",
-						"message": "Request processed by SafeCtx",
+						"id": "123",
 					},
 				},
 				{
 					name: "Request with sensitive data",
 					requestBody: map[string]interface{}{
-						"id":     "456",
-						"method": "test",
+						"jsonrpc": "2.0",
+						"id":      "456",
+						"method":  "test",
 						"params": map[string]interface{}{
 							"prompt":     "Hello",
 							"password":   "secret123",
@@ -53,8 +61,19 @@ func TestNewGatewayHandler(t *testing.T) {
 					},
 					expectedStatus: http.StatusOK,
 					expectedBody: map[string]interface{}{
-						"status":  "ok",
-						"message": "Request processed by SafeCtx",
+						"id": "456",
+					},
+				},
+				{
+					name: "Numeric ID",
+					requestBody: map[string]interface{}{
+						"jsonrpc": "2.0",
+						"id":      7,
+						"method":  "tools/list",
+					},
+					expectedStatus: http.StatusOK,
+					expectedBody: map[string]interface{}{
+						"id": float64(7),
 					},
 				},
 			},
@@ -65,19 +84,44 @@ func TestNewGatewayHandler(t *testing.T) {
 				{
 					name: "Missing required fields",
 					requestBody: map[string]interface{}{
-						"id": "123",
-						// Missing method and params
+						"jsonrpc": "2.0",
+						"id":      "123",
+						// Missing method
 					},
 					expectedStatus: http.StatusBadRequest,
+					expectedCode:   -32600,
 				},
 				{
-					name: "Invalid JSON format",
+					name: "Missing protocol version",
 					requestBody: map[string]interface{}{
 						"id":     "123",
 						"method": "test",
-						"params": "not a map", // Invalid type
+						"params": map[string]interface{}{},
 					},
 					expectedStatus: http.StatusBadRequest,
+					expectedCode:   -32600,
+				},
+				{
+					name: "Invalid JSON format",
+					requestBody: map[string]interface{}{
+						"jsonrpc": "2.0",
+						"id":      "123",
+						"method":  "test",
+						"params":  "not a map", // Invalid type
+					},
+					expectedStatus: http.StatusBadRequest,
+					expectedCode:   -32600,
+				},
+				{
+					name: "Positional params",
+					requestBody: map[string]interface{}{
+						"jsonrpc": "2.0",
+						"id":      "123",
+						"method":  "test",
+						"params":  []interface{}{"a", "b"},
+					},
+					expectedStatus: http.StatusBadRequest,
+					expectedCode:   -32602,
 				},
 			},
 		},
@@ -87,24 +131,28 @@ func TestNewGatewayHandler(t *testing.T) {
 				{
 					name: "SQL injection attempt",
 					requestBody: map[string]interface{}{
-						"id":     "123",
-						"method": "test",
+						"jsonrpc": "2.0",
+						"id":      "123",
+						"method":  "test",
 						"params": map[string]interface{}{
 							"prompt": "DROP TABLE users;",
 						},
 					},
 					expectedStatus: http.StatusForbidden,
+					expectedCode:   -32001,
 				},
 				{
 					name: "System command attempt",
 					requestBody: map[string]interface{}{
-						"id":     "123",
-						"method": "test",
+						"jsonrpc": "2.0",
+						"id":      "123",
+						"method":  "test",
 						"params": map[string]interface{}{
 							"prompt": "system command: shutdown",
 						},
 					},
 					expectedStatus: http.StatusForbidden,
+					expectedCode:   -32001,
 				},
 			},
 		},
@@ -125,7 +173,7 @@ func TestNewGatewayHandler(t *testing.T) {
 					rr := httptest.NewRecorder()
 
 					// Execute
-					handler := NewGatewayHandler()
+					handler := NewGatewayHandler(newEchoUpstream(t))
 					handler.ServeHTTP(rr, req)
 
 					// Assert status code
@@ -134,6 +182,29 @@ func TestNewGatewayHandler(t *testing.T) {
 							status, tc.expectedStatus)
 					}
 
+					// If we expect an error, verify the JSON-RPC error object
+					if tc.expectedCode != 0 {
+						var response struct {
+							JSONRPC string      `json:"jsonrpc"`
+							ID      interface{} `json:"id"`
+							Error   struct {
+								Code int `json:"code"`
+							} `json:"error"`
+						}
+						if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+							t.Fatalf("Failed to unmarshal error response: %v", err)
+						}
+						if response.JSONRPC != "2.0" {
+							t.Errorf("Error response has jsonrpc %q, want 2.0", response.JSONRPC)
+						}
+						if response.Error.Code != tc.expectedCode {
+							t.Errorf("Error response has code %d, want %d", response.Error.Code, tc.expectedCode)
+						}
+						if response.ID != tc.requestBody["id"] {
+							t.Errorf("Error response has id %v, want %v", response.ID, tc.requestBody["id"])
+						}
+					}
+
 					// If we expect a successful response, verify the response body
 					if tc.expectedStatus == http.StatusOK && tc.expectedBody != nil {
 						var response map[string]interface{}
@@ -150,7 +221,7 @@ func TestNewGatewayHandler(t *testing.T) {
 						}
 
 						// Verify sensitive data was redacted
-						if params, ok := response["data"].(map[string]interface{}); ok {
+						if params, ok := response["result"].(map[string]interface{}); ok {
 							for _, sensitiveKey := range []string{"password", "api_key"} {
 								if value, exists := params[sensitiveKey]; exists && value != "[REDACTED]" {
 									t.Errorf("Sensitive data not redacted: %s", sensitiveKey)
@@ -163,3 +234,314 @@ func TestNewGatewayHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestGatewayWithInspector(t *testing.T) {
+	inspector := NewInspector(policy.NewEngineFromConfig(&config.PolicyConfig{
+		DefaultEffect: "allow",
+		Rules:         []config.PolicyRule{{ID: "no-shell", Tools: []string{"shell"}, Effect: "deny"}},
+	}))
+	gateway := NewGatewayHandler(newEchoUpstream(t)).WithInspector(inspector)
+
+	_, resp := call(t, gateway, "/", "", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"shell"}}`)
+	if errObj, _ := resp["error"].(map[string]interface{}); errObj["code"] != float64(schema.CodePolicyDenied) {
+		t.Errorf("Got response %v, want the shared inspector's policy to deny the call", resp)
+	}
+}
+
+func TestGatewayParseError(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", bytes.NewBufferString(`{"jsonrpc":"2.0","id":1,`))
+	rr := httptest.NewRecorder()
+
+	NewGatewayHandler(newEchoUpstream(t)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if id, exists := response["id"]; !exists || id != nil {
+		t.Errorf("Parse error response must have a null id, got %v", id)
+	}
+	if errObj, _ := response["error"].(map[string]interface{}); errObj["code"] != float64(-32700) {
+		t.Errorf("Got error %v, want code -32700", response["error"])
+	}
+}
+
+func TestGatewayBatch(t *testing.T) {
+	var mu sync.Mutex
+	var forwarded []string
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		params, _ := req["params"].(map[string]interface{})
+		prompt, _ := params["prompt"].(string)
+
+		mu.Lock()
+		forwarded = append(forwarded, prompt)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      req["id"],
+			"result":  params,
+		})
+	}))
+	defer upstream.Close()
+
+	batch := `[
+		{"jsonrpc":"2.0","id":1,"method":"test","params":{"prompt":"Hello"}},
+		{"jsonrpc":"2.0","id":"two","method":"test","params":{"prompt":"DROP TABLE users;"}},
+		{"jsonrpc":"2.0","id":3,"method":"test","params":{"prompt":"Hi","password":"secret123"}},
+		{"jsonrpc":"2.0","id":4},
+		42
+	]`
+
+	handler := NewGatewayHandler(newTestProxy(t, upstream.URL, time.Second))
+	req := httptest.NewRequest("POST", "/", bytes.NewBufferString(batch))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var responses []struct {
+		ID     interface{}            `json:"id"`
+		Result map[string]interface{} `json:"result"`
+		Error  *struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("Failed to unmarshal batch response: %v", err)
+	}
+	if len(responses) != 5 {
+		t.Fatalf("Got %d batch responses, want 5", len(responses))
+	}
+
+	wantCodes := []int{0, -32001, 0, -32600, -32600}
+	wantIDs := []interface{}{float64(1), "two", float64(3), float64(4), nil}
+	for i, resp := range responses {
+		code := 0
+		if resp.Error != nil {
+			code = resp.Error.Code
+		}
+		if code != wantCodes[i] {
+			t.Errorf("Element %d: got error code %d, want %d", i, code, wantCodes[i])
+		}
+		if resp.ID != wantIDs[i] {
+			t.Errorf("Element %d: got id %v, want %v", i, resp.ID, wantIDs[i])
+		}
+	}
+
+	if got := responses[2].Result["password"]; got != "[REDACTED]" {
+		t.Errorf("Sensitive data not redacted in batch element: %v", got)
+	}
+
+	// The malicious element must never reach the upstream
+	for _, prompt := range forwarded {
+		if prompt == "DROP TABLE users;" {
+			t.Errorf("Blocked batch element was forwarded upstream")
+		}
+	}
+	if len(forwarded) != 2 {
+		t.Errorf("Got %d forwarded elements, want 2", len(forwarded))
+	}
+}
+
+func TestGatewayBatchLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		batch string
+	}{
+		{
+			name:  "Empty batch",
+			batch: `[]`,
+		},
+		{
+			name: "Batch too large",
+			batch: `[
+				{"jsonrpc":"2.0","id":1,"method":"ping"},
+				{"jsonrpc":"2.0","id":2,"method":"ping"},
+				{"jsonrpc":"2.0","id":3,"method":"ping"}
+			]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewGatewayHandler(newEchoUpstream(t)).WithMaxBatchSize(2)
+			req := httptest.NewRequest("POST", "/", bytes.NewBufferString(tt.batch))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+			}
+
+			var response map[string]interface{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if errObj, _ := response["error"].(map[string]interface{}); errObj["code"] != float64(-32600) {
+				t.Errorf("Got error %v, want code -32600", response["error"])
+			}
+		})
+	}
+}
+
+func TestGatewayNotifications(t *testing.T) {
+	var mu sync.Mutex
+	var forwarded []string
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		forwarded = append(forwarded, req["method"].(string))
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer upstream.Close()
+
+	engine := policy.NewEngineFromConfig(&config.PolicyConfig{
+		Rules: []config.PolicyRule{
+			{ID: "deny-progress", Methods: []string{"notifications/progress"}, Effect: "deny"},
+		},
+	})
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		forwarded      []string
+	}{
+		{
+			name:           "Allowed notification",
+			body:           `{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+			expectedStatus: http.StatusAccepted,
+			forwarded:      []string{"notifications/initialized"},
+		},
+		{
+			name:           "Notification denied by policy",
+			body:           `{"jsonrpc":"2.0","method":"notifications/progress","params":{"progress":1}}`,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Notification with injection",
+			body:           `{"jsonrpc":"2.0","method":"notifications/message","params":{"prompt":"DROP TABLE users"}}`,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "Batch of notifications",
+			body: `[
+				{"jsonrpc":"2.0","method":"notifications/initialized"},
+				{"jsonrpc":"2.0","method":"notifications/progress"}
+			]`,
+			expectedStatus: http.StatusAccepted,
+			forwarded:      []string{"notifications/initialized"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = nil
+
+			handler := NewGatewayHandler(newTestProxy(t, upstream.URL, time.Second)).WithPolicyEngine(engine)
+			req := httptest.NewRequest("POST", "/", bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+			if rr.Code == http.StatusAccepted && rr.Body.Len() != 0 {
+				t.Errorf("Accepted notification must have no body, got %s", rr.Body.String())
+			}
+			if len(forwarded) != len(tt.forwarded) {
+				t.Fatalf("Got forwarded %v, want %v", forwarded, tt.forwarded)
+			}
+			for i := range forwarded {
+				if forwarded[i] != tt.forwarded[i] {
+					t.Errorf("Got forwarded %v, want %v", forwarded, tt.forwarded)
+				}
+			}
+		})
+	}
+}
+
+func TestGatewayBatchWithNotifications(t *testing.T) {
+	batch := `[
+		{"jsonrpc":"2.0","method":"notifications/initialized"},
+		{"jsonrpc":"2.0","id":1,"method":"tools/list"}
+	]`
+
+	handler := NewGatewayHandler(newEchoUpstream(t))
+	req := httptest.NewRequest("POST", "/", bytes.NewBufferString(batch))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var responses []map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("Failed to unmarshal batch response: %v", err)
+	}
+	if len(responses) != 1 || responses[0]["id"] != float64(1) {
+		t.Errorf("Got batch response %v, want only the response to id 1", responses)
+	}
+}
+
+func TestGatewayBatchInvalidUpstreamResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("not json"))
+	}))
+	defer upstream.Close()
+
+	handler := NewGatewayHandler(newTestProxy(t, upstream.URL, time.Second))
+	req := httptest.NewRequest("POST", "/", bytes.NewBufferString(`[{"jsonrpc":"2.0","id":7,"method":"tools/list"}]`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var responses []map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("Failed to unmarshal batch response: %v", err)
+	}
+	// The error keeps the ID of the element, so the client can match it
+	if len(responses) != 1 || responses[0]["id"] != float64(7) || responses[0]["error"] == nil {
+		t.Errorf("Got batch response %v, want an error for id 7", responses)
+	}
+}
+
+// newEchoUpstream starts an upstream MCP server that returns the params it
+// received as the result, and a proxy pointing at it
+func newEchoUpstream(t *testing.T) *Proxy {
+	t.Helper()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      req["id"],
+			"result":  req["params"],
+		})
+	}))
+	t.Cleanup(upstream.Close)
+
+	cfg := config.DefaultGatewayConfig().Upstream
+	cfg.URL = upstream.URL
+	proxy, err := NewProxy(&cfg)
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	return proxy
+}
@@ -0,0 +1,881 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"safectx/internal/approval"
+	"safectx/internal/config"
+	"safectx/internal/contextfilter"
+	"safectx/internal/enforcement"
+	"safectx/internal/handshake"
+	"safectx/internal/pipeline"
+	"safectx/internal/policy"
+	"safectx/internal/toolschema"
+	"safectx/pkg/schema"
+)
+
+// Inspector runs JSON-RPC messages through the request pipeline and the
+// response filter independent of the transport they arrived on
+type Inspector struct {
+	policy    policy.Engine
+	pipeline  *pipeline.Pipeline
+	custom    bool
+	responses *contextfilter.ResponseFilter
+	modes     *enforcement.Modes
+	detail    string
+	handshake *handshake.Guard
+	cache     *ResponseCache
+	approvals *approval.Queue
+	tools     *toolschema.Registry
+}
+
+// NewInspector creates a new inspector running the built-in stages with the
+// given policy engine, and the default response rules
+func NewInspector(engine policy.Engine) *Inspector {
+	i := &Inspector{
+		responses: contextfilter.NewDefaultResponseFilter(),
+		detail:    config.DetailStandard,
+	}
+	return i.WithPolicyEngine(engine)
+}
+
+// WithPolicyEngine sets the policy engine used to filter list results. It
+// also runs in the policy stage unless a pipeline was set with
+// WithPipeline.
+func (i *Inspector) WithPolicyEngine(engine policy.Engine) *Inspector {
+	i.policy = engine
+	if !i.custom {
+		i.pipeline = pipeline.New(
+			pipeline.NewDetectionStage(),
+			pipeline.NewPolicyStage(engine),
+			pipeline.NewRedactionStage(),
+		)
+	}
+	return i
+}
+
+// WithPipeline sets the pipeline requests run through
+func (i *Inspector) WithPipeline(p *pipeline.Pipeline) *Inspector {
+	i.pipeline = p
+	i.custom = true
+	return i
+}
+
+// WithResponseFilter sets the filter applied to upstream responses
+func (i *Inspector) WithResponseFilter(filter *contextfilter.ResponseFilter) *Inspector {
+	i.responses = filter
+	return i
+}
+
+// WithEnforcement sets the modes deciding which verdicts are enforced and
+// which are only monitored. Without modes every verdict is enforced.
+func (i *Inspector) WithEnforcement(modes *enforcement.Modes) *Inspector {
+	i.modes = modes
+	return i
+}
+
+// WithDenialDetail sets how much of a denial is exposed to clients in the
+// error data, one of the config.Detail levels
+func (i *Inspector) WithDenialDetail(detail string) *Inspector {
+	i.detail = detail
+	return i
+}
+
+// WithHandshake applies the server side of the handshake policy to
+// initialize results and records what each session negotiated. The
+// client side is applied by the handshake stage of the pipeline.
+func (i *Inspector) WithHandshake(guard *handshake.Guard) *Inspector {
+	i.handshake = guard
+	return i
+}
+
+// WithCache invalidates the results cached in c when a server message
+// reports a change
+func (i *Inspector) WithCache(c *ResponseCache) *Inspector {
+	i.cache = c
+	return i
+}
+
+// WithApprovals holds the requests a policy rule requires approval for in
+// queue until they are decided
+func (i *Inspector) WithApprovals(queue *approval.Queue) *Inspector {
+	i.approvals = queue
+	return i
+}
+
+// WithToolSchemas records the input schemas of the tools in tools/list
+// results in registry, for the arguments stage
+func (i *Inspector) WithToolSchemas(registry *toolschema.Registry) *Inspector {
+	i.tools = registry
+	return i
+}
+
+// EndSession forgets the negotiated state of an MCP session
+func (i *Inspector) EndSession(session string) {
+	if i.handshake != nil {
+		i.handshake.End(session)
+	}
+}
+
+// Screen runs a request through the pipeline. It returns the JSON-RPC error
+// to report if the request is denied, and nil if it may be forwarded.
+// Mutations, e.g. redaction, are applied to the request in place. A
+// request that requires approval is not held: it is rejected as pending
+// until a retry finds its approval granted.
+func (i *Inspector) Screen(call *pipeline.Call) *schema.Error {
+	result, rpcErr := i.ScreenResult(call)
+	if rpcErr != nil {
+		return rpcErr
+	}
+	return i.hold(context.Background(), call, result, false)
+}
+
+// ScreenResult is like Screen and also returns the pipeline result with
+// the verdicts of the stages
+func (i *Inspector) ScreenResult(call *pipeline.Call) (*pipeline.Result, *schema.Error) {
+	result := i.pipeline.Run(call, i.modes)
+	return result, i.denialError(call, result)
+}
+
+// Hold waits until the request of a call screened into result is approved
+// if a policy rule requires approval for it. It returns the JSON-RPC error
+// to report if it is denied, times out or ctx is done first, and nil if it
+// may be forwarded.
+func (i *Inspector) Hold(ctx context.Context, call *pipeline.Call, result *pipeline.Result) *schema.Error {
+	return i.hold(ctx, call, result, true)
+}
+
+// hold files the approval of a call that requires one and reports whether
+// it may be forwarded. With wait it blocks until the approval is decided;
+// otherwise a pending approval is reported to the client.
+func (i *Inspector) hold(ctx context.Context, call *pipeline.Call, result *pipeline.Result, wait bool) *schema.Error {
+	verdict := result.Approval
+	if verdict == nil {
+		return nil
+	}
+	req := call.Request
+	if i.approvals == nil || call.FromServer || req.IsNotification() {
+		// Without a queue, or a response to carry the decision, the
+		// request cannot be held
+		log.Printf("Request %s (%s) requires approval, which it cannot wait for", req.ID, req.Method)
+		return i.approvalError(call, verdict, nil, schema.CodeApprovalDenied, "Approval required")
+	}
+
+	held := &approval.Approval{
+		Method:    req.Method,
+		Params:    req.Params,
+		RuleID:    verdict.RuleID,
+		Reason:    verdict.Reason,
+		Session:   call.Session,
+		RequestID: call.CorrelationID,
+	}
+	if params, ok := req.ToolCall(); ok {
+		held.Tool = params.Name
+	}
+	if call.Subject != nil {
+		held.Requester = call.Subject.ID
+	}
+	a, err := i.approvals.Request(ctx, held)
+	if err == nil && wait && a.Status == approval.Pending {
+		log.Printf("Request %s (%s) held for approval %s", req.ID, req.Method, a.ID)
+		a, err = i.approvals.Wait(ctx, a.ID)
+	}
+	if err == nil && a.Status == approval.Approved {
+		_, err = i.approvals.Consume(ctx, a.ID)
+	}
+	switch {
+	case ctx.Err() != nil:
+		return &schema.Error{Code: schema.CodeRequestCancelled, Message: "Request cancelled"}
+	case errors.Is(err, approval.ErrNotApproved):
+		return i.approvalError(call, verdict, a, schema.CodeApprovalDenied, "Approval already used")
+	case err != nil:
+		log.Printf("Approval queue unavailable for request %s (%s): %v", req.ID, req.Method, err)
+		return i.approvalError(call, verdict, nil, schema.CodeApprovalDenied, "Approval unavailable")
+	}
+
+	switch a.Status {
+	case approval.Approved:
+		log.Printf("Request %s (%s) approved by %s (approval %s)", req.ID, req.Method, a.DecidedBy, a.ID)
+		return nil
+	case approval.Denied:
+		return i.approvalError(call, verdict, a, schema.CodeApprovalDenied, "Approval denied")
+	case approval.Expired:
+		return i.approvalError(call, verdict, a, schema.CodeApprovalTimeout, "Approval timed out")
+	default:
+		return i.approvalError(call, verdict, a, schema.CodeApprovalPending, "Approval pending")
+	}
+}
+
+// approvalError returns the JSON-RPC error for a held request that may not
+// be forwarded. The approval, if any, is identified in the error data and
+// the approver's comment given as the reason.
+func (i *Inspector) approvalError(call *pipeline.Call, verdict *pipeline.Verdict, a *approval.Approval, code int, message string) *schema.Error {
+	rpcErr := &schema.Error{Code: code, Message: message}
+	denial := schema.Denial{
+		Stage:     verdict.Stage,
+		Rule:      verdict.RuleID,
+		Severity:  verdict.Severity,
+		Path:      verdict.Path,
+		Reason:    verdict.Reason,
+		RequestID: call.CorrelationID,
+	}
+	if a != nil {
+		denial.ApprovalID = a.ID
+		if a.Comment != "" {
+			denial.Reason = a.Comment
+		}
+	}
+	if data := i.denialData(denial); data != nil {
+		rpcErr.Data = data
+	}
+	return rpcErr
+}
+
+// Explain runs a request through the pipeline like Screen without logging
+// or counting the verdicts. It returns the traced result and the error
+// Screen would report.
+func (i *Inspector) Explain(call *pipeline.Call) (*pipeline.Result, *schema.Error) {
+	result := i.pipeline.Explain(call, i.modes)
+	return result, i.denialError(call, result)
+}
+
+// Stages returns the names of the stages that run for a call
+func (i *Inspector) Stages(call *pipeline.Call) []string {
+	return i.pipeline.Stages(call)
+}
+
+// Policy returns the policy engine used by the inspector
+func (i *Inspector) Policy() policy.Engine {
+	return i.policy
+}
+
+// denialError returns the JSON-RPC error reporting the denial of a
+// pipeline result, or nil if the request was not denied
+func (i *Inspector) denialError(call *pipeline.Call, result *pipeline.Result) *schema.Error {
+	if result.Denial == nil {
+		return nil
+	}
+
+	denial := result.Denial
+	code, message := denial.Code, denial.Message
+	if code == 0 {
+		code = schema.CodePolicyDenied
+	}
+	if message == "" {
+		message = "Request denied"
+	}
+	rpcErr := &schema.Error{Code: code, Message: message}
+	if data := i.denialData(schema.Denial{
+		Stage:     denial.Stage,
+		Rule:      denial.RuleID,
+		Severity:  denial.Severity,
+		Path:      denial.Path,
+		Reason:    denial.Reason,
+		RequestID: call.CorrelationID,
+	}); data != nil {
+		rpcErr.Data = data
+	}
+	return rpcErr
+}
+
+// denialData reduces a denial to the fields the configured detail exposes
+// to clients. It returns nil if no field is left.
+func (i *Inspector) denialData(d schema.Denial) *schema.Denial {
+	switch i.detail {
+	case config.DetailMinimal:
+		d = schema.Denial{RequestID: d.RequestID, ApprovalID: d.ApprovalID}
+	case config.DetailFull:
+	default:
+		d.Path, d.Reason = "", ""
+	}
+	if d == (schema.Denial{}) {
+		return nil
+	}
+	return &d
+}
+
+// NewCorrelationID creates a random ID correlating a request with the
+// gateway logs
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AuthorizeTool applies the policy decision enforced on tools/call to a
+// call of the named tool by subject, without running the other stages. It
+// returns the error to report if the call is denied, with correlationID
+// identifying the denial. tools resolves the name to the upstream's own
+// name, which the policy is also applied to, like it is on tools/call.
+// Denials of monitored policy rules pass, and so do
+// tools that require approval, like in tools/list results: a call of such a
+// tool is held until it is decided, and hiding it would keep it from being
+// called even once approved.
+func (i *Inspector) AuthorizeTool(subject *policy.Subject, name string, tools ToolResolver, correlationID string) *schema.Error {
+	call := &pipeline.Call{
+		Request:       toolCall(name),
+		Subject:       subject,
+		CorrelationID: correlationID,
+	}
+	_, call.Tool = tools.resolve(name)
+	verdict := pipeline.NewPolicyStage(i.policy).Inspect(call)
+	if verdict.Outcome != pipeline.Deny || i.modes.Monitor(pipeline.StagePolicy, verdict.RuleID) {
+		return nil
+	}
+	verdict.Stage = pipeline.StagePolicy
+	return i.denialError(call, &pipeline.Result{Denial: &verdict})
+}
+
+// InspectContent runs text from upstream content that is not carried in a
+// JSON-RPC result, e.g. a tool result in a chat message, through the
+// response filter. req is the request the content answers and path the
+// JSON pointer of the text, reported if it is blocked. It returns the text
+// to relay, or the error to report instead.
+func (i *Inspector) InspectContent(req *schema.MCPRequest, path, text string) (string, *schema.Error) {
+	result, _ := json.Marshal(map[string]interface{}{
+		"content": []interface{}{map[string]interface{}{"type": "text", "text": text}},
+	})
+	out, verdict, err := i.filterResult(result)
+	if err != nil {
+		log.Printf("Blocked unreadable content at %s: %v", path, err)
+	}
+	for _, finding := range verdict.Findings {
+		i.modes.Record(config.ResponseStage, finding.RuleID, finding.Action)
+	}
+	if len(verdict.Findings) > 0 {
+		log.Printf("Request %s (%s): content at %s %s", req.ID, req.Method, path, verdict)
+	}
+
+	if verdict.Blocked() {
+		rpcErr := &schema.Error{Code: schema.CodeResponseBlocked, Message: "Upstream response blocked"}
+		if data := i.denialData(schema.Denial{
+			Stage:    config.ResponseStage,
+			Rule:     verdict.BlockedBy,
+			Severity: pipeline.SeverityHigh,
+			Path:     path,
+		}); data != nil {
+			rpcErr.Data = data
+		}
+		return "", rpcErr
+	}
+	if !verdict.Changed() {
+		return text, nil
+	}
+
+	var filtered struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(out, &filtered); err != nil || len(filtered.Content) != 1 {
+		return text, nil
+	}
+	return filtered.Content[0].Text, nil
+}
+
+// toolCall returns a tools/call request of the named tool
+func toolCall(name string) *schema.MCPRequest {
+	return &schema.MCPRequest{
+		JSONRPC: schema.Version,
+		Method:  schema.MethodToolsCall,
+		Params:  map[string]interface{}{"name": name},
+	}
+}
+
+// listAllowed reports whether subject may invoke the tool, prompt or
+// resource addressed by req, which the upstream knows as tool if it is a
+// renamed tool. Denials of monitored policy rules allow.
+func (i *Inspector) listAllowed(subject *policy.Subject, req *schema.MCPRequest, tool string) bool {
+	verdict := pipeline.NewPolicyStage(i.policy).Inspect(&pipeline.Call{Request: req, Subject: subject, Tool: tool})
+	return verdict.Outcome != pipeline.Deny || i.modes.Monitor(pipeline.StagePolicy, verdict.RuleID)
+}
+
+// InspectServerMessage runs a server-sent JSON-RPC message through the
+// inspection pipeline. Server requests and notifications get the same
+// detection, policy and redaction as client requests; responses go through
+// the response filter. subject is the client the message is sent to,
+// session the MCP session it belongs to, upstream the name of the upstream
+// that sent it, and req the client request the message was sent in reply
+// to, if known. tools resolves the tools in list results to the upstream's
+// own names. It returns false if the message must not reach the client.
+// A blocked server request also returns reply, the error response the
+// transport should send back to the upstream in place of the client.
+func (i *Inspector) InspectServerMessage(subject *policy.Subject, session, upstream string, data []byte, req *schema.MCPRequest, tools ToolResolver) (out, reply []byte, ok bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var elements []json.RawMessage
+		if err := json.Unmarshal(data, &elements); err != nil {
+			log.Printf("Dropped malformed server batch: %v", err)
+			return nil, nil, false
+		}
+
+		kept := make([]json.RawMessage, 0, len(elements))
+		var replies []json.RawMessage
+		for _, element := range elements {
+			out, reply, ok := i.InspectServerMessage(subject, session, upstream, element, req, tools)
+			if ok {
+				kept = append(kept, out)
+			}
+			if reply != nil {
+				replies = append(replies, reply)
+			}
+		}
+		if len(replies) > 0 {
+			reply, _ = json.Marshal(replies)
+		}
+		if len(kept) == 0 {
+			return nil, reply, false
+		}
+		out, _ = json.Marshal(kept)
+		return out, reply, true
+	}
+
+	var probe struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		log.Printf("Dropped malformed server message: %v", err)
+		return nil, nil, false
+	}
+	if probe.Method == "" {
+		out, _ := i.InspectResponse(subject, session, data, req, tools)
+		return out, nil, true
+	}
+
+	msg, err := schema.ParseRequest(data)
+	if err != nil {
+		log.Printf("Dropped invalid server message: %v", err)
+		return nil, nil, false
+	}
+	// The upstream changed even if the client may not learn about it
+	i.cache.Invalidate(msg)
+	if msg.Method == "notifications/tools/list_changed" {
+		i.tools.Forget(upstream)
+	}
+	call := &pipeline.Call{Request: msg, Subject: subject, CorrelationID: NewCorrelationID(), Session: session, FromServer: true, Upstream: upstream}
+	if rpcErr := i.Screen(call); rpcErr != nil {
+		log.Printf("Blocked server message %s (%s) from %s", msg.Method, msg.ID, upstream)
+		if !msg.IsNotification() {
+			// The server would otherwise wait for an answer that never comes
+			reply, _ = json.Marshal(&schema.MCPResponse{JSONRPC: schema.Version, ID: msg.ID, Error: rpcErr})
+		}
+		return nil, reply, false
+	}
+
+	out, err = json.Marshal(msg)
+	if err != nil {
+		return nil, nil, false
+	}
+	return out, nil, true
+}
+
+// InspectClientResponse inspects the response a client sends to a request
+// of the server, e.g. the user input of an elicitation or the completion of
+// a sampling request. Sensitive keys in its result are redacted, and the
+// result runs through the response filter, which blocks injections and
+// redacts secrets. A blocked response is replaced by an error response with
+// the same ID, so the server does not wait for it. Error responses are
+// returned as is. It returns false if the message is malformed.
+func (i *Inspector) InspectClientResponse(data []byte) ([]byte, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var elements []json.RawMessage
+		if err := json.Unmarshal(data, &elements); err != nil {
+			log.Printf("Dropped malformed client batch: %v", err)
+			return nil, false
+		}
+		kept := make([]json.RawMessage, 0, len(elements))
+		for _, element := range elements {
+			if out, ok := i.InspectClientResponse(element); ok {
+				kept = append(kept, out)
+			}
+		}
+		if len(kept) == 0 {
+			return nil, false
+		}
+		out, _ := json.Marshal(kept)
+		return out, true
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		log.Printf("Dropped malformed client response: %v", err)
+		return nil, false
+	}
+	if fields["result"] == nil {
+		return data, true
+	}
+	var id schema.ID
+	json.Unmarshal(fields["id"], &id)
+
+	dec := json.NewDecoder(bytes.NewReader(fields["result"]))
+	dec.UseNumber()
+	var result interface{}
+	if err := dec.Decode(&result); err != nil {
+		log.Printf("Dropped malformed client response %s: %v", id, err)
+		return nil, false
+	}
+	if paths := contextfilter.SensitiveValuePaths(result); len(paths) > 0 {
+		monitored := i.modes.Record(pipeline.StageRedaction, contextfilter.SensitiveKeysRuleID, string(pipeline.Mutate))
+		if !monitored {
+			contextfilter.RedactValue(result)
+		}
+		log.Printf("Client response %s: sensitive values at %s (monitored: %v)", id, strings.Join(paths, ", "), monitored)
+	}
+	redacted, err := json.Marshal(result)
+	if err != nil {
+		return nil, false
+	}
+
+	filtered, verdict, err := i.filterResult(redacted)
+	if err != nil {
+		log.Printf("Blocked unreadable client response %s: %v", id, err)
+	}
+	for _, finding := range verdict.Findings {
+		i.modes.Record(config.ResponseStage, finding.RuleID, finding.Action)
+	}
+	if len(verdict.Findings) > 0 {
+		log.Printf("Client response %s: %s", id, verdict)
+	}
+
+	if verdict.Blocked() {
+		resp := schema.NewErrorResponse(id, schema.CodeResponseBlocked, "Client response blocked")
+		if data := i.denialData(schema.Denial{
+			Stage:    config.ResponseStage,
+			Rule:     verdict.BlockedBy,
+			Severity: pipeline.SeverityHigh,
+			Path:     blockedPath(verdict),
+		}); data != nil {
+			resp.Error.Data = data
+		}
+		out, _ := json.Marshal(resp)
+		return out, true
+	}
+	if verdict.Changed() {
+		redacted = filtered
+	}
+	fields["result"] = redacted
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// InspectResponse runs the result of a JSON-RPC response through the
+// response filter and logs the outcome alongside the verdict of req, the
+// request it answers, if known. List results are reduced to the items
+// subject may use, checking tools under the upstream's own names as
+// resolved by tools too, and initialize results to what the handshake policy
+// allows for session. Tool schemas are learned from tools/list results.
+// A blocked response is replaced by an error response with the same ID.
+// Messages without a result and empty bodies are returned as is; anything
+// else that is not a JSON object is blocked, whatever its Content-Type.
+func (i *Inspector) InspectResponse(subject *policy.Subject, session string, data []byte, req *schema.MCPRequest, tools ToolResolver) ([]byte, *contextfilter.ResponseVerdict) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return data, &contextfilter.ResponseVerdict{}
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		log.Printf("Blocked unreadable upstream response: %v", err)
+		verdict := &contextfilter.ResponseVerdict{BlockedBy: "invalid-response"}
+		verdict.Findings = append(verdict.Findings, contextfilter.Finding{RuleID: verdict.BlockedBy, Action: contextfilter.ActionBlock})
+		i.modes.Record(config.ResponseStage, verdict.BlockedBy, contextfilter.ActionBlock)
+		out, _ := json.Marshal(schema.NewErrorResponse(requestID(req), schema.CodeResponseBlocked, "Upstream response blocked"))
+		return out, verdict
+	}
+	if fields["result"] == nil {
+		return data, &contextfilter.ResponseVerdict{}
+	}
+
+	var id schema.ID
+	json.Unmarshal(fields["id"], &id)
+
+	if i.handshake != nil && req != nil && req.Method == schema.MethodInitialize {
+		result, rpcErr := i.negotiate(session, req, fields["result"])
+		if rpcErr != nil {
+			out, _ := json.Marshal(&schema.MCPResponse{JSONRPC: schema.Version, ID: id, Error: rpcErr})
+			return out, &contextfilter.ResponseVerdict{BlockedBy: pipeline.ProtocolVersionRuleID}
+		}
+		if !bytes.Equal(result, fields["result"]) {
+			fields["result"] = result
+			data, _ = json.Marshal(fields)
+		}
+	}
+
+	// Schemas are learned from the full list, before it is reduced to
+	// what subject may use
+	if req != nil && req.Method == "tools/list" {
+		i.learnTools(fields["result"], tools)
+	}
+
+	listed, filtered := i.filterList(subject, req, fields["result"], tools)
+	if filtered {
+		fields["result"] = listed
+		data, _ = json.Marshal(fields)
+	}
+
+	result, verdict, err := i.filterResult(fields["result"])
+	if err != nil {
+		log.Printf("Blocked unreadable result for response %s: %v", id, err)
+	}
+	for _, finding := range verdict.Findings {
+		i.modes.Record(config.ResponseStage, finding.RuleID, finding.Action)
+	}
+	logResponseVerdict(req, id, verdict)
+
+	if verdict.Blocked() {
+		resp := schema.NewErrorResponse(id, schema.CodeResponseBlocked, "Upstream response blocked")
+		if data := i.denialData(schema.Denial{
+			Stage:    config.ResponseStage,
+			Rule:     verdict.BlockedBy,
+			Severity: pipeline.SeverityHigh,
+			Path:     blockedPath(verdict),
+		}); data != nil {
+			resp.Error.Data = data
+		}
+		out, _ := json.Marshal(resp)
+		return out, verdict
+	}
+	if !verdict.Changed() {
+		return data, verdict
+	}
+
+	fields["result"] = result
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return data, verdict
+	}
+	return out, verdict
+}
+
+// negotiate applies the handshake policy to the result of an initialize
+// request and records what the session negotiated. It returns the result
+// with the denied server capabilities stripped, or the error replacing it
+// if the server chose a protocol version that is not allowed.
+func (i *Inspector) negotiate(session string, req *schema.MCPRequest, result json.RawMessage) (json.RawMessage, *schema.Error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(result, &fields); err != nil {
+		return result, nil
+	}
+	version, _ := fields["protocolVersion"].(string)
+	caps, _ := fields["capabilities"].(map[string]interface{})
+
+	if !i.handshake.VersionAllowed(version) {
+		monitored := i.modes.Record(pipeline.StageHandshake, pipeline.ProtocolVersionRuleID, string(pipeline.Deny))
+		log.Printf("Request %s (%s): server chose protocol version %q, which is not allowed (monitored: %v)", req.ID, req.Method, version, monitored)
+		if !monitored {
+			rpcErr := &schema.Error{Code: schema.CodeResponseBlocked, Message: "Unsupported protocol version"}
+			if data := i.denialData(schema.Denial{
+				Stage:    pipeline.StageHandshake,
+				Rule:     pipeline.ProtocolVersionRuleID,
+				Severity: pipeline.SeverityMedium,
+				Path:     "/protocolVersion",
+				Reason:   fmt.Sprintf("protocol version %s is not allowed", version),
+			}); data != nil {
+				rpcErr.Data = data
+			}
+			return nil, rpcErr
+		}
+	}
+
+	stripped, names := i.handshake.Strip(caps)
+	if len(names) > 0 {
+		monitored := i.modes.Record(pipeline.StageHandshake, pipeline.StripCapabilitiesRuleID, string(pipeline.Mutate))
+		log.Printf("Request %s (%s): stripped server capabilities %s (monitored: %v)", req.ID, req.Method, strings.Join(names, ", "), monitored)
+		if !monitored {
+			fields["capabilities"] = map[string]interface{}(stripped)
+			if out, err := json.Marshal(fields); err == nil {
+				result = out
+			}
+			caps = stripped
+		}
+	}
+
+	params, _ := req.Initialize()
+	i.handshake.Record(session, &handshake.Session{
+		ProtocolVersion: version,
+		Client:          params.Capabilities,
+		Server:          caps,
+	})
+	return result, nil
+}
+
+// ExplainResponse runs the result of a JSON-RPC response through the
+// response filter like InspectResponse, without logging or counting the
+// verdict. Messages without a result pass.
+func (i *Inspector) ExplainResponse(data []byte) *contextfilter.ResponseVerdict {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || fields["result"] == nil {
+		return &contextfilter.ResponseVerdict{}
+	}
+	_, verdict, _ := i.filterResult(fields["result"])
+	return verdict
+}
+
+// filterResult runs a result through the response filter. Results that
+// cannot be read are blocked.
+func (i *Inspector) filterResult(result json.RawMessage) (json.RawMessage, *contextfilter.ResponseVerdict, error) {
+	monitored := func(rule string) bool { return i.modes.Monitor(config.ResponseStage, rule) }
+	out, verdict, err := i.responses.FilterResultMonitored(result, monitored)
+	if err != nil {
+		verdict.BlockedBy = "invalid-result"
+		verdict.Findings = append(verdict.Findings, contextfilter.Finding{RuleID: verdict.BlockedBy, Action: contextfilter.ActionBlock})
+	}
+	return out, verdict, err
+}
+
+// blockedPath returns the path of the finding that blocked a response
+func blockedPath(verdict *contextfilter.ResponseVerdict) string {
+	for _, finding := range verdict.Findings {
+		if finding.RuleID == verdict.BlockedBy && !finding.Monitored {
+			return finding.Path
+		}
+	}
+	return ""
+}
+
+// listTargets maps the list methods filtered by policy to the result field
+// holding the items, the item field naming them and the method used to
+// invoke them
+var listTargets = map[string]struct{ field, key, method string }{
+	"tools/list":     {"tools", "name", schema.MethodToolsCall},
+	"prompts/list":   {"prompts", "name", schema.MethodPromptsGet},
+	"resources/list": {"resources", "uri", schema.MethodResourcesRead},
+}
+
+// learnTools hands the tools of a tools/list result to the schema registry,
+// grouped by the upstream tools resolves each to and under the upstream's
+// own names. Each upstream in the list has its learned schemas replaced,
+// and so does the only upstream if tools is nil, even if it lists none.
+func (i *Inspector) learnTools(result json.RawMessage, tools ToolResolver) {
+	var list struct {
+		Tools []toolschema.Tool `json:"tools"`
+	}
+	if i.tools == nil || json.Unmarshal(result, &list) != nil {
+		return
+	}
+	byUpstream := make(map[string][]toolschema.Tool)
+	if tools == nil {
+		byUpstream[DefaultUpstreamName] = nil
+	}
+	for _, tool := range list.Tools {
+		upstream, name := tools.resolve(tool.Name)
+		if upstream == "" {
+			continue
+		}
+		if name != "" {
+			tool.Name = name
+		}
+		byUpstream[upstream] = append(byUpstream[upstream], tool)
+	}
+	for upstream, listed := range byUpstream {
+		i.tools.Learn(upstream, listed)
+	}
+}
+
+// filterList removes the items of a tools/list, prompts/list or
+// resources/list result that subject is not allowed to invoke, using the
+// same policy decision that is enforced when the item is called, with tools
+// resolving the upstream's own tool names. It returns false if the result
+// was left unchanged.
+func (i *Inspector) filterList(subject *policy.Subject, req *schema.MCPRequest, result json.RawMessage, tools ToolResolver) (json.RawMessage, bool) {
+	if req == nil {
+		return result, false
+	}
+	target, ok := listTargets[req.Method]
+	if !ok {
+		return result, false
+	}
+
+	var fields map[string]json.RawMessage
+	var items []map[string]json.RawMessage
+	if json.Unmarshal(result, &fields) != nil || json.Unmarshal(fields[target.field], &items) != nil {
+		return result, false
+	}
+
+	kept := make([]map[string]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		var key string
+		json.Unmarshal(item[target.key], &key)
+		call := &schema.MCPRequest{
+			JSONRPC: schema.Version,
+			Method:  target.method,
+			Params:  map[string]interface{}{target.key: key},
+		}
+		tool := ""
+		if target.method == schema.MethodToolsCall {
+			_, tool = tools.resolve(key)
+		}
+		if i.listAllowed(subject, call, tool) {
+			kept = append(kept, item)
+		}
+	}
+	if len(kept) == len(items) {
+		return result, false
+	}
+
+	log.Printf("Removed %d of %d %s from %s (%s) for %s", len(items)-len(kept), len(items), target.field, req.Method, req.ID, subjectName(subject))
+	fields[target.field], _ = json.Marshal(kept)
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return result, false
+	}
+	return out, true
+}
+
+// subjectName identifies a subject in logs
+func subjectName(subject *policy.Subject) string {
+	if subject == nil || subject.ID == "" {
+		return "anonymous caller"
+	}
+	return "user " + subject.ID
+}
+
+// logResponseVerdict logs the response verdict together with the request
+// it answers. Responses to unknown requests are only logged when a rule
+// matched.
+func logResponseVerdict(req *schema.MCPRequest, id schema.ID, verdict *contextfilter.ResponseVerdict) {
+	if req != nil {
+		log.Printf("Request %s (%s): request allowed, response %s", req.ID, req.Method, verdict)
+		return
+	}
+	if len(verdict.Findings) > 0 {
+		log.Printf("Response %s: %s", id, verdict)
+	}
+}
+
+// StatusForCode returns the HTTP status used for a gateway error code
+func StatusForCode(code int) int {
+	switch code {
+	case schema.CodeParseError, schema.CodeInvalidRequest, schema.CodeInvalidParams:
+		return http.StatusBadRequest
+	case schema.CodeMethodNotFound:
+		return http.StatusNotFound
+	case schema.CodeInjectionDetected, schema.CodePolicyDenied, schema.CodeApprovalDenied:
+		return http.StatusForbidden
+	case schema.CodeApprovalTimeout:
+		return http.StatusRequestTimeout
+	case schema.CodeApprovalPending:
+		return http.StatusPreconditionRequired
+	case schema.CodeRequestCancelled:
+		return statusClientClosedRequest
+	case schema.CodeReplayDetected:
+		return http.StatusConflict
+	case schema.CodeUpstreamTimeout:
+		return http.StatusGatewayTimeout
+	case schema.CodeUpstreamError, schema.CodeResponseBlocked:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
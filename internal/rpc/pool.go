@@ -0,0 +1,499 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/pkg/schema"
+)
+
+// sessionIdleTimeout is how long a session is pinned to its endpoint after
+// its last request when the client never terminates it
+const sessionIdleTimeout = time.Hour
+
+// Pool forwards requests to a set of endpoints serving the same MCP server.
+// Endpoints that fail their health checks or whose circuit breaker is open
+// are skipped, and idempotent requests are retried on another endpoint.
+// Requests of an MCP session always go to the endpoint that created it.
+type Pool struct {
+	name      string
+	endpoints []*endpoint
+	balancing string
+	check     config.HealthCheckConfig
+	attempts  int
+	retryable map[string]bool
+	next      atomic.Uint64
+
+	mu         sync.Mutex
+	sessions   map[string]*pinnedSession
+	lastPruned time.Time
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// endpoint is a single server of a Pool
+type endpoint struct {
+	url      string
+	proxy    *Proxy
+	breaker  *CircuitBreaker
+	inFlight atomic.Int64
+
+	mu        sync.Mutex
+	healthy   bool
+	passes    int
+	failures  int
+	lastCheck time.Time
+	lastError string
+}
+
+// pinnedSession records the endpoint that owns an MCP session
+type pinnedSession struct {
+	endpoint *endpoint
+	lastUsed time.Time
+}
+
+// EndpointStatus is the health of a pool endpoint as reported by the admin
+// endpoint
+type EndpointStatus struct {
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	Circuit   string    `json:"circuit"`
+	InFlight  int64     `json:"inFlight"`
+	LastCheck time.Time `json:"lastCheck,omitzero"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// PoolStatus is the health of a pool as reported by the admin endpoint
+type PoolStatus struct {
+	Name string `json:"name"`
+	// Available is true while at least one endpoint can take requests
+	Available bool             `json:"available"`
+	Endpoints []EndpointStatus `json:"endpoints"`
+}
+
+// NewPool creates a pool over the URL and Endpoints of the upstream
+// configuration. Call Start to run the health checks.
+func NewPool(name string, cfg *config.UpstreamConfig) (*Pool, error) {
+	urls := cfg.Endpoints
+	if cfg.URL != "" {
+		urls = append([]string{cfg.URL}, urls...)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("upstream %s has no endpoints", name)
+	}
+
+	p := &Pool{
+		name:      name,
+		balancing: cfg.Balancing,
+		check:     cfg.HealthCheck,
+		attempts:  max(cfg.Retry.MaxAttempts, 1),
+		retryable: make(map[string]bool),
+		sessions:  make(map[string]*pinnedSession),
+		stop:      make(chan struct{}),
+	}
+	for _, method := range cfg.Retry.Methods {
+		p.retryable[method] = true
+	}
+
+	for _, rawURL := range urls {
+		endpointCfg := *cfg
+		endpointCfg.URL = rawURL
+		proxy, err := NewProxy(&endpointCfg)
+		if err != nil {
+			return nil, err
+		}
+		p.endpoints = append(p.endpoints, &endpoint{
+			url:     proxy.target.Redacted(),
+			proxy:   proxy,
+			breaker: NewCircuitBreaker(cfg.CircuitBreaker.FailureThreshold, cfg.CircuitBreaker.OpenDuration),
+			healthy: true,
+		})
+	}
+	return p, nil
+}
+
+// Name returns the name of the upstream served by the pool
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// Forward implements the Forwarder interface
+func (p *Pool) Forward(r *http.Request, req *schema.MCPRequest) (*UpstreamResponse, error) {
+	resp, err := p.send(r, req.Method, p.retryable[req.Method], func(e *endpoint) (*UpstreamResponse, error) {
+		return e.proxy.Forward(r, req)
+	})
+	if err == nil {
+		resp.Upstream = p.name
+	}
+	return resp, err
+}
+
+// Resolve implements the Resolver interface
+func (p *Pool) Resolve(r *http.Request, req *schema.MCPRequest) (*RouteTarget, error) {
+	return &RouteTarget{Upstreams: []string{p.name}}, nil
+}
+
+// ForwardResponse implements the ResponseForwarder interface. Responses
+// are not retried, since the request they answer lives on one endpoint.
+func (p *Pool) ForwardResponse(r *http.Request, upstream string, data []byte) (*UpstreamResponse, error) {
+	resp, err := p.send(r, "response", false, func(e *endpoint) (*UpstreamResponse, error) {
+		return e.proxy.ForwardResponse(r, p.name, data)
+	})
+	if err == nil {
+		resp.Upstream = p.name
+	}
+	return resp, err
+}
+
+// ForwardSession implements the SessionForwarder interface
+func (p *Pool) ForwardSession(r *http.Request) (*UpstreamResponse, error) {
+	resp, err := p.send(r, r.Method, false, func(e *endpoint) (*UpstreamResponse, error) {
+		return e.proxy.ForwardSession(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.Upstream = p.name
+	if r.Method == http.MethodDelete {
+		p.mu.Lock()
+		delete(p.sessions, r.Header.Get("Mcp-Session-Id"))
+		p.mu.Unlock()
+	}
+	return resp, nil
+}
+
+// send delivers a request to an endpoint, moving on to the next endpoint
+// after a failure if retry is set
+func (p *Pool) send(r *http.Request, method string, retry bool, do func(*endpoint) (*UpstreamResponse, error)) (*UpstreamResponse, error) {
+	if e := p.pinned(r.Header.Get("Mcp-Session-Id")); e != nil {
+		// Sessions only exist on the endpoint that created them
+		if !e.isHealthy() || !e.breaker.Allow() {
+			return nil, fmt.Errorf("%w: session endpoint %s of %s is down", ErrUpstreamUnavailable, e.url, p.name)
+		}
+		return p.attempt(r, e, do)
+	}
+
+	attempts := 1
+	if retry {
+		attempts = p.attempts
+	}
+
+	var resp *UpstreamResponse
+	err := fmt.Errorf("%w: no available endpoint for %s", ErrUpstreamUnavailable, p.name)
+	tried := make(map[*endpoint]bool)
+	for i := 0; i < attempts; i++ {
+		e := p.pick(tried)
+		if e == nil {
+			break
+		}
+		if i > 0 {
+			log.Printf("Retrying %s on %s endpoint %s after: %s", method, p.name, e.url, describeFailure(resp, err))
+			if resp != nil {
+				resp.Body.Close()
+			}
+		}
+		tried[e] = true
+
+		resp, err = p.attempt(r, e, do)
+		if !failed(resp, err) || r.Context().Err() != nil {
+			break
+		}
+	}
+	return resp, err
+}
+
+// attempt sends a request to a single endpoint and records the outcome
+func (p *Pool) attempt(r *http.Request, e *endpoint, do func(*endpoint) (*UpstreamResponse, error)) (*UpstreamResponse, error) {
+	e.inFlight.Add(1)
+	resp, err := do(e)
+
+	switch {
+	case err != nil && r.Context().Err() != nil:
+		// The client went away, which says nothing about the endpoint
+		e.breaker.Release()
+	case failed(resp, err):
+		e.breaker.Failure()
+	default:
+		e.breaker.Success()
+	}
+
+	if err != nil {
+		e.inFlight.Add(-1)
+		return nil, err
+	}
+
+	if session := resp.Header.Get("Mcp-Session-Id"); session != "" {
+		p.pin(session, e)
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { e.inFlight.Add(-1) }}
+	return resp, nil
+}
+
+// pick returns the next available endpoint that was not tried yet, or nil
+// if there is none
+func (p *Pool) pick(tried map[*endpoint]bool) *endpoint {
+	start := int(p.next.Add(1) - 1)
+	candidates := make([]*endpoint, 0, len(p.endpoints))
+	for i := range p.endpoints {
+		e := p.endpoints[(start+i)%len(p.endpoints)]
+		if !tried[e] && e.isHealthy() && e.breaker.Ready() {
+			candidates = append(candidates, e)
+		}
+	}
+
+	if p.balancing == "least-in-flight" {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].inFlight.Load() < candidates[j].inFlight.Load()
+		})
+	}
+
+	for _, e := range candidates {
+		if e.breaker.Allow() {
+			return e
+		}
+	}
+	return nil
+}
+
+// pinned returns the endpoint owning a session, or nil if the session is
+// unknown
+func (p *Pool) pinned(session string) *endpoint {
+	if session == "" {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pin, ok := p.sessions[session]
+	if !ok {
+		return nil
+	}
+	pin.lastUsed = time.Now()
+	return pin.endpoint
+}
+
+// pin records the endpoint owning a session and forgets idle sessions
+func (p *Pool) pin(session string, e *endpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.sessions[session] = &pinnedSession{endpoint: e, lastUsed: now}
+
+	if now.Sub(p.lastPruned) < time.Minute {
+		return
+	}
+	p.lastPruned = now
+	for id, pin := range p.sessions {
+		if now.Sub(pin.lastUsed) > sessionIdleTimeout {
+			delete(p.sessions, id)
+		}
+	}
+}
+
+// Start runs the health checks in the background until Close is called.
+// It does nothing if health checks are disabled.
+func (p *Pool) Start() {
+	if p.check.Interval <= 0 {
+		return
+	}
+
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+
+		ticker := time.NewTicker(p.check.Interval)
+		defer ticker.Stop()
+		for {
+			p.checkAll()
+			select {
+			case <-ticker.C:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the health checks
+func (p *Pool) Close() {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	p.done.Wait()
+}
+
+// checkAll checks every endpoint concurrently
+func (p *Pool) checkAll() {
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+			p.checkEndpoint(e)
+		}(e)
+	}
+	wg.Wait()
+}
+
+// checkEndpoint sends the health check request to an endpoint and updates
+// its health
+func (p *Pool) checkEndpoint(e *endpoint) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.check.Timeout)
+	defer cancel()
+
+	body, _ := json.Marshal(&schema.MCPRequest{
+		JSONRPC: schema.Version,
+		ID:      schema.StringID("safectx-health-check"),
+		Method:  p.check.Method,
+	})
+
+	var checkErr error
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.proxy.target.String(), bytes.NewReader(body))
+	if err != nil {
+		checkErr = err
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json, text/event-stream")
+		resp, err := e.proxy.client.Do(req)
+		if err != nil {
+			checkErr = classifyUpstreamError(err)
+		} else {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			if resp.StatusCode >= http.StatusInternalServerError {
+				checkErr = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+	}
+
+	if changed, healthy := e.record(checkErr, p.check.UnhealthyThreshold, p.check.HealthyThreshold); changed {
+		if healthy {
+			log.Printf("Upstream %s endpoint %s is healthy again", p.name, e.url)
+		} else {
+			log.Printf("Upstream %s endpoint %s is unhealthy: %v", p.name, e.url, checkErr)
+		}
+	}
+}
+
+// Status returns the current health of the pool
+func (p *Pool) Status() PoolStatus {
+	status := PoolStatus{Name: p.name}
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		es := EndpointStatus{
+			URL:       e.url,
+			Healthy:   e.healthy,
+			Circuit:   e.breaker.State(),
+			InFlight:  e.inFlight.Load(),
+			LastCheck: e.lastCheck,
+			LastError: e.lastError,
+		}
+		e.mu.Unlock()
+
+		if es.Healthy && es.Circuit != CircuitOpen {
+			status.Available = true
+		}
+		status.Endpoints = append(status.Endpoints, es)
+	}
+	return status
+}
+
+// isHealthy reports whether the endpoint passed its recent health checks
+func (e *endpoint) isHealthy() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.healthy
+}
+
+// record updates the endpoint health with the outcome of a check. It
+// returns true if the endpoint became healthy or unhealthy.
+func (e *endpoint) record(err error, unhealthyAfter, healthyAfter int) (bool, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastCheck = time.Now()
+	if err != nil {
+		e.lastError = err.Error()
+		e.failures++
+		e.passes = 0
+		if e.healthy && e.failures >= max(unhealthyAfter, 1) {
+			e.healthy = false
+			return true, false
+		}
+		return false, e.healthy
+	}
+
+	e.lastError = ""
+	e.passes++
+	e.failures = 0
+	if !e.healthy && e.passes >= max(healthyAfter, 1) {
+		e.healthy = true
+		return true, true
+	}
+	return false, e.healthy
+}
+
+// failed reports whether an endpoint failed to handle a request. Error
+// statuses below 500 are answers from a working endpoint.
+func failed(resp *UpstreamResponse, err error) bool {
+	return err != nil || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// describeFailure describes a failed attempt for logging
+func describeFailure(resp *UpstreamResponse, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("status %d", resp.StatusCode)
+}
+
+// releasingBody calls release once when the response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// HealthHandler serves the status of the pools as JSON. It responds with
+// 503 if any pool has no available endpoint.
+func HealthHandler(pools ...*Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		status := http.StatusOK
+		upstreams := make([]PoolStatus, 0, len(pools))
+		for _, pool := range pools {
+			s := pool.Status()
+			if !s.Available {
+				status = http.StatusServiceUnavailable
+			}
+			upstreams = append(upstreams, s)
+		}
+		writeJSON(w, status, map[string]interface{}{"upstreams": upstreams})
+	})
+}
@@ -0,0 +1,286 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/pkg/schema"
+)
+
+// flakyServer is an upstream endpoint that fails with 503 on demand
+type flakyServer struct {
+	*httptest.Server
+	name    string
+	failing atomic.Bool
+	hits    atomic.Int64
+}
+
+func newFlakyServer(t *testing.T, name string) *flakyServer {
+	t.Helper()
+
+	s := &flakyServer{name: name}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if string(req.ID) != `"safectx-health-check"` {
+			s.hits.Add(1)
+		}
+
+		if s.failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if req.Method == "initialize" {
+			w.Header().Set("Mcp-Session-Id", name+"-session")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":{"upstream":%q}}`, req.ID, name)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestPool(t *testing.T, configure func(*config.UpstreamConfig), servers ...*flakyServer) *Pool {
+	t.Helper()
+
+	cfg := config.DefaultGatewayConfig().Upstream
+	cfg.Timeout = time.Second
+	cfg.HealthCheck.Interval = 0
+	for _, s := range servers {
+		cfg.Endpoints = append(cfg.Endpoints, s.URL)
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	pool, err := NewPool("test", &cfg)
+	if err != nil {
+		t.Fatalf("NewPool() error = %v", err)
+	}
+	pool.Start()
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// forwardTo sends a request through the pool and returns the status and the
+// upstream that answered
+func forwardTo(t *testing.T, pool *Pool, method, session string) (int, string, error) {
+	t.Helper()
+
+	r := httptest.NewRequest("POST", "/", nil)
+	if session != "" {
+		r.Header.Set("Mcp-Session-Id", session)
+	}
+	resp, err := pool.Forward(r, &schema.MCPRequest{JSONRPC: "2.0", ID: schema.NumberID(1), Method: method})
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Result struct {
+			Upstream string `json:"upstream"`
+		} `json:"result"`
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out.Result.Upstream, nil
+}
+
+func TestPoolBalancing(t *testing.T) {
+	t.Run("round robin", func(t *testing.T) {
+		a, b := newFlakyServer(t, "a"), newFlakyServer(t, "b")
+		pool := newTestPool(t, nil, a, b)
+
+		var got []string
+		for i := 0; i < 4; i++ {
+			_, upstream, err := forwardTo(t, pool, "tools/call", "")
+			if err != nil {
+				t.Fatalf("Forward() error = %v", err)
+			}
+			got = append(got, upstream)
+		}
+		if strings.Join(got, ",") != "a,b,a,b" {
+			t.Errorf("Requests went to %v, want a,b,a,b", got)
+		}
+	})
+
+	t.Run("least in flight", func(t *testing.T) {
+		a, b := newFlakyServer(t, "a"), newFlakyServer(t, "b")
+		pool := newTestPool(t, func(cfg *config.UpstreamConfig) { cfg.Balancing = "least-in-flight" }, a, b)
+
+		// Keep a request to a open
+		open, err := pool.Forward(httptest.NewRequest("POST", "/", nil), &schema.MCPRequest{JSONRPC: "2.0", ID: schema.NumberID(1), Method: "tools/call"})
+		if err != nil {
+			t.Fatalf("Forward() error = %v", err)
+		}
+		defer open.Body.Close()
+
+		for i := 0; i < 3; i++ {
+			if _, upstream, _ := forwardTo(t, pool, "tools/call", ""); upstream != "b" {
+				t.Errorf("Request %d went to %s while a was busy", i, upstream)
+			}
+		}
+	})
+}
+
+func TestPoolRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		maxAttempts  int
+		wantStatus   int
+		wantUpstream string
+		wantBadHits  int64
+	}{
+		{name: "idempotent method", method: "tools/list", maxAttempts: 2, wantStatus: http.StatusOK, wantUpstream: "good", wantBadHits: 1},
+		{name: "non-idempotent method", method: "tools/call", maxAttempts: 2, wantStatus: http.StatusServiceUnavailable, wantBadHits: 1},
+		{name: "retries disabled", method: "resources/read", maxAttempts: 1, wantStatus: http.StatusServiceUnavailable, wantBadHits: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bad, good := newFlakyServer(t, "bad"), newFlakyServer(t, "good")
+			bad.failing.Store(true)
+			pool := newTestPool(t, func(cfg *config.UpstreamConfig) { cfg.Retry.MaxAttempts = tt.maxAttempts }, bad, good)
+
+			status, upstream, err := forwardTo(t, pool, tt.method, "")
+			if err != nil {
+				t.Fatalf("Forward() error = %v", err)
+			}
+			if status != tt.wantStatus || upstream != tt.wantUpstream {
+				t.Errorf("Got status %d from %q, want %d from %q", status, upstream, tt.wantStatus, tt.wantUpstream)
+			}
+			if hits := bad.hits.Load(); hits != tt.wantBadHits {
+				t.Errorf("Failing endpoint got %d requests, want %d", hits, tt.wantBadHits)
+			}
+		})
+	}
+}
+
+func TestPoolCircuitBreaker(t *testing.T) {
+	s := newFlakyServer(t, "only")
+	s.failing.Store(true)
+	pool := newTestPool(t, func(cfg *config.UpstreamConfig) {
+		cfg.CircuitBreaker = config.CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: 50 * time.Millisecond}
+	}, s)
+
+	for i := 0; i < 2; i++ {
+		if status, _, _ := forwardTo(t, pool, "tools/call", ""); status != http.StatusServiceUnavailable {
+			t.Fatalf("Got status %d, want 503 from the endpoint", status)
+		}
+	}
+
+	// The open circuit rejects requests without reaching the endpoint
+	if _, _, err := forwardTo(t, pool, "tools/call", ""); !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Errorf("Got error %v with an open circuit, want ErrUpstreamUnavailable", err)
+	}
+	if hits := s.hits.Load(); hits != 2 {
+		t.Errorf("Endpoint got %d requests, want 2", hits)
+	}
+	if state := pool.Status().Endpoints[0].Circuit; state != CircuitOpen {
+		t.Errorf("Circuit is %s, want open", state)
+	}
+
+	// After the open duration a probe closes the circuit again
+	s.failing.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if status, _, err := forwardTo(t, pool, "tools/call", ""); err != nil || status != http.StatusOK {
+		t.Fatalf("Probe got status %d, error %v", status, err)
+	}
+	if state := pool.Status().Endpoints[0].Circuit; state != CircuitClosed {
+		t.Errorf("Circuit is %s after a successful probe, want closed", state)
+	}
+}
+
+func TestPoolHealthChecks(t *testing.T) {
+	a, b := newFlakyServer(t, "a"), newFlakyServer(t, "b")
+	a.failing.Store(true)
+	pool := newTestPool(t, func(cfg *config.UpstreamConfig) {
+		cfg.HealthCheck.Interval = 10 * time.Millisecond
+		cfg.HealthCheck.UnhealthyThreshold = 1
+		cfg.HealthCheck.HealthyThreshold = 1
+	}, a, b)
+
+	waitFor := func(desc string, cond func(PoolStatus) bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond(pool.Status()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting until %s: %+v", desc, pool.Status())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("a is unhealthy", func(s PoolStatus) bool { return !s.Endpoints[0].Healthy })
+
+	a.hits.Store(0)
+	for i := 0; i < 4; i++ {
+		if _, upstream, _ := forwardTo(t, pool, "tools/call", ""); upstream != "b" {
+			t.Errorf("Request %d went to %q, want b", i, upstream)
+		}
+	}
+	if hits := a.hits.Load(); hits != 0 {
+		t.Errorf("Unhealthy endpoint got %d requests", hits)
+	}
+
+	health := func() (int, map[string][]PoolStatus) {
+		rr := httptest.NewRecorder()
+		HealthHandler(pool).ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+		var body map[string][]PoolStatus
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to unmarshal health %q: %v", rr.Body.String(), err)
+		}
+		return rr.Code, body
+	}
+	if code, body := health(); code != http.StatusOK || body["upstreams"][0].Endpoints[0].LastError == "" {
+		t.Errorf("Got health %d %+v, want 200 with a failing endpoint", code, body)
+	}
+
+	b.failing.Store(true)
+	waitFor("b is unhealthy", func(s PoolStatus) bool { return !s.Available })
+	if code, _ := health(); code != http.StatusServiceUnavailable {
+		t.Errorf("Got health status %d with no healthy endpoint, want 503", code)
+	}
+
+	a.failing.Store(false)
+	waitFor("a recovers", func(s PoolStatus) bool { return s.Endpoints[0].Healthy })
+}
+
+func TestPoolPinsSessions(t *testing.T) {
+	a, b := newFlakyServer(t, "a"), newFlakyServer(t, "b")
+	pool := newTestPool(t, nil, a, b)
+
+	// The second initialize lands on b, which owns the session from then on
+	forwardTo(t, pool, "initialize", "")
+	forwardTo(t, pool, "initialize", "")
+	for i := 0; i < 3; i++ {
+		if _, upstream, _ := forwardTo(t, pool, "tools/call", "b-session"); upstream != "b" {
+			t.Errorf("Session request %d went to %q, want b", i, upstream)
+		}
+	}
+
+	// Terminating the session forgets the pin
+	r := httptest.NewRequest("DELETE", "/", nil)
+	r.Header.Set("Mcp-Session-Id", "b-session")
+	resp, err := pool.ForwardSession(r)
+	if err != nil {
+		t.Fatalf("ForwardSession() error = %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if pool.pinned("b-session") != nil {
+		t.Error("Session is still pinned after DELETE")
+	}
+}
@@ -0,0 +1,281 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/pkg/schema"
+)
+
+// Error definitions
+var (
+	ErrUpstreamTimeout     = errors.New("upstream request timed out")
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	ErrRequestCancelled    = errors.New("request cancelled")
+)
+
+// hopHeaders are connection-specific headers that must not be relayed
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// StripHopHeaders removes the connection-specific headers from a header
+// about to be relayed
+func StripHopHeaders(header http.Header) {
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+}
+
+// sessionHeaders are the Streamable HTTP transport headers that are always
+// passed through so MCP sessions and stream resumption keep working
+var sessionHeaders = []string{
+	"Mcp-Session-Id",
+	"Mcp-Protocol-Version",
+	"Last-Event-Id",
+}
+
+// DefaultUpstreamName names the upstream of a gateway without named
+// upstreams
+const DefaultUpstreamName = "upstream"
+
+// UpstreamResponse is the response received from an upstream server
+type UpstreamResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       io.ReadCloser
+	// Upstream names the upstream that sent the response, if it came from
+	// a single one
+	Upstream string
+	// Merged is set for an SSE stream merged from several upstreams. The
+	// ID of each event names the upstream it came from.
+	Merged bool
+}
+
+// Forwarder delivers sanitized requests to an upstream MCP server
+type Forwarder interface {
+	// Forward sends req upstream on behalf of the client request r
+	Forward(r *http.Request, req *schema.MCPRequest) (*UpstreamResponse, error)
+}
+
+// Resolver is implemented by forwarders that can tell where a request would
+// be sent without sending it
+type Resolver interface {
+	// Resolve returns the upstreams req would be forwarded to on behalf of
+	// the client request r
+	Resolve(r *http.Request, req *schema.MCPRequest) (*RouteTarget, error)
+}
+
+// RouteTarget describes where a request is forwarded
+type RouteTarget struct {
+	// Upstreams are the names of the upstreams receiving the request.
+	// Requests sent to several upstreams have their responses merged.
+	Upstreams []string `json:"upstreams"`
+	// Tool is the upstream's own name of the called tool, if it differs
+	// from the name the client used
+	Tool string `json:"tool,omitempty"`
+}
+
+// ResponseForwarder is implemented by forwarders that can deliver the
+// responses of the client to the requests servers send
+type ResponseForwarder interface {
+	// ForwardResponse sends the JSON-RPC response data to the named
+	// upstream, which sent the request it answers, on behalf of the client
+	// request r. An empty name stands for the only upstream.
+	ForwardResponse(r *http.Request, upstream string, data []byte) (*UpstreamResponse, error)
+}
+
+// SessionForwarder is implemented by forwarders that support the session
+// requests of the MCP Streamable HTTP transport
+type SessionForwarder interface {
+	// ForwardSession relays a GET (open the server-to-client SSE stream) or
+	// DELETE (terminate the session) request upstream
+	ForwardSession(r *http.Request) (*UpstreamResponse, error)
+}
+
+// Proxy forwards requests to a single upstream MCP/JSON-RPC endpoint over HTTP
+type Proxy struct {
+	target         *url.URL
+	client         *http.Client
+	timeout        time.Duration
+	allowedHeaders []string
+}
+
+// NewProxy creates a new proxy for the given upstream
+func NewProxy(cfg *config.UpstreamConfig) (*Proxy, error) {
+	target, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URL: %w", err)
+	}
+
+	allowed := make([]string, 0, len(cfg.AllowedHeaders))
+	for _, h := range cfg.AllowedHeaders {
+		allowed = append(allowed, http.CanonicalHeaderKey(h))
+	}
+
+	return &Proxy{
+		target:         target,
+		client:         &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		timeout:        cfg.Timeout,
+		allowedHeaders: allowed,
+	}, nil
+}
+
+// Forward implements the Forwarder interface
+func (p *Proxy) Forward(r *http.Request, req *schema.MCPRequest) (*UpstreamResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode upstream request: %w", err)
+	}
+
+	upReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, p.target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upstream request: %w", err)
+	}
+	p.copyHeaders(upReq, r)
+	upReq.Header.Set("Content-Type", "application/json")
+	upReq.Header.Set("Accept", "application/json, text/event-stream")
+
+	return p.do(upReq)
+}
+
+// ForwardResponse implements the ResponseForwarder interface
+func (p *Proxy) ForwardResponse(r *http.Request, upstream string, data []byte) (*UpstreamResponse, error) {
+	upReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, p.target.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upstream request: %w", err)
+	}
+	p.copyHeaders(upReq, r)
+	upReq.Header.Set("Content-Type", "application/json")
+	upReq.Header.Set("Accept", "application/json, text/event-stream")
+
+	return p.do(upReq)
+}
+
+// ForwardSession implements the SessionForwarder interface
+func (p *Proxy) ForwardSession(r *http.Request) (*UpstreamResponse, error) {
+	upReq, err := http.NewRequestWithContext(r.Context(), r.Method, p.target.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upstream request: %w", err)
+	}
+	p.copyHeaders(upReq, r)
+	if r.Method == http.MethodGet {
+		upReq.Header.Set("Accept", "text/event-stream")
+	}
+
+	return p.do(upReq)
+}
+
+// copyHeaders copies the allow-listed and session headers of the client
+// request r onto the upstream request. All other client headers are dropped.
+func (p *Proxy) copyHeaders(upReq, r *http.Request) {
+	for _, list := range [][]string{p.allowedHeaders, sessionHeaders} {
+		for _, h := range list {
+			if values := r.Header.Values(h); len(values) > 0 {
+				upReq.Header[h] = append([]string(nil), values...)
+			}
+		}
+	}
+}
+
+// do sends the upstream request and prepares the response for relaying.
+// Requests without a deadline of their own are bounded by the upstream
+// timeout until the response body has been read; for SSE responses only
+// the headers are, since the stream stays open for as long as it lasts.
+func (p *Proxy) do(upReq *http.Request) (*UpstreamResponse, error) {
+	ctx, cancel := context.WithCancel(upReq.Context())
+	var timer *time.Timer
+	expired := new(atomic.Bool)
+	if _, ok := ctx.Deadline(); !ok && p.timeout > 0 {
+		timer = time.AfterFunc(p.timeout, func() {
+			expired.Store(true)
+			cancel()
+		})
+	}
+
+	resp, err := p.client.Do(upReq.WithContext(ctx))
+	if err == nil && timer != nil && isEventStream(resp.Header) {
+		timer.Stop()
+	}
+	if expired.Load() && upReq.Context().Err() == nil {
+		// The timer fired, so the body cannot be read even if the
+		// headers arrived just in time
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, fmt.Errorf("%w: no response headers after %s", ErrUpstreamTimeout, p.timeout)
+	}
+	if err != nil {
+		if timer != nil {
+			timer.Stop()
+		}
+		cancel()
+		return nil, classifyUpstreamError(err)
+	}
+
+	header := resp.Header.Clone()
+	StripHopHeaders(header)
+	header.Del("Content-Length")
+
+	return &UpstreamResponse{
+		StatusCode: resp.StatusCode,
+		Header:     header,
+		Body:       &cancelBody{ReadCloser: resp.Body, cancel: cancel, timer: timer, expired: expired, timeout: p.timeout},
+	}, nil
+}
+
+// cancelBody releases the context of an upstream request when its
+// response body is closed. A read cut short by the upstream timeout fails
+// with ErrUpstreamTimeout.
+type cancelBody struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	expired *atomic.Bool
+	timeout time.Duration
+}
+
+func (b *cancelBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.expired.Load() {
+		err = fmt.Errorf("%w: response not complete after %s", ErrUpstreamTimeout, b.timeout)
+	}
+	return n, err
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.cancel()
+	return err
+}
+
+// classifyUpstreamError maps transport errors onto gateway errors
+func classifyUpstreamError(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %v", ErrUpstreamTimeout, err)
+	}
+	return fmt.Errorf("%w: %v", ErrUpstreamUnavailable, err)
+}
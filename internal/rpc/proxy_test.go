@@ -0,0 +1,200 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/pkg/schema"
+)
+
+func newTestProxy(t *testing.T, url string, timeout time.Duration) *Proxy {
+	t.Helper()
+
+	cfg := &config.UpstreamConfig{
+		URL:            url,
+		Timeout:        timeout,
+		AllowedHeaders: []string{"x-request-id"},
+	}
+	proxy, err := NewProxy(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	return proxy
+}
+
+func TestProxyForward(t *testing.T) {
+	var received map[string]interface{}
+	var receivedHeader http.Header
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedHeader = r.Header.Clone()
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Upstream failed to decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Upstream", "mcp")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"id":"1","result":{}}`))
+	}))
+	defer upstream.Close()
+
+	proxy := newTestProxy(t, upstream.URL, time.Second)
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("X-Request-ID", "req-1")
+	r.Header.Set("Authorization", "Bearer client-token")
+	r.Header.Set("Cookie", "session=abc")
+
+	resp, err := proxy.Forward(r, &schema.MCPRequest{
+		JSONRPC: "2.0",
+		ID:      schema.StringID("1"),
+		Method:  "tools/list",
+		Params:  map[string]interface{}{"password": "[REDACTED]"},
+	})
+	if err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Check the sanitized request reached the upstream
+	if received["method"] != "tools/list" {
+		t.Errorf("Upstream got method %v, want tools/list", received["method"])
+	}
+	if params, _ := received["params"].(map[string]interface{}); params["password"] != "[REDACTED]" {
+		t.Errorf("Upstream got params %v, want redacted password", received["params"])
+	}
+
+	// Check header allow-listing
+	if got := receivedHeader.Get("X-Request-ID"); got != "req-1" {
+		t.Errorf("Allow-listed header not forwarded: got %q", got)
+	}
+	for _, h := range []string{"Authorization", "Cookie"} {
+		if got := receivedHeader.Get(h); got != "" {
+			t.Errorf("Header %s should not be forwarded, got %q", h, got)
+		}
+	}
+
+	// Check the upstream response is relayed
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Got status %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	if got := resp.Header.Get("X-Upstream"); got != "mcp" {
+		t.Errorf("Upstream header not relayed: got %q", got)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != `{"id":"1","result":{}}` {
+		t.Errorf("Got body %s", body)
+	}
+}
+
+func TestProxyForwardErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr error
+	}{
+		{
+			name:    "Upstream timeout",
+			url:     slow.URL,
+			wantErr: ErrUpstreamTimeout,
+		},
+		{
+			name:    "Connection refused",
+			url:     closed.URL,
+			wantErr: ErrUpstreamUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(t, tt.url, 50*time.Millisecond)
+			r := httptest.NewRequest("POST", "/", nil)
+
+			_, err := proxy.Forward(r, &schema.MCPRequest{JSONRPC: "2.0", ID: schema.NumberID(1), Method: "ping"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Forward() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProxyBodyTimeout(t *testing.T) {
+	// Both servers send their headers at once, then stall past the timeout
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"jsonrpc":"2.0",`)
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, `"id":1,"result":{}}`)
+	}))
+	defer stalled.Close()
+	stream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {}\n\n")
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, "data: {}\n\n")
+	}))
+	defer stream.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr error
+	}{
+		{name: "JSON body", url: stalled.URL, wantErr: ErrUpstreamTimeout},
+		{name: "event stream", url: stream.URL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(t, tt.url, 50*time.Millisecond)
+			r := httptest.NewRequest("POST", "/", nil)
+
+			resp, err := proxy.Forward(r, &schema.MCPRequest{JSONRPC: "2.0", ID: schema.NumberID(1), Method: "ping"})
+			if err != nil {
+				t.Fatalf("Forward() error = %v, want the headers", err)
+			}
+			defer resp.Body.Close()
+			if _, err := io.ReadAll(resp.Body); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Reading the body failed with %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGatewayUpstreamErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	handler := NewGatewayHandler(newTestProxy(t, slow.URL, 50*time.Millisecond))
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":"1","method":"ping"}`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("Got status %d, want %d", rr.Code, http.StatusGatewayTimeout)
+	}
+	if !strings.Contains(rr.Body.String(), `"code":-32004`) {
+		t.Errorf("Got body %s, want upstream timeout error", rr.Body.String())
+	}
+}
@@ -0,0 +1,152 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// maxServerRequests bounds the server requests awaiting a client response
+// that are remembered per gateway
+const maxServerRequests = 4096
+
+// ServerOrigins remembers the upstream that sent each server request
+// relayed to a client, until the client answers it
+type ServerOrigins struct {
+	mu       sync.Mutex
+	requests map[string]string
+}
+
+// NewServerOrigins creates an empty record of server requests
+func NewServerOrigins() *ServerOrigins {
+	return &ServerOrigins{requests: make(map[string]string)}
+}
+
+// originKey identifies a server request by MCP session and ID
+func originKey(session string, id json.RawMessage) string {
+	return session + "|" + string(bytes.TrimSpace(id))
+}
+
+// Track remembers the upstream of the server requests in a relayed
+// message, a single message or a batch
+func (o *ServerOrigins) Track(session, upstream string, data []byte) {
+	if upstream == "" {
+		return
+	}
+	var msgs []struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] != '[' {
+		data = append(append([]byte{'['}, data...), ']')
+	}
+	if err := json.Unmarshal(data, &msgs); err != nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, msg := range msgs {
+		if msg.Method == "" || len(msg.ID) == 0 || string(msg.ID) == "null" {
+			continue
+		}
+		if len(o.requests) >= maxServerRequests {
+			log.Printf("Not tracking server request %s: too many unanswered server requests", msg.ID)
+			return
+		}
+		o.requests[originKey(session, msg.ID)] = upstream
+	}
+}
+
+// Take returns and forgets the upstream of the server request a client
+// response answers, or "" if it is unknown
+func (o *ServerOrigins) Take(session string, id json.RawMessage) string {
+	key := originKey(session, id)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	upstream := o.requests[key]
+	delete(o.requests, key)
+	return upstream
+}
+
+// End forgets the server requests of an MCP session
+func (o *ServerOrigins) End(session string) {
+	prefix := session + "|"
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for key := range o.requests {
+		if len(key) >= len(prefix) && key[:len(prefix)] == prefix {
+			delete(o.requests, key)
+		}
+	}
+}
+
+// isClientResponse reports whether a message is a JSON-RPC response rather
+// than a request or notification
+func isClientResponse(data []byte) bool {
+	var msg struct {
+		Method *string         `json:"method"`
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return false
+	}
+	return msg.Method == nil && (msg.Result != nil || msg.Error != nil)
+}
+
+// forwardResponse inspects the response of the client to a server request
+// and forwards it to the upstream that sent the request. Responses get no
+// response of their own; the result only carries the HTTP status.
+func (g *Gateway) forwardResponse(r *http.Request, data []byte) *result {
+	var msg struct {
+		ID json.RawMessage `json:"id"`
+	}
+	json.Unmarshal(data, &msg)
+	session := r.Header.Get("Mcp-Session-Id")
+	upstream := g.origins.Take(session, msg.ID)
+
+	out, ok := g.inspector.InspectClientResponse(data)
+	if !ok {
+		return &result{status: http.StatusBadRequest, notification: true}
+	}
+	if status := g.sendResponse(r, upstream, out); status != http.StatusAccepted {
+		return &result{status: status, notification: true}
+	}
+	log.Printf("Forwarded client response %s", msg.ID)
+	return &result{status: http.StatusAccepted, notification: true}
+}
+
+// answerServer sends the error response replacing the client's answer to a
+// blocked server request, so the upstream does not wait for it
+func (g *Gateway) answerServer(r *http.Request, upstream string, reply []byte) {
+	if status := g.sendResponse(r, upstream, reply); status == http.StatusAccepted {
+		log.Printf("Answered blocked server request from %s", upstream)
+	}
+}
+
+// sendResponse forwards a response to the named upstream and returns the
+// HTTP status reported to the client: 202 once the upstream accepted it
+func (g *Gateway) sendResponse(r *http.Request, upstream string, data []byte) int {
+	rf, ok := g.upstream.(ResponseForwarder)
+	if !ok {
+		log.Printf("Dropped client response: the upstream cannot receive responses")
+		return http.StatusNotImplemented
+	}
+	resp, err := rf.ForwardResponse(r, upstream, data)
+	if err != nil {
+		log.Printf("Error forwarding client response: %v", err)
+		status, _, _ := forwardError(err)
+		return status
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		log.Printf("Upstream rejected client response with status %d", resp.StatusCode)
+		return http.StatusBadGateway
+	}
+	return http.StatusAccepted
+}
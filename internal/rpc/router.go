@@ -0,0 +1,765 @@
+package rpc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/pkg/schema"
+)
+
+// ErrNoRoute is returned when no route matches a request
+var ErrNoRoute = errors.New("no upstream for request")
+
+// toolPrefixSeparator joins the upstream name and tool name of a colliding
+// tool, e.g. "github__search"
+const toolPrefixSeparator = "__"
+
+// maxListPages bounds the pages fetched from one upstream for an
+// aggregated list
+const maxListPages = 100
+
+// aggregatedLists maps the list methods merged across upstreams to the
+// result field holding the items
+var aggregatedLists = map[string]string{
+	"tools/list":     "tools",
+	"resources/list": "resources",
+}
+
+// Router forwards each request to one of several upstreams according to a
+// routing table, and presents them to the client as a single MCP server.
+// initialize is sent to every upstream and the capabilities are merged,
+// tools/list and resources/list are aggregated, and notifications are
+// broadcast.
+type Router struct {
+	upstreams   map[string]Forwarder
+	order       []string
+	routes      []config.RouteConfig
+	onCollision string
+
+	sessionTTL time.Duration
+
+	mu sync.RWMutex
+	// tools maps tool names exposed to clients to the upstream serving
+	// them, per set of candidate upstreams. Each aggregated tools/list
+	// replaces the names of its set.
+	tools map[string]map[string]toolTarget
+	// resources maps resource URIs to the upstream serving them, per set
+	// of candidate upstreams
+	resources map[string]map[string]string
+	// sessions maps gateway session IDs to the sessions of the upstreams
+	sessions  map[string]*routerSession
+	lastSweep time.Time
+}
+
+// routerSession holds the session ID of each upstream for a gateway
+// session, and the time the gateway session was last used
+type routerSession struct {
+	upstreams map[string]string
+	lastSeen  time.Time
+}
+
+// toolTarget identifies a tool on an upstream
+type toolTarget struct {
+	upstream string
+	name     string
+}
+
+// upstreamResult is the response of one upstream to a fanned out request
+type upstreamResult struct {
+	upstream string
+	data     []byte
+	session  string
+	err      error
+}
+
+// NewRouter creates a router with the given routing table. Upstreams are
+// added with WithUpstream.
+func NewRouter(cfg *config.RoutingConfig) *Router {
+	onCollision := cfg.OnCollision
+	if onCollision == "" {
+		onCollision = "prefix"
+	}
+	sessionTTL := cfg.SessionTTL
+	if sessionTTL <= 0 {
+		sessionTTL = config.DefaultRoutingConfig().SessionTTL
+	}
+	return &Router{
+		upstreams:   make(map[string]Forwarder),
+		routes:      cfg.Routes,
+		onCollision: onCollision,
+		sessionTTL:  sessionTTL,
+		tools:       make(map[string]map[string]toolTarget),
+		resources:   make(map[string]map[string]string),
+		sessions:    make(map[string]*routerSession),
+		lastSweep:   time.Now(),
+	}
+}
+
+// WithUpstream adds a named upstream. Upstreams added first win tool name
+// collisions when they are resolved with "first".
+func (rt *Router) WithUpstream(name string, upstream Forwarder) *Router {
+	if _, exists := rt.upstreams[name]; !exists {
+		rt.order = append(rt.order, name)
+	}
+	rt.upstreams[name] = upstream
+	return rt
+}
+
+// Forward implements the Forwarder interface
+func (rt *Router) Forward(r *http.Request, req *schema.MCPRequest) (*UpstreamResponse, error) {
+	if req.IsNotification() {
+		return rt.broadcast(r, req)
+	}
+	if req.Method == "initialize" {
+		return rt.initialize(r, req)
+	}
+	if field, ok := aggregatedLists[req.Method]; ok {
+		return rt.aggregate(r, req, field)
+	}
+
+	name, out, err := rt.route(r, req)
+	if err != nil {
+		return nil, err
+	}
+	return rt.forwardTo(r, name, out)
+}
+
+// Resolve implements the Resolver interface
+func (rt *Router) Resolve(r *http.Request, req *schema.MCPRequest) (*RouteTarget, error) {
+	_, aggregated := aggregatedLists[req.Method]
+	if req.IsNotification() || req.Method == "initialize" || aggregated {
+		return &RouteTarget{Upstreams: rt.candidates(r)}, nil
+	}
+
+	name, out, err := rt.route(r, req)
+	if err != nil {
+		return nil, err
+	}
+	target := &RouteTarget{Upstreams: []string{name}}
+	if call, ok := out.ToolCall(); ok && out != req {
+		target.Tool = call.Name
+	}
+	return target, nil
+}
+
+// ForwardSession implements the SessionForwarder interface. GET merges the
+// server streams of every upstream into one; event IDs are replaced by the
+// name of the upstream, since they cannot be resumed across upstreams.
+// DELETE ends the session on every upstream.
+func (rt *Router) ForwardSession(r *http.Request) (*UpstreamResponse, error) {
+	streams := make(map[string]*UpstreamResponse)
+	for _, name := range rt.candidates(r) {
+		sf, ok := rt.upstreams[name].(SessionForwarder)
+		if !ok {
+			continue
+		}
+		resp, err := sf.ForwardSession(rt.upstreamRequest(r, name))
+		if err != nil {
+			log.Printf("Error forwarding %s session request to %s: %v", r.Method, name, err)
+			continue
+		}
+		if r.Method == http.MethodGet && resp.StatusCode == http.StatusOK && isEventStream(resp.Header) {
+			streams[name] = resp
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	if r.Method == http.MethodDelete {
+		rt.endSession(r.Header.Get("Mcp-Session-Id"))
+		return newJSONResponse(http.StatusNoContent, nil, nil), nil
+	}
+	if len(streams) == 0 {
+		resp := newJSONResponse(http.StatusMethodNotAllowed, nil, nil)
+		resp.Header.Set("Allow", http.MethodPost)
+		return resp, nil
+	}
+	return mergeStreams(streams), nil
+}
+
+// ForwardResponse implements the ResponseForwarder interface. Without an
+// upstream name, the response goes to the only candidate upstream.
+func (rt *Router) ForwardResponse(r *http.Request, upstream string, data []byte) (*UpstreamResponse, error) {
+	if upstream == "" {
+		if names := rt.candidates(r); len(names) == 1 {
+			upstream = names[0]
+		}
+	}
+	rf, ok := rt.upstreams[upstream].(ResponseForwarder)
+	if !ok {
+		return nil, fmt.Errorf("%w: no upstream %q receiving client responses", ErrNoRoute, upstream)
+	}
+	resp, err := rf.ForwardResponse(rt.upstreamRequest(r, upstream), upstream, data)
+	if err != nil {
+		return nil, fmt.Errorf("upstream %s: %w", upstream, err)
+	}
+	resp.Upstream = upstream
+	return resp, nil
+}
+
+// route picks the upstream for a request. Tools and resources learned from
+// aggregated lists take precedence over the routing table. The returned
+// request has prefixed tool names replaced with the upstream's own name.
+func (rt *Router) route(r *http.Request, req *schema.MCPRequest) (string, *schema.MCPRequest, error) {
+	set := candidateSet(rt.candidates(r))
+	if call, ok := req.ToolCall(); ok {
+		if target, ok := rt.lookupTool(set, call.Name); ok {
+			out := *req
+			out.Params = make(map[string]interface{}, len(req.Params))
+			for key, value := range req.Params {
+				out.Params[key] = value
+			}
+			out.Params["name"] = target.name
+			return target.upstream, &out, nil
+		}
+	}
+
+	if strings.HasPrefix(req.Method, "resources/") {
+		if uri, ok := req.Params["uri"].(string); ok {
+			rt.mu.RLock()
+			name, ok := rt.resources[set][uri]
+			rt.mu.RUnlock()
+			if ok {
+				return name, req, nil
+			}
+		}
+	}
+
+	for _, route := range rt.routes {
+		if routeMatches(&route, r, req) {
+			return route.Upstream, req, nil
+		}
+	}
+	return "", nil, fmt.Errorf("%w: %s", ErrNoRoute, req.Method)
+}
+
+// lookupTool resolves a tool name exposed to clients of a set of candidate
+// upstreams. Only names listed by the last aggregated tools/list of the set
+// are resolved, so a prefix cannot address a tool the upstream exposes
+// under its own name.
+func (rt *Router) lookupTool(set, name string) (toolTarget, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	target, ok := rt.tools[set][name]
+	return target, ok
+}
+
+// candidateSet returns the key of the tool and resource indexes for a set
+// of candidate upstreams
+func candidateSet(names []string) string {
+	return strings.Join(names, ",")
+}
+
+// routeMatches reports whether every criterion set on the route matches
+func routeMatches(route *config.RouteConfig, r *http.Request, req *schema.MCPRequest) bool {
+	if route.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+		return false
+	}
+	if len(route.Methods) > 0 && !matchAny(route.Methods, req.Method) {
+		return false
+	}
+	if len(route.Tools) > 0 {
+		call, ok := req.ToolCall()
+		if !ok || !matchAny(route.Tools, call.Name) {
+			return false
+		}
+	}
+	if len(route.URISchemes) > 0 {
+		uri, _ := req.Params["uri"].(string)
+		u, err := url.Parse(uri)
+		if !strings.HasPrefix(req.Method, "resources/") || uri == "" || err != nil {
+			return false
+		}
+		matched := false
+		for _, scheme := range route.URISchemes {
+			if strings.EqualFold(u.Scheme, scheme) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny reports whether value matches any of the path.Match patterns
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, value); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates returns the upstreams that can serve the client request, i.e.
+// those with a route whose path prefix matches, in upstream order
+func (rt *Router) candidates(r *http.Request) []string {
+	eligible := make(map[string]bool)
+	for _, route := range rt.routes {
+		if route.PathPrefix == "" || strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+			eligible[route.Upstream] = true
+		}
+	}
+
+	names := make([]string, 0, len(eligible))
+	for _, name := range rt.order {
+		if eligible[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// forwardTo forwards a request to the named upstream, translating between
+// the gateway session and the upstream's own session
+func (rt *Router) forwardTo(r *http.Request, name string, req *schema.MCPRequest) (*UpstreamResponse, error) {
+	upstream, ok := rt.upstreams[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown upstream %s", ErrNoRoute, name)
+	}
+
+	resp, err := upstream.Forward(rt.upstreamRequest(r, name), req)
+	if err != nil {
+		return nil, fmt.Errorf("upstream %s: %w", name, err)
+	}
+	resp.Header.Del("Mcp-Session-Id")
+	if session := r.Header.Get("Mcp-Session-Id"); session != "" {
+		resp.Header.Set("Mcp-Session-Id", session)
+	}
+	resp.Upstream = name
+	log.Printf("Routed %s (%s) to upstream %s", req.ID, req.Method, name)
+	return resp, nil
+}
+
+// upstreamRequest returns a copy of the client request carrying the
+// upstream's session ID in place of the gateway session ID
+func (rt *Router) upstreamRequest(r *http.Request, name string) *http.Request {
+	out := r.Clone(r.Context())
+	out.Header.Del("Mcp-Session-Id")
+	out.Header.Del("Last-Event-Id")
+
+	if session := rt.upstreamSession(r.Header.Get("Mcp-Session-Id"), name); session != "" {
+		out.Header.Set("Mcp-Session-Id", session)
+	}
+	return out
+}
+
+// startSession records the upstream sessions of a new gateway session,
+// removing the sessions idle for longer than the session TTL
+func (rt *Router) startSession(id string, upstreams map[string]string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rt.lastSweep) >= rt.sessionTTL {
+		for id, s := range rt.sessions {
+			if now.Sub(s.lastSeen) >= rt.sessionTTL {
+				delete(rt.sessions, id)
+			}
+		}
+		rt.lastSweep = now
+	}
+	rt.sessions[id] = &routerSession{upstreams: upstreams, lastSeen: now}
+}
+
+// upstreamSession returns the session of the named upstream for a gateway
+// session, or "" if there is none or the gateway session expired
+func (rt *Router) upstreamSession(id, name string) string {
+	if id == "" {
+		return ""
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	s, ok := rt.sessions[id]
+	if !ok {
+		return ""
+	}
+	now := time.Now()
+	if now.Sub(s.lastSeen) >= rt.sessionTTL {
+		delete(rt.sessions, id)
+		return ""
+	}
+	s.lastSeen = now
+	return s.upstreams[name]
+}
+
+// endSession forgets a gateway session
+func (rt *Router) endSession(id string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	delete(rt.sessions, id)
+}
+
+// fanOut sends a request to every candidate upstream concurrently and reads
+// each response. makeReq may return a different request per upstream.
+func (rt *Router) fanOut(r *http.Request, makeReq func(name string) *schema.MCPRequest) []upstreamResult {
+	names := rt.candidates(r)
+	results := make([]upstreamResult, len(names))
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			req := makeReq(name)
+			results[i].upstream = name
+
+			resp, err := rt.upstreams[name].Forward(rt.upstreamRequest(r, name), req)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			results[i].session = resp.Header.Get("Mcp-Session-Id")
+			if req.IsNotification() {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				return
+			}
+			results[i].data, results[i].err = readResponse(resp, req.ID)
+		}(i, name)
+	}
+	wg.Wait()
+	return results
+}
+
+// broadcast sends a notification to every candidate upstream
+func (rt *Router) broadcast(r *http.Request, req *schema.MCPRequest) (*UpstreamResponse, error) {
+	for _, res := range rt.fanOut(r, func(string) *schema.MCPRequest { return req }) {
+		if res.err != nil {
+			log.Printf("Error sending notification %s to upstream %s: %v", req.Method, res.upstream, res.err)
+		}
+	}
+	return newJSONResponse(http.StatusAccepted, nil, nil), nil
+}
+
+// initialize sends initialize to every upstream and merges their
+// capabilities. The first upstream's answer provides the protocol version
+// and server info. A gateway session is created if any upstream uses
+// sessions.
+func (rt *Router) initialize(r *http.Request, req *schema.MCPRequest) (*UpstreamResponse, error) {
+	var base map[string]json.RawMessage
+	capabilities := make(map[string]json.RawMessage)
+	sessions := make(map[string]string)
+
+	var firstErr error
+	for _, res := range rt.fanOut(r, func(string) *schema.MCPRequest { return req }) {
+		result, err := resultOf(res)
+		if err != nil {
+			log.Printf("Upstream %s failed to initialize: %v", res.upstream, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(result, &fields); err != nil {
+			log.Printf("Upstream %s sent an invalid initialize result: %v", res.upstream, err)
+			continue
+		}
+		if base == nil {
+			base = fields
+		}
+
+		var caps map[string]json.RawMessage
+		json.Unmarshal(fields["capabilities"], &caps)
+		for key, value := range caps {
+			if _, exists := capabilities[key]; !exists {
+				capabilities[key] = value
+			}
+		}
+		if res.session != "" {
+			sessions[res.upstream] = res.session
+		}
+	}
+
+	if base == nil {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("%w: no upstream initialized", ErrUpstreamUnavailable)
+		}
+		return nil, firstErr
+	}
+	base["capabilities"], _ = json.Marshal(capabilities)
+
+	header := make(http.Header)
+	if len(sessions) > 0 {
+		session := newSessionID()
+		rt.startSession(session, sessions)
+		header.Set("Mcp-Session-Id", session)
+	}
+	return resultResponse(req.ID, base, header)
+}
+
+// aggregate merges a list method across every candidate upstream. Tool
+// name collisions are resolved according to the collision setting, and the
+// tool or resource index used for routing requests of the candidates is
+// replaced.
+func (rt *Router) aggregate(r *http.Request, req *schema.MCPRequest, field string) (*UpstreamResponse, error) {
+	names := rt.candidates(r)
+	lists := make([][]map[string]json.RawMessage, len(names))
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			items, err := rt.listAll(r, name, req, field)
+			if err != nil {
+				log.Printf("Skipping upstream %s in %s: %v", name, req.Method, err)
+				return
+			}
+			lists[i] = items
+		}(i, name)
+	}
+	wg.Wait()
+
+	keyField := "name"
+	if field == "resources" {
+		keyField = "uri"
+	}
+
+	// Count the upstreams offering each key to find collisions
+	counts := make(map[string]int)
+	for _, items := range lists {
+		for _, it := range items {
+			counts[itemKey(it, keyField)]++
+		}
+	}
+
+	merged := make([]map[string]json.RawMessage, 0)
+	seen := make(map[string]bool)
+	tools := make(map[string]toolTarget)
+	resources := make(map[string]string)
+	for i, items := range lists {
+		name := names[i]
+		for _, it := range items {
+			key := itemKey(it, keyField)
+			exposed := key
+
+			switch {
+			case seen[key] && (field == "resources" || rt.onCollision == "first"):
+				log.Printf("%s %s of upstream %s hidden by another upstream", keyField, key, name)
+				continue
+			case counts[key] > 1 && field == "tools" && rt.onCollision == "prefix":
+				exposed = name + toolPrefixSeparator + key
+				it["name"], _ = json.Marshal(exposed)
+			}
+			seen[key] = true
+
+			if field == "tools" {
+				tools[exposed] = toolTarget{upstream: name, name: key}
+			} else {
+				resources[key] = name
+			}
+			merged = append(merged, it)
+		}
+	}
+
+	rt.mu.Lock()
+	if field == "tools" {
+		rt.tools[candidateSet(names)] = tools
+	} else {
+		rt.resources[candidateSet(names)] = resources
+	}
+	rt.mu.Unlock()
+
+	list, _ := json.Marshal(merged)
+	return resultResponse(req.ID, map[string]json.RawMessage{field: list}, nil)
+}
+
+// listAll fetches every page of a list method from one upstream
+func (rt *Router) listAll(r *http.Request, name string, req *schema.MCPRequest, field string) ([]map[string]json.RawMessage, error) {
+	var all []map[string]json.RawMessage
+	cursor := ""
+	for page := 0; page < maxListPages; page++ {
+		pageReq := *req
+		pageReq.Params = nil
+		if cursor != "" {
+			pageReq.Params = map[string]interface{}{"cursor": cursor}
+		}
+
+		resp, err := rt.upstreams[name].Forward(rt.upstreamRequest(r, name), &pageReq)
+		if err != nil {
+			return nil, err
+		}
+		data, err := readResponse(resp, req.ID)
+		if err != nil {
+			return nil, err
+		}
+		result, err := resultOf(upstreamResult{upstream: name, data: data})
+		if err != nil {
+			return nil, err
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(result, &fields); err != nil {
+			return nil, fmt.Errorf("invalid %s result: %w", req.Method, err)
+		}
+		var items []map[string]json.RawMessage
+		if err := json.Unmarshal(fields[field], &items); err != nil && fields[field] != nil {
+			return nil, fmt.Errorf("invalid %s result: %w", req.Method, err)
+		}
+		all = append(all, items...)
+
+		cursor = ""
+		json.Unmarshal(fields["nextCursor"], &cursor)
+		if cursor == "" {
+			return all, nil
+		}
+	}
+	log.Printf("Upstream %s returned more than %d pages for %s", name, maxListPages, req.Method)
+	return all, nil
+}
+
+// itemKey returns the string value of a field of a list item
+func itemKey(item map[string]json.RawMessage, field string) string {
+	var key string
+	json.Unmarshal(item[field], &key)
+	return key
+}
+
+// resultOf extracts the result of a JSON-RPC response read from an
+// upstream, turning error responses into errors
+func resultOf(res upstreamResult) (json.RawMessage, error) {
+	if res.err != nil {
+		return nil, res.err
+	}
+	var resp schema.MCPResponse
+	if err := json.Unmarshal(res.data, &resp); err != nil {
+		return nil, fmt.Errorf("%w: invalid response: %v", ErrUpstreamUnavailable, err)
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return resp.Result, nil
+}
+
+// readResponse reads the JSON-RPC response to the request with the given ID
+// from an upstream response, which may be JSON or an SSE stream
+func readResponse(resp *UpstreamResponse, id schema.ID) ([]byte, error) {
+	defer resp.Body.Close()
+
+	if !isEventStream(resp.Header) {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUpstreamUnavailable, err)
+		}
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("%w: invalid response with status %d", ErrUpstreamUnavailable, resp.StatusCode)
+		}
+		return data, nil
+	}
+
+	want, _ := json.Marshal(id)
+	events := NewEventReader(resp.Body)
+	for {
+		ev, err := events.Next()
+		if err != nil {
+			return nil, fmt.Errorf("%w: stream ended without a response: %v", ErrUpstreamUnavailable, err)
+		}
+		var msg struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if json.Unmarshal(ev.Data, &msg) == nil && msg.Method == "" && bytes.Equal(msg.ID, want) {
+			return ev.Data, nil
+		}
+	}
+}
+
+// resultResponse creates an upstream response holding a JSON-RPC result
+// generated by the router
+func resultResponse(id schema.ID, result map[string]json.RawMessage, header http.Header) (*UpstreamResponse, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(&schema.MCPResponse{JSONRPC: schema.Version, ID: id, Result: data})
+	if err != nil {
+		return nil, err
+	}
+	return newJSONResponse(http.StatusOK, header, body), nil
+}
+
+// newJSONResponse creates an upstream response with an in-memory JSON body
+func newJSONResponse(status int, header http.Header, body []byte) *UpstreamResponse {
+	if header == nil {
+		header = make(http.Header)
+	}
+	if body != nil {
+		header.Set("Content-Type", "application/json")
+	}
+	return &UpstreamResponse{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}
+}
+
+// mergeStreams merges the SSE streams of several upstreams, by name, into
+// one. Event IDs are only meaningful to the upstream that assigned them, so
+// they are replaced by the name of the upstream for the gateway to inspect
+// each event as coming from it.
+func mergeStreams(streams map[string]*UpstreamResponse) *UpstreamResponse {
+	pr, pw := io.Pipe()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, stream := range streams {
+		wg.Add(1)
+		go func(name string, stream *UpstreamResponse) {
+			defer wg.Done()
+			defer stream.Body.Close()
+
+			events := NewEventReader(stream.Body)
+			for {
+				ev, err := events.Next()
+				if err != nil {
+					return
+				}
+				if len(ev.Data) == 0 {
+					continue
+				}
+				ev.ID = name
+				mu.Lock()
+				err = WriteEvent(pw, ev)
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}(name, stream)
+	}
+	go func() {
+		wg.Wait()
+		pw.Close()
+	}()
+
+	header := make(http.Header)
+	header.Set("Content-Type", "text/event-stream")
+	return &UpstreamResponse{StatusCode: http.StatusOK, Header: header, Body: pr, Merged: true}
+}
+
+// newSessionID creates a random gateway session ID
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,441 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"safectx/internal/cache"
+	"safectx/internal/config"
+	"safectx/internal/policy"
+	"safectx/pkg/schema"
+)
+
+// fakeMCPServer is an upstream with a fixed set of tools and resources that
+// records the calls it receives
+type fakeMCPServer struct {
+	name      string
+	tools     []string
+	resources []string
+	session   string
+
+	mu       sync.Mutex
+	calls    []string
+	sessions []string
+}
+
+func (s *fakeMCPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage        `json:"id"`
+		Method string                 `json:"method"`
+		Params map[string]interface{} `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	s.mu.Lock()
+	s.calls = append(s.calls, fmt.Sprintf("%s %v", req.Method, req.Params["name"]))
+	s.sessions = append(s.sessions, r.Header.Get("Mcp-Session-Id"))
+	s.mu.Unlock()
+
+	var result interface{}
+	switch req.Method {
+	case "initialize":
+		w.Header().Set("Mcp-Session-Id", s.session)
+		result = map[string]interface{}{
+			"protocolVersion": "2025-06-18",
+			"capabilities":    map[string]interface{}{s.name: map[string]interface{}{}},
+			"serverInfo":      map[string]interface{}{"name": s.name},
+		}
+	case "tools/list":
+		var tools []map[string]interface{}
+		for _, name := range s.tools {
+			tools = append(tools, map[string]interface{}{"name": name, "description": s.name})
+		}
+		result = map[string]interface{}{"tools": tools}
+	case "resources/list":
+		var resources []map[string]interface{}
+		for _, uri := range s.resources {
+			resources = append(resources, map[string]interface{}{"uri": uri, "name": s.name})
+		}
+		result = map[string]interface{}{"resources": resources}
+	case "notifications/initialized":
+		w.WriteHeader(http.StatusAccepted)
+		return
+	default:
+		result = map[string]interface{}{"upstream": s.name, "params": req.Params}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+}
+
+func (s *fakeMCPServer) received() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...), append([]string(nil), s.sessions...)
+}
+
+func newTestRouter(t *testing.T, cfg *config.RoutingConfig, servers ...*fakeMCPServer) *Gateway {
+	t.Helper()
+
+	router := NewRouter(cfg)
+	for _, server := range servers {
+		upstream := httptest.NewServer(server)
+		t.Cleanup(upstream.Close)
+		router.WithUpstream(server.name, newTestProxy(t, upstream.URL, time.Second))
+	}
+	return NewGatewayHandler(router)
+}
+
+// call sends a request through the gateway and returns the decoded response
+func call(t *testing.T, handler http.Handler, target, session, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", target, strings.NewReader(body))
+	if session != "" {
+		req.Header.Set("Mcp-Session-Id", session)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var resp map[string]interface{}
+	if rr.Code != http.StatusAccepted {
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to unmarshal response %q: %v", rr.Body.String(), err)
+		}
+	}
+	return rr, resp
+}
+
+func TestRouterAggregatesTools(t *testing.T) {
+	github := &fakeMCPServer{name: "github", tools: []string{"create_issue", "search"}}
+	search := &fakeMCPServer{name: "search", tools: []string{"search"}}
+
+	tests := []struct {
+		name        string
+		onCollision string
+		wantTools   []string
+	}{
+		{name: "prefix collisions", onCollision: "prefix", wantTools: []string{"create_issue", "github__search", "search__search"}},
+		{name: "first upstream wins", onCollision: "first", wantTools: []string{"create_issue", "search"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newTestRouter(t, &config.RoutingConfig{
+				OnCollision: tt.onCollision,
+				Routes:      []config.RouteConfig{{Upstream: "github"}, {Upstream: "search"}},
+			}, github, search)
+
+			_, resp := call(t, handler, "/", "", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+			result, _ := resp["result"].(map[string]interface{})
+			tools, _ := result["tools"].([]interface{})
+
+			var names []string
+			for _, tool := range tools {
+				names = append(names, tool.(map[string]interface{})["name"].(string))
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantTools, ",") {
+				t.Errorf("Got tools %v, want %v", names, tt.wantTools)
+			}
+		})
+	}
+}
+
+func TestRouterRoutesRequests(t *testing.T) {
+	github := &fakeMCPServer{name: "github", tools: []string{"create_issue", "search"}}
+	search := &fakeMCPServer{name: "search", tools: []string{"search"}}
+	db := &fakeMCPServer{name: "db", resources: []string{"postgres://main/users"}}
+
+	handler := newTestRouter(t, &config.RoutingConfig{
+		Routes: []config.RouteConfig{
+			{Upstream: "db", URISchemes: []string{"postgres"}},
+			{Upstream: "db", Tools: []string{"sql_*"}},
+			{Upstream: "search", PathPrefix: "/search"},
+			{Upstream: "github", Methods: []string{"tools/*", "prompts/*"}},
+		},
+	}, github, search, db)
+
+	// Learn the tool names exposed to clients; search is only listed, and
+	// its prefixed names only resolved, under its path prefix, where its
+	// tool collides with github's
+	call(t, handler, "/", "", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	call(t, handler, "/search/mcp", "", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+
+	tests := []struct {
+		name         string
+		target       string
+		body         string
+		wantUpstream string
+		wantTool     string
+		wantCode     int
+	}{
+		{
+			name:         "prefixed tool",
+			target:       "/search/mcp",
+			body:         `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"search__search","arguments":{}}}`,
+			wantUpstream: "search",
+			wantTool:     "search",
+		},
+		{
+			name:         "learned tool",
+			target:       "/",
+			body:         `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"create_issue"}}`,
+			wantUpstream: "github",
+			wantTool:     "create_issue",
+		},
+		{
+			name:         "tool pattern",
+			target:       "/",
+			body:         `{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"sql_query"}}`,
+			wantUpstream: "db",
+			wantTool:     "sql_query",
+		},
+		{
+			name:         "URI scheme",
+			target:       "/",
+			body:         `{"jsonrpc":"2.0","id":5,"method":"resources/read","params":{"uri":"postgres://main/orders"}}`,
+			wantUpstream: "db",
+		},
+		{
+			name:         "path prefix",
+			target:       "/search/mcp",
+			body:         `{"jsonrpc":"2.0","id":6,"method":"ping"}`,
+			wantUpstream: "search",
+		},
+		{
+			name:         "method pattern",
+			target:       "/",
+			body:         `{"jsonrpc":"2.0","id":7,"method":"prompts/get","params":{"name":"review"}}`,
+			wantUpstream: "github",
+		},
+		{
+			name:     "no route",
+			target:   "/",
+			body:     `{"jsonrpc":"2.0","id":8,"method":"completion/complete"}`,
+			wantCode: schema.CodeMethodNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, resp := call(t, handler, tt.target, "", tt.body)
+
+			if tt.wantCode != 0 {
+				errObj, _ := resp["error"].(map[string]interface{})
+				if rr.Code != http.StatusNotFound || errObj["code"] != float64(tt.wantCode) {
+					t.Errorf("Got status %d and response %v, want 404 with code %d", rr.Code, resp, tt.wantCode)
+				}
+				return
+			}
+
+			result, _ := resp["result"].(map[string]interface{})
+			if result["upstream"] != tt.wantUpstream {
+				t.Errorf("Routed to %v, want %s", result["upstream"], tt.wantUpstream)
+			}
+			if params, _ := result["params"].(map[string]interface{}); tt.wantTool != "" && params["name"] != tt.wantTool {
+				t.Errorf("Upstream got tool %v, want %s", params["name"], tt.wantTool)
+			}
+		})
+	}
+}
+
+func TestRouterPrefixedToolPolicy(t *testing.T) {
+	github := &fakeMCPServer{name: "github", tools: []string{"search", "delete_repo"}}
+	search := &fakeMCPServer{name: "search", tools: []string{"search"}}
+
+	router := NewRouter(&config.RoutingConfig{
+		Routes: []config.RouteConfig{{Upstream: "github"}, {Upstream: "search"}},
+	})
+	for _, server := range []*fakeMCPServer{github, search} {
+		upstream := httptest.NewServer(server)
+		defer upstream.Close()
+		router.WithUpstream(server.name, newTestProxy(t, upstream.URL, time.Second))
+	}
+	handler := NewGatewayHandler(router).WithPolicyEngine(policy.NewEngineFromConfig(&config.PolicyConfig{
+		DefaultEffect: "allow",
+		Rules: []config.PolicyRule{
+			{ID: "deny-delete", Tools: []string{"delete_repo"}, Effect: "deny"},
+			{ID: "deny-search", Tools: []string{"search"}, Effect: "deny"},
+		},
+	}))
+	// search collides, so it is listed as github__search and
+	// search__search, and hidden under the upstream's own name
+	_, resp := call(t, handler, "/", "", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	result, _ := resp["result"].(map[string]interface{})
+	if tools, _ := result["tools"].([]interface{}); len(tools) != 0 {
+		t.Errorf("Listed %v, want every tool hidden", tools)
+	}
+
+	// github__delete_repo is not listed, so its prefix is not resolved;
+	// github__search is listed, and denied under the upstream's own name
+	for _, tool := range []string{"github__delete_repo", "github__search"} {
+		body := fmt.Sprintf(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":%q}}`, tool)
+		call(t, handler, "/", "", body)
+	}
+
+	for _, server := range []*fakeMCPServer{github, search} {
+		server.mu.Lock()
+		for _, c := range server.calls {
+			if c == "tools/call delete_repo" || c == "tools/call search" {
+				t.Errorf("Upstream %s received denied %s", server.name, c)
+			}
+		}
+		server.mu.Unlock()
+	}
+}
+
+func TestRouterAggregatesResources(t *testing.T) {
+	files := &fakeMCPServer{name: "files", resources: []string{"file:///a.txt", "file:///shared.txt"}}
+	db := &fakeMCPServer{name: "db", resources: []string{"postgres://main/users", "file:///shared.txt"}}
+
+	handler := newTestRouter(t, &config.RoutingConfig{
+		Routes: []config.RouteConfig{{Upstream: "files"}, {Upstream: "db"}},
+	}, files, db)
+
+	_, resp := call(t, handler, "/", "", `{"jsonrpc":"2.0","id":1,"method":"resources/list"}`)
+	result, _ := resp["result"].(map[string]interface{})
+	resources, _ := result["resources"].([]interface{})
+	if len(resources) != 3 {
+		t.Fatalf("Got %d resources, want 3 with the duplicate URI hidden: %v", len(resources), resources)
+	}
+
+	// Learned resources are read from the upstream that listed them
+	_, resp = call(t, handler, "/", "", `{"jsonrpc":"2.0","id":2,"method":"resources/read","params":{"uri":"postgres://main/users"}}`)
+	if result, _ := resp["result"].(map[string]interface{}); result["upstream"] != "db" {
+		t.Errorf("Routed resources/read to %v, want db", result["upstream"])
+	}
+}
+
+func TestRouterSessions(t *testing.T) {
+	github := &fakeMCPServer{name: "github", session: "gh-session"}
+	search := &fakeMCPServer{name: "search", session: "search-session"}
+
+	handler := newTestRouter(t, &config.RoutingConfig{
+		Routes: []config.RouteConfig{
+			{Upstream: "search", Methods: []string{"ping"}},
+			{Upstream: "github"},
+		},
+	}, github, search)
+
+	rr, resp := call(t, handler, "/", "", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18"}}`)
+	session := rr.Header().Get("Mcp-Session-Id")
+	if session == "" || session == "gh-session" || session == "search-session" {
+		t.Fatalf("Got session %q, want a gateway session", session)
+	}
+	result, _ := resp["result"].(map[string]interface{})
+	caps, _ := result["capabilities"].(map[string]interface{})
+	if caps["github"] == nil || caps["search"] == nil {
+		t.Errorf("Capabilities not merged: %v", caps)
+	}
+
+	call(t, handler, "/", session, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	rr, _ = call(t, handler, "/", session, `{"jsonrpc":"2.0","id":2,"method":"ping"}`)
+	if got := rr.Header().Get("Mcp-Session-Id"); got != session {
+		t.Errorf("Got session header %q on response, want %q", got, session)
+	}
+
+	calls, sessions := search.received()
+	if len(calls) != 3 || sessions[1] != "search-session" || sessions[2] != "search-session" {
+		t.Errorf("Search upstream got calls %v with sessions %v", calls, sessions)
+	}
+	if calls, _ := github.received(); len(calls) != 2 {
+		t.Errorf("Github upstream got calls %v, want initialize and the notification", calls)
+	}
+}
+
+func TestRouterToolIndex(t *testing.T) {
+	github := &fakeMCPServer{name: "github", tools: []string{"create_issue", "search"}}
+	search := &fakeMCPServer{name: "search", tools: []string{"search"}}
+
+	router := NewRouter(&config.RoutingConfig{
+		Routes: []config.RouteConfig{{Upstream: "github"}, {Upstream: "search"}},
+	})
+	for _, server := range []*fakeMCPServer{github, search} {
+		upstream := httptest.NewServer(server)
+		defer upstream.Close()
+		router.WithUpstream(server.name, newTestProxy(t, upstream.URL, time.Second))
+	}
+	handler := NewGatewayHandler(router)
+	set := candidateSet([]string{"github", "search"})
+
+	call(t, handler, "/", "", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	if _, ok := router.lookupTool(set, "github__search"); !ok {
+		t.Fatal("github__search not resolved after tools/list")
+	}
+
+	// Once the collision is gone, the next list replaces the prefixed names
+	github.mu.Lock()
+	github.tools = []string{"create_issue"}
+	github.mu.Unlock()
+	call(t, handler, "/", "", `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	if _, ok := router.lookupTool(set, "github__search"); ok {
+		t.Error("github__search still resolved after it was no longer listed")
+	}
+	if target, ok := router.lookupTool(set, "search"); !ok || target.upstream != "search" {
+		t.Errorf("Got %+v for search, want the search upstream", target)
+	}
+}
+
+func TestRouterCachedToolList(t *testing.T) {
+	github := &fakeMCPServer{name: "github", tools: []string{"search"}}
+	search := &fakeMCPServer{name: "search", tools: []string{"search"}}
+	upstreams := make(map[string]string)
+	for _, server := range []*fakeMCPServer{github, search} {
+		upstream := httptest.NewServer(server)
+		defer upstream.Close()
+		upstreams[server.name] = upstream.URL
+	}
+
+	// Gateways sharing a cache, e.g. replicas, each learn their routes
+	cfg := config.DefaultCacheConfig()
+	cfg.Methods = map[string]time.Duration{"tools/list": time.Minute}
+	responses := NewResponseCache(&cfg, cache.NewMemoryStore(100))
+	for i := 0; i < 2; i++ {
+		router := NewRouter(&config.RoutingConfig{
+			Routes: []config.RouteConfig{{Upstream: "github"}, {Upstream: "search"}},
+		})
+		for _, name := range []string{"github", "search"} {
+			router.WithUpstream(name, newTestProxy(t, upstreams[name], time.Second))
+		}
+		handler := NewGatewayHandler(router).WithCache(responses)
+
+		call(t, handler, "/", "", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+		_, resp := call(t, handler, "/", "", `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"search__search"}}`)
+		result, _ := resp["result"].(map[string]interface{})
+		if result["upstream"] != "search" {
+			t.Errorf("Gateway %d answered %v, want the call routed to search", i, resp)
+		}
+	}
+}
+
+func TestRouterSessionTTL(t *testing.T) {
+	router := NewRouter(&config.RoutingConfig{SessionTTL: 20 * time.Millisecond})
+
+	router.startSession("idle", map[string]string{"github": "gh-idle"})
+	router.startSession("active", map[string]string{"github": "gh-active"})
+	for i := 0; i < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if got := router.upstreamSession("active", "github"); got != "gh-active" {
+			t.Fatalf("Got upstream session %q for the active session, want gh-active", got)
+		}
+	}
+	if got := router.upstreamSession("idle", "github"); got != "" {
+		t.Errorf("Got upstream session %q for the idle session, want it expired", got)
+	}
+
+	// Starting a session sweeps the idle ones
+	router.startSession("old", map[string]string{"github": "gh-old"})
+	time.Sleep(30 * time.Millisecond)
+	router.startSession("new", map[string]string{"github": "gh-new"})
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+	if _, ok := router.sessions["old"]; ok || len(router.sessions) != 1 {
+		t.Errorf("Got %d sessions after the sweep, want only the new one", len(router.sessions))
+	}
+}
@@ -0,0 +1,112 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxEventSize bounds a single server-sent event
+const maxEventSize = 10 << 20
+
+// Event is a single server-sent event
+type Event struct {
+	ID    string
+	Event string
+	Data  []byte
+	Retry string
+}
+
+// EventReader reads server-sent events from a text/event-stream body
+type EventReader struct {
+	scanner *bufio.Scanner
+}
+
+// NewEventReader creates a new event reader
+func NewEventReader(r io.Reader) *EventReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+	return &EventReader{scanner: scanner}
+}
+
+// Next returns the next event in the stream, or io.EOF when the stream ends
+func (r *EventReader) Next() (*Event, error) {
+	var ev Event
+	var data [][]byte
+	seen := false
+
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+
+		// A blank line dispatches the event
+		if line == "" {
+			if seen {
+				ev.Data = bytes.Join(data, []byte("\n"))
+				return &ev, nil
+			}
+			continue
+		}
+
+		// Comment lines are keep-alives
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		seen = true
+
+		switch field {
+		case "id":
+			ev.ID = value
+		case "event":
+			ev.Event = value
+		case "data":
+			data = append(data, []byte(value))
+		case "retry":
+			ev.Retry = value
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if seen {
+		ev.Data = bytes.Join(data, []byte("\n"))
+		return &ev, nil
+	}
+	return nil, io.EOF
+}
+
+// WriteEvent writes an event in text/event-stream format
+func WriteEvent(w io.Writer, ev *Event) error {
+	var buf bytes.Buffer
+	if ev.ID != "" {
+		fmt.Fprintf(&buf, "id: %s\n", ev.ID)
+	}
+	if ev.Event != "" {
+		fmt.Fprintf(&buf, "event: %s\n", ev.Event)
+	}
+	if ev.Retry != "" {
+		fmt.Fprintf(&buf, "retry: %s\n", ev.Retry)
+	}
+	if len(ev.Data) > 0 {
+		for _, line := range bytes.Split(ev.Data, []byte("\n")) {
+			fmt.Fprintf(&buf, "data: %s\n", line)
+		}
+	}
+	buf.WriteByte('\n')
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// isEventStream reports whether the header describes a text/event-stream body
+func isEventStream(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestEventReader(t *testing.T) {
+	stream := ": keep-alive\n\n" +
+		"id: 1\nevent: message\ndata: {\"a\":1}\n\n" +
+		"data: line one\ndata: line two\n\n" +
+		"id: 3\n\n" +
+		"data: trailing"
+
+	events := NewEventReader(strings.NewReader(stream))
+
+	want := []Event{
+		{ID: "1", Event: "message", Data: []byte(`{"a":1}`)},
+		{Data: []byte("line one\nline two")},
+		{ID: "3"},
+		{Data: []byte("trailing")},
+	}
+
+	for i, w := range want {
+		ev, err := events.Next()
+		if err != nil {
+			t.Fatalf("Event %d: Next() error = %v", i, err)
+		}
+		if ev.ID != w.ID || ev.Event != w.Event || !bytes.Equal(ev.Data, w.Data) {
+			t.Errorf("Event %d: got %+v, want %+v", i, ev, w)
+		}
+	}
+
+	if _, err := events.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, want io.EOF", err)
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteEvent(&buf, &Event{ID: "7", Event: "message", Data: []byte("a\nb")}); err != nil {
+		t.Fatalf("WriteEvent() error = %v", err)
+	}
+
+	want := "id: 7\nevent: message\ndata: a\ndata: b\n\n"
+	if buf.String() != want {
+		t.Errorf("WriteEvent() wrote %q, want %q", buf.String(), want)
+	}
+
+	ev, err := NewEventReader(&buf).Next()
+	if err != nil || ev.ID != "7" || string(ev.Data) != "a\nb" {
+		t.Errorf("Round trip got %+v, %v", ev, err)
+	}
+}
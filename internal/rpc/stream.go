@@ -0,0 +1,228 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"safectx/internal/capture"
+	"safectx/pkg/schema"
+)
+
+// serveSession relays the GET and DELETE requests of the Streamable HTTP
+// transport. GET opens the server-to-client SSE stream and DELETE ends the
+// MCP session.
+func (g *Gateway) serveSession(w http.ResponseWriter, r *http.Request) {
+	sf, ok := g.upstream.(SessionForwarder)
+	if !ok {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp, err := sf.ForwardSession(r)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, ErrUpstreamTimeout) {
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, http.StatusText(status), status)
+		log.Printf("Error forwarding %s session request: %v", r.Method, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	session := r.Header.Get("Mcp-Session-Id")
+	if r.Method == http.MethodDelete && resp.StatusCode < http.StatusBadRequest {
+		g.inspector.EndSession(session)
+		g.origins.End(session)
+	}
+	if isEventStream(resp.Header) {
+		log.Printf("Opened server stream for session %q", session)
+		g.relayStream(w, r, resp, session, nil, nil)
+		return
+	}
+	g.relayBody(w, r, resp, session, nil, nil)
+}
+
+// relayBody copies a non-streaming upstream response to the client. The
+// body is run through the response filter first, whatever its
+// Content-Type, and blocked if it is not JSON-RPC; r is the client
+// request, session the caller's MCP session and req the request they
+// answer, if known. The response is recorded in rec.
+func (g *Gateway) relayBody(w http.ResponseWriter, r *http.Request, resp *UpstreamResponse, session string, req *schema.MCPRequest, rec *capture.Record) {
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ended(err) {
+			status, code, message := forwardError(err)
+			writeError(w, status, requestID(req), code, message)
+		} else {
+			writeError(w, http.StatusBadGateway, schema.ID{}, schema.CodeUpstreamError, "Invalid upstream response")
+		}
+		log.Printf("Error reading upstream response: %v", err)
+		return
+	}
+
+	status := resp.StatusCode
+	raw := body
+	body, verdict := g.inspector.InspectResponse(SubjectFrom(r), session, body, req, UpstreamTools(g.upstream, r))
+	rec.Respond(raw, verdict)
+	if verdict.Blocked() {
+		status = StatusForCode(schema.CodeResponseBlocked)
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Error relaying upstream response: %v", err)
+	}
+}
+
+// relayStream copies server-sent events from the upstream to the client one
+// at a time. Every JSON-RPC message is inspected before it is written, and
+// the response is flushed after each event. r is the client request,
+// session its MCP session, and req the request the stream answers, or nil
+// for the session stream. The response to req is recorded in rec.
+func (g *Gateway) relayStream(w http.ResponseWriter, r *http.Request, resp *UpstreamResponse, session string, req *schema.MCPRequest, rec *capture.Record) {
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(resp.StatusCode)
+
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		log.Printf("Streaming response cannot be flushed: %v", err)
+	}
+
+	events := NewEventReader(resp.Body)
+	for {
+		ev, err := events.Next()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Error reading upstream stream: %v", err)
+			}
+			if ended(err) && req != nil && !req.IsNotification() {
+				// Answer the request, since the upstream never will
+				_, code, message := forwardError(err)
+				data, _ := json.Marshal(schema.NewErrorResponse(req.ID, code, message))
+				WriteEvent(w, &Event{Data: data})
+				rc.Flush()
+			}
+			return
+		}
+
+		if len(ev.Data) > 0 {
+			upstream := resp.Upstream
+			if resp.Merged {
+				upstream, ev.ID = ev.ID, ""
+			}
+			data, ok := g.inspectEvent(r, session, upstream, ev.Data, req, rec)
+			if !ok {
+				// Keep the event ID so the client can still resume the
+				// stream, but drop the blocked message
+				ev.Data = nil
+			} else {
+				ev.Data = data
+			}
+		}
+
+		if err := WriteEvent(w, ev); err != nil {
+			log.Printf("Error writing event to client: %v", err)
+			return
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("Error flushing event to client: %v", err)
+			return
+		}
+	}
+}
+
+// readStreamResponse reads an upstream SSE stream until the response to req
+// arrives. Other messages on the stream are inspected and dropped, since a
+// batch response has no place for them. The response is recorded in rec.
+func (g *Gateway) readStreamResponse(r *http.Request, resp *UpstreamResponse, session string, req *schema.MCPRequest, rec *capture.Record) ([]byte, error) {
+	events := NewEventReader(resp.Body)
+	for {
+		ev, err := events.Next()
+		if err != nil {
+			return nil, err
+		}
+		if len(ev.Data) == 0 {
+			continue
+		}
+
+		data, ok := g.inspectEvent(r, session, resp.Upstream, ev.Data, req, rec)
+		if !ok {
+			continue
+		}
+		if isResponseTo(data, req) {
+			return data, nil
+		}
+		log.Printf("Dropped stream message while waiting for response %s", req.ID)
+	}
+}
+
+// inspectEvent runs a message the named upstream sent through the
+// inspector on behalf of the client request r. Server requests that pass
+// are remembered, so the client's response can be routed back to their
+// upstream; blocked ones are answered upstream in place of the client. The
+// response to req is recorded in rec.
+func (g *Gateway) inspectEvent(r *http.Request, session, upstream string, data []byte, req *schema.MCPRequest, rec *capture.Record) ([]byte, bool) {
+	subject := SubjectFrom(r)
+	if rec == nil || !isResponseTo(data, req) {
+		out, reply, ok := g.inspector.InspectServerMessage(subject, session, upstream, data, req, UpstreamTools(g.upstream, r))
+		if reply != nil {
+			g.answerServer(r, upstream, reply)
+		}
+		if ok {
+			g.origins.Track(session, upstream, out)
+		}
+		return out, ok
+	}
+	out, verdict := g.inspector.InspectResponse(subject, session, data, req, UpstreamTools(g.upstream, r))
+	rec.Respond(data, verdict)
+	return out, true
+}
+
+// isResponseTo reports whether a server-sent message is the response to
+// req
+func isResponseTo(data []byte, req *schema.MCPRequest) bool {
+	if req == nil {
+		return false
+	}
+	want, _ := json.Marshal(req.ID)
+	var msg struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	return json.Unmarshal(data, &msg) == nil && msg.Method == "" && bytes.Equal(msg.ID, want)
+}
+
+// ended reports whether err is the end of a request that was cancelled or
+// ran past its deadline
+func ended(err error) bool {
+	return errors.Is(err, ErrRequestCancelled) || errors.Is(err, ErrUpstreamTimeout)
+}
+
+// requestID returns the ID of req, or the zero ID if req is unknown
+func requestID(req *schema.MCPRequest) schema.ID {
+	if req == nil {
+		return schema.ID{}
+	}
+	return req.ID
+}
+
+// isJSON reports whether the header describes a JSON body
+func isJSON(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
@@ -0,0 +1,471 @@
+package stdio
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"safectx/internal/config"
+	"safectx/internal/rpc"
+	"safectx/pkg/schema"
+)
+
+// maxMessageSize bounds a single line of JSON-RPC read from the child
+const maxMessageSize = 10 << 20
+
+// ErrProcessClosed is returned once the process has been shut down
+var ErrProcessClosed = errors.New("process closed")
+
+// Process supervises an MCP server that speaks newline-delimited JSON-RPC
+// over stdin/stdout. It restarts the process when it crashes and implements
+// rpc.Forwarder so the gateway can expose it over HTTP.
+type Process struct {
+	cfg *config.StdioConfig
+
+	mu          sync.Mutex
+	stdin       io.WriteCloser
+	cmd         *exec.Cmd
+	readers     *sync.WaitGroup
+	pending     map[string]chan []byte
+	nextID      int64
+	subscribers map[chan []byte]struct{}
+	onMessage   func([]byte)
+	onExit      func()
+	closed      bool
+
+	writeMu sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewProcess creates a new supervised process. It is not started until
+// Start is called.
+func NewProcess(cfg *config.StdioConfig) *Process {
+	return &Process{
+		cfg:         cfg,
+		pending:     make(map[string]chan []byte),
+		subscribers: make(map[chan []byte]struct{}),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// OnMessage registers a handler for messages from the process that are not
+// responses to a forwarded request, i.e. server requests, notifications and
+// responses to messages written with Send. It must be called before Start.
+func (p *Process) OnMessage(handler func([]byte)) {
+	p.onMessage = handler
+}
+
+// OnExit registers a handler called each time the process exits, after the
+// requests forwarded to it have been failed. It must be called before Start.
+func (p *Process) OnExit(handler func()) {
+	p.onExit = handler
+}
+
+// Start launches the process and keeps restarting it until Close is called
+func (p *Process) Start() error {
+	if err := p.launch(); err != nil {
+		return err
+	}
+	go p.supervise()
+	return nil
+}
+
+// Close stops the process. Its stdin is closed first so it can exit cleanly;
+// it is killed if it is still running after the shutdown timeout.
+func (p *Process) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.stop)
+	stdin, cmd := p.stdin, p.cmd
+	p.mu.Unlock()
+
+	if cmd == nil {
+		// Never started
+		return nil
+	}
+
+	if stdin != nil {
+		stdin.Close()
+	}
+
+	select {
+	case <-p.done:
+	case <-time.After(p.cfg.ShutdownTimeout):
+		log.Printf("MCP server %s did not exit after %s, killing it", p.cfg.Command, p.cfg.ShutdownTimeout)
+		p.mu.Lock()
+		cmd = p.cmd
+		p.mu.Unlock()
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+		<-p.done
+	}
+	return nil
+}
+
+// Send writes a single JSON-RPC message to the process
+func (p *Process) Send(msg []byte) error {
+	p.mu.Lock()
+	stdin, closed := p.stdin, p.closed
+	p.mu.Unlock()
+
+	if closed {
+		return ErrProcessClosed
+	}
+	if stdin == nil {
+		return fmt.Errorf("%w: process is not running", rpc.ErrUpstreamUnavailable)
+	}
+
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if _, err := stdin.Write(append(msg, '\n')); err != nil {
+		return fmt.Errorf("%w: %v", rpc.ErrUpstreamUnavailable, err)
+	}
+	return nil
+}
+
+// Forward implements the rpc.Forwarder interface. Request IDs are rewritten
+// so concurrent HTTP clients cannot collide, and restored on the response.
+// A request waits for its response until the deadline of r, or the
+// configured request timeout if r has none. When r ends first, the process
+// is sent notifications/cancelled for the rewritten ID; cancellations sent
+// by clients name IDs the process never saw, so they are dropped.
+func (p *Process) Forward(r *http.Request, req *schema.MCPRequest) (*rpc.UpstreamResponse, error) {
+	if req.Method == schema.MethodCancelled {
+		return newResponse(http.StatusAccepted, "", nil), nil
+	}
+	if req.IsNotification() {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.Send(data); err != nil {
+			return nil, err
+		}
+		return newResponse(http.StatusAccepted, "", nil), nil
+	}
+
+	p.mu.Lock()
+	p.nextID++
+	id := p.nextID
+	ch := make(chan []byte, 1)
+	key := strconv.FormatInt(id, 10)
+	p.pending[key] = ch
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, key)
+		p.mu.Unlock()
+	}()
+
+	out := *req
+	out.ID = schema.NumberID(id)
+	data, err := json.Marshal(&out)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Send(data); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := r.Context(), context.CancelFunc(func() {})
+	if _, ok := ctx.Deadline(); !ok {
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.RequestTimeout)
+	}
+	defer cancel()
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, fmt.Errorf("%w: process exited", rpc.ErrUpstreamUnavailable)
+		}
+		body, err := restoreID(resp, req.ID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid response: %v", rpc.ErrUpstreamUnavailable, err)
+		}
+		return newResponse(http.StatusOK, "application/json", body), nil
+	case <-ctx.Done():
+		p.cancel(id, ctx.Err())
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: no response before the deadline", rpc.ErrUpstreamTimeout)
+		}
+		return nil, fmt.Errorf("%w: %v", rpc.ErrUpstreamUnavailable, ctx.Err())
+	}
+}
+
+// cancel tells the process to stop working on the request with the
+// rewritten ID
+func (p *Process) cancel(id int64, reason error) {
+	data, _ := json.Marshal(&schema.MCPRequest{
+		JSONRPC: schema.Version,
+		Method:  schema.MethodCancelled,
+		Params:  map[string]interface{}{"requestId": id, "reason": reason.Error()},
+	})
+	if err := p.Send(data); err != nil {
+		log.Printf("Failed to cancel request %d: %v", id, err)
+	}
+}
+
+// ForwardResponse implements the rpc.ResponseForwarder interface. Server
+// requests reach the client with the IDs the process chose, so responses
+// are written as they are.
+func (p *Process) ForwardResponse(r *http.Request, upstream string, data []byte) (*rpc.UpstreamResponse, error) {
+	if err := p.Send(data); err != nil {
+		return nil, err
+	}
+	return newResponse(http.StatusAccepted, "", nil), nil
+}
+
+// ForwardSession implements the rpc.SessionForwarder interface. A GET opens
+// a stream of the messages the process sends on its own, such as
+// notifications and server requests.
+func (p *Process) ForwardSession(r *http.Request) (*rpc.UpstreamResponse, error) {
+	if r.Method != http.MethodGet {
+		return newResponse(http.StatusMethodNotAllowed, "text/plain", []byte("Method not allowed\n")), nil
+	}
+
+	ch := make(chan []byte, 64)
+	p.mu.Lock()
+	p.subscribers[ch] = struct{}{}
+	p.mu.Unlock()
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			delete(p.subscribers, ch)
+			p.mu.Unlock()
+			pw.Close()
+		}()
+
+		for {
+			select {
+			case msg := <-ch:
+				if err := rpc.WriteEvent(pw, &rpc.Event{Data: msg}); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+
+	resp := newResponse(http.StatusOK, "text/event-stream", nil)
+	resp.Body = pr
+	return resp, nil
+}
+
+// supervise restarts the process whenever it exits, with exponential
+// backoff, until Close is called
+func (p *Process) supervise() {
+	defer close(p.done)
+
+	delay := p.cfg.RestartDelay
+	for {
+		started := time.Now()
+		err := p.wait()
+
+		p.mu.Lock()
+		p.stdin = nil
+		for key, ch := range p.pending {
+			close(ch)
+			delete(p.pending, key)
+		}
+		closed := p.closed
+		p.mu.Unlock()
+
+		if p.onExit != nil {
+			p.onExit()
+		}
+		if closed {
+			log.Printf("MCP server %s stopped", p.cfg.Command)
+			return
+		}
+
+		// A process that ran for a while gets a fresh backoff
+		if time.Since(started) > p.cfg.MaxRestartDelay {
+			delay = p.cfg.RestartDelay
+		}
+		log.Printf("MCP server %s exited: %v; restarting in %s", p.cfg.Command, err, delay)
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(delay):
+			}
+
+			delay = min(delay*2, p.cfg.MaxRestartDelay)
+			if err := p.launch(); err != nil {
+				log.Printf("Failed to restart MCP server %s: %v; retrying in %s", p.cfg.Command, err, delay)
+				continue
+			}
+			break
+		}
+	}
+}
+
+// launch starts a new instance of the process and its output readers
+func (p *Process) launch() error {
+	cmd := exec.Command(p.cfg.Command, p.cfg.Args...)
+	cmd.Dir = p.cfg.Dir
+	cmd.Env = append(os.Environ(), p.cfg.Env...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", p.cfg.Command, err)
+	}
+	log.Printf("Started MCP server %s (pid %d)", p.cfg.Command, cmd.Process.Pid)
+
+	readers := &sync.WaitGroup{}
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		p.captureStderr(stderr)
+	}()
+	go func() {
+		defer readers.Done()
+		p.readStdout(stdout)
+	}()
+
+	p.mu.Lock()
+	p.cmd = cmd
+	p.stdin = stdin
+	p.readers = readers
+	if p.closed {
+		// Close raced with a restart, so let the new instance exit too
+		stdin.Close()
+	}
+	p.mu.Unlock()
+	return nil
+}
+
+// wait blocks until the current process exits. Its output is drained
+// before the process is reaped, as required by exec.Cmd.
+func (p *Process) wait() error {
+	p.mu.Lock()
+	cmd, readers := p.cmd, p.readers
+	p.mu.Unlock()
+
+	readers.Wait()
+	return cmd.Wait()
+}
+
+// captureStderr copies the process's stderr into the gateway log
+func (p *Process) captureStderr(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		log.Printf("[%s] %s", p.cfg.Command, scanner.Text())
+	}
+}
+
+// readStdout reads JSON-RPC messages from the process's stdout
+func (p *Process) readStdout(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			p.dispatch([]byte(line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading from MCP server %s: %v", p.cfg.Command, err)
+	}
+}
+
+// dispatch routes a message from the process to the forwarded request it
+// answers, or to the message handler and stream subscribers
+func (p *Process) dispatch(msg []byte) {
+	var probe struct {
+		ID     schema.ID `json:"id"`
+		Method string    `json:"method"`
+	}
+	if err := json.Unmarshal(msg, &probe); err == nil && probe.Method == "" && !probe.ID.IsZero() {
+		p.mu.Lock()
+		ch, ok := p.pending[probe.ID.String()]
+		p.mu.Unlock()
+		if ok {
+			select {
+			case ch <- msg:
+			default:
+				log.Printf("Dropped duplicate response %s from MCP server", probe.ID)
+			}
+			return
+		}
+	}
+
+	if p.onMessage != nil {
+		p.onMessage(msg)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ch := range p.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			log.Printf("Dropped message for slow stream subscriber")
+		}
+	}
+}
+
+// restoreID replaces the id of a response with the client's original id
+func restoreID(msg []byte, id schema.ID) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(msg, &fields); err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+	fields["id"] = raw
+	return json.Marshal(fields)
+}
+
+// newResponse creates an upstream response with an in-memory body
+func newResponse(status int, contentType string, body []byte) *rpc.UpstreamResponse {
+	header := make(http.Header)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return &rpc.UpstreamResponse{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(string(body))),
+	}
+}
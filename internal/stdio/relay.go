@@ -0,0 +1,202 @@
+package stdio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+
+	"safectx/internal/pipeline"
+	"safectx/internal/rpc"
+	"safectx/pkg/schema"
+)
+
+// Relay is a transparent stdio-to-stdio bridge. Messages from the client on
+// in are inspected and written to the process; messages from the process
+// are inspected and written to the client on out.
+type Relay struct {
+	process   *Process
+	inspector *rpc.Inspector
+	out       io.Writer
+	mu        sync.Mutex
+	// session identifies the client in the pipeline, since a relay serves
+	// a single client
+	session string
+
+	pendingMu sync.Mutex
+	// pending maps the IDs of forwarded requests to the requests, so
+	// responses can be inspected alongside the request they answer
+	pending map[string]*schema.MCPRequest
+}
+
+// NewRelay creates a relay between the client streams and the process. It
+// must be created before the process is started.
+func NewRelay(process *Process, inspector *rpc.Inspector, out io.Writer) *Relay {
+	relay := &Relay{
+		process:   process,
+		inspector: inspector,
+		out:       out,
+		session:   rpc.NewCorrelationID(),
+		pending:   make(map[string]*schema.MCPRequest),
+	}
+	process.OnMessage(relay.fromServer)
+	process.OnExit(relay.failPending)
+	return relay
+}
+
+// Run relays client messages from in until it is closed
+func (r *Relay) Run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := r.fromClient(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// fromClient inspects a client message and forwards it to the process, or
+// answers the client directly if it is blocked
+func (r *Relay) fromClient(line []byte) error {
+	var probe struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(line, &probe); err == nil && probe.Method == "" && line[0] == '{' {
+		// Responses to server requests carry user input and model output
+		data, ok := r.inspector.InspectClientResponse(line)
+		if !ok {
+			return nil
+		}
+		return r.send(data)
+	}
+
+	req, err := schema.ParseRequest(line)
+	if err != nil {
+		code := schema.CodeInvalidRequest
+		var verr *schema.ValidationError
+		if errors.As(err, &verr) {
+			code = verr.Code()
+		}
+		log.Printf("Schema validation failed: %v", err)
+		return r.reply(schema.NewErrorResponse(req.ID, code, err.Error()))
+	}
+
+	call := &pipeline.Call{Request: req, CorrelationID: rpc.NewCorrelationID(), Session: r.session}
+	if rpcErr := r.inspector.Screen(call); rpcErr != nil {
+		if req.IsNotification() {
+			return nil
+		}
+		return r.reply(&schema.MCPResponse{JSONRPC: schema.Version, ID: req.ID, Error: rpcErr})
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if req.IsNotification() {
+		return r.send(data)
+	}
+	r.track(req)
+	err = r.process.Send(data)
+	if errors.Is(err, rpc.ErrUpstreamUnavailable) {
+		log.Printf("Failed client request: %v", err)
+		r.untrack(req.ID)
+		return r.reply(schema.NewErrorResponse(req.ID, schema.CodeUpstreamError, "Upstream unavailable"))
+	}
+	return err
+}
+
+// send writes a message to the process. A process that is restarting
+// fails the message instead of stopping the relay.
+func (r *Relay) send(msg []byte) error {
+	err := r.process.Send(msg)
+	if errors.Is(err, rpc.ErrUpstreamUnavailable) {
+		log.Printf("Dropped client message: %v", err)
+		return nil
+	}
+	return err
+}
+
+// fromServer inspects a message from the process and writes it to the
+// client. A blocked server request is answered to the process instead.
+func (r *Relay) fromServer(msg []byte) {
+	var probe struct {
+		ID     schema.ID `json:"id"`
+		Method string    `json:"method"`
+	}
+	var req *schema.MCPRequest
+	if err := json.Unmarshal(msg, &probe); err == nil && probe.Method == "" && !probe.ID.IsZero() {
+		req = r.untrack(probe.ID)
+	}
+
+	data, reply, ok := r.inspector.InspectServerMessage(nil, r.session, rpc.DefaultUpstreamName, msg, req, nil)
+	if reply != nil {
+		if err := r.send(reply); err != nil {
+			log.Printf("Error answering blocked server request: %v", err)
+		}
+	}
+	if !ok {
+		return
+	}
+	if err := r.write(data); err != nil {
+		log.Printf("Error writing to client: %v", err)
+	}
+}
+
+// track records a forwarded request until its response arrives
+func (r *Relay) track(req *schema.MCPRequest) {
+	key, _ := json.Marshal(req.ID)
+	r.pendingMu.Lock()
+	r.pending[string(key)] = req
+	r.pendingMu.Unlock()
+}
+
+// untrack removes and returns the forwarded request with the given ID
+func (r *Relay) untrack(id schema.ID) *schema.MCPRequest {
+	key, _ := json.Marshal(id)
+	r.pendingMu.Lock()
+	defer r.pendingMu.Unlock()
+	req := r.pending[string(key)]
+	delete(r.pending, string(key))
+	return req
+}
+
+// failPending answers the forwarded requests still awaiting a response when
+// the process exits, since the process will never answer them
+func (r *Relay) failPending() {
+	r.pendingMu.Lock()
+	pending := r.pending
+	r.pending = make(map[string]*schema.MCPRequest)
+	r.pendingMu.Unlock()
+
+	for _, req := range pending {
+		if err := r.reply(schema.NewErrorResponse(req.ID, schema.CodeUpstreamError, "Upstream unavailable")); err != nil {
+			log.Printf("Error writing to client: %v", err)
+		}
+	}
+}
+
+// reply writes a gateway generated response to the client
+func (r *Relay) reply(resp *schema.MCPResponse) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return r.write(data)
+}
+
+// write writes a single newline-delimited message to the client
+func (r *Relay) write(msg []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err := r.out.Write(append(msg, '\n'))
+	return err
+}
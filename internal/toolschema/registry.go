@@ -0,0 +1,127 @@
+// Package toolschema keeps the input schemas of MCP tools, pinned from
+// local files or learned from the tools/list results of the upstream
+package toolschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"safectx/internal/config"
+	"safectx/internal/jsonschema"
+)
+
+// Registry holds the compiled input schema of every known tool. Learned
+// schemas are kept per upstream, under the upstream's own tool names, and
+// each upstream's set belongs to the generation of its tool list that it
+// was learned from.
+type Registry struct {
+	pinned      map[string]*jsonschema.Schema
+	learn       bool
+	denyUnknown bool
+
+	mu      sync.RWMutex
+	learned map[learnedKey]map[string]*jsonschema.Schema
+	// generations holds the current generation of each upstream's tool
+	// list, which starts anew when the upstream reports a change
+	generations map[string]uint64
+}
+
+// learnedKey identifies the schemas learned from one generation of an
+// upstream's tool list
+type learnedKey struct {
+	upstream   string
+	generation uint64
+}
+
+// Tool is a tool of a tools/list result
+type Tool struct {
+	Name        string          `json:"name"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+}
+
+// NewRegistry creates a registry with the schemas pinned in cfg. It fails
+// if a schema file cannot be read or compiled.
+func NewRegistry(cfg *config.ArgumentsConfig) (*Registry, error) {
+	r := &Registry{
+		pinned:      make(map[string]*jsonschema.Schema, len(cfg.Schemas)),
+		learn:       cfg.Learn,
+		denyUnknown: cfg.UnknownTools == config.UnknownToolsDeny,
+		learned:     make(map[learnedKey]map[string]*jsonschema.Schema),
+		generations: make(map[string]uint64),
+	}
+	for tool, file := range cfg.Schemas {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("schema of tool %s: %w", tool, err)
+		}
+		s, err := jsonschema.Compile(data)
+		if err != nil {
+			return nil, fmt.Errorf("schema of tool %s in %s: %w", tool, file, err)
+		}
+		r.pinned[tool] = s
+	}
+	return r, nil
+}
+
+// Pinned returns the input schema pinned for the named tool
+func (r *Registry) Pinned(name string) (*jsonschema.Schema, bool) {
+	s, ok := r.pinned[name]
+	return s, ok
+}
+
+// Learned returns the input schema learned from the current tool list of
+// upstream for tool, the upstream's own name of it
+func (r *Registry) Learned(upstream, tool string) (*jsonschema.Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.learned[learnedKey{upstream, r.generations[upstream]}][tool]
+	return s, ok
+}
+
+// DenyUnknown reports whether calls of tools without a known schema are
+// denied
+func (r *Registry) DenyUnknown() bool {
+	return r.denyUnknown
+}
+
+// Learn replaces the input schemas learned from upstream with those of
+// tools, the upstream's full tool list under its own names, so tools it no
+// longer lists are forgotten. Pinned tools keep their schema. A tool whose
+// schema does not compile is left out, so it is treated as unknown.
+func (r *Registry) Learn(upstream string, tools []Tool) {
+	if r == nil || !r.learn {
+		return
+	}
+
+	learned := make(map[string]*jsonschema.Schema, len(tools))
+	for _, tool := range tools {
+		if _, ok := r.pinned[tool.Name]; ok || tool.Name == "" || len(tool.InputSchema) == 0 {
+			continue
+		}
+		s, err := jsonschema.Compile(tool.InputSchema)
+		if err != nil {
+			log.Printf("Ignoring the input schema of tool %s of %s: %v", tool.Name, upstream, err)
+			continue
+		}
+		learned[tool.Name] = s
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.learned[learnedKey{upstream, r.generations[upstream]}] = learned
+}
+
+// Forget starts a new generation of upstream's tool list, dropping the
+// schemas learned from it until it is listed again
+func (r *Registry) Forget(upstream string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.learned, learnedKey{upstream, r.generations[upstream]})
+	r.generations[upstream]++
+}
@@ -0,0 +1,77 @@
+package toolschema
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"safectx/internal/config"
+)
+
+func TestRegistryLearn(t *testing.T) {
+	cfg := config.DefaultArgumentsConfig()
+	r, err := NewRegistry(&cfg)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	tool := func(name, inputSchema string) Tool {
+		return Tool{Name: name, InputSchema: json.RawMessage(inputSchema)}
+	}
+
+	r.Learn("github", []Tool{tool("search", `{"type":"object","required":["q"]}`), tool("delete_repo", `{"type":"object"}`)})
+	s, ok := r.Learned("github", "search")
+	if !ok || s.Valid(map[string]interface{}{}) {
+		t.Fatal("Learned() did not return the learned schema")
+	}
+	if _, ok := r.Learned("gitlab", "search"); ok {
+		t.Error("Learned() returned the schema of another upstream's tool")
+	}
+
+	// A later listing replaces the whole set: a tool no longer listed is
+	// forgotten, and so is one whose schema does not compile
+	r.Learn("github", []Tool{tool("search", `{"type":"object"}`), tool("list", `{"type":"object","pattern":"("}`)})
+	if s, _ := r.Learned("github", "search"); !s.Valid(map[string]interface{}{}) {
+		t.Error("Learned() returned the replaced schema")
+	}
+	for _, name := range []string{"delete_repo", "list"} {
+		if _, ok := r.Learned("github", name); ok {
+			t.Errorf("Learned() returned a schema for %s", name)
+		}
+	}
+
+	// A changed list is forgotten until it is listed again
+	r.Forget("github")
+	if _, ok := r.Learned("github", "search"); ok {
+		t.Error("Learned() returned a schema of the list before the change")
+	}
+	r.Learn("github", []Tool{tool("search", `{"type":"object"}`)})
+	if _, ok := r.Learned("github", "search"); !ok {
+		t.Error("Learned() did not return the schema listed after the change")
+	}
+
+	cfg.Learn = false
+	r, _ = NewRegistry(&cfg)
+	r.Learn("github", []Tool{tool("search", `{"type":"object"}`)})
+	if _, ok := r.Learned("github", "search"); ok {
+		t.Error("Registry learned a schema with learning disabled")
+	}
+}
+
+func TestNewRegistryErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"$ref":"https://example.com/tool.json"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, file := range map[string]string{"missing file": filepath.Join(dir, "missing.json"), "invalid schema": invalid} {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.DefaultArgumentsConfig()
+			cfg.Schemas = map[string]string{"deploy": file}
+			if _, err := NewRegistry(&cfg); err == nil {
+				t.Error("NewRegistry() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"safectx/internal/middleware"
+	"safectx/internal/pipeline"
+	"safectx/internal/policy"
+	"safectx/internal/rpc"
+	"safectx/pkg/schema"
+)
+
+// closeTimeout bounds writing the close frame
+const closeTimeout = time.Second
+
+// closeError ends a connection with a WebSocket close code
+type closeError struct {
+	code   int
+	reason string
+}
+
+// conn relays the messages of a single client connection
+type conn struct {
+	client       *websocket.Conn
+	upstream     upstream
+	inspector    *rpc.Inspector
+	subject      *policy.Subject
+	path         string
+	session      string
+	limiter      *middleware.RateLimiter
+	closeOnBlock bool
+
+	writeMu sync.Mutex
+	closed  bool
+
+	mu sync.Mutex
+	// pending maps the IDs of forwarded requests to the requests, so
+	// responses can be inspected alongside the request they answer
+	pending map[string]*schema.MCPRequest
+	// origins remembers the upstream of each server request relayed to
+	// the client, so its response goes back to the same upstream
+	origins *rpc.ServerOrigins
+}
+
+// run relays client messages until either side closes the connection
+func (c *conn) run() {
+	go func() {
+		err := c.upstream.Receive()
+		if err != nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			log.Printf("WebSocket upstream closed: %v", err)
+		}
+		c.close(websocket.CloseGoingAway, "upstream closed")
+	}()
+
+	for {
+		_, data, err := c.client.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Error reading WebSocket frame: %v", err)
+			}
+			if errors.Is(err, websocket.ErrReadLimit) {
+				c.close(websocket.CloseMessageTooBig, "message too big")
+			}
+			break
+		}
+		if cerr := c.fromClient(data); cerr != nil {
+			c.close(cerr.code, cerr.reason)
+			break
+		}
+	}
+	c.close(websocket.CloseNormalClosure, "")
+	c.upstream.Close()
+	c.inspector.EndSession(c.session)
+}
+
+// fromClient inspects a client frame and sends the allowed messages
+// upstream. Batches are split: every element is screened and forwarded on
+// its own, and the errors for rejected elements are sent back as a batch.
+func (c *conn) fromClient(data []byte) *closeError {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '[' {
+		resp, cerr := c.handle(data)
+		if resp != nil {
+			c.writeJSON(resp)
+		}
+		return cerr
+	}
+
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil || len(elements) == 0 {
+		c.writeJSON(schema.NewErrorResponse(schema.ID{}, schema.CodeInvalidRequest, "invalid request: malformed batch"))
+		return nil
+	}
+
+	var responses []*schema.MCPResponse
+	var blocked *closeError
+	for _, element := range elements {
+		resp, cerr := c.handle(element)
+		if resp != nil {
+			responses = append(responses, resp)
+		}
+		if blocked == nil {
+			blocked = cerr
+		}
+	}
+	if len(responses) > 0 {
+		c.writeJSON(responses)
+	}
+	return blocked
+}
+
+// handle screens a single client message and sends it upstream if it is
+// allowed. It returns the error response for a rejected request, and a
+// close error if the connection must be closed.
+func (c *conn) handle(data []byte) (*schema.MCPResponse, *closeError) {
+	var probe struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if err := json.Unmarshal(data, &probe); err == nil && probe.Method == "" && len(data) > 0 && data[0] == '{' {
+		// Responses to server requests carry user input and model output
+		upstream := c.origins.Take(c.session, probe.ID)
+		out, ok := c.inspector.InspectClientResponse(data)
+		if !ok {
+			return nil, nil
+		}
+		if err := c.upstream.Respond(out, upstream); err != nil {
+			log.Printf("Error sending client response upstream: %v", err)
+		}
+		return nil, nil
+	}
+
+	req, err := schema.ParseRequest(data)
+	if err != nil {
+		code := schema.CodeInvalidRequest
+		var verr *schema.ValidationError
+		if errors.As(err, &verr) {
+			code = verr.Code()
+		}
+		log.Printf("Schema validation failed: %v", err)
+		return schema.NewErrorResponse(req.ID, code, err.Error()), nil
+	}
+
+	if c.limiter != nil && !c.limiter.Allow("") {
+		log.Printf("Rate limited WebSocket message %s (%s)", req.Method, req.ID)
+		if req.IsNotification() {
+			return nil, nil
+		}
+		return schema.NewErrorResponse(req.ID, schema.CodeRateLimited, "Rate limit exceeded"), nil
+	}
+
+	call := &pipeline.Call{Request: req, Subject: c.subject, Path: c.path, CorrelationID: rpc.NewCorrelationID(), Session: c.session}
+	call.Upstream, call.Tool = c.upstream.Tools().Route(req)
+	if rpcErr := c.inspector.Screen(call); rpcErr != nil {
+		var resp *schema.MCPResponse
+		if !req.IsNotification() {
+			resp = &schema.MCPResponse{JSONRPC: schema.Version, ID: req.ID, Error: rpcErr}
+		}
+		if c.closeOnBlock {
+			return resp, &closeError{code: websocket.ClosePolicyViolation, reason: rpcErr.Message}
+		}
+		return resp, nil
+	}
+
+	out, err := json.Marshal(req)
+	if err != nil {
+		return schema.NewErrorResponse(req.ID, schema.CodeInternalError, "Failed to encode request"), nil
+	}
+	if !req.IsNotification() {
+		c.track(req)
+	}
+	if err := c.upstream.Send(out, req); err != nil {
+		log.Printf("Error sending request %s upstream: %v", req.ID, err)
+		if req.IsNotification() {
+			return nil, nil
+		}
+		c.untrack(req.ID)
+		return schema.NewErrorResponse(req.ID, schema.CodeUpstreamError, "Upstream unavailable"), nil
+	}
+	return nil, nil
+}
+
+// fromServer inspects a message from the named upstream and writes it to
+// the client. A blocked server request is answered upstream instead.
+func (c *conn) fromServer(data []byte, upstream string) {
+	var msg struct {
+		ID     schema.ID `json:"id"`
+		Method string    `json:"method"`
+	}
+	var req *schema.MCPRequest
+	if err := json.Unmarshal(data, &msg); err == nil && msg.Method == "" && !msg.ID.IsZero() {
+		req = c.untrack(msg.ID)
+	}
+
+	out, reply, ok := c.inspector.InspectServerMessage(c.subject, c.session, upstream, data, req, c.upstream.Tools())
+	if reply != nil {
+		if err := c.upstream.Respond(reply, upstream); err != nil {
+			log.Printf("Error answering blocked server request: %v", err)
+		}
+	}
+	if !ok {
+		return
+	}
+	c.origins.Track(c.session, upstream, out)
+	c.write(out)
+}
+
+// track records a forwarded request until its response arrives
+func (c *conn) track(req *schema.MCPRequest) {
+	key, _ := json.Marshal(req.ID)
+	c.mu.Lock()
+	c.pending[string(key)] = req
+	c.mu.Unlock()
+}
+
+// untrack removes and returns the forwarded request with the given ID
+func (c *conn) untrack(id schema.ID) *schema.MCPRequest {
+	key, _ := json.Marshal(id)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	req := c.pending[string(key)]
+	delete(c.pending, string(key))
+	return req
+}
+
+// writeJSON writes v as a text frame to the client
+func (c *conn) writeJSON(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding WebSocket message: %v", err)
+		return
+	}
+	c.write(data)
+}
+
+// write writes a text frame to the client
+func (c *conn) write(data []byte) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return
+	}
+	if err := c.client.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Printf("Error writing WebSocket frame: %v", err)
+	}
+}
+
+// close sends a close frame with the given code and closes the client
+// connection. Only the first call has an effect.
+func (c *conn) close(code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	// Close reasons are limited to 123 bytes
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	msg := websocket.FormatCloseMessage(code, reason)
+	c.client.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeTimeout))
+	c.client.Close()
+}
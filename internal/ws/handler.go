@@ -0,0 +1,139 @@
+package ws
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"safectx/internal/config"
+	"safectx/internal/middleware"
+	"safectx/internal/policy"
+	"safectx/internal/rpc"
+	"safectx/pkg/schema"
+)
+
+// Handler accepts WebSocket connections carrying JSON-RPC messages in text
+// frames. The caller is authenticated on upgrade. Every message in both
+// directions runs through the same inspection as HTTP requests, and
+// allowed messages are relayed to a WebSocket upstream or forwarded to the
+// HTTP upstream.
+type Handler struct {
+	cfg            *config.WebSocketConfig
+	inspector      *rpc.Inspector
+	forwarder      rpc.Forwarder
+	auths          []middleware.Authenticator
+	allowedHeaders []string
+	deadlines      *config.DeadlineConfig
+	cache          *rpc.ResponseCache
+	upgrader       websocket.Upgrader
+	dialer         *websocket.Dialer
+}
+
+// NewHandler creates a WebSocket handler. forwarder is used when no
+// WebSocket upstream is configured.
+func NewHandler(cfg *config.WebSocketConfig, inspector *rpc.Inspector, forwarder rpc.Forwarder) *Handler {
+	return &Handler{
+		cfg:       cfg,
+		inspector: inspector,
+		forwarder: forwarder,
+		deadlines: &config.DeadlineConfig{},
+		dialer:    websocket.DefaultDialer,
+	}
+}
+
+// WithAuthenticators sets the authenticators tried in order when a
+// connection is opened. The first that accepts the upgrade request decides
+// the caller; without authenticators every caller is anonymous.
+func (h *Handler) WithAuthenticators(auths ...middleware.Authenticator) *Handler {
+	h.auths = auths
+	return h
+}
+
+// WithAllowedHeaders sets the headers of the upgrade request that are
+// passed on when the WebSocket upstream is dialed
+func (h *Handler) WithAllowedHeaders(headers []string) *Handler {
+	h.allowedHeaders = headers
+	return h
+}
+
+// WithDeadlines bounds the requests forwarded to the HTTP upstream by
+// method and tool
+func (h *Handler) WithDeadlines(cfg *config.DeadlineConfig) *Handler {
+	h.deadlines = cfg
+	return h
+}
+
+// WithCache answers idempotent requests for the HTTP upstream from c
+func (h *Handler) WithCache(c *rpc.ResponseCache) *Handler {
+	h.cache = c
+	return h
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		log.Printf("Rejected WebSocket connection from %s: %v", r.RemoteAddr, err)
+		return
+	}
+	var subject *policy.Subject
+	if user != nil {
+		r = r.WithContext(middleware.WithUser(r.Context(), user))
+		subject = &policy.Subject{ID: user.ID, Roles: user.Roles, Claims: user.Claims}
+	}
+
+	c := &conn{
+		inspector:    h.inspector,
+		subject:      subject,
+		path:         r.URL.Path,
+		session:      rpc.NewCorrelationID(),
+		closeOnBlock: h.cfg.CloseOnBlock,
+		pending:      make(map[string]*schema.MCPRequest),
+		origins:      rpc.NewServerOrigins(),
+	}
+	if h.cfg.RateLimit > 0 {
+		c.limiter = middleware.NewRateLimiter(h.cfg.RateLimit, float64(h.cfg.Burst), time.Second)
+	}
+
+	// The upstream is connected first so a failure is reported as an HTTP
+	// error rather than an immediate close
+	if h.cfg.Upstream != "" {
+		c.upstream, err = dialUpstream(h.dialer, h.cfg.Upstream, r, h.allowedHeaders, c.fromServer)
+		if err != nil {
+			http.Error(w, "Upstream unavailable", http.StatusBadGateway)
+			log.Printf("Error connecting to WebSocket upstream: %v", err)
+			return
+		}
+	} else {
+		c.upstream = newHTTPUpstream(h.forwarder, r, h.deadlines, h.cache, c.fromServer)
+	}
+
+	client, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		c.upstream.Close()
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	client.SetReadLimit(h.cfg.MaxMessageSize)
+	c.client = client
+
+	log.Printf("Opened WebSocket connection from %s", r.RemoteAddr)
+	c.run()
+	log.Printf("Closed WebSocket connection from %s", r.RemoteAddr)
+}
+
+// authenticate returns the caller of the upgrade request, or nil if no
+// authenticators are configured
+func (h *Handler) authenticate(r *http.Request) (*middleware.User, error) {
+	var err error
+	for _, auth := range h.auths {
+		var user *middleware.User
+		if user, err = auth.Authenticate(r); err == nil {
+			return user, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,283 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"safectx/internal/config"
+	"safectx/internal/rpc"
+	"safectx/pkg/schema"
+)
+
+// upstream carries the messages of one client connection to the upstream
+// MCP server. Upstream messages are passed to the deliver function the
+// upstream was created with, along with the name of the upstream that sent
+// them, if known.
+type upstream interface {
+	// Send delivers a client request or notification, parsed as req
+	Send(data []byte, req *schema.MCPRequest) error
+	// Respond delivers a client response to a server request the named
+	// upstream sent; an empty name stands for the only upstream
+	Respond(data []byte, upstream string) error
+	// Tools resolves the tool names listed to the client to the
+	// upstream's own names
+	Tools() rpc.ToolResolver
+	// Receive delivers upstream messages until the upstream closes or
+	// Close is called
+	Receive() error
+	// Close ends the upstream connection
+	Close() error
+}
+
+// wsUpstream relays messages over a WebSocket connection to the upstream
+type wsUpstream struct {
+	conn    *websocket.Conn
+	deliver func([]byte, string)
+	mu      sync.Mutex
+}
+
+// dialUpstream opens the upstream WebSocket connection for the client
+// upgrade request r, passing on the allow-listed headers
+func dialUpstream(dialer *websocket.Dialer, url string, r *http.Request, allowedHeaders []string, deliver func([]byte, string)) (*wsUpstream, error) {
+	header := make(http.Header)
+	for _, h := range allowedHeaders {
+		if values := r.Header.Values(h); len(values) > 0 {
+			header[http.CanonicalHeaderKey(h)] = append([]string(nil), values...)
+		}
+	}
+
+	conn, resp, err := dialer.DialContext(r.Context(), url, header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("dial %s: %w (status %d)", url, err, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("dial %s: %w", url, err)
+	}
+	return &wsUpstream{conn: conn, deliver: deliver}, nil
+}
+
+// Send implements the upstream interface
+func (u *wsUpstream) Send(data []byte, req *schema.MCPRequest) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Tools implements the upstream interface. A WebSocket upstream is a single
+// server, so tools keep their names.
+func (u *wsUpstream) Tools() rpc.ToolResolver {
+	return nil
+}
+
+// Respond implements the upstream interface
+func (u *wsUpstream) Respond(data []byte, upstream string) error {
+	return u.Send(data, nil)
+}
+
+// Receive implements the upstream interface
+func (u *wsUpstream) Receive() error {
+	for {
+		_, data, err := u.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		u.deliver(data, rpc.DefaultUpstreamName)
+	}
+}
+
+// Close implements the upstream interface
+func (u *wsUpstream) Close() error {
+	u.mu.Lock()
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	u.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeTimeout))
+	u.mu.Unlock()
+	return u.conn.Close()
+}
+
+// httpUpstream forwards each client request to the HTTP upstream and
+// delivers the response, or every message of a streamed response
+type httpUpstream struct {
+	forwarder rpc.Forwarder
+	r         *http.Request
+	deadlines *config.DeadlineConfig
+	cache     *rpc.ResponseCache
+	inflight  *rpc.InFlight
+	deliver   func([]byte, string)
+	done      chan struct{}
+	once      sync.Once
+}
+
+// newHTTPUpstream creates an upstream forwarding on behalf of the client
+// upgrade request r, within the configured deadlines. Idempotent requests
+// are answered from cache if it is not nil.
+func newHTTPUpstream(forwarder rpc.Forwarder, r *http.Request, deadlines *config.DeadlineConfig, cache *rpc.ResponseCache, deliver func([]byte, string)) *httpUpstream {
+	return &httpUpstream{
+		forwarder: forwarder,
+		r:         r,
+		deadlines: deadlines,
+		cache:     cache,
+		inflight:  rpc.NewInFlight(),
+		deliver:   deliver,
+		done:      make(chan struct{}),
+	}
+}
+
+// Send implements the upstream interface. Requests are forwarded
+// concurrently, so a slow call does not hold up the connection.
+func (u *httpUpstream) Send(data []byte, req *schema.MCPRequest) error {
+	if params, ok := req.Cancelled(); ok && u.inflight.Cancel(flightKey(params.RequestID)) {
+		log.Printf("Client cancelled request %s: %s", params.RequestID, params.Reason)
+	}
+	go u.forward(req)
+	return nil
+}
+
+// Respond implements the upstream interface. The response is posted to the
+// upstream in the background, like the HTTP gateway forwards it; it fails
+// only if the forwarder cannot deliver responses at all.
+func (u *httpUpstream) Respond(data []byte, upstream string) error {
+	rf, ok := u.forwarder.(rpc.ResponseForwarder)
+	if !ok {
+		return errors.New("the upstream cannot receive responses")
+	}
+	go func() {
+		resp, err := rf.ForwardResponse(u.r, upstream, data)
+		if err != nil {
+			log.Printf("Error forwarding client response: %v", err)
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			log.Printf("Upstream rejected client response with status %d", resp.StatusCode)
+		}
+	}()
+	return nil
+}
+
+// Tools implements the upstream interface, resolving tools the way the
+// HTTP gateway does for the client upgrade request
+func (u *httpUpstream) Tools() rpc.ToolResolver {
+	return rpc.UpstreamTools(u.forwarder, u.r)
+}
+
+// forward sends a request to the HTTP upstream within its deadline and
+// delivers its response. Requests that end early are cancelled upstream;
+// requests the client cancelled get no response.
+func (u *httpUpstream) forward(req *schema.MCPRequest) {
+	cached, cacheKey := u.cache.Lookup(u.forwarder, u.r, req)
+	if cached != nil {
+		body, _ := io.ReadAll(cached.Body)
+		log.Printf("Served request %s (%s) from cache", req.ID, req.Method)
+		u.deliver(body, "")
+		return
+	}
+
+	key := ""
+	if !req.IsNotification() {
+		key = flightKey(req.ID)
+	}
+	flight := u.inflight.Start(u.r.Context(), key, rpc.Deadline(u.deadlines, req))
+	defer func() {
+		abandoned := flight.Abandoned()
+		u.inflight.Finish(key, flight)
+		if abandoned && !req.IsNotification() {
+			rpc.NotifyCancelled(u.forwarder, u.r, req.ID, flight.Err().Error())
+		}
+	}()
+
+	resp, err := u.forwarder.Forward(u.r.WithContext(flight.Context()), req)
+	if err != nil {
+		if ferr := flight.Err(); ferr != nil {
+			err = ferr
+		}
+		log.Printf("Error forwarding request %s: %v", req.ID, err)
+		if !req.IsNotification() {
+			u.deliverError(req.ID, err)
+		}
+		return
+	}
+	defer resp.Body.Close()
+	u.cache.Fill(cacheKey, req, resp)
+
+	if req.IsNotification() {
+		io.Copy(io.Discard, resp.Body)
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/event-stream" {
+		events := rpc.NewEventReader(resp.Body)
+		for {
+			ev, err := events.Next()
+			if err != nil {
+				if err != io.EOF {
+					log.Printf("Error reading upstream stream: %v", err)
+				}
+				if ferr := flight.Err(); ferr != nil {
+					u.deliverError(req.ID, ferr)
+				}
+				return
+			}
+			if len(ev.Data) > 0 {
+				u.deliver(ev.Data, resp.Upstream)
+			}
+		}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if body = bytes.TrimSpace(body); err != nil || len(body) == 0 {
+		log.Printf("Empty upstream response to %s (status %d): %v", req.ID, resp.StatusCode, err)
+		if err = flight.Err(); err == nil {
+			err = rpc.ErrUpstreamUnavailable
+		}
+		u.deliverError(req.ID, err)
+		return
+	}
+	u.deliver(body, resp.Upstream)
+}
+
+// deliverError delivers the error response for a request that could not be
+// forwarded. Cancelled requests get no response.
+func (u *httpUpstream) deliverError(id schema.ID, err error) {
+	resp := schema.NewErrorResponse(id, schema.CodeUpstreamError, "Upstream unavailable")
+	switch {
+	case errors.Is(err, rpc.ErrRequestCancelled):
+		return
+	case errors.Is(err, rpc.ErrUpstreamTimeout):
+		resp = schema.NewErrorResponse(id, schema.CodeUpstreamTimeout, "Upstream request timed out")
+	case errors.Is(err, rpc.ErrNoRoute):
+		resp = schema.NewErrorResponse(id, schema.CodeMethodNotFound, "Method not found")
+	}
+	data, _ := json.Marshal(resp)
+	u.deliver(data, "")
+}
+
+// flightKey identifies a request among the requests of the connection in
+// flight
+func flightKey(id schema.ID) string {
+	raw, _ := json.Marshal(id)
+	return string(raw)
+}
+
+// Receive implements the upstream interface. Responses are delivered by
+// the requests that asked for them, so it only waits for Close.
+func (u *httpUpstream) Receive() error {
+	<-u.done
+	return nil
+}
+
+// Close implements the upstream interface
+func (u *httpUpstream) Close() error {
+	u.once.Do(func() { close(u.done) })
+	return nil
+}
@@ -0,0 +1,134 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MethodChatCompletions is the method that requests of the OpenAI-compatible
+// chat completions API are screened as. Their params are the request body.
+const MethodChatCompletions = "chat/completions"
+
+// Roles of chat messages
+const (
+	RoleSystem    = "system"
+	RoleDeveloper = "developer"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// ChatMessage is a view of a message of a chat completion request or
+// response
+type ChatMessage struct {
+	Role string
+	// Path is the JSON pointer of the message
+	Path string
+	// Texts are the string content of the message, or its text parts
+	Texts []ChatText
+	// ToolCallID is the tool call a tool message answers
+	ToolCallID string
+	// ToolCalls are the tool calls of an assistant message
+	ToolCalls []ChatToolCall
+}
+
+// ChatText is a text within a chat message
+type ChatText struct {
+	// Path is the JSON pointer of the text
+	Path string
+	Text string
+	// set replaces the text in the message
+	set func(text string)
+}
+
+// Set replaces the text in the message it was read from
+func (t *ChatText) Set(text string) {
+	t.Text = text
+	t.set(text)
+}
+
+// ChatToolCall is a function call made by an assistant message
+type ChatToolCall struct {
+	ID   string
+	Name string
+	// Path is the JSON pointer of the encoded arguments
+	Path string
+	// RawArguments is the JSON string the model produced
+	RawArguments string
+	// Arguments are the decoded arguments, or nil if RawArguments is not a
+	// JSON object. Changes are written back by Update.
+	Arguments map[string]interface{}
+	function  map[string]interface{}
+}
+
+// Update encodes Arguments back into the message it was read from
+func (c *ChatToolCall) Update() {
+	data, err := json.Marshal(c.Arguments)
+	if err != nil {
+		return
+	}
+	c.RawArguments = string(data)
+	c.function["arguments"] = c.RawArguments
+}
+
+// ChatMessages returns views of the messages of a chat/completions
+// request. It returns false for other methods.
+func (r *MCPRequest) ChatMessages() ([]ChatMessage, bool) {
+	if r.Method != MethodChatCompletions {
+		return nil, false
+	}
+	list, _ := r.Params["messages"].([]interface{})
+	messages := make([]ChatMessage, 0, len(list))
+	for i, item := range list {
+		if msg, ok := item.(map[string]interface{}); ok {
+			messages = append(messages, ParseChatMessage(msg, fmt.Sprintf("/messages/%d", i)))
+		}
+	}
+	return messages, true
+}
+
+// ParseChatMessage returns a view of a decoded chat message found at path.
+// Changes made through the view are made to msg.
+func ParseChatMessage(msg map[string]interface{}, path string) ChatMessage {
+	m := ChatMessage{Path: path}
+	m.Role, _ = msg["role"].(string)
+	m.ToolCallID, _ = msg["tool_call_id"].(string)
+
+	switch content := msg["content"].(type) {
+	case string:
+		m.Texts = append(m.Texts, ChatText{
+			Path: path + "/content",
+			Text: content,
+			set:  func(text string) { msg["content"] = text },
+		})
+	case []interface{}:
+		for i, item := range content {
+			part, ok := item.(map[string]interface{})
+			if !ok || part["type"] != "text" {
+				continue
+			}
+			text, _ := part["text"].(string)
+			m.Texts = append(m.Texts, ChatText{
+				Path: fmt.Sprintf("%s/content/%d/text", path, i),
+				Text: text,
+				set:  func(text string) { part["text"] = text },
+			})
+		}
+	}
+
+	calls, _ := msg["tool_calls"].([]interface{})
+	for i, item := range calls {
+		call, _ := item.(map[string]interface{})
+		function, ok := call["function"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		c := ChatToolCall{Path: fmt.Sprintf("%s/tool_calls/%d/function/arguments", path, i), function: function}
+		c.ID, _ = call["id"].(string)
+		c.Name, _ = function["name"].(string)
+		c.RawArguments, _ = function["arguments"].(string)
+		json.Unmarshal([]byte(c.RawArguments), &c.Arguments)
+		m.ToolCalls = append(m.ToolCalls, c)
+	}
+	return m
+}
@@ -0,0 +1,84 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// ID is a JSON-RPC request identifier. It keeps the original JSON encoding
+// (string, number or null) so responses echo the ID with the same type the
+// client sent.
+type ID struct {
+	raw json.RawMessage
+}
+
+// StringID returns an ID holding a string value
+func StringID(s string) ID {
+	raw, _ := json.Marshal(s)
+	return ID{raw: raw}
+}
+
+// NumberID returns an ID holding a numeric value
+func NumberID(n int64) ID {
+	return ID{raw: json.RawMessage(strconv.FormatInt(n, 10))}
+}
+
+// IsZero reports whether the ID was absent from the message
+func (id ID) IsZero() bool {
+	return len(id.raw) == 0
+}
+
+// IsNull reports whether the ID was explicitly set to null
+func (id ID) IsNull() bool {
+	return string(id.raw) == "null"
+}
+
+// String returns the ID in a form suitable for logging
+func (id ID) String() string {
+	var s string
+	if err := json.Unmarshal(id.raw, &s); err == nil {
+		return s
+	}
+	if id.IsZero() {
+		return "<none>"
+	}
+	return string(id.raw)
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (id ID) MarshalJSON() ([]byte, error) {
+	if id.IsZero() {
+		return []byte("null"), nil
+	}
+	return id.raw, nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (id *ID) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return fmt.Errorf("invalid id: empty value")
+	}
+
+	switch data[0] {
+	case '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("invalid id: %w", err)
+		}
+	case 'n':
+		if string(data) != "null" {
+			return fmt.Errorf("invalid id: %s", data)
+		}
+	default:
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid id: must be a string, number or null")
+		}
+	}
+
+	id.raw = append(json.RawMessage(nil), data...)
+	return nil
+}
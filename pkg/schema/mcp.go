@@ -0,0 +1,220 @@
+package schema
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// MCP methods with typed params
+const (
+	MethodInitialize    = "initialize"
+	MethodToolsCall     = "tools/call"
+	MethodResourcesRead = "resources/read"
+	MethodPromptsGet    = "prompts/get"
+	MethodCancelled     = "notifications/cancelled"
+)
+
+// MCP requests the server sends to the client
+const (
+	MethodCreateMessage = "sampling/createMessage"
+	MethodElicit        = "elicitation/create"
+)
+
+// ToolCallParams are the params of a tools/call request
+type ToolCallParams struct {
+	Name string
+	// Arguments shares its map with the request params, so changes made
+	// through the view are forwarded
+	Arguments map[string]interface{}
+}
+
+// ResourceReadParams are the params of a resources/read request
+type ResourceReadParams struct {
+	// URI is the normalized URI of the resource, see NormalizeURI
+	URI string
+}
+
+// PromptGetParams are the params of a prompts/get request
+type PromptGetParams struct {
+	Name string
+	// Arguments shares its map with the request params
+	Arguments map[string]interface{}
+}
+
+// CancelledParams are the params of a notifications/cancelled notification
+type CancelledParams struct {
+	// RequestID is the ID of the request to cancel
+	RequestID ID
+	Reason    string
+}
+
+// InitializeParams are the params of an initialize request
+type InitializeParams struct {
+	ProtocolVersion string
+	Capabilities    Capabilities
+	ClientInfo      Implementation
+}
+
+// Implementation describes an MCP client or server
+type Implementation struct {
+	Name    string
+	Version string
+}
+
+// Capabilities holds the capabilities declared during initialization,
+// keyed by capability name, e.g. "sampling" or "roots"
+type Capabilities map[string]interface{}
+
+// Has reports whether the named capability is declared
+func (c Capabilities) Has(name string) bool {
+	_, ok := c[name]
+	return ok
+}
+
+// ToolCall returns the typed params of a tools/call request. It returns
+// false for other methods.
+func (r *MCPRequest) ToolCall() (*ToolCallParams, bool) {
+	if r.Method != MethodToolsCall {
+		return nil, false
+	}
+	name, ok := r.Params["name"].(string)
+	if !ok {
+		return nil, false
+	}
+	args, _ := r.Params["arguments"].(map[string]interface{})
+	return &ToolCallParams{Name: name, Arguments: args}, true
+}
+
+// ResourceRead returns the typed params of a resources/read request. It
+// returns false for other methods. A URI that does not parse is returned
+// as sent; ParseRequest rejects such requests.
+func (r *MCPRequest) ResourceRead() (*ResourceReadParams, bool) {
+	if r.Method != MethodResourcesRead {
+		return nil, false
+	}
+	uri, ok := r.Params["uri"].(string)
+	if !ok {
+		return nil, false
+	}
+	if normalized, err := NormalizeURI(uri); err == nil {
+		uri = normalized
+	}
+	return &ResourceReadParams{URI: uri}, true
+}
+
+// NormalizeURI returns the form of a resource URI that policy rules match
+// on, so one resource cannot be named in ways a rule does not expect. The
+// scheme and host are lowercased, the localhost host of a file URI is
+// dropped, and the path is unescaped and cleaned of "." and ".." segments
+// and repeated slashes. A trailing slash is kept.
+func NormalizeURI(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if u.Scheme == "file" && u.Hostname() == "localhost" {
+		u.Host = ""
+	}
+	if u.Path != "" {
+		cleaned := path.Clean(u.Path)
+		if strings.HasSuffix(u.Path, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		u.Path = cleaned
+	}
+	u.RawPath = ""
+	u.OmitHost = false
+	return u.String(), nil
+}
+
+// PromptGet returns the typed params of a prompts/get request. It returns
+// false for other methods.
+func (r *MCPRequest) PromptGet() (*PromptGetParams, bool) {
+	if r.Method != MethodPromptsGet {
+		return nil, false
+	}
+	name, ok := r.Params["name"].(string)
+	if !ok {
+		return nil, false
+	}
+	args, _ := r.Params["arguments"].(map[string]interface{})
+	return &PromptGetParams{Name: name, Arguments: args}, true
+}
+
+// Initialize returns the typed params of an initialize request. It returns
+// false for other methods.
+func (r *MCPRequest) Initialize() (*InitializeParams, bool) {
+	if r.Method != MethodInitialize {
+		return nil, false
+	}
+	params := &InitializeParams{}
+	params.ProtocolVersion, _ = r.Params["protocolVersion"].(string)
+	params.Capabilities, _ = r.Params["capabilities"].(map[string]interface{})
+	if info, ok := r.Params["clientInfo"].(map[string]interface{}); ok {
+		params.ClientInfo.Name, _ = info["name"].(string)
+		params.ClientInfo.Version, _ = info["version"].(string)
+	}
+	return params, true
+}
+
+// Cancelled returns the typed params of a notifications/cancelled
+// notification. It returns false for other methods, or if the request ID
+// is missing.
+func (r *MCPRequest) Cancelled() (*CancelledParams, bool) {
+	if r.Method != MethodCancelled || r.Params["requestId"] == nil {
+		return nil, false
+	}
+	raw, err := json.Marshal(r.Params["requestId"])
+	if err != nil {
+		return nil, false
+	}
+	params := &CancelledParams{}
+	if err := params.RequestID.UnmarshalJSON(raw); err != nil {
+		return nil, false
+	}
+	params.Reason, _ = r.Params["reason"].(string)
+	return params, true
+}
+
+// validateParams checks the params of the methods that have typed views.
+// Params of other methods are not interpreted.
+func validateParams(req *MCPRequest) error {
+	switch req.Method {
+	case MethodToolsCall, MethodPromptsGet:
+		if name, _ := req.Params["name"].(string); name == "" {
+			return newParamsError(req.Method + " requires a name")
+		}
+		if args, ok := req.Params["arguments"]; ok && args != nil {
+			if _, ok := args.(map[string]interface{}); !ok {
+				return newParamsError(req.Method + " arguments must be an object")
+			}
+		}
+	case MethodResourcesRead:
+		uri, _ := req.Params["uri"].(string)
+		if uri == "" {
+			return newParamsError(req.Method + " requires a uri")
+		}
+		if _, err := NormalizeURI(uri); err != nil {
+			return newParamsError(req.Method + " uri is invalid")
+		}
+	case MethodInitialize:
+		if version, _ := req.Params["protocolVersion"].(string); version == "" {
+			return newParamsError(req.Method + " requires a protocolVersion")
+		}
+		if caps, ok := req.Params["capabilities"]; ok && caps != nil {
+			if _, ok := caps.(map[string]interface{}); !ok {
+				return newParamsError(req.Method + " capabilities must be an object")
+			}
+		}
+	}
+	return nil
+}
+
+// newParamsError creates a validation error reported as invalid params
+func newParamsError(message string) error {
+	return &ValidationError{code: CodeInvalidParams, message: "invalid params: " + message}
+}
@@ -0,0 +1,161 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTypedViews(t *testing.T) {
+	t.Run("tools/call", func(t *testing.T) {
+		req, err := ParseRequest([]byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"query","arguments":{"sql":"select 1"}}}`))
+		if err != nil {
+			t.Fatalf("ParseRequest() error = %v", err)
+		}
+		call, ok := req.ToolCall()
+		if !ok || call.Name != "query" || call.Arguments["sql"] != "select 1" {
+			t.Fatalf("ToolCall() = %+v, %v", call, ok)
+		}
+
+		// Arguments share their map with the params
+		call.Arguments["sql"] = "[REDACTED]"
+		if req.Params["arguments"].(map[string]interface{})["sql"] != "[REDACTED]" {
+			t.Error("Change to arguments not visible in params")
+		}
+		if _, ok := req.PromptGet(); ok {
+			t.Error("PromptGet() should not match tools/call")
+		}
+	})
+
+	t.Run("resources/read", func(t *testing.T) {
+		req, _ := ParseRequest([]byte(`{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{"uri":"file:///etc/hosts"}}`))
+		if read, ok := req.ResourceRead(); !ok || read.URI != "file:///etc/hosts" {
+			t.Errorf("ResourceRead() = %+v, %v", read, ok)
+		}
+	})
+
+	t.Run("prompts/get", func(t *testing.T) {
+		req, _ := ParseRequest([]byte(`{"jsonrpc":"2.0","id":1,"method":"prompts/get","params":{"name":"review","arguments":{"code":"x := 1"}}}`))
+		if prompt, ok := req.PromptGet(); !ok || prompt.Name != "review" || prompt.Arguments["code"] != "x := 1" {
+			t.Errorf("PromptGet() = %+v, %v", prompt, ok)
+		}
+	})
+
+	t.Run("initialize", func(t *testing.T) {
+		req, _ := ParseRequest([]byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{"sampling":{},"roots":{"listChanged":true}},"clientInfo":{"name":"cli","version":"1.2"}}}`))
+		init, ok := req.Initialize()
+		if !ok || init.ProtocolVersion != "2025-06-18" || init.ClientInfo.Name != "cli" || init.ClientInfo.Version != "1.2" {
+			t.Fatalf("Initialize() = %+v, %v", init, ok)
+		}
+		if !init.Capabilities.Has("sampling") || !init.Capabilities.Has("roots") || init.Capabilities.Has("elicitation") {
+			t.Errorf("Got capabilities %v", init.Capabilities)
+		}
+	})
+
+	t.Run("chat/completions", func(t *testing.T) {
+		req := &MCPRequest{Method: MethodChatCompletions}
+		json.Unmarshal([]byte(`{"messages":[
+			{"role":"user","content":[{"type":"text","text":"hi"},{"type":"image_url","image_url":{"url":"data:"}}]},
+			{"role":"assistant","tool_calls":[{"id":"c1","type":"function","function":{"name":"search","arguments":"{\"q\":\"go\"}"}}]},
+			{"role":"tool","tool_call_id":"c1","content":"results"}
+		]}`), &req.Params)
+		messages, ok := req.ChatMessages()
+		if !ok || len(messages) != 3 {
+			t.Fatalf("ChatMessages() = %+v, %v", messages, ok)
+		}
+		if texts := messages[0].Texts; len(texts) != 1 || texts[0].Path != "/messages/0/content/0/text" {
+			t.Errorf("Got texts %+v, want the text part", texts)
+		}
+		call := messages[1].ToolCalls[0]
+		if call.ID != "c1" || call.Name != "search" || call.Arguments["q"] != "go" {
+			t.Errorf("Got tool call %+v", call)
+		}
+		if messages[2].Role != RoleTool || messages[2].ToolCallID != "c1" || messages[2].Texts[0].Text != "results" {
+			t.Errorf("Got tool message %+v", messages[2])
+		}
+
+		// Changes are written back to the params
+		messages[2].Texts[0].Set("[REDACTED]")
+		call.Arguments["q"] = "rust"
+		call.Update()
+		again, _ := req.ChatMessages()
+		if again[2].Texts[0].Text != "[REDACTED]" || again[1].ToolCalls[0].RawArguments != `{"q":"rust"}` {
+			t.Errorf("Changes not visible in params: %+v", again)
+		}
+	})
+}
+
+func TestTypedParamsValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{name: "tool call", body: `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo"}}`},
+		{name: "tool call without name", body: `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"arguments":{}}}`, wantErr: true},
+		{name: "tool call with array arguments", body: `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":[1]}}`, wantErr: true},
+		{name: "resource read without uri", body: `{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{}}`, wantErr: true},
+		{name: "resource read with invalid uri", body: `{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{"uri":"file:///%zz"}}`, wantErr: true},
+		{name: "prompt without name", body: `{"jsonrpc":"2.0","id":1,"method":"prompts/get"}`, wantErr: true},
+		{name: "initialize without version", body: `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{}}}`, wantErr: true},
+		{name: "unknown method", body: `{"jsonrpc":"2.0","id":1,"method":"custom/anything","params":{"name":42}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRequest([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if verr, ok := err.(*ValidationError); !ok || verr.Code() != CodeInvalidParams {
+					t.Errorf("Got error %v, want invalid params", err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownMethodRoundTrip(t *testing.T) {
+	body := `{"jsonrpc":"2.0","id":1,"method":"vendor/custom","params":{"big":12345678901234567890,"float":1.50,"nested":{"list":[1,"two",null,true]}}}`
+
+	req, err := ParseRequest([]byte(body))
+	if err != nil {
+		t.Fatalf("ParseRequest() error = %v", err)
+	}
+	out, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(out) != body {
+		t.Errorf("Round trip mismatch:\n got %s\nwant %s", out, body)
+	}
+}
+
+func TestNormalizeURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		want    string
+		wantErr bool
+	}{
+		{uri: "file:///etc/passwd", want: "file:///etc/passwd"},
+		{uri: "file:///tmp/../etc/passwd", want: "file:///etc/passwd"},
+		{uri: "file:////etc//passwd", want: "file:///etc/passwd"},
+		{uri: "FILE:///etc/passwd", want: "file:///etc/passwd"},
+		{uri: "file://localhost/etc/passwd", want: "file:///etc/passwd"},
+		{uri: "file:/etc/passwd", want: "file:///etc/passwd"},
+		{uri: "file:///%65tc/passwd", want: "file:///etc/passwd"},
+		{uri: "file:///etc/", want: "file:///etc/"},
+		{uri: "Postgres://Main/./orders?limit=1", want: "postgres://main/orders?limit=1"},
+		{uri: "urn:ietf:rfc:2648", want: "urn:ietf:rfc:2648"},
+		{uri: "file:///%zz", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			got, err := NormalizeURI(tt.uri)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("NormalizeURI(%q) = %q, %v, want %q", tt.uri, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package schema
+
+import "strings"
+
+var (
+	pointerEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+	pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+)
+
+// EscapePointer encodes a key as a JSON pointer token (RFC 6901)
+func EscapePointer(key string) string {
+	return pointerEscaper.Replace(key)
+}
+
+// UnescapePointer decodes a JSON pointer token back into a key
+func UnescapePointer(token string) string {
+	return pointerUnescaper.Replace(token)
+}
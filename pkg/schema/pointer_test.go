@@ -0,0 +1,27 @@
+package schema
+
+import "testing"
+
+func TestEscapePointer(t *testing.T) {
+	tests := []struct {
+		key   string
+		token string
+	}{
+		{"env", "env"},
+		{"a/b", "a~1b"},
+		{"~home", "~0home"},
+		{"~1", "~01"},
+		{"/~/", "~1~0~1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := EscapePointer(tt.key); got != tt.token {
+				t.Errorf("EscapePointer(%q) = %q, want %q", tt.key, got, tt.token)
+			}
+			if got := UnescapePointer(tt.token); got != tt.key {
+				t.Errorf("UnescapePointer(%q) = %q, want %q", tt.token, got, tt.key)
+			}
+		})
+	}
+}
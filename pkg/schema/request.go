@@ -1,42 +1,111 @@
 package schema
 
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Version is the JSON-RPC protocol version spoken by SafeCtx
+const Version = "2.0"
+
 // MCPRequest represents the structure of an incoming JSON-RPC request
 type MCPRequest struct {
-	ID     string                 `json:"id"`
-	Method string                 `json:"method"`
-	Params map[string]interface{} `json:"params"`
+	JSONRPC string                 `json:"jsonrpc"`
+	ID      ID                     `json:"id,omitzero"`
+	Method  string                 `json:"method"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+}
+
+// rawRequest is the wire form of a request before params are checked
+type rawRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      ID              `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+// ParseRequest decodes a single JSON-RPC request. On failure the returned
+// request carries whatever ID could be recovered so the error response can
+// echo it.
+func ParseRequest(data []byte) (*MCPRequest, error) {
+	if !json.Valid(data) {
+		return &MCPRequest{}, ErrParse
+	}
+
+	var raw rawRequest
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return &MCPRequest{}, ErrInvalidRequest
+	}
+
+	req := &MCPRequest{
+		JSONRPC: raw.JSONRPC,
+		ID:      raw.ID,
+		Method:  raw.Method,
+	}
+
+	params := bytes.TrimSpace(raw.Params)
+	switch {
+	case len(params) == 0 || string(params) == "null":
+		// Params may be omitted
+	case params[0] == '{':
+		// Numbers are kept as json.Number so params round-trip unchanged
+		decoder := json.NewDecoder(bytes.NewReader(params))
+		decoder.UseNumber()
+		if err := decoder.Decode(&req.Params); err != nil {
+			return req, ErrInvalidParams
+		}
+	case params[0] == '[':
+		// MCP only uses named parameters
+		return req, ErrInvalidParams
+	default:
+		return req, ErrInvalidRequest
+	}
+
+	return req, Validate(req)
+}
+
+// IsNotification reports whether the request is a notification, i.e. a
+// request without an id that expects no response
+func (r *MCPRequest) IsNotification() bool {
+	return r.ID.IsZero()
 }
 
 // Validate performs basic validation on the MCPRequest
 func Validate(req *MCPRequest) error {
-	if req.ID == "" {
-		return ErrMissingID
+	if req.JSONRPC != Version {
+		return ErrInvalidVersion
 	}
 	if req.Method == "" {
 		return ErrMissingMethod
 	}
-	if req.Params == nil {
-		return ErrMissingParams
-	}
-	return nil
+	return validateParams(req)
 }
 
 // Error definitions
 var (
-	ErrMissingID     = NewValidationError("missing required field: id")
-	ErrMissingMethod = NewValidationError("missing required field: method")
-	ErrMissingParams = NewValidationError("missing required field: params")
+	ErrParse          = &ValidationError{code: CodeParseError, message: "parse error"}
+	ErrInvalidRequest = &ValidationError{code: CodeInvalidRequest, message: "invalid request"}
+	ErrInvalidParams  = &ValidationError{code: CodeInvalidParams, message: "invalid params: must be an object"}
+	ErrInvalidVersion = NewValidationError(`invalid request: jsonrpc must be "2.0"`)
+	ErrMissingMethod  = NewValidationError("missing required field: method")
 )
 
 // ValidationError represents a validation error
 type ValidationError struct {
+	code    int
 	message string
 }
 
+// NewValidationError creates a validation error reported as an invalid request
 func NewValidationError(message string) error {
-	return &ValidationError{message: message}
+	return &ValidationError{code: CodeInvalidRequest, message: message}
 }
 
 func (e *ValidationError) Error() string {
 	return e.message
 }
+
+// Code returns the JSON-RPC error code for the validation error
+func (e *ValidationError) Code() int {
+	return e.code
+}
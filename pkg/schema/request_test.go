@@ -0,0 +1,101 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantErr  error
+		wantID   string
+		wantCode int
+	}{
+		{
+			name:   "String ID",
+			body:   `{"jsonrpc":"2.0","id":"abc","method":"tools/list"}`,
+			wantID: `"abc"`,
+		},
+		{
+			name:   "Numeric ID",
+			body:   `{"jsonrpc":"2.0","id":42,"method":"tools/list","params":{}}`,
+			wantID: `42`,
+		},
+		{
+			name:     "Malformed JSON",
+			body:     `{"jsonrpc":"2.0",`,
+			wantErr:  ErrParse,
+			wantID:   `null`,
+			wantCode: CodeParseError,
+		},
+		{
+			name:     "Boolean ID",
+			body:     `{"jsonrpc":"2.0","id":true,"method":"ping"}`,
+			wantErr:  ErrInvalidRequest,
+			wantID:   `null`,
+			wantCode: CodeInvalidRequest,
+		},
+		{
+			name:     "Wrong version",
+			body:     `{"jsonrpc":"1.0","id":1,"method":"ping"}`,
+			wantErr:  ErrInvalidVersion,
+			wantID:   `1`,
+			wantCode: CodeInvalidRequest,
+		},
+		{
+			name:     "Positional params",
+			body:     `{"jsonrpc":"2.0","id":1,"method":"ping","params":[1,2]}`,
+			wantErr:  ErrInvalidParams,
+			wantID:   `1`,
+			wantCode: CodeInvalidParams,
+		},
+		{
+			name:     "Scalar params",
+			body:     `{"jsonrpc":"2.0","id":1,"method":"ping","params":"x"}`,
+			wantErr:  ErrInvalidRequest,
+			wantID:   `1`,
+			wantCode: CodeInvalidRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ParseRequest([]byte(tt.body))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseRequest() error = %v, want %v", err, tt.wantErr)
+			}
+
+			id, _ := json.Marshal(req.ID)
+			if string(id) != tt.wantID {
+				t.Errorf("ParseRequest() id = %s, want %s", id, tt.wantID)
+			}
+
+			if err != nil {
+				var verr *ValidationError
+				if !errors.As(err, &verr) || verr.Code() != tt.wantCode {
+					t.Errorf("ParseRequest() error code = %v, want %d", err, tt.wantCode)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	body := `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"echo"}}`
+
+	req, err := ParseRequest([]byte(body))
+	if err != nil {
+		t.Fatalf("ParseRequest() error = %v", err)
+	}
+
+	out, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(out) != body {
+		t.Errorf("Round trip mismatch:\n got %s\nwant %s", out, body)
+	}
+}
@@ -0,0 +1,82 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Standard JSON-RPC 2.0 error codes
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// SafeCtx error codes, taken from the range JSON-RPC reserves for
+// implementation-defined server errors (-32000 to -32099)
+const (
+	CodeInjectionDetected = -32001
+	CodePolicyDenied      = -32002
+	CodeUpstreamError     = -32003
+	CodeUpstreamTimeout   = -32004
+	CodeResponseBlocked   = -32005
+	CodeRateLimited       = -32006
+	CodeReplayDetected    = -32007
+	CodeRequestCancelled  = -32008
+	CodeApprovalDenied    = -32009
+	CodeApprovalTimeout   = -32010
+	CodeApprovalPending   = -32011
+)
+
+// MCPResponse represents the structure of an outgoing JSON-RPC response
+type MCPResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      ID              `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error represents a JSON-RPC error object
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// NewErrorResponse creates an error response for the request with the given ID
+func NewErrorResponse(id ID, code int, message string) *MCPResponse {
+	return &MCPResponse{
+		JSONRPC: Version,
+		ID:      id,
+		Error: &Error{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+// Denial is the error data of a request or response blocked by SafeCtx.
+// Which fields are set depends on the configured denial detail.
+type Denial struct {
+	// Stage is the inspection stage that blocked the message
+	Stage string `json:"stage,omitempty"`
+	// Rule is the ID of the rule that fired within the stage
+	Rule string `json:"rule,omitempty"`
+	// Severity rates the finding: "low", "medium" or "high"
+	Severity string `json:"severity,omitempty"`
+	// Path is the JSON pointer of the matched field within the params of
+	// a request, or within the result of a response
+	Path string `json:"path,omitempty"`
+	// Reason explains the denial
+	Reason string `json:"reason,omitempty"`
+	// RequestID correlates the denial with the gateway logs
+	RequestID string `json:"requestId,omitempty"`
+	// ApprovalID identifies the approval a held request waited for
+	ApprovalID string `json:"approvalId,omitempty"`
+}